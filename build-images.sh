@@ -21,7 +21,7 @@ docker push ${REGISTRY}:web-${VERSION}
 
 # Build and push backend
 echo "Building backend..."
-docker build -t ${REGISTRY}:server-${VERSION} ./otail-server
+docker build -t ${REGISTRY}:server-${VERSION} -f otail-server/Dockerfile .
 echo "Pushing backend image..."
 docker push ${REGISTRY}:server-${VERSION}
 
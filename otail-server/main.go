@@ -11,17 +11,24 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/google/uuid"
 
 	"github.com/mottibec/otail-server/pkg/agents"
 	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
 	"github.com/mottibec/otail-server/pkg/agents/deployments"
 	"github.com/mottibec/otail-server/pkg/agents/groups"
 	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/registry"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/rollout"
+	"github.com/mottibec/otail-server/pkg/agents/simulation"
 	"github.com/mottibec/otail-server/pkg/agents/tailsampling"
+	"github.com/mottibec/otail-server/pkg/agents/traces"
 	"github.com/mottibec/otail-server/pkg/auth"
 	"github.com/mottibec/otail-server/pkg/organization"
 	"github.com/mottibec/otail-server/pkg/telemetry"
 	"github.com/mottibec/otail-server/pkg/user"
+	"github.com/mottibec/otail/wasm/ottl/filter"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -162,33 +169,99 @@ func main() {
 		return groupID, deploymentID, nil
 	}
 
-	allAgents := opamp.NewDefaultAgents(logger)
+	// Restore the agent registry so agents keep their state across restarts
+	agentsStore := registry.NewMongoStore(db, logger)
+	allAgents := opamp.NewPersistentAgents(agentsStore, logger)
+	if err := allAgents.Load(ctx); err != nil {
+		logger.Fatal("Failed to load agents", zap.Error(err))
+	}
+
+	// Agents that stop reporting for longer than this are flagged as stale
+	var heartbeatTimeout time.Duration
+	if v := os.Getenv("AGENT_HEARTBEAT_TIMEOUT"); v != "" {
+		heartbeatTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			logger.Fatal("Invalid AGENT_HEARTBEAT_TIMEOUT", zap.Error(err))
+		}
+	}
+
+	// Conditions are checked against the OTTL functions of the collector
+	// version each agent runs. The functions of the versions up to the linked
+	// one are built in, others can be registered from a JSON file mapping
+	// versions to function names.
+	if path := os.Getenv("OTTL_FUNCTIONS_FILE"); path != "" {
+		if err := filter.DefaultFunctions.LoadFile(path); err != nil {
+			logger.Fatal("Failed to load OTTL functions", zap.Error(err))
+		}
+	}
 
 	// Initialize OPAMP server
 	opampServer, err := opamp.NewServer(
 		allAgents,
 		verifyToken,
 		onAgentConnected,
+		heartbeatTimeout,
 		logger,
 	)
 	if err != nil {
 		logger.Fatal("Failed to create OpAMP server", zap.Error(err))
 	}
 
+	// Restore the config shared by groups and deployments
+	allGroups, err := groupsStore.List(ctx, "")
+	if err != nil {
+		logger.Fatal("Failed to list agent groups", zap.Error(err))
+	}
+	for _, group := range allGroups {
+		opampServer.UpdateGroupConfig(group.ID, group.Config)
+	}
+	allDeployments, err := deploymentsStore.List(ctx)
+	if err != nil {
+		logger.Fatal("Failed to list deployments", zap.Error(err))
+	}
+	for _, deployment := range allDeployments {
+		opampServer.UpdateDeploymentConfig(deployment.ID, deployment.Config)
+	}
+
 	// Start OPAMP server
 	if err := opampServer.Start(); err != nil {
 		logger.Fatal("Failed to start OpAMP server", zap.Error(err))
 	}
 
-	// Initialize ClickHouse client
+	// Initialize ClickHouse client. The server runs without ClickHouse: the
+	// client reconnects in the background and until then the log, trace and
+	// metric endpoints answer 503. With an invalid DSN the client is nil and
+	// they always do.
 	clickhouseClient, err := clickhouse.NewClient(os.Getenv("CLICKHOUSE_DSN"), logger)
 	if err != nil {
 		logger.Error("Failed to create ClickHouse client", zap.Error(err))
 	}
 	defer clickhouseClient.Close()
 
+	// Create the config revisions service. Rolling back re-applies the config
+	// of a revision to its agent or group, after validating it against their
+	// current state like any other update.
+	revisionsStore, err := revisions.NewMongoStore(ctx, db, logger)
+	if err != nil {
+		logger.Fatal("Failed to create revisions store", zap.Error(err))
+	}
+	applyAgentRevision := func(ctx context.Context, agentID, file, config string) error {
+		id, err := uuid.Parse(agentID)
+		if err != nil {
+			return fmt.Errorf("invalid agent ID: %w", err)
+		}
+		if err := opampServer.ValidateRawConfig(id, file, config); err != nil {
+			return err
+		}
+		return opampServer.SetRawConfigFile(id, file, config, nil)
+	}
+	revisionsService := revisions.NewService(revisionsStore, applyAgentRevision, groups.ApplyRevision(groupsStore, opampServer), logger)
+
 	// Create the tail sampling service
-	samplingService := tailsampling.NewService(logger, opampServer)
+	samplingService := tailsampling.NewService(logger, opampServer, revisionsService, groupsStore)
+
+	// Create the staged rollout service
+	rolloutService := rollout.NewService(logger, opampServer, revisionsService)
 
 	// Create HTTP router
 	r := chi.NewRouter()
@@ -215,8 +288,13 @@ func main() {
 	// Add organization routes with auth middleware
 	orgHandler := organization.NewOrgHandler(orgService, logger)
 	agentsHandler := agents.NewHandler(logger, samplingService, clickhouseClient)
-	groupsHandler := groups.NewHandler(groupsStore, logger)
-	deploymentsHandler := deployments.NewHandler(deploymentsStore, logger)
+	groupsHandler := groups.NewHandler(groupsStore, opampServer, revisionsService, logger)
+	deploymentsHandler := deployments.NewHandler(deploymentsStore, opampServer, logger)
+	rolloutHandler := rollout.NewHandler(rolloutService, logger)
+	revisionsHandler := revisions.NewHandler(revisionsService, logger)
+	policiesHandler := tailsampling.NewHandler(samplingService, logger)
+	simulationHandler := simulation.NewHandler(simulation.NewService(clickhouseClient, logger), logger)
+	tracesHandler := traces.NewHandler(clickhouseClient, logger)
 
 	// Protected routes (auth required)
 	r.Route("/api/v1", func(r chi.Router) {
@@ -225,6 +303,11 @@ func main() {
 		r.Route("/agents", agentsHandler.RegisterRoutes)
 		r.Route("/agent-groups", groupsHandler.RegisterRoutes)
 		r.Route("/deployments", deploymentsHandler.RegisterRoutes)
+		r.Route("/rollouts", rolloutHandler.RegisterRoutes)
+		r.Route("/revisions", revisionsHandler.RegisterRoutes)
+		r.Route("/policies", policiesHandler.RegisterRoutes)
+		r.Route("/sampling", simulationHandler.RegisterRoutes)
+		r.Route("/traces", tracesHandler.RegisterRoutes)
 	})
 
 	// Create HTTP server
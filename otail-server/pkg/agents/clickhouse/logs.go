@@ -0,0 +1,750 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"go.uber.org/zap"
+)
+
+type LogEntry struct {
+	Timestamp          time.Time         `json:"timestamp"`
+	TraceId            string            `json:"traceId"`
+	SpanId             string            `json:"spanId"`
+	TraceFlags         uint8             `json:"traceFlags"`
+	SeverityText       string            `json:"severityText"`
+	SeverityNumber     uint8             `json:"severityNumber"`
+	ServiceName        string            `json:"serviceName"`
+	InstanceId         string            `json:"instanceId"`
+	Body               string            `json:"body"`
+	ResourceSchemaUrl  string            `json:"resourceSchemaUrl"`
+	ResourceAttributes map[string]string `json:"resourceAttributes,omitempty"`
+	ScopeSchemaUrl     string            `json:"scopeSchemaUrl"`
+	ScopeName          string            `json:"scopeName"`
+	ScopeVersion       string            `json:"scopeVersion"`
+	ScopeAttributes    map[string]string `json:"scopeAttributes,omitempty"`
+	LogAttributes      map[string]string `json:"logAttributes,omitempty"`
+}
+
+// logColumns are the columns of otel_logs scanned by scanLogEntry
+const logColumns = `
+			Timestamp,
+			TraceId,
+			SpanId,
+			TraceFlags,
+			SeverityText,
+			SeverityNumber,
+			ServiceName,
+			InstanceId,
+			Body,
+			ResourceSchemaUrl,
+			ResourceAttributes,
+			ScopeSchemaUrl,
+			ScopeName,
+			ScopeVersion,
+			ScopeAttributes,
+			LogAttributes`
+
+// scanLogEntry scans the log columns followed by any extra columns
+func scanLogEntry(rows driver.Rows, extra ...interface{}) (LogEntry, error) {
+	var log LogEntry
+	dest := []interface{}{
+		&log.Timestamp,
+		&log.TraceId,
+		&log.SpanId,
+		&log.TraceFlags,
+		&log.SeverityText,
+		&log.SeverityNumber,
+		&log.ServiceName,
+		&log.InstanceId,
+		&log.Body,
+		&log.ResourceSchemaUrl,
+		&log.ResourceAttributes,
+		&log.ScopeSchemaUrl,
+		&log.ScopeName,
+		&log.ScopeVersion,
+		&log.ScopeAttributes,
+		&log.LogAttributes,
+	}
+	err := rows.Scan(append(dest, extra...)...)
+	return log, err
+}
+
+// QueryLogs returns the latest log records of the agent in the time range,
+// at most limit of them
+func (c *Client) QueryLogs(ctx context.Context, serviceInstanceID string, startTime, endTime time.Time, limit int) ([]LogEntry, error) {
+	page, err := c.SearchLogs(ctx, LogQuery{
+		LogFilter: LogFilter{InstanceID: serviceInstanceID, Start: startTime, End: endTime},
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page.Logs, nil
+}
+
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 1000
+)
+
+var (
+	ErrInvalidCursor  = errors.New("invalid cursor")
+	ErrInvalidTraceID = errors.New("trace ID must be 32 hex characters")
+	ErrInvalidRegex   = errors.New("invalid regex")
+)
+
+// LogFilter selects the log records of an agent. Zero fields don't filter.
+type LogFilter struct {
+	InstanceID string
+	Start      time.Time
+	End        time.Time
+	// MinSeverity and MaxSeverity bound the SeverityNumber of the records
+	MinSeverity uint8
+	MaxSeverity uint8
+	// Contains keeps the records whose body contains the text, ignoring case
+	Contains string
+	// Regex keeps the records whose body matches the RE2 regular expression
+	Regex string
+	// ResourceAttributes and LogAttributes must all be equal to the
+	// attributes of the records
+	ResourceAttributes map[string]string
+	LogAttributes      map[string]string
+	TraceID            string
+}
+
+// where returns the condition and the arguments of the filter, as
+// parameters of the query
+func (f LogFilter) where() (string, []interface{}, error) {
+	conditions := []string{"InstanceId = ?"}
+	args := []interface{}{f.InstanceID}
+	if !f.Start.IsZero() {
+		conditions = append(conditions, "Timestamp >= fromUnixTimestamp64Nano(?)")
+		args = append(args, f.Start.UnixNano())
+	}
+	if !f.End.IsZero() {
+		conditions = append(conditions, "Timestamp < fromUnixTimestamp64Nano(?)")
+		args = append(args, f.End.UnixNano())
+	}
+	if f.MinSeverity > 0 {
+		conditions = append(conditions, "SeverityNumber >= ?")
+		args = append(args, f.MinSeverity)
+	}
+	if f.MaxSeverity > 0 {
+		conditions = append(conditions, "SeverityNumber <= ?")
+		args = append(args, f.MaxSeverity)
+	}
+	if f.Contains != "" {
+		conditions = append(conditions, "positionCaseInsensitiveUTF8(Body, ?) > 0")
+		args = append(args, f.Contains)
+	}
+	if f.Regex != "" {
+		// ClickHouse and Go both use RE2, so the expression is checked here
+		if _, err := regexp.Compile(f.Regex); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidRegex, err)
+		}
+		conditions = append(conditions, "match(Body, ?)")
+		args = append(args, f.Regex)
+	}
+	for _, attrs := range []struct {
+		column string
+		values map[string]string
+	}{{"ResourceAttributes", f.ResourceAttributes}, {"LogAttributes", f.LogAttributes}} {
+		keys := make([]string, 0, len(attrs.values))
+		for k := range attrs.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			conditions = append(conditions, attrs.column+"[?] = ?")
+			args = append(args, k, attrs.values[k])
+		}
+	}
+	if f.TraceID != "" {
+		if b, err := hex.DecodeString(f.TraceID); err != nil || len(b) != 16 {
+			return "", nil, ErrInvalidTraceID
+		}
+		conditions = append(conditions, "TraceId = ?")
+		args = append(args, strings.ToLower(f.TraceID))
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// LogQuery is a page of a log search, newest records first
+type LogQuery struct {
+	LogFilter
+	// Limit is the page size, 100 by default and at most 1000
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// LogPage is a page of log records with the number of records matching the
+// filter across all pages
+type LogPage struct {
+	Logs       []LogEntry `json:"logs"`
+	Total      uint64     `json:"total"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// logHash orders the records with the same timestamp, to make the keyset of
+// the pagination unique
+const logHash = "cityHash64(TraceId, SpanId, Body)"
+
+// SearchLogs returns a page of the log records matching the query. Pages are
+// read with keyset pagination on the timestamp and a hash of the record, so
+// records written while paging don't shift the pages.
+func (c *Client) SearchLogs(ctx context.Context, q LogQuery) (*LogPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLogLimit
+	}
+	if limit > maxLogLimit {
+		limit = maxLogLimit
+	}
+
+	where, args, err := q.where()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &LogPage{Logs: []LogEntry{}}
+	countQuery := "SELECT count() FROM " + c.table(c.logsTable) + " WHERE " + where
+	if err := conn.QueryRow(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count logs: %w", err)
+	}
+
+	pageWhere, pageArgs := where, args
+	if q.Cursor != "" {
+		timestamp, hash, err := decodeLogCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		pageWhere += " AND (Timestamp, " + logHash + ") < (fromUnixTimestamp64Nano(?), ?)"
+		pageArgs = append(append([]interface{}{}, args...), timestamp, hash)
+	}
+	pageArgs = append(pageArgs, limit)
+
+	query := `
+		SELECT` + logColumns + `,
+			` + logHash + `
+		FROM ` + c.table(c.logsTable) + `
+		WHERE ` + pageWhere + `
+		ORDER BY Timestamp DESC, ` + logHash + ` DESC
+		LIMIT ?`
+
+	rows, err := conn.Query(ctx, query, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer rows.Close()
+
+	var lastHash uint64
+	for rows.Next() {
+		log, err := scanLogEntry(rows, &lastHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan log entry: %w", err)
+		}
+		page.Logs = append(page.Logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	if len(page.Logs) == limit {
+		page.NextCursor = encodeLogCursor(page.Logs[len(page.Logs)-1].Timestamp.UnixNano(), lastHash)
+	}
+	return page, nil
+}
+
+func encodeLogCursor(timestamp int64, hash uint64) string {
+	raw := strconv.FormatInt(timestamp, 10) + ":" + strconv.FormatUint(hash, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLogCursor(cursor string) (int64, uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	timestampStr, hashStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, ErrInvalidCursor
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	hash, err := strconv.ParseUint(hashStr, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return timestamp, hash, nil
+}
+
+// LogGroupBy is what the log records of a histogram are grouped by
+type LogGroupBy string
+
+const (
+	// LogGroupBySeverity groups by severity name, e.g. WARN, derived from the
+	// SeverityNumber so records with unusual severity texts are grouped too
+	LogGroupBySeverity LogGroupBy = "severity"
+	LogGroupByScope    LogGroupBy = "scope"
+	// LogGroupByAttribute groups by the value of a log attribute
+	LogGroupByAttribute LogGroupBy = "attribute"
+)
+
+const (
+	defaultLogGroups = 10
+	maxLogGroups     = 100
+)
+
+var ErrInvalidGroupBy = errors.New("group by must be severity, scope or attribute with an attribute key")
+
+// LogHistogramQuery counts the log records matching the filter per time
+// bucket and group. Start and End of the filter are required.
+type LogHistogramQuery struct {
+	LogFilter
+	// Step is the width of the time buckets, by default the range split into
+	// 100 buckets
+	Step    time.Duration
+	GroupBy LogGroupBy
+	// AttributeKey is the log attribute grouped by with LogGroupByAttribute
+	AttributeKey string
+	// Top is the number of groups with the most records returned, 10 by
+	// default and at most 100. The other records are counted together.
+	Top int
+}
+
+// LogBucket is the number of records in the time bucket starting at Timestamp
+type LogBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     uint64    `json:"count"`
+}
+
+// LogGroup is the histogram of the records with one value of the grouping
+type LogGroup struct {
+	Value   string      `json:"value"`
+	Count   uint64      `json:"count"`
+	Buckets []LogBucket `json:"buckets"`
+}
+
+// LogHistogram is the histogram of the top groups, most records first, and
+// of the records of the remaining groups
+type LogHistogram struct {
+	StepSeconds int64      `json:"stepSeconds"`
+	Total       uint64     `json:"total"`
+	Groups      []LogGroup `json:"groups"`
+	Other       LogGroup   `json:"other"`
+}
+
+// groupExpr returns the expression and arguments of the value records are
+// grouped by
+func (q LogHistogramQuery) groupExpr() (string, []interface{}, error) {
+	switch q.GroupBy {
+	case LogGroupBySeverity:
+		return `multiIf(
+				SeverityNumber >= 21, 'FATAL',
+				SeverityNumber >= 17, 'ERROR',
+				SeverityNumber >= 13, 'WARN',
+				SeverityNumber >= 9, 'INFO',
+				SeverityNumber >= 5, 'DEBUG',
+				SeverityNumber >= 1, 'TRACE',
+				'UNSPECIFIED')`, nil, nil
+	case LogGroupByScope:
+		return "ScopeName", nil, nil
+	case LogGroupByAttribute:
+		if q.AttributeKey == "" {
+			return "", nil, ErrInvalidGroupBy
+		}
+		return "LogAttributes[?]", []interface{}{q.AttributeKey}, nil
+	default:
+		return "", nil, ErrInvalidGroupBy
+	}
+}
+
+// AggregateLogs returns the number of log records matching the query per time
+// bucket, for the groups with the most records. The top groups are found
+// first, so the bucketed query returns a bounded number of rows however many
+// distinct values the grouping has.
+func (c *Client) AggregateLogs(ctx context.Context, q LogHistogramQuery) (*LogHistogram, error) {
+	top := q.Top
+	if top <= 0 {
+		top = defaultLogGroups
+	}
+	if top > maxLogGroups {
+		top = maxLogGroups
+	}
+
+	group, groupArgs, err := q.groupExpr()
+	if err != nil {
+		return nil, err
+	}
+	stepSeconds, err := bucketSeconds(q.Start, q.End, q.Step)
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs, err := q.where()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Arguments of the group expression come first, since it is in the WITH
+	// clause before the filter
+	args := append(append([]interface{}{}, groupArgs...), whereArgs...)
+	topQuery := `
+		WITH ` + group + ` AS Value
+		SELECT Value, count() AS Count
+		FROM ` + c.table(c.logsTable) + `
+		WHERE ` + where + `
+		GROUP BY Value
+		ORDER BY Count DESC, Value
+		LIMIT ?`
+
+	rows, err := conn.Query(ctx, topQuery, append(append([]interface{}{}, args...), top)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate logs: %w", err)
+	}
+	defer rows.Close()
+
+	histogram := &LogHistogram{
+		StepSeconds: stepSeconds,
+		Groups:      []LogGroup{},
+		Other:       LogGroup{Buckets: []LogBucket{}},
+	}
+	values := []string{}
+	for rows.Next() {
+		var g LogGroup
+		if err := rows.Scan(&g.Value, &g.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan log group: %w", err)
+		}
+		g.Buckets = []LogBucket{}
+		histogram.Groups = append(histogram.Groups, g)
+		values = append(values, g.Value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	if len(values) == 0 {
+		return histogram, nil
+	}
+	groups := make(map[string]*LogGroup, len(values))
+	for i := range histogram.Groups {
+		groups[histogram.Groups[i].Value] = &histogram.Groups[i]
+	}
+
+	bucketQuery := fmt.Sprintf(`
+		WITH %s AS Value
+		SELECT
+			toStartOfInterval(Timestamp, INTERVAL %d SECOND) AS Bucket,
+			has(?, Value) AS Top,
+			if(Top, Value, '') AS TopValue,
+			count()
+		FROM %s
+		WHERE %s
+		GROUP BY Top, TopValue, Bucket
+		ORDER BY Top, TopValue, Bucket`, group, stepSeconds, c.table(c.logsTable), where)
+
+	bucketArgs := append(append(append([]interface{}{}, groupArgs...), values), whereArgs...)
+	bucketRows, err := conn.Query(ctx, bucketQuery, bucketArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate logs: %w", err)
+	}
+	defer bucketRows.Close()
+
+	for bucketRows.Next() {
+		var (
+			bucket LogBucket
+			isTop  uint8
+			value  string
+		)
+		if err := bucketRows.Scan(&bucket.Timestamp, &isTop, &value, &bucket.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan log bucket: %w", err)
+		}
+
+		g := &histogram.Other
+		if isTop == 1 {
+			if g = groups[value]; g == nil {
+				continue
+			}
+		} else {
+			g.Count += bucket.Count
+		}
+		g.Buckets = append(g.Buckets, bucket)
+		histogram.Total += bucket.Count
+	}
+	if err := bucketRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return histogram, nil
+}
+
+const (
+	defaultLogPollInterval = time.Second
+	// logStreamBatchSize is the maximum number of records sent in one batch.
+	// A full batch is followed by another query without waiting.
+	logStreamBatchSize = 500
+	// logStreamBuffer is the number of batches buffered for a slow consumer
+	logStreamBuffer = 4
+	// logStreamLookback is how long before the newest streamed record polls
+	// look for records that were written late, e.g. after an agent retried
+	// an export
+	logStreamLookback = time.Minute
+)
+
+// LogStreamQuery selects the log records of an agent streamed by StreamLogs
+type LogStreamQuery struct {
+	InstanceID string
+	// MinSeverity and MaxSeverity bound the SeverityNumber of the streamed
+	// records, 0 doesn't bound it
+	MinSeverity uint8
+	MaxSeverity uint8
+	// Contains keeps the records whose body contains the text, ignoring case
+	Contains string
+	// Since is the timestamp the stream starts from, now by default
+	Since time.Time
+	// PollInterval is how often new records are polled for, 1s by default
+	PollInterval time.Duration
+}
+
+// StreamLogs polls for the log records of the query and sends them in batches
+// until ctx is done or a query fails. The error that ended the stream, if any,
+// is sent on the error channel once the batch channel is closed.
+//
+// Each poll reads the records from logStreamLookback before the newest
+// streamed record on, ordered by timestamp and a hash of the record, and
+// sends those that were not sent yet. Records written later than that after
+// their timestamp are skipped.
+//
+// Polling pauses while the consumer has not taken the buffered batches, so a
+// slow consumer slows down the queries instead of records piling up in
+// memory. While ClickHouse is unavailable the stream keeps polling.
+func (c *Client) StreamLogs(ctx context.Context, q LogStreamQuery) (<-chan []LogEntry, <-chan error) {
+	batches := make(chan []LogEntry, logStreamBuffer)
+	errs := make(chan error, 1)
+
+	interval := q.PollInterval
+	if interval <= 0 {
+		interval = defaultLogPollInterval
+	}
+	since := q.Since
+	if since.IsZero() {
+		since = time.Now()
+	}
+	stream := newLogStream(since)
+
+	go func() {
+		defer close(errs)
+		defer close(batches)
+
+		wait := time.Duration(0)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait = interval
+
+			err := c.pollLogs(ctx, q, stream, func(batch []LogEntry) bool {
+				select {
+				case batches <- batch:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+			if errors.Is(err, ErrUnavailable) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					c.logger.Error("Failed to poll logs for streaming", zap.Error(err))
+					errs <- err
+				}
+				return
+			}
+			stream.prune()
+		}
+	}()
+
+	return batches, errs
+}
+
+// pollLogs reads the records of the query from the start of the lookback
+// window of the stream in batches, and passes the batches of records that
+// were not sent yet to send until it returns false
+func (c *Client) pollLogs(ctx context.Context, q LogStreamQuery, stream *logStream, send func([]LogEntry) bool) error {
+	filter := "InstanceId = ? AND (Timestamp, " + logHash + ") > (fromUnixTimestamp64Nano(?), ?)"
+	var filterArgs []interface{}
+	if q.MinSeverity > 0 {
+		filter += " AND SeverityNumber >= ?"
+		filterArgs = append(filterArgs, q.MinSeverity)
+	}
+	if q.MaxSeverity > 0 {
+		filter += " AND SeverityNumber <= ?"
+		filterArgs = append(filterArgs, q.MaxSeverity)
+	}
+	if q.Contains != "" {
+		filter += " AND positionCaseInsensitiveUTF8(Body, ?) > 0"
+		filterArgs = append(filterArgs, q.Contains)
+	}
+
+	query := `
+		SELECT` + logColumns + `,
+			` + logHash + `
+		FROM ` + c.table(c.logsTable) + `
+		WHERE ` + filter + `
+		ORDER BY Timestamp, ` + logHash + `
+		LIMIT ?`
+
+	// Start right before the first record of the window
+	after := logPosition{timestamp: stream.from().UnixNano() - 1, hash: math.MaxUint64}
+	for {
+		conn, err := c.connection()
+		if err != nil {
+			return err
+		}
+		args := append([]interface{}{q.InstanceID, after.timestamp, after.hash}, filterArgs...)
+		rows, err := conn.Query(ctx, query, append(args, logStreamBatchSize)...)
+		if err != nil {
+			return fmt.Errorf("failed to query logs: %w", err)
+		}
+
+		var batch []LogEntry
+		read := 0
+		for rows.Next() {
+			var hash uint64
+			log, err := scanLogEntry(rows, &hash)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan log entry: %w", err)
+			}
+			read++
+			after = logPosition{timestamp: log.Timestamp.UnixNano(), hash: hash}
+			if stream.add(after, log.Timestamp) {
+				batch = append(batch, log)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("error iterating over rows: %w", err)
+		}
+
+		if len(batch) > 0 && !send(batch) {
+			return nil
+		}
+		if read < logStreamBatchSize {
+			return nil
+		}
+	}
+}
+
+// logPosition is the position of a record in the order of a log stream
+type logPosition struct {
+	timestamp int64
+	hash      uint64
+}
+
+// logStream tracks the records a log stream sent within its lookback window
+type logStream struct {
+	since  time.Time
+	newest time.Time
+	sent   map[logPosition]bool
+}
+
+func newLogStream(since time.Time) *logStream {
+	return &logStream{
+		since:  since,
+		newest: since,
+		sent:   make(map[logPosition]bool),
+	}
+}
+
+// from returns the start of the lookback window, which is never before the
+// start of the stream
+func (s *logStream) from() time.Time {
+	from := s.newest.Add(-logStreamLookback)
+	if from.Before(s.since) {
+		return s.since
+	}
+	return from
+}
+
+// add marks the record at the position as sent and returns false if it
+// already was
+func (s *logStream) add(position logPosition, timestamp time.Time) bool {
+	if s.sent[position] {
+		return false
+	}
+	s.sent[position] = true
+	if timestamp.After(s.newest) {
+		s.newest = timestamp
+	}
+	return true
+}
+
+// prune forgets the records before the lookback window, which are not read
+// again
+func (s *logStream) prune() {
+	from := s.from().UnixNano()
+	for position := range s.sent {
+		if position.timestamp < from {
+			delete(s.sent, position)
+		}
+	}
+}
+
+// ParseSeverity returns the lowest SeverityNumber of a severity name, e.g.
+// WARN, or the number itself
+func ParseSeverity(s string) (uint8, error) {
+	if n, err := strconv.ParseUint(s, 10, 8); err == nil && n <= 24 {
+		return uint8(n), nil
+	}
+	if n, ok := severityNumbers[strings.ToUpper(s)]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q", s)
+}
+
+// ParseMaxSeverity returns the highest SeverityNumber of a severity name, e.g.
+// 16 for WARN so that WARN2 to WARN4 are included, or the number itself
+func ParseMaxSeverity(s string) (uint8, error) {
+	if n, err := strconv.ParseUint(s, 10, 8); err == nil && n <= 24 {
+		return uint8(n), nil
+	}
+	if n, ok := severityNumbers[strings.ToUpper(s)]; ok {
+		// Each severity name spans four numbers, e.g. WARN to WARN4
+		return n + 3, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q", s)
+}
+
+var severityNumbers = map[string]uint8{
+	"TRACE":   1,
+	"DEBUG":   5,
+	"INFO":    9,
+	"WARN":    13,
+	"WARNING": 13,
+	"ERROR":   17,
+	"FATAL":   21,
+}
@@ -0,0 +1,177 @@
+package clickhouse
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCursor(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp int64
+		hash      uint64
+	}{
+		{name: "zero", timestamp: 0, hash: 0},
+		{name: "typical", timestamp: 1700000000123456789, hash: 12345678901234567890},
+		{name: "largest", timestamp: math.MaxInt64, hash: math.MaxUint64},
+		{name: "before epoch", timestamp: -1, hash: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := encodeLogCursor(tt.timestamp, tt.hash)
+			timestamp, hash, err := decodeLogCursor(cursor)
+			if err != nil {
+				t.Fatalf("decodeLogCursor(%q) = %v", cursor, err)
+			}
+			if timestamp != tt.timestamp || hash != tt.hash {
+				t.Errorf("decodeLogCursor(%q) = %d, %d, want %d, %d", cursor, timestamp, hash, tt.timestamp, tt.hash)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidLogCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "empty", cursor: ""},
+		{name: "not base64", cursor: "!!!"},
+		{name: "no separator", cursor: encode("12")},
+		{name: "timestamp not a number", cursor: encode("now:2")},
+		{name: "hash not a number", cursor: encode("1:abc")},
+		{name: "negative hash", cursor: encode("1:-2")},
+		{name: "timestamp overflows", cursor: encode("9223372036854775808:2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeLogCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeLogCursor(%q) = %v, want %v", tt.cursor, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestParseMaxSeverity(t *testing.T) {
+	tests := []struct {
+		severity string
+		want     uint8
+		wantErr  bool
+	}{
+		{severity: "TRACE", want: 4},
+		{severity: "debug", want: 8},
+		{severity: "INFO", want: 12},
+		{severity: "WARN", want: 16},
+		{severity: "warning", want: 16},
+		{severity: "ERROR", want: 20},
+		{severity: "FATAL", want: 24},
+		{severity: "0", want: 0},
+		{severity: "13", want: 13},
+		{severity: "24", want: 24},
+		{severity: "25", wantErr: true},
+		{severity: "-1", wantErr: true},
+		{severity: "WARN2", wantErr: true},
+		{severity: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.severity, func(t *testing.T) {
+			got, err := ParseMaxSeverity(tt.severity)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMaxSeverity(%q) = %v, wantErr %v", tt.severity, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMaxSeverity(%q) = %d, want %d", tt.severity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogStream(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	stream := newLogStream(since)
+	at := func(offset time.Duration, hash uint64) (logPosition, time.Time) {
+		timestamp := since.Add(offset)
+		return logPosition{timestamp: timestamp.UnixNano(), hash: hash}, timestamp
+	}
+
+	if !stream.from().Equal(since) {
+		t.Errorf("from() = %v, want the start of the stream %v", stream.from(), since)
+	}
+
+	// Records sharing a timestamp are told apart by their hash
+	for _, hash := range []uint64{1, 2} {
+		if !stream.add(at(2*logStreamLookback, hash)) {
+			t.Errorf("record with hash %d was not sent", hash)
+		}
+	}
+	if stream.add(at(2*logStreamLookback, 1)) {
+		t.Error("record read again by the next poll was sent twice")
+	}
+	if want := since.Add(logStreamLookback); !stream.from().Equal(want) {
+		t.Errorf("from() = %v, want %v", stream.from(), want)
+	}
+
+	// A record written late within the lookback window is still sent
+	if !stream.add(at(logStreamLookback+time.Second, 3)) {
+		t.Error("late record within the lookback window was not sent")
+	}
+	if want := since.Add(logStreamLookback); !stream.from().Equal(want) {
+		t.Errorf("from() after a late record = %v, want %v", stream.from(), want)
+	}
+
+	stream.add(at(3*logStreamLookback+time.Second, 4))
+	stream.prune()
+	if len(stream.sent) != 1 {
+		t.Errorf("%d records kept after pruning, want only the one in the lookback window", len(stream.sent))
+	}
+}
+
+func TestLogHistogramGroupExpr(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    LogHistogramQuery
+		wantExpr string
+		wantArgs []interface{}
+		wantErr  error
+	}{
+		{name: "scope", query: LogHistogramQuery{GroupBy: LogGroupByScope}, wantExpr: "ScopeName"},
+		{
+			name:     "attribute",
+			query:    LogHistogramQuery{GroupBy: LogGroupByAttribute, AttributeKey: "http.route"},
+			wantExpr: "LogAttributes[?]",
+			wantArgs: []interface{}{"http.route"},
+		},
+		{name: "attribute without key", query: LogHistogramQuery{GroupBy: LogGroupByAttribute}, wantErr: ErrInvalidGroupBy},
+		{name: "unknown", query: LogHistogramQuery{GroupBy: "body"}, wantErr: ErrInvalidGroupBy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, args, err := tt.query.groupExpr()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("groupExpr() = %v, want %v", err, tt.wantErr)
+			}
+			if expr != tt.wantExpr || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("groupExpr() = %q, %v, want %q, %v", expr, args, tt.wantExpr, tt.wantArgs)
+			}
+		})
+	}
+
+	// Severities are named by their number, whatever the severity text
+	expr, _, err := LogHistogramQuery{GroupBy: LogGroupBySeverity}.groupExpr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"FATAL", "ERROR", "WARN", "INFO", "DEBUG", "TRACE", "UNSPECIFIED"} {
+		if !strings.Contains(expr, "'"+name+"'") {
+			t.Errorf("severity expression %q does not name %s", expr, name)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MetricType is the type of a metric, which the clickhouse exporter writes to
+// its own table, e.g. otel_metrics_gauge
+type MetricType string
+
+const (
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeSum       MetricType = "sum"
+	MetricTypeHistogram MetricType = "histogram"
+)
+
+// Aggregation reduces the data points of a series within a step
+type Aggregation string
+
+const (
+	AggregationAvg  Aggregation = "avg"
+	AggregationMin  Aggregation = "min"
+	AggregationMax  Aggregation = "max"
+	AggregationSum  Aggregation = "sum"
+	AggregationLast Aggregation = "last"
+	// AggregationRate is the per-second increase of a cumulative value
+	// between steps. Counter resets are treated as an increase from zero.
+	AggregationRate Aggregation = "rate"
+)
+
+// HistogramField is the value of a histogram data point that is aggregated
+type HistogramField string
+
+const (
+	HistogramCount HistogramField = "count"
+	HistogramSum   HistogramField = "sum"
+	HistogramMean  HistogramField = "mean"
+)
+
+const (
+	// defaultMetricPoints is the number of points per series when no step is given
+	defaultMetricPoints = 100
+	// maxMetricPoints bounds the number of steps of a query
+	maxMetricPoints = 11000
+)
+
+var (
+	ErrInvalidMetricType  = errors.New("metric type must be gauge, sum or histogram")
+	ErrInvalidAggregation = errors.New("aggregation must be avg, min, max, sum, last or rate")
+	ErrInvalidField       = errors.New("histogram field must be count, sum or mean")
+	ErrTooManyPoints      = fmt.Errorf("step is too small for the time range, at most %d points per series", maxMetricPoints)
+)
+
+// MetricQuery selects the series of a metric reported by an agent. Agents are
+// identified by the service.instance.id resource attribute, which the opamp
+// extension sets to the instance UID of the agent.
+type MetricQuery struct {
+	InstanceID string
+	MetricName string
+	Type       MetricType
+	Start      time.Time
+	End        time.Time
+	// Step is the width of the time buckets, by default the range split into
+	// 100 buckets
+	Step time.Duration
+	// Aggregation reduces the data points within a step, avg by default
+	Aggregation Aggregation
+	// Field is the aggregated value of histograms, mean by default
+	Field HistogramField
+}
+
+// MetricPoint is the aggregated value of a series at the start of a step
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// MetricSeries is a metric with one set of data point attributes
+type MetricSeries struct {
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes"`
+	Points     []MetricPoint     `json:"points"`
+}
+
+// MetricInfo describes a metric reported by an agent
+type MetricInfo struct {
+	Name        string     `json:"name"`
+	Type        MetricType `json:"type"`
+	Unit        string     `json:"unit"`
+	Description string     `json:"description"`
+}
+
+// typeTable returns the table the clickhouse exporter writes metrics of the
+// type to
+func (c *Client) typeTable(typ MetricType) (string, error) {
+	switch typ {
+	case MetricTypeGauge, MetricTypeSum, MetricTypeHistogram:
+		return c.table(c.metricsTable + "_" + string(typ)), nil
+	default:
+		return "", ErrInvalidMetricType
+	}
+}
+
+// valueExpr returns the value of a data point of the metric type
+func valueExpr(typ MetricType, field HistogramField) (string, error) {
+	if typ != MetricTypeHistogram {
+		return "Value", nil
+	}
+	switch field {
+	case HistogramCount:
+		return "toFloat64(Count)", nil
+	case HistogramSum:
+		return "Sum", nil
+	case HistogramMean, "":
+		return "if(Count > 0, Sum / Count, 0)", nil
+	default:
+		return "", ErrInvalidField
+	}
+}
+
+// aggregationExpr returns the expression that reduces the values of a step
+func aggregationExpr(aggregation Aggregation, value string) (string, error) {
+	switch aggregation {
+	case AggregationAvg, "":
+		return "avg(" + value + ")", nil
+	case AggregationMin, AggregationMax, AggregationSum:
+		return string(aggregation) + "(" + value + ")", nil
+	case AggregationLast, AggregationRate:
+		return "argMax(" + value + ", TimeUnix)", nil
+	default:
+		return "", ErrInvalidAggregation
+	}
+}
+
+// bucketSeconds returns the width in seconds of the time buckets of a range,
+// by default the range split into 100 buckets
+func bucketSeconds(start, end time.Time, step time.Duration) (int64, error) {
+	if step <= 0 {
+		step = end.Sub(start) / defaultMetricPoints
+	}
+	step = step.Truncate(time.Second)
+	if step < time.Second {
+		step = time.Second
+	}
+	if end.Sub(start)/step > maxMetricPoints {
+		return 0, ErrTooManyPoints
+	}
+	return int64(step / time.Second), nil
+}
+
+// QueryMetrics returns the series of the metric, one per set of data point
+// attributes, with the data points aggregated per step
+func (c *Client) QueryMetrics(ctx context.Context, q MetricQuery) ([]MetricSeries, error) {
+	table, err := c.typeTable(q.Type)
+	if err != nil {
+		return nil, err
+	}
+	value, err := valueExpr(q.Type, q.Field)
+	if err != nil {
+		return nil, err
+	}
+	aggregated, err := aggregationExpr(q.Aggregation, value)
+	if err != nil {
+		return nil, err
+	}
+
+	stepSeconds, err := bucketSeconds(q.Start, q.End, q.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			MetricName,
+			any(Attributes),
+			toStartOfInterval(TimeUnix, INTERVAL %d SECOND) AS Bucket,
+			%s
+		FROM %s
+		WHERE ResourceAttributes['service.instance.id'] = ?
+			AND MetricName = ?
+			AND TimeUnix >= ? AND TimeUnix < ?
+		GROUP BY MetricName, arraySort(arrayZip(mapKeys(Attributes), mapValues(Attributes))) AS AttributesKey, Bucket
+		ORDER BY MetricName, AttributesKey, Bucket`, stepSeconds, aggregated, table)
+
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, query, q.InstanceID, q.MetricName, q.Start, q.End)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metrics: %w", err)
+	}
+	defer rows.Close()
+
+	series := []MetricSeries{}
+	var current *MetricSeries
+	var currentKey string
+	for rows.Next() {
+		var (
+			name       string
+			attributes map[string]string
+			bucket     time.Time
+			v          float64
+		)
+		if err := rows.Scan(&name, &attributes, &bucket, &v); err != nil {
+			return nil, fmt.Errorf("failed to scan metric point: %w", err)
+		}
+
+		// Rows are ordered by series, so a new key starts a new series
+		key := name + "\x00" + attributesKey(attributes)
+		if current == nil || key != currentKey {
+			if attributes == nil {
+				attributes = map[string]string{}
+			}
+			series = append(series, MetricSeries{Name: name, Attributes: attributes, Points: []MetricPoint{}})
+			current = &series[len(series)-1]
+			currentKey = key
+		}
+		current.Points = append(current.Points, MetricPoint{Timestamp: bucket, Value: v})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	if q.Aggregation == AggregationRate {
+		for i := range series {
+			series[i].Points = ratePoints(series[i].Points)
+		}
+	}
+	return series, nil
+}
+
+// ratePoints converts the last cumulative value of each step into the
+// per-second increase since the previous step. The first step has no previous
+// value and is dropped.
+func ratePoints(points []MetricPoint) []MetricPoint {
+	result := []MetricPoint{}
+	for i := 1; i < len(points); i++ {
+		increase := points[i].Value - points[i-1].Value
+		if increase < 0 {
+			// The counter was reset, e.g. by a collector restart
+			increase = points[i].Value
+		}
+		seconds := points[i].Timestamp.Sub(points[i-1].Timestamp).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+		result = append(result, MetricPoint{Timestamp: points[i].Timestamp, Value: increase / seconds})
+	}
+	return result
+}
+
+// ListMetrics returns the gauge, sum and histogram metrics the agent reported
+// in the time range
+func (c *Client) ListMetrics(ctx context.Context, instanceID string, start, end time.Time) ([]MetricInfo, error) {
+	var (
+		selects []string
+		args    []interface{}
+	)
+	for _, typ := range []MetricType{MetricTypeGauge, MetricTypeSum, MetricTypeHistogram} {
+		table, _ := c.typeTable(typ)
+		selects = append(selects, fmt.Sprintf(`
+		SELECT MetricName, '%s', any(MetricUnit), any(MetricDescription)
+		FROM %s
+		WHERE ResourceAttributes['service.instance.id'] = ? AND TimeUnix >= ? AND TimeUnix < ?
+		GROUP BY MetricName`, typ, table))
+		args = append(args, instanceID, start, end)
+	}
+	query := "SELECT * FROM (" + strings.Join(selects, "\n\t\tUNION ALL") + ") ORDER BY 1, 2"
+
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+	defer rows.Close()
+
+	metrics := []MetricInfo{}
+	for rows.Next() {
+		var info MetricInfo
+		var typ string
+		if err := rows.Scan(&info.Name, &typ, &info.Unit, &info.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan metric: %w", err)
+		}
+		info.Type = MetricType(typ)
+		metrics = append(metrics, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,72 @@
+package clickhouse
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRatePoints(t *testing.T) {
+	at := func(minute int, value float64) MetricPoint {
+		return MetricPoint{Timestamp: time.Date(2024, 5, 1, 10, minute, 0, 0, time.UTC), Value: value}
+	}
+	tests := []struct {
+		name   string
+		points []MetricPoint
+		want   []MetricPoint
+	}{
+		{name: "no points", points: nil, want: []MetricPoint{}},
+		{name: "one point", points: []MetricPoint{at(0, 10)}, want: []MetricPoint{}},
+		{name: "increase", points: []MetricPoint{at(0, 10), at(1, 70), at(2, 190)}, want: []MetricPoint{at(1, 1), at(2, 2)}},
+		{name: "counter reset", points: []MetricPoint{at(0, 600), at(1, 120)}, want: []MetricPoint{at(1, 2)}},
+		{name: "same timestamp", points: []MetricPoint{at(0, 10), at(0, 20), at(1, 80)}, want: []MetricPoint{at(1, 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ratePoints(tt.points); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ratePoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketSeconds(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		end     time.Time
+		step    time.Duration
+		want    int64
+		wantErr error
+	}{
+		{name: "default step", end: start.Add(time.Hour), want: 36},
+		{name: "step", end: start.Add(time.Hour), step: time.Minute, want: 60},
+		{name: "truncated to seconds", end: start.Add(time.Hour), step: 1500 * time.Millisecond, want: 1},
+		{name: "at least a second", end: start.Add(time.Minute), want: 1},
+		{name: "too many points", end: start.Add(24 * time.Hour), step: time.Second, wantErr: ErrTooManyPoints},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bucketSeconds(start, tt.end, tt.step)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("bucketSeconds(%v) = %d, %v, want %d, %v", tt.step, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricExpressions(t *testing.T) {
+	if _, err := valueExpr(MetricTypeHistogram, "p99"); !errors.Is(err, ErrInvalidField) {
+		t.Errorf("valueExpr(histogram, p99) = %v, want %v", err, ErrInvalidField)
+	}
+	if got, err := valueExpr(MetricTypeGauge, HistogramCount); err != nil || got != "Value" {
+		t.Errorf("valueExpr(gauge, count) = %q, %v, want the value", got, err)
+	}
+	if got, err := aggregationExpr(AggregationRate, "Value"); err != nil || got != "argMax(Value, TimeUnix)" {
+		t.Errorf("aggregationExpr(rate) = %q, %v, want the last value", got, err)
+	}
+	if _, err := aggregationExpr("median", "Value"); !errors.Is(err, ErrInvalidAggregation) {
+		t.Errorf("aggregationExpr(median) = %v, want %v", err, ErrInvalidAggregation)
+	}
+}
@@ -0,0 +1,383 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+const defaultTraceLimit = 1000
+
+// ErrTraceNotFound is returned by GetTrace when no span of the trace is stored
+var ErrTraceNotFound = errors.New("trace not found")
+
+// TraceQuery selects the traces with a span in the time range that matches
+// every filter of the query
+type TraceQuery struct {
+	Start       time.Time
+	End         time.Time
+	ServiceName string
+	SpanName    string
+	// MinDuration and MaxDuration bound the duration of the matching span,
+	// zero means unbounded
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// StatusCode is the status of the matching span, e.g. Error or
+	// STATUS_CODE_ERROR
+	StatusCode string
+	// Attributes are matched against the span and resource attributes
+	Attributes map[string]string
+	// Limit is the maximum number of traces, 1000 by default
+	Limit int
+}
+
+// QueryTraces returns every span of the latest traces selected by the query,
+// as written by the collector's clickhouse exporter. The exporter stores
+// attributes as strings, so attribute values that parse as ints, doubles or
+// bools are converted back.
+func (c *Client) QueryTraces(ctx context.Context, q TraceQuery) (ptrace.Traces, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultTraceLimit
+	}
+
+	filter := "Timestamp >= ? AND Timestamp < ?"
+	args := []interface{}{q.Start, q.End}
+	if q.ServiceName != "" {
+		filter += " AND ServiceName = ?"
+		args = append(args, q.ServiceName)
+	}
+	if q.SpanName != "" {
+		filter += " AND SpanName = ?"
+		args = append(args, q.SpanName)
+	}
+	if q.MinDuration > 0 {
+		filter += " AND Duration >= ?"
+		args = append(args, q.MinDuration.Nanoseconds())
+	}
+	if q.MaxDuration > 0 {
+		filter += " AND Duration <= ?"
+		args = append(args, q.MaxDuration.Nanoseconds())
+	}
+	if q.StatusCode != "" {
+		// Match both the short and the enum names the exporter may write
+		short, enum := statusCodeNames(parseStatusCode(q.StatusCode))
+		filter += " AND StatusCode IN (?, ?)"
+		args = append(args, short, enum)
+	}
+	keys := make([]string, 0, len(q.Attributes))
+	for k := range q.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		filter += " AND (SpanAttributes[?] = ? OR ResourceAttributes[?] = ?)"
+		args = append(args, k, q.Attributes[k], k, q.Attributes[k])
+	}
+	args = append(args, limit)
+
+	conn, err := c.connection()
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+
+	// The latest traces with a matching span
+	rows, err := conn.Query(ctx, `
+		SELECT TraceId
+		FROM `+c.table(c.tracesTable)+`
+		WHERE `+filter+`
+		GROUP BY TraceId
+		ORDER BY max(Timestamp) DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return ptrace.Traces{}, fmt.Errorf("failed to query trace IDs: %w", err)
+	}
+	defer rows.Close()
+	var traceIDs []string
+	for rows.Next() {
+		var traceID string
+		if err := rows.Scan(&traceID); err != nil {
+			return ptrace.Traces{}, fmt.Errorf("failed to scan trace ID: %w", err)
+		}
+		traceIDs = append(traceIDs, traceID)
+	}
+	if err := rows.Err(); err != nil {
+		return ptrace.Traces{}, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	if len(traceIDs) == 0 {
+		return ptrace.NewTraces(), nil
+	}
+
+	// Their spans may start before or end after the time range
+	start, end, found, err := c.traceBounds(ctx, traceIDs)
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+	if !found {
+		return c.queryTraces(ctx, "has(?, TraceId)", traceIDs)
+	}
+	return c.queryTraces(ctx, "has(?, TraceId) AND Timestamp >= ? AND Timestamp < ?", traceIDs, start, end)
+}
+
+// traceBounds returns the time range of the spans of the traces, from the
+// table in which the exporter keeps the first and last span timestamp of each
+// trace. The table has second precision, so end is the second after the last
+// span. found is false if the table has none of the traces.
+func (c *Client) traceBounds(ctx context.Context, traceIDs []string) (start, end time.Time, found bool, err error) {
+	conn, err := c.connection()
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	var count uint64
+	err = conn.QueryRow(ctx, `
+		SELECT min(Start), max(End), count()
+		FROM `+c.table(c.tracesTable+"_trace_id_ts")+`
+		WHERE has(?, TraceId)`, traceIDs).Scan(&start, &end, &count)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("failed to query trace time ranges: %w", err)
+	}
+	return start, end.Add(time.Second), count > 0, nil
+}
+
+// GetTrace returns every span of the trace with the given hex trace ID
+func (c *Client) GetTrace(ctx context.Context, traceID string) (ptrace.Traces, error) {
+	td, err := c.queryTraces(ctx, "TraceId = ?", strings.ToLower(traceID))
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+	if td.SpanCount() == 0 {
+		return ptrace.Traces{}, ErrTraceNotFound
+	}
+	return td, nil
+}
+
+// queryTraces returns the spans selected by the where clause, grouped into
+// batches by resource and scope
+func (c *Client) queryTraces(ctx context.Context, where string, args ...interface{}) (ptrace.Traces, error) {
+	query := `
+		SELECT
+			Timestamp,
+			TraceId,
+			SpanId,
+			ParentSpanId,
+			TraceState,
+			SpanName,
+			SpanKind,
+			ServiceName,
+			ResourceAttributes,
+			ScopeName,
+			ScopeVersion,
+			SpanAttributes,
+			toInt64(Duration),
+			StatusCode,
+			StatusMessage,
+			Events.Timestamp,
+			Events.Name,
+			Events.Attributes
+		FROM ` + c.table(c.tracesTable) + `
+		WHERE ` + where + `
+		ORDER BY Timestamp`
+
+	conn, err := c.connection()
+	if err != nil {
+		return ptrace.Traces{}, err
+	}
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return ptrace.Traces{}, fmt.Errorf("failed to query traces: %w", err)
+	}
+	defer rows.Close()
+
+	td := ptrace.NewTraces()
+	scopes := make(map[string]ptrace.SpanSlice)
+	for rows.Next() {
+		var (
+			timestamp          time.Time
+			traceID            string
+			spanID             string
+			parentSpanID       string
+			traceState         string
+			spanName           string
+			spanKind           string
+			serviceName        string
+			resourceAttributes map[string]string
+			scopeName          string
+			scopeVersion       string
+			spanAttributes     map[string]string
+			duration           int64
+			statusCode         string
+			statusMessage      string
+			eventTimestamps    []time.Time
+			eventNames         []string
+			eventAttributes    []map[string]string
+		)
+		if err := rows.Scan(
+			&timestamp,
+			&traceID,
+			&spanID,
+			&parentSpanID,
+			&traceState,
+			&spanName,
+			&spanKind,
+			&serviceName,
+			&resourceAttributes,
+			&scopeName,
+			&scopeVersion,
+			&spanAttributes,
+			&duration,
+			&statusCode,
+			&statusMessage,
+			&eventTimestamps,
+			&eventNames,
+			&eventAttributes,
+		); err != nil {
+			return ptrace.Traces{}, fmt.Errorf("failed to scan span: %w", err)
+		}
+
+		if resourceAttributes == nil {
+			resourceAttributes = make(map[string]string)
+		}
+		if _, ok := resourceAttributes["service.name"]; !ok && serviceName != "" {
+			resourceAttributes["service.name"] = serviceName
+		}
+
+		// Spans with the same resource and scope share a batch
+		key := attributesKey(resourceAttributes) + "\x00" + scopeName + "\x00" + scopeVersion
+		spans, ok := scopes[key]
+		if !ok {
+			rs := td.ResourceSpans().AppendEmpty()
+			putAttributes(rs.Resource().Attributes(), resourceAttributes)
+			ss := rs.ScopeSpans().AppendEmpty()
+			ss.Scope().SetName(scopeName)
+			ss.Scope().SetVersion(scopeVersion)
+			spans = ss.Spans()
+			scopes[key] = spans
+		}
+
+		span := spans.AppendEmpty()
+		span.SetTraceID(parseTraceID(traceID))
+		span.SetSpanID(parseSpanID(spanID))
+		span.SetParentSpanID(parseSpanID(parentSpanID))
+		span.TraceState().FromRaw(traceState)
+		span.SetName(spanName)
+		span.SetKind(parseSpanKind(spanKind))
+		span.SetStartTimestamp(pcommon.NewTimestampFromTime(timestamp))
+		span.SetEndTimestamp(pcommon.NewTimestampFromTime(timestamp.Add(time.Duration(duration))))
+		putAttributes(span.Attributes(), spanAttributes)
+		span.Status().SetCode(parseStatusCode(statusCode))
+		span.Status().SetMessage(statusMessage)
+
+		for i := range eventNames {
+			event := span.Events().AppendEmpty()
+			event.SetName(eventNames[i])
+			if i < len(eventTimestamps) {
+				event.SetTimestamp(pcommon.NewTimestampFromTime(eventTimestamps[i]))
+			}
+			if i < len(eventAttributes) {
+				putAttributes(event.Attributes(), eventAttributes[i])
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return ptrace.Traces{}, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return td, nil
+}
+
+func attributesKey(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(attrs[k])
+		b.WriteByte('\x00')
+	}
+	return b.String()
+}
+
+// putAttributes copies the attributes as strings. The clickhouse exporter
+// stores attributes as Map(String, String), so their original type is lost and
+// guessing it would make OTTL conditions behave differently than in the
+// collector.
+func putAttributes(dest pcommon.Map, attrs map[string]string) {
+	dest.EnsureCapacity(len(attrs))
+	for k, v := range attrs {
+		dest.PutStr(k, v)
+	}
+}
+
+func parseTraceID(s string) pcommon.TraceID {
+	var id pcommon.TraceID
+	if b, err := hex.DecodeString(s); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+	}
+	return id
+}
+
+func parseSpanID(s string) pcommon.SpanID {
+	var id pcommon.SpanID
+	if b, err := hex.DecodeString(s); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+	}
+	return id
+}
+
+// parseSpanKind accepts both the short names written by the exporter, e.g.
+// Server, and the enum names, e.g. SPAN_KIND_SERVER
+func parseSpanKind(s string) ptrace.SpanKind {
+	switch strings.ToLower(strings.TrimPrefix(s, "SPAN_KIND_")) {
+	case "internal":
+		return ptrace.SpanKindInternal
+	case "server":
+		return ptrace.SpanKindServer
+	case "client":
+		return ptrace.SpanKindClient
+	case "producer":
+		return ptrace.SpanKindProducer
+	case "consumer":
+		return ptrace.SpanKindConsumer
+	default:
+		return ptrace.SpanKindUnspecified
+	}
+}
+
+// statusCodeNames returns the short and the enum name of the status code
+func statusCodeNames(code ptrace.StatusCode) (short, enum string) {
+	switch code {
+	case ptrace.StatusCodeOk:
+		return "Ok", "STATUS_CODE_OK"
+	case ptrace.StatusCodeError:
+		return "Error", "STATUS_CODE_ERROR"
+	default:
+		return "Unset", "STATUS_CODE_UNSET"
+	}
+}
+
+// parseStatusCode accepts both the short names written by the exporter, e.g.
+// Error, and the enum names, e.g. STATUS_CODE_ERROR
+func parseStatusCode(s string) ptrace.StatusCode {
+	switch strings.ToLower(strings.TrimPrefix(s, "STATUS_CODE_")) {
+	case "ok":
+		return ptrace.StatusCodeOk
+	case "error":
+		return ptrace.StatusCodeError
+	default:
+		return ptrace.StatusCodeUnset
+	}
+}
@@ -2,21 +2,32 @@ package deployments
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
 	"go.uber.org/zap"
 )
 
+// ConfigPublisher validates and pushes the config of a deployment to the deployment's
+// agents
+type ConfigPublisher interface {
+	ValidateDeploymentConfig(deploymentID string, config []byte) error
+	UpdateDeploymentConfig(deploymentID string, config []byte)
+}
+
 type Handler struct {
-	store  Store
-	logger *zap.Logger
+	store     Store
+	publisher ConfigPublisher
+	logger    *zap.Logger
 }
 
-func NewHandler(store Store, logger *zap.Logger) *Handler {
+func NewHandler(store Store, publisher ConfigPublisher, logger *zap.Logger) *Handler {
 	return &Handler{
-		store:  store,
-		logger: logger,
+		store:     store,
+		publisher: publisher,
+		logger:    logger,
 	}
 }
 
@@ -78,12 +89,32 @@ func (h *Handler) UpdateDeployment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	deployment.ID = id
+
+	// Reject a config the deployment's agents could not run before it is stored
+	var validationErr *validation.Error
+	if err := h.publisher.ValidateDeploymentConfig(deployment.ID, deployment.Config); errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid configuration",
+			"errors": validationErr.Errors,
+		})
+		return
+	} else if err != nil {
+		h.logger.Error("Failed to validate deployment config", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to update deployment")
+		return
+	}
+
 	if err := h.store.Update(r.Context(), &deployment); err != nil {
 		h.logger.Error("Failed to update deployment", zap.Error(err))
 		h.writeError(w, http.StatusInternalServerError, "Failed to update deployment")
 		return
 	}
 
+	// Push the deployment config to the deployment's agents
+	h.publisher.UpdateDeploymentConfig(deployment.ID, deployment.Config)
+
 	h.writeJSON(w, deployment)
 }
 
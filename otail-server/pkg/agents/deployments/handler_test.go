@@ -0,0 +1,86 @@
+package deployments
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"go.uber.org/zap"
+)
+
+// memoryStore is an in-memory Store of the deployments the tests use
+type memoryStore struct {
+	Store
+	deployments map[string]*Deployment
+}
+
+func (m *memoryStore) Update(ctx context.Context, deployment *Deployment) error {
+	stored := *deployment
+	m.deployments[deployment.ID] = &stored
+	return nil
+}
+
+func TestUpdateDeploymentValidatesConfig(t *testing.T) {
+	complete := `
+receivers:
+  otlp: {}
+exporters:
+  debug: {}
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [debug]
+`
+	tests := []struct {
+		name       string
+		config     string
+		wantStatus int
+	}{
+		{name: "complete config", config: complete, wantStatus: http.StatusOK},
+		{
+			// The agent of the deployment has no other config
+			name:       "no pipelines",
+			config:     "exporters:\n  debug: {}\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "undefined exporter",
+			config:     strings.Replace(complete, "exporters: [debug]", "exporters: [otlp]", 1),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := opamp.NewAgents(zap.NewNop())
+			if _, err := agents.FindOrCreateAgent(uuid.New(), nil, "org-1"); err != nil {
+				t.Fatal(err)
+			}
+			agents.SetConnection(nil, "org-1", "", "deployment-1")
+			server, err := opamp.NewServer(agents, nil, nil, 0, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := &memoryStore{deployments: map[string]*Deployment{}}
+			r := chi.NewRouter()
+			NewHandler(store, server, zap.NewNop()).RegisterRoutes(r)
+
+			body := `{"name": "prod", "config": "` + base64.StdEncoding.EncodeToString([]byte(tt.config)) + `"}`
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/deployment-1", strings.NewReader(body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if _, stored := store.deployments["deployment-1"]; stored != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("deployment stored = %v, want only valid configs to be stored", stored)
+			}
+		})
+	}
+}
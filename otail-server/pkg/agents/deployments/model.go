@@ -13,6 +13,7 @@ import (
 type Deployment struct {
 	ID        string    `bson:"_id" json:"id"`
 	Name      string    `bson:"name" json:"name"`
+	Config    []byte    `bson:"config" json:"config"`
 	GroupIDs  []string  `bson:"group_ids" json:"group_ids"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
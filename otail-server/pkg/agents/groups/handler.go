@@ -1,22 +1,37 @@
 package groups
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
 	"go.uber.org/zap"
 )
 
+// ConfigPublisher validates and pushes the config of a group to the group's
+// agents
+type ConfigPublisher interface {
+	ValidateGroupConfig(groupID string, config []byte) error
+	UpdateGroupConfig(groupID string, config []byte)
+}
+
 type Handler struct {
-	store  Store
-	logger *zap.Logger
+	store     Store
+	publisher ConfigPublisher
+	revisions *revisions.Service
+	logger    *zap.Logger
 }
 
-func NewHandler(store Store, logger *zap.Logger) *Handler {
+func NewHandler(store Store, publisher ConfigPublisher, revisions *revisions.Service, logger *zap.Logger) *Handler {
 	return &Handler{
-		store:  store,
-		logger: logger,
+		store:     store,
+		publisher: publisher,
+		revisions: revisions,
+		logger:    logger,
 	}
 }
 
@@ -79,12 +94,54 @@ func (h *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	group.ID = id
+
+	stored, err := h.store.Get(r.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to get group", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to update group")
+		return
+	}
+	if stored == nil {
+		h.writeError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	configChanged := !bytes.Equal(stored.Config, group.Config)
+
+	// Reject a config the group's agents could not run before it is stored
+	if configChanged {
+		var validationErr *validation.Error
+		if err := h.publisher.ValidateGroupConfig(group.ID, group.Config); errors.As(err, &validationErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":  "Invalid configuration",
+				"errors": validationErr.Errors,
+			})
+			return
+		} else if err != nil {
+			h.logger.Error("Failed to validate group config", zap.Error(err))
+			h.writeError(w, http.StatusInternalServerError, "Failed to update group")
+			return
+		}
+	}
+
 	if err := h.store.Update(r.Context(), &group); err != nil {
 		h.logger.Error("Failed to update group", zap.Error(err))
 		h.writeError(w, http.StatusInternalServerError, "Failed to update group")
 		return
 	}
 
+	// Push the group config to the group's agents and record it, unless only
+	// the other fields of the group changed
+	if configChanged {
+		h.publisher.UpdateGroupConfig(group.ID, group.Config)
+
+		_, err := h.revisions.Record(r.Context(), revisions.TargetGroup, group.ID, string(group.Config), revisions.ChangeFromRequest(r))
+		if err != nil {
+			h.logger.Error("Failed to record group config revision", zap.Error(err))
+		}
+	}
+
 	h.writeJSON(w, group)
 }
 
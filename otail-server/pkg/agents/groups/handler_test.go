@@ -0,0 +1,142 @@
+package groups
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"go.uber.org/zap"
+)
+
+// memoryStore is an in-memory Store of the groups the tests use
+type memoryStore struct {
+	Store
+	groups map[string]*AgentGroup
+}
+
+func (m *memoryStore) Get(ctx context.Context, id string) (*AgentGroup, error) {
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *group
+	return &found, nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, group *AgentGroup) error {
+	stored := *group
+	m.groups[group.ID] = &stored
+	return nil
+}
+
+func newTestHandler(t *testing.T) (http.Handler, *memoryStore, *revisions.MemoryStore) {
+	t.Helper()
+	server, err := opamp.NewServer(opamp.NewAgents(zap.NewNop()), nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{groups: map[string]*AgentGroup{"group-1": {ID: "group-1", Name: "edge"}}}
+	revisionStore := revisions.NewMemoryStore()
+	revisionService := revisions.NewService(revisionStore, nil, nil, zap.NewNop())
+
+	r := chi.NewRouter()
+	NewHandler(store, server, revisionService, zap.NewNop()).RegisterRoutes(r)
+	return r, store, revisionStore
+}
+
+func TestUpdateGroupValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		wantStatus int
+	}{
+		{
+			name:       "valid shared config",
+			config:     "processors:\n  tail_sampling:\n    policies:\n      - name: all\n        type: always_sample\n",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid policy",
+			config:     "processors:\n  tail_sampling:\n    policies:\n      - name: all\n        type: sometimes_sample\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid YAML",
+			config:     "processors: [",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, store, _ := newTestHandler(t)
+
+			body := `{"name": "edge", "config": "` + base64.StdEncoding.EncodeToString([]byte(tt.config)) + `"}`
+			req := httptest.NewRequest(http.MethodPut, "/group-1", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if stored := string(store.groups["group-1"].Config) == tt.config; stored != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("config stored = %v, want only valid configs to be stored", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateGroupRecordsConfigChanges(t *testing.T) {
+	handler, store, revisionStore := newTestHandler(t)
+	update := func(name, config string) {
+		t.Helper()
+		body := `{"name": "` + name + `", "config": "` + base64.StdEncoding.EncodeToString([]byte(config)) + `"}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/group-1", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	countRevisions := func() int {
+		t.Helper()
+		list, err := revisionStore.List(context.Background(), revisions.TargetGroup, "group-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list)
+	}
+
+	config := "processors:\n  batch: {}\n"
+	update("edge", config)
+	if n := countRevisions(); n != 1 {
+		t.Fatalf("revisions after changing the config = %d, want 1", n)
+	}
+
+	// Renaming the group keeps its config
+	update("edge-eu", config)
+	if n := countRevisions(); n != 1 {
+		t.Errorf("revisions after renaming = %d, want 1", n)
+	}
+	if name := store.groups["group-1"].Name; name != "edge-eu" {
+		t.Errorf("name = %q, want %q", name, "edge-eu")
+	}
+
+	update("edge-eu", "processors:\n  batch:\n    timeout: 5s\n")
+	if n := countRevisions(); n != 2 {
+		t.Errorf("revisions after changing the config again = %d, want 2", n)
+	}
+}
+
+func TestUpdateUnknownGroup(t *testing.T) {
+	handler, _, _ := newTestHandler(t)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/group-2", strings.NewReader(`{"name": "edge"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -23,6 +23,7 @@ type AgentGroup struct {
 type Store interface {
 	Create(ctx context.Context, group *AgentGroup) error
 	Get(ctx context.Context, id string) (*AgentGroup, error)
+	GetByID(ctx context.Context, id string) (*AgentGroup, error)
 	List(ctx context.Context, deploymentID string) ([]*AgentGroup, error)
 	Update(ctx context.Context, group *AgentGroup) error
 	Delete(ctx context.Context, id string) error
@@ -63,6 +64,18 @@ func (s *MongoStore) Get(ctx context.Context, name string) (*AgentGroup, error)
 	return &group, nil
 }
 
+func (s *MongoStore) GetByID(ctx context.Context, id string) (*AgentGroup, error) {
+	var group AgentGroup
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&group)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
 func (s *MongoStore) List(ctx context.Context, deploymentID string) ([]*AgentGroup, error) {
 	filter := bson.M{}
 	if deploymentID != "" {
@@ -0,0 +1,33 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+)
+
+// ApplyRevision returns the function the revisions service rolls groups back
+// with. The config of the revision is validated against the group's agents,
+// stored and pushed to them like an update of the group.
+func ApplyRevision(store Store, publisher ConfigPublisher) revisions.ApplyFunc {
+	return func(ctx context.Context, groupID, file, config string) error {
+		group, err := store.GetByID(ctx, groupID)
+		if err != nil {
+			return fmt.Errorf("failed to get agent group: %w", err)
+		}
+		if group == nil {
+			return fmt.Errorf("agent group %s not found", groupID)
+		}
+
+		if err := publisher.ValidateGroupConfig(group.ID, []byte(config)); err != nil {
+			return err
+		}
+		group.Config = []byte(config)
+		if err := store.Update(ctx, group); err != nil {
+			return fmt.Errorf("failed to update agent group: %w", err)
+		}
+		publisher.UpdateGroupConfig(group.ID, group.Config)
+		return nil
+	}
+}
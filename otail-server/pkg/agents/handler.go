@@ -2,6 +2,7 @@ package agents
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -9,7 +10,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
 	"github.com/mottibec/otail-server/pkg/agents/tailsampling"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
 	"github.com/mottibec/otail-server/pkg/auth"
 	"go.uber.org/zap"
 )
@@ -37,18 +41,59 @@ func NewHandler(logger *zap.Logger, samplingService *tailsampling.Service, click
 // SetupRoutes configures the HTTP routes
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Get("/", h.ListAgents)
+	r.Get("/config-failures", h.GetConfigFailures)
 	r.Get("/{agentId}/config", h.GetConfig)
 	r.Put("/{agentId}/config", h.UpdateConfig)
+	r.Patch("/{agentId}/config/tail-sampling", h.UpdateTailSampling)
+	r.Put("/{agentId}/config/tail-sampling/policies/{name}", h.UpsertPolicy)
+	r.Get("/{agentId}/config/files", h.GetConfigFiles)
+	r.Put("/{agentId}/config/files/{name}", h.UpdateConfigFile)
+	r.Delete("/{agentId}/config/files/{name}", h.DeleteConfigFile)
 	r.Get("/{agentId}/logs", h.GetLogs)
+	r.Get("/{agentId}/logs/search", h.SearchLogs)
+	r.Get("/{agentId}/logs/histogram", h.GetLogHistogram)
+	r.Get("/{agentId}/logs/stream", h.StreamLogs)
+	r.Get("/{agentId}/metrics", h.ListMetrics)
+	r.Get("/{agentId}/metrics/{name}", h.GetMetric)
 	r.Get("/groups/{groupId}", h.GetAgentsByGroup)
 }
 
 func (h *Handler) ListAgents(w http.ResponseWriter, r *http.Request) {
 	organizationID := r.Context().Value(auth.OrganizationIDKey).(string)
 	agents := h.samplingService.GetAgentsByOrganization(organizationID)
+
+	// Optionally filter by status: online, offline or an exact connection state
+	status := r.URL.Query().Get("status")
+	if status != "" {
+		var keep func(agent *opamp.Agent) bool
+		switch status {
+		case "online":
+			keep = func(agent *opamp.Agent) bool { return agent.IsOnline() }
+		case "offline":
+			keep = func(agent *opamp.Agent) bool { return !agent.IsOnline() }
+		case string(opamp.ConnectionStateConnected), string(opamp.ConnectionStateDisconnected), string(opamp.ConnectionStateStale):
+			keep = func(agent *opamp.Agent) bool { return agent.ConnectionState == opamp.ConnectionState(status) }
+		default:
+			h.writeError(w, http.StatusBadRequest, "Invalid status filter")
+			return
+		}
+
+		for id, agent := range agents {
+			if !keep(agent) {
+				delete(agents, id)
+			}
+		}
+	}
+
 	h.writeJSON(w, agents)
 }
 
+// GetConfigFailures lists the agents whose current remote config failed to apply
+func (h *Handler) GetConfigFailures(w http.ResponseWriter, r *http.Request) {
+	organizationID := r.Context().Value(auth.OrganizationIDKey).(string)
+	h.writeJSON(w, h.samplingService.GetConfigFailures(organizationID))
+}
+
 func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
 	vars := chi.URLParam(r, "agentId")
 	agentID := vars
@@ -83,17 +128,155 @@ func (h *Handler) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.samplingService.UpdateConfig(instanceID, config); err != nil {
-		h.logger.Error("Failed to update configuration", zap.Error(err))
-		h.writeError(w, http.StatusInternalServerError, "Failed to update configuration")
+	if err := h.samplingService.UpdateConfig(r.Context(), instanceID, config, revisions.ChangeFromRequest(r)); err != nil {
+		h.writeConfigUpdateError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateTailSampling merges a tail_sampling processor block into the agent's
+// effective config
+func (h *Handler) UpdateTailSampling(w http.ResponseWriter, r *http.Request) {
+	instanceID, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return
+	}
+
+	var block map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.samplingService.UpdateTailSampling(r.Context(), instanceID, block, revisions.ChangeFromRequest(r)); err != nil {
+		h.writeConfigUpdateError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpsertPolicy adds or replaces a single tail sampling policy of the agent
+func (h *Handler) UpsertPolicy(w http.ResponseWriter, r *http.Request) {
+	instanceID, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return
+	}
+
+	var policy map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if policyName, ok := policy["name"]; ok && policyName != name {
+		h.writeError(w, http.StatusBadRequest, "Policy name does not match the URL")
+		return
+	}
+	policy["name"] = name
+
+	if err := h.samplingService.UpsertPolicy(r.Context(), instanceID, policy, revisions.ChangeFromRequest(r)); err != nil {
+		h.writeConfigUpdateError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetConfigFiles(w http.ResponseWriter, r *http.Request) {
+	instanceID, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return
+	}
+
+	files, err := h.samplingService.GetConfigFiles(instanceID)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	h.writeJSON(w, files)
+}
+
+func (h *Handler) UpdateConfigFile(w http.ResponseWriter, r *http.Request) {
+	instanceID, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return
+	}
+
+	var config map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.samplingService.UpdateConfigFile(r.Context(), instanceID, chi.URLParam(r, "name"), config, revisions.ChangeFromRequest(r)); err != nil {
+		h.writeConfigUpdateError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) DeleteConfigFile(w http.ResponseWriter, r *http.Request) {
+	instanceID, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return
+	}
+
+	if err := h.samplingService.DeleteConfigFile(r.Context(), instanceID, chi.URLParam(r, "name"), revisions.ChangeFromRequest(r)); err != nil {
+		h.writeConfigUpdateError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeConfigUpdateError maps the errors of pushing a config to an agent to
+// HTTP status codes
+func (h *Handler) writeConfigUpdateError(w http.ResponseWriter, err error) {
+	var applyErr *tailsampling.ConfigApplyError
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeValidationError(w, validationErr)
+	case errors.Is(err, opamp.ErrInvalidConfigFileName):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tailsampling.ErrPolicyNameRequired):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tailsampling.ErrNoEffectiveConfig):
+		h.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, opamp.ErrConfigFileNotFound):
+		h.writeError(w, http.StatusNotFound, "Config file not found")
+	case errors.Is(err, tailsampling.ErrAgentNotFound):
+		h.writeError(w, http.StatusNotFound, "Agent not found")
+	case errors.As(err, &applyErr):
+		h.writeError(w, http.StatusUnprocessableEntity, applyErr.Error())
+	case errors.Is(err, tailsampling.ErrConfigPending):
+		// The config is stored and will be applied once the agent reports back
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending", "message": err.Error()})
+	default:
+		h.logger.Error("Failed to update configuration", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to update configuration")
+	}
+}
+
 func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	agentId := chi.URLParam(r, "agentId")
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+		return
+	}
 
 	// Parse query parameters
 	startTimeStr := r.URL.Query().Get("start_time")
@@ -115,6 +298,10 @@ func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logs, err := h.clickhouse.QueryLogs(r.Context(), agentId, startTime, endTime, limit)
+	if errors.Is(err, clickhouse.ErrUnavailable) {
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+		return
+	}
 	if err != nil {
 		h.writeError(w, http.StatusInternalServerError, "Failed to query logs")
 		return
@@ -138,6 +325,16 @@ func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// writeValidationError writes the field errors of a rejected config
+func (h *Handler) writeValidationError(w http.ResponseWriter, err *validation.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Invalid configuration",
+		"errors": err.Errors,
+	})
+}
+
 // writeError writes an error response
 func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
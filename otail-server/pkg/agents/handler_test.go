@@ -0,0 +1,109 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/tailsampling"
+	"github.com/mottibec/otail-server/pkg/auth"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// testConnection is an OpAMP connection that discards what is sent
+type testConnection struct{}
+
+func (c *testConnection) Connection() net.Conn                                         { return nil }
+func (c *testConnection) Send(ctx context.Context, msg *protobufs.ServerToAgent) error { return nil }
+func (c *testConnection) Disconnect() error                                            { return nil }
+
+// newTestHandler returns the routes of a handler without ClickHouse and the
+// agents they serve
+func newTestHandler(t *testing.T) (http.Handler, *opamp.Agents) {
+	t.Helper()
+	agents := opamp.NewAgents(zap.NewNop())
+	server, err := opamp.NewServer(agents, nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	NewHandler(zap.NewNop(), tailsampling.NewService(zap.NewNop(), server, nil, nil), nil).RegisterRoutes(r)
+	return r, agents
+}
+
+// connectAgent registers a new reporting agent of the organization
+func connectAgent(t *testing.T, agents *opamp.Agents, orgID string) (uuid.UUID, *testConnection) {
+	t.Helper()
+	agentId := uuid.New()
+	conn := &testConnection{}
+	agent, err := agents.FindOrCreateAgent(agentId, conn, orgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents.SetConnection(conn, orgID, "", "")
+	agent.UpdateStatus(&protobufs.AgentToServer{InstanceUid: agentId[:]}, &protobufs.ServerToAgent{})
+	return agentId, conn
+}
+
+// get serves a GET request of org-1
+func get(handler http.Handler, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req = req.WithContext(context.WithValue(req.Context(), auth.OrganizationIDKey, "org-1"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestListAgentsByStatus(t *testing.T) {
+	handler, agents := newTestHandler(t)
+	connectedId, _ := connectAgent(t, agents, "org-1")
+	disconnectedId, conn := connectAgent(t, agents, "org-1")
+	agents.RemoveConnection(conn)
+	staleId, _ := connectAgent(t, agents, "org-1")
+	agents.FindAgent(staleId).LastSeen = time.Now().Add(-time.Hour)
+	agents.MarkStaleAgents(time.Minute)
+	connectAgent(t, agents, "org-2")
+
+	tests := []struct {
+		status string
+		want   []uuid.UUID
+	}{
+		{status: "", want: []uuid.UUID{connectedId, disconnectedId, staleId}},
+		{status: "online", want: []uuid.UUID{connectedId}},
+		{status: "offline", want: []uuid.UUID{disconnectedId, staleId}},
+		{status: "disconnected", want: []uuid.UUID{disconnectedId}},
+		{status: "stale", want: []uuid.UUID{staleId}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			rec := get(handler, "/?status="+tt.status)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var listed map[uuid.UUID]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != len(tt.want) {
+				t.Errorf("listed %d agents, want %d", len(listed), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if _, ok := listed[id]; !ok {
+					t.Errorf("agent %v is not listed", id)
+				}
+			}
+		})
+	}
+
+	if rec := get(handler, "/?status=sleeping"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status of an unknown filter = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
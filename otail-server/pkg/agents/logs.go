@@ -0,0 +1,293 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"go.uber.org/zap"
+)
+
+// SearchLogs returns a page of the agent's log records, newest first, with
+// the number of records matching the filters. Query parameters:
+//
+//	start_time, end_time   RFC3339 time range, the last hour by default
+//	severity               lowest severity, e.g. WARN or 13
+//	max_severity           highest severity, e.g. WARN up to WARN4
+//	q                      text the body must contain, ignoring case
+//	regex                  RE2 regular expression the body must match
+//	resource_attribute     key=value resource attribute, can be repeated
+//	attribute              key=value log attribute, can be repeated
+//	trace_id               hex trace ID
+//	limit                  page size, 100 by default and at most 1000
+//	cursor                 nextCursor of the previous page
+func (h *Handler) SearchLogs(w http.ResponseWriter, r *http.Request) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+		return
+	}
+
+	params := r.URL.Query()
+	filter, err := parseLogFilter(chi.URLParam(r, "agentId"), params)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := clickhouse.LogQuery{LogFilter: filter, Cursor: params.Get("cursor")}
+	if v := params.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit <= 0 || query.Limit > 1000 {
+			h.writeError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+	}
+
+	page, err := h.clickhouse.SearchLogs(r.Context(), query)
+	switch {
+	case errors.Is(err, clickhouse.ErrInvalidCursor),
+		errors.Is(err, clickhouse.ErrInvalidTraceID),
+		errors.Is(err, clickhouse.ErrInvalidRegex):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, clickhouse.ErrUnavailable):
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+	case err != nil:
+		h.logger.Error("Failed to search logs", zap.String("agent_id", filter.InstanceID), zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to search logs")
+	default:
+		h.writeJSON(w, page)
+	}
+}
+
+// parseLogFilter reads the filter parameters of the log search
+func parseLogFilter(agentId string, params url.Values) (clickhouse.LogFilter, error) {
+	filter := clickhouse.LogFilter{
+		InstanceID: agentId,
+		Contains:   params.Get("q"),
+		Regex:      params.Get("regex"),
+		TraceID:    params.Get("trace_id"),
+	}
+
+	var err error
+	if filter.Start, filter.End, err = parseTimeRange(params); err != nil {
+		return filter, err
+	}
+	if v := params.Get("severity"); v != "" {
+		if filter.MinSeverity, err = clickhouse.ParseSeverity(v); err != nil {
+			return filter, err
+		}
+	}
+	if v := params.Get("max_severity"); v != "" {
+		if filter.MaxSeverity, err = clickhouse.ParseMaxSeverity(v); err != nil {
+			return filter, err
+		}
+	}
+	if filter.ResourceAttributes, err = parseAttributes(params["resource_attribute"]); err != nil {
+		return filter, err
+	}
+	if filter.LogAttributes, err = parseAttributes(params["attribute"]); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseAttributes parses key=value attribute filters
+func parseAttributes(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	attributes := make(map[string]string, len(values))
+	for _, attribute := range values {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid attribute %q, must be key=value", attribute)
+		}
+		attributes[key] = value
+	}
+	return attributes, nil
+}
+
+// GetLogHistogram returns the number of the agent's log records per time
+// bucket, for the groups with the most records. Query parameters:
+//
+//	group_by        severity, scope or attribute, severity by default
+//	attribute_key   log attribute grouped by with group_by=attribute
+//	step            width of the time buckets, e.g. 1m
+//	top             number of groups returned, 10 by default and at most 100
+//
+// and the filters of SearchLogs, except limit and cursor.
+func (h *Handler) GetLogHistogram(w http.ResponseWriter, r *http.Request) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+		return
+	}
+
+	params := r.URL.Query()
+	filter, err := parseLogFilter(chi.URLParam(r, "agentId"), params)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := clickhouse.LogHistogramQuery{
+		LogFilter:    filter,
+		GroupBy:      clickhouse.LogGroupBy(params.Get("group_by")),
+		AttributeKey: params.Get("attribute_key"),
+	}
+	if query.GroupBy == "" {
+		query.GroupBy = clickhouse.LogGroupBySeverity
+	}
+	if v := params.Get("step"); v != "" {
+		if query.Step, err = time.ParseDuration(v); err != nil || query.Step <= 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid step %q", v))
+			return
+		}
+	}
+	if v := params.Get("top"); v != "" {
+		if query.Top, err = strconv.Atoi(v); err != nil || query.Top <= 0 || query.Top > 100 {
+			h.writeError(w, http.StatusBadRequest, "top must be between 1 and 100")
+			return
+		}
+	}
+
+	histogram, err := h.clickhouse.AggregateLogs(r.Context(), query)
+	switch {
+	case errors.Is(err, clickhouse.ErrInvalidGroupBy),
+		errors.Is(err, clickhouse.ErrTooManyPoints),
+		errors.Is(err, clickhouse.ErrInvalidTraceID),
+		errors.Is(err, clickhouse.ErrInvalidRegex):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, clickhouse.ErrUnavailable):
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+	case err != nil:
+		h.logger.Error("Failed to aggregate logs", zap.String("agent_id", filter.InstanceID), zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to aggregate logs")
+	default:
+		h.writeJSON(w, histogram)
+	}
+}
+
+const (
+	// logStreamWriteTimeout is how long a batch may take to be written to the
+	// client. Slower clients are disconnected.
+	logStreamWriteTimeout = 10 * time.Second
+	// logStreamPingInterval is how often the connection is pinged, so idle
+	// streams are not closed by proxies
+	logStreamPingInterval = 30 * time.Second
+)
+
+// logStreamMessage is a message sent to log stream clients, either a batch
+// of new log records or the error that ended the stream
+type logStreamMessage struct {
+	Logs  []clickhouse.LogEntry `json:"logs,omitempty"`
+	Error string                `json:"error,omitempty"`
+}
+
+// StreamLogs upgrades to a WebSocket and pushes the new log records of the
+// agent as they are written. Query parameters:
+//
+//	severity       lowest severity streamed, e.g. WARN or 13
+//	max_severity   highest severity streamed, e.g. WARN up to WARN4
+//	q              text the log body must contain, ignoring case
+//	since          RFC3339 time the stream starts from, now by default
+//
+// New records are polled for while the client keeps up. A client that does
+// not read its messages within the write timeout is disconnected.
+func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "log storage is not available")
+		return
+	}
+
+	params := r.URL.Query()
+	query := clickhouse.LogStreamQuery{
+		InstanceID: chi.URLParam(r, "agentId"),
+		Contains:   params.Get("q"),
+	}
+	if v := params.Get("severity"); v != "" {
+		severity, err := clickhouse.ParseSeverity(v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		query.MinSeverity = severity
+	}
+	if v := params.Get("max_severity"); v != "" {
+		severity, err := clickhouse.ParseMaxSeverity(v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		query.MaxSeverity = severity
+	}
+	if v := params.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+			return
+		}
+		query.Since = since
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		h.logger.Debug("Failed to upgrade log stream", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Clients don't send messages, but reading processes the control frames
+	// and notices when the client goes away
+	conn.SetReadLimit(512)
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	batches, errs := h.clickhouse.StreamLogs(ctx, query)
+	ping := time.NewTicker(logStreamPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteTimeout)); err != nil {
+				return
+			}
+		case batch, ok := <-batches:
+			if !ok {
+				closeCode, reason := websocket.CloseNormalClosure, ""
+				if err := <-errs; err != nil {
+					conn.SetWriteDeadline(time.Now().Add(logStreamWriteTimeout))
+					conn.WriteJSON(logStreamMessage{Error: "Failed to query logs"})
+					closeCode, reason = websocket.CloseInternalServerErr, "failed to query logs"
+				}
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(time.Second))
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(logStreamWriteTimeout))
+			if err := conn.WriteJSON(logStreamMessage{Logs: batch}); err != nil {
+				h.logger.Info("Closing log stream of slow or disconnected client",
+					zap.String("agent_id", query.InstanceID),
+					zap.Error(err))
+				return
+			}
+		}
+	}
+}
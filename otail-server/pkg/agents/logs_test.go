@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamLogsStatus(t *testing.T) {
+	withoutClickHouse := newStorageHandler(nil)
+	unavailable := newStorageHandler(unavailableClient(t))
+	const agent = "/7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		target     string
+		wantStatus int
+	}{
+		{name: "without ClickHouse", handler: withoutClickHouse, target: agent + "/logs/stream", wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid severity", handler: unavailable, target: agent + "/logs/stream?severity=LOUD", wantStatus: http.StatusBadRequest},
+		{name: "invalid max severity", handler: unavailable, target: agent + "/logs/stream?max_severity=99", wantStatus: http.StatusBadRequest},
+		{name: "invalid since", handler: unavailable, target: agent + "/logs/stream?since=yesterday", wantStatus: http.StatusBadRequest},
+		// The upgrader rejects requests that are not WebSocket handshakes
+		{name: "not a WebSocket", handler: unavailable, target: agent + "/logs/stream", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := get(tt.handler, tt.target); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestStreamLogsWhileUnavailable(t *testing.T) {
+	server := httptest.NewServer(newStorageHandler(unavailableClient(t)))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/7c9e6679-7425-40de-944b-e07fc1f90ae7/logs/stream?severity=WARN"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// The stream waits for the storage to come back instead of ending
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, message, err := conn.ReadMessage()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("ReadMessage() = %s, %v, want no message before the deadline", message, err)
+	}
+}
+
+func TestLogHistogramStatus(t *testing.T) {
+	withoutClickHouse := newStorageHandler(nil)
+	unavailable := newStorageHandler(unavailableClient(t))
+	const histogram = "/7c9e6679-7425-40de-944b-e07fc1f90ae7/logs/histogram"
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		params     string
+		wantStatus int
+	}{
+		{name: "without ClickHouse", handler: withoutClickHouse, wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid time range", handler: unavailable, params: "?start_time=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid severity", handler: unavailable, params: "?severity=LOUD", wantStatus: http.StatusBadRequest},
+		{name: "invalid attribute", handler: unavailable, params: "?attribute=env", wantStatus: http.StatusBadRequest},
+		{name: "invalid step", handler: unavailable, params: "?step=0s", wantStatus: http.StatusBadRequest},
+		{name: "top too large", handler: unavailable, params: "?top=101", wantStatus: http.StatusBadRequest},
+		{name: "invalid group by", handler: unavailable, params: "?group_by=body", wantStatus: http.StatusBadRequest},
+		{name: "attribute without key", handler: unavailable, params: "?group_by=attribute", wantStatus: http.StatusBadRequest},
+		{
+			name:       "too many points",
+			handler:    unavailable,
+			params:     "?start_time=2024-01-01T00:00:00Z&end_time=2024-02-01T00:00:00Z&step=1s",
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "invalid regex", handler: unavailable, params: "?regex=%28", wantStatus: http.StatusBadRequest},
+		{name: "unavailable", handler: unavailable, params: "?group_by=attribute&attribute_key=http.route&top=5", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := get(tt.handler, histogram+tt.params); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
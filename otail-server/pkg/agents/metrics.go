@@ -0,0 +1,130 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"go.uber.org/zap"
+)
+
+// ListMetrics returns the metrics the agent reported about itself in the
+// time range given by start_time and end_time, the last hour by default
+func (h *Handler) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	agentId, ok := h.metricsAgentID(w, r)
+	if !ok {
+		return
+	}
+	start, end, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, err := h.clickhouse.ListMetrics(r.Context(), agentId.String(), start, end)
+	if errors.Is(err, clickhouse.ErrUnavailable) {
+		h.writeError(w, http.StatusServiceUnavailable, "metric storage is not available")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to list metrics", zap.String("agent_id", agentId.String()), zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to list metrics")
+		return
+	}
+	h.writeJSON(w, metrics)
+}
+
+// GetMetric returns the series of a metric the agent reported about itself,
+// e.g. otelcol_receiver_accepted_spans. Query parameters:
+//
+//	type          gauge, sum or histogram, required
+//	start_time    RFC3339, an hour before end_time by default
+//	end_time      RFC3339, now by default
+//	step          width of the time buckets, e.g. 1m
+//	aggregation   avg, min, max, sum, last or rate, avg by default
+//	field         aggregated value of histograms: count, sum or mean
+func (h *Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
+	agentId, ok := h.metricsAgentID(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	start, end, err := parseTimeRange(params)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := clickhouse.MetricQuery{
+		InstanceID:  agentId.String(),
+		MetricName:  chi.URLParam(r, "name"),
+		Type:        clickhouse.MetricType(params.Get("type")),
+		Start:       start,
+		End:         end,
+		Aggregation: clickhouse.Aggregation(params.Get("aggregation")),
+		Field:       clickhouse.HistogramField(params.Get("field")),
+	}
+	if v := params.Get("step"); v != "" {
+		if query.Step, err = time.ParseDuration(v); err != nil || query.Step <= 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid step %q", v))
+			return
+		}
+	}
+
+	series, err := h.clickhouse.QueryMetrics(r.Context(), query)
+	switch {
+	case errors.Is(err, clickhouse.ErrInvalidMetricType),
+		errors.Is(err, clickhouse.ErrInvalidAggregation),
+		errors.Is(err, clickhouse.ErrInvalidField),
+		errors.Is(err, clickhouse.ErrTooManyPoints):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, clickhouse.ErrUnavailable):
+		h.writeError(w, http.StatusServiceUnavailable, "metric storage is not available")
+	case err != nil:
+		h.logger.Error("Failed to query metrics", zap.String("agent_id", agentId.String()), zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to query metrics")
+	default:
+		h.writeJSON(w, series)
+	}
+}
+
+// metricsAgentID parses the agent ID of a metrics request and checks that
+// metrics can be queried, writing the error response if not
+func (h *Handler) metricsAgentID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "metric storage is not available")
+		return uuid.Nil, false
+	}
+	agentId, err := uuid.Parse(chi.URLParam(r, "agentId"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid agent ID")
+		return uuid.Nil, false
+	}
+	return agentId, true
+}
+
+// parseTimeRange reads the start_time and end_time parameters, the last hour
+// by default
+func parseTimeRange(params url.Values) (start, end time.Time, err error) {
+	end = time.Now()
+	if v := params.Get("end_time"); v != "" {
+		if end, err = time.Parse(time.RFC3339, v); err != nil {
+			return start, end, fmt.Errorf("invalid end_time: %w", err)
+		}
+	}
+	start = end.Add(-time.Hour)
+	if v := params.Get("start_time"); v != "" {
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			return start, end, fmt.Errorf("invalid start_time: %w", err)
+		}
+	}
+	if !end.After(start) {
+		return start, end, errors.New("end_time must be after start_time")
+	}
+	return start, end, nil
+}
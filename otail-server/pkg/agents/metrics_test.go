@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"go.uber.org/zap"
+)
+
+// unavailableClient returns a client of a ClickHouse that can't be reached
+func unavailableClient(t *testing.T) *clickhouse.Client {
+	t.Helper()
+	client, err := clickhouse.NewClient("clickhouse://127.0.0.1:1/default?dial_timeout=100ms", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newStorageHandler returns the routes of a handler that only serves the
+// logs and metrics stored in ClickHouse
+func newStorageHandler(client *clickhouse.Client) http.Handler {
+	r := chi.NewRouter()
+	NewHandler(zap.NewNop(), nil, client).RegisterRoutes(r)
+	return r
+}
+
+func TestParseTimeRange(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+
+	tests := []struct {
+		name      string
+		params    string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   bool
+	}{
+		{name: "range", params: "start_time=2024-05-01T10:00:00Z&end_time=2024-05-01T10:30:00Z", wantStart: start, wantEnd: end},
+		{name: "hour before end", params: "end_time=2024-05-01T10:30:00Z", wantStart: end.Add(-time.Hour), wantEnd: end},
+		{name: "invalid start", params: "start_time=yesterday", wantErr: true},
+		{name: "invalid end", params: "end_time=1714557600", wantErr: true},
+		{name: "end before start", params: "start_time=2024-05-01T10:30:00Z&end_time=2024-05-01T10:00:00Z", wantErr: true},
+		{name: "empty range", params: "start_time=2024-05-01T10:00:00Z&end_time=2024-05-01T10:00:00Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, end, err := parseTimeRange(params)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTimeRange(%q) = %v, %v, want an error", tt.params, start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimeRange(%q) = %v", tt.params, err)
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("parseTimeRange(%q) = %v, %v, want %v, %v", tt.params, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+
+	// The last hour by default
+	start, end, err := parseTimeRange(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end.Sub(start) != time.Hour || time.Since(end) > time.Minute {
+		t.Errorf("parseTimeRange() = %v, %v, want the last hour", start, end)
+	}
+}
+
+func TestMetricsHandlerStatus(t *testing.T) {
+	withoutClickHouse := newStorageHandler(nil)
+	unavailable := newStorageHandler(unavailableClient(t))
+	const agent = "/7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		target     string
+		wantStatus int
+	}{
+		{name: "list without ClickHouse", handler: withoutClickHouse, target: agent + "/metrics", wantStatus: http.StatusServiceUnavailable},
+		{name: "metric without ClickHouse", handler: withoutClickHouse, target: agent + "/metrics/up?type=gauge", wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid agent ID", handler: unavailable, target: "/agent-1/metrics", wantStatus: http.StatusBadRequest},
+		{name: "invalid time range", handler: unavailable, target: agent + "/metrics?start_time=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid step", handler: unavailable, target: agent + "/metrics/up?type=gauge&step=-1m", wantStatus: http.StatusBadRequest},
+		{name: "no type", handler: unavailable, target: agent + "/metrics/up", wantStatus: http.StatusBadRequest},
+		{name: "invalid aggregation", handler: unavailable, target: agent + "/metrics/up?type=gauge&aggregation=median", wantStatus: http.StatusBadRequest},
+		{name: "invalid field", handler: unavailable, target: agent + "/metrics/up?type=histogram&field=p99", wantStatus: http.StatusBadRequest},
+		{
+			name:       "too many points",
+			handler:    unavailable,
+			target:     agent + "/metrics/up?type=sum&start_time=2024-01-01T00:00:00Z&end_time=2024-02-01T00:00:00Z&step=1s",
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "list while unavailable", handler: unavailable, target: agent + "/metrics", wantStatus: http.StatusServiceUnavailable},
+		{name: "metric while unavailable", handler: unavailable, target: agent + "/metrics/up?type=histogram&field=sum&aggregation=rate", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := get(tt.handler, tt.target); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
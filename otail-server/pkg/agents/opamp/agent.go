@@ -8,16 +8,53 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/registry"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server/types"
 )
 
+// ConnectionState describes whether an Agent is currently reporting to the server.
+type ConnectionState string
+
+const (
+	// The Agent has an open connection and reports within the heartbeat timeout.
+	ConnectionStateConnected ConnectionState = "connected"
+	// The Agent closed its connection or was never seen since the server started.
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+	// The Agent connection is open but the Agent stopped reporting.
+	ConnectionStateStale ConnectionState = "stale"
+)
+
+// Names of the config files of the Agent's remote config that carry the config
+// shared by a deployment or a group, and the instance config. The supervisor
+// merges the files of the remote config in name order, so the shared configs
+// start with a character that sorts before any named config file of the Agent
+// and the instance config with one that sorts after, see ConfigFileOrder.
+const (
+	DeploymentConfigName = ".deployment"
+	GroupConfigName      = ".group"
+	InstanceConfigName   = "~instance"
+)
+
+// ConfigContentType is the content type of the config files sent to Agents.
+const ConfigContentType = "text/yaml"
+
+// Reasons recorded when an Agent stops being connected.
+const (
+	DisconnectReasonConnectionClosed = "connection closed"
+	DisconnectReasonAgentShutdown    = "agent shutdown"
+	DisconnectReasonHeartbeatTimeout = "heartbeat timeout"
+	DisconnectReasonServerRestart    = "server restart"
+)
+
 // Agent represents a connected Agent.
 type Agent struct {
 	// Some fields in this struct are exported so that we can render them in the UI.
@@ -29,33 +66,63 @@ type Agent struct {
 	// User ID of the Agent.
 	UserID string
 
-	// Connection to the Agent.
-	conn types.Connection
-
 	// mutex for the fields that follow it.
 	mux sync.RWMutex
 
+	// Connection to the Agent. Replaced when the Agent reconnects.
+	conn types.Connection
+
 	// Agent's current status.
 	Status *protobufs.AgentToServer
 
 	// The time when the agent has started. Valid only if Status.Health.Up==true
 	StartedAt time.Time
 
-	// Effective config reported by the Agent.
-	EffectiveConfig string
+	// The time when the last status report was received from the agent.
+	LastSeen time.Time
+
+	// Whether the Agent is currently reporting, and why it stopped if it is not.
+	ConnectionState  ConnectionState
+	DisconnectReason string
+
+	// Effective config reported by the Agent, as the set of config files and
+	// as a single document for displaying purposes.
+	EffectiveConfigFiles map[string]string
+	EffectiveConfig      string
 
 	// Optional special remote config for this particular instance defined by
 	// the user in the UI.
 	CustomInstanceConfig string
 
+	// Optional named config files for this particular instance, e.g. base.yaml
+	// or tail_sampling.yaml. They are merged in name order after the deployment
+	// and group configs and before CustomInstanceConfig.
+	CustomConfigFiles map[string]string
+
+	// Outcome reported by the Agent for its current remote config. Only set
+	// on readonly copies, see CloneReadonly.
+	RemoteConfigStatus string
+	RemoteConfigError  string
+
 	// Client certificate
 	ClientCert                  *x509.Certificate
 	ClientCertSha256Fingerprint string
 	ClientCertOfferError        string
 
+	// Remote config shared by all Agents in the same deployment and group.
+	deploymentConfig string
+	groupConfig      string
+
 	// Remote config that we will give to this Agent.
 	remoteConfig *protobufs.AgentRemoteConfig
 
+	// Set when the Agent was rebound to a new connection and still needs to
+	// be sent its connection settings.
+	reconnected bool
+
+	// Set when the Agent announced that it is about to disconnect.
+	disconnectAnnounced bool
+
 	// Channels to notify when this Agent's status is updated next time.
 	statusUpdateWatchers []chan<- struct{}
 }
@@ -65,9 +132,20 @@ func NewAgent(
 	conn types.Connection,
 ) *Agent {
 	agent := &Agent{
-		InstanceId:    instanceId,
-		InstanceIdStr: instanceId.String(),
-		conn:          conn,
+		InstanceId:      instanceId,
+		InstanceIdStr:   instanceId.String(),
+		ConnectionState: ConnectionStateConnected,
+		conn:            conn,
+	}
+	agent.readClientCert(conn)
+
+	return agent
+}
+
+// readClientCert records the client-side certificate details of the connection, if any.
+func (agent *Agent) readClientCert(conn types.Connection) {
+	if conn == nil {
+		return
 	}
 	tslConn, ok := conn.Connection().(*tls.Conn)
 	if ok {
@@ -81,8 +159,189 @@ func NewAgent(
 			agent.ClientCertSha256Fingerprint = fmt.Sprintf("%X", fingerprint)
 		}
 	}
+}
 
-	return agent
+// rebind attaches the Agent to a new connection after the Agent reconnected.
+func (agent *Agent) rebind(conn types.Connection) {
+	agent.mux.Lock()
+	defer agent.mux.Unlock()
+
+	agent.conn = conn
+	agent.reconnected = true
+	agent.disconnectAnnounced = false
+	agent.ConnectionState = ConnectionStateConnected
+	agent.DisconnectReason = ""
+	agent.ClientCert = nil
+	agent.ClientCertSha256Fingerprint = ""
+	agent.readClientCert(conn)
+}
+
+// disconnect detaches the Agent from its closed connection and returns the
+// reason it disconnected.
+func (agent *Agent) disconnect() string {
+	agent.mux.Lock()
+	defer agent.mux.Unlock()
+
+	agent.conn = nil
+	agent.ConnectionState = ConnectionStateDisconnected
+	agent.DisconnectReason = DisconnectReasonConnectionClosed
+	if agent.disconnectAnnounced {
+		agent.DisconnectReason = DisconnectReasonAgentShutdown
+	}
+	return agent.DisconnectReason
+}
+
+// markStaleIfSilent flags a connected Agent as stale when its last report is
+// older than the deadline. It returns true if the Agent became stale.
+func (agent *Agent) markStaleIfSilent(deadline time.Time) bool {
+	agent.mux.Lock()
+	defer agent.mux.Unlock()
+
+	if agent.ConnectionState != ConnectionStateConnected || !agent.LastSeen.Before(deadline) {
+		return false
+	}
+	agent.ConnectionState = ConnectionStateStale
+	agent.DisconnectReason = DisconnectReasonHeartbeatTimeout
+	return true
+}
+
+// lastSeen returns the time of the last status report of the Agent.
+func (agent *Agent) lastSeen() time.Time {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.LastSeen
+}
+
+// RemoteConfigApplyStatus returns the status the Agent reported for its current
+// remote config. UNSET is returned until the Agent acknowledges the current config.
+func (agent *Agent) RemoteConfigApplyStatus() (protobufs.RemoteConfigStatuses, string) {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.remoteConfigApplyStatus()
+}
+
+func (agent *Agent) remoteConfigApplyStatus() (protobufs.RemoteConfigStatuses, string) {
+	if agent.Status == nil || agent.Status.RemoteConfigStatus == nil || agent.remoteConfig == nil {
+		return protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET, ""
+	}
+	status := agent.Status.RemoteConfigStatus
+	if !bytes.Equal(status.LastRemoteConfigHash, agent.remoteConfig.ConfigHash) {
+		return protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET, ""
+	}
+	return status.Status, status.ErrorMessage
+}
+
+// RemoteConfigStatusName returns the lowercase name of the status as exposed
+// by the API, e.g. "applied" or "failed".
+func RemoteConfigStatusName(status protobufs.RemoteConfigStatuses) string {
+	return strings.ToLower(strings.TrimPrefix(status.String(), "RemoteConfigStatuses_"))
+}
+
+// ReportsRemoteConfig returns true if the Agent reports the status of the
+// remote config it receives.
+func (agent *Agent) ReportsRemoteConfig() bool {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.Status != nil && agent.hasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig)
+}
+
+// ReportsHealth returns true if the Agent reports its health.
+func (agent *Agent) ReportsHealth() bool {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.Status != nil && agent.hasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth)
+}
+
+// addStatusWatcher registers a channel that is notified on the next status
+// update from the Agent. The channel must have a buffer size of at least 1.
+func (agent *Agent) addStatusWatcher(ch chan<- struct{}) {
+	agent.mux.Lock()
+	defer agent.mux.Unlock()
+	agent.statusUpdateWatchers = append(agent.statusUpdateWatchers, ch)
+}
+
+// IsHealthy returns true if the Agent last reported itself as healthy.
+func (agent *Agent) IsHealthy() bool {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.Status != nil && agent.Status.Health != nil && agent.Status.Health.Healthy
+}
+
+// IsOnline returns true if the Agent is connected and reporting.
+func (agent *Agent) IsOnline() bool {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.ConnectionState == ConnectionStateConnected
+}
+
+// CollectorVersion returns the service.version the Agent reported in its
+// AgentDescription, or "" if it did not report one.
+func (agent *Agent) CollectorVersion() string {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	if agent.Status == nil || agent.Status.AgentDescription == nil {
+		return ""
+	}
+	for _, attr := range agent.Status.AgentDescription.IdentifyingAttributes {
+		if attr.Key == "service.version" {
+			return attr.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+// newAgentFromRecord restores an Agent that is not connected from its persisted record.
+func newAgentFromRecord(instanceId uuid.UUID, record *registry.AgentRecord) (*Agent, error) {
+	agent := &Agent{
+		InstanceId:           instanceId,
+		InstanceIdStr:        instanceId.String(),
+		StartedAt:            record.StartedAt,
+		LastSeen:             record.LastSeen,
+		ConnectionState:      ConnectionStateDisconnected,
+		DisconnectReason:     record.DisconnectReason,
+		CustomInstanceConfig: record.CustomConfig,
+		CustomConfigFiles:    record.CustomConfigFiles,
+	}
+	if ConnectionState(record.ConnectionState) == ConnectionStateConnected {
+		// The server went away while the Agent was still connected.
+		agent.DisconnectReason = DisconnectReasonServerRestart
+	}
+
+	if len(record.Status) > 0 {
+		status := &protobufs.AgentToServer{}
+		if err := proto.Unmarshal(record.Status, status); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal agent status: %w", err)
+		}
+		agent.Status = status
+		agent.updateEffectiveConfig(status, nil)
+	}
+	agent.calcRemoteConfig()
+
+	return agent, nil
+}
+
+// record returns the persisted representation of the Agent.
+func (agent *Agent) record() (*registry.AgentRecord, error) {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+
+	record := &registry.AgentRecord{
+		ID:                agent.InstanceIdStr,
+		CustomConfig:      agent.CustomInstanceConfig,
+		CustomConfigFiles: copyConfigFiles(agent.CustomConfigFiles),
+		ConnectionState:   string(agent.ConnectionState),
+		DisconnectReason:  agent.DisconnectReason,
+		StartedAt:         agent.StartedAt,
+		LastSeen:          agent.LastSeen,
+	}
+	if agent.Status != nil {
+		status, err := proto.Marshal(agent.Status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal agent status: %w", err)
+		}
+		record.Status = status
+	}
+	return record, nil
 }
 
 // CloneReadonly returns a copy of the Agent that is safe to read.
@@ -90,15 +349,23 @@ func NewAgent(
 func (agent *Agent) CloneReadonly() *Agent {
 	agent.mux.RLock()
 	defer agent.mux.RUnlock()
+	remoteConfigStatus, remoteConfigError := agent.remoteConfigApplyStatus()
 	return &Agent{
 		InstanceId:                  agent.InstanceId,
 		InstanceIdStr:               uuid.UUID(agent.InstanceId).String(),
 		UserID:                      agent.UserID,
 		Status:                      proto.Clone(agent.Status).(*protobufs.AgentToServer),
+		EffectiveConfigFiles:        copyConfigFiles(agent.EffectiveConfigFiles),
 		EffectiveConfig:             agent.EffectiveConfig,
 		CustomInstanceConfig:        agent.CustomInstanceConfig,
+		CustomConfigFiles:           copyConfigFiles(agent.CustomConfigFiles),
+		RemoteConfigStatus:          RemoteConfigStatusName(remoteConfigStatus),
+		RemoteConfigError:           remoteConfigError,
 		remoteConfig:                proto.Clone(agent.remoteConfig).(*protobufs.AgentRemoteConfig),
 		StartedAt:                   agent.StartedAt,
+		LastSeen:                    agent.LastSeen,
+		ConnectionState:             agent.ConnectionState,
+		DisconnectReason:            agent.DisconnectReason,
 		ClientCert:                  agent.ClientCert,
 		ClientCertOfferError:        agent.ClientCertOfferError,
 		ClientCertSha256Fingerprint: agent.ClientCertSha256Fingerprint,
@@ -107,14 +374,27 @@ func (agent *Agent) CloneReadonly() *Agent {
 
 // UpdateStatus updates the status of the Agent struct based on the newly received
 // status report and sets appropriate fields in the response message to be sent
-// to the Agent.
+// to the Agent. It returns true if the report changed the description, health,
+// effective config, remote config status or connection state of the Agent, so
+// heartbeats that change nothing don't need to be persisted.
 func (agent *Agent) UpdateStatus(
 	statusMsg *protobufs.AgentToServer,
 	response *protobufs.ServerToAgent,
-) {
+) (changed bool) {
 	agent.mux.Lock()
 
-	agent.processStatusUpdate(statusMsg, response)
+	// Any report means the Agent is alive, even if it was considered stale.
+	agent.LastSeen = time.Now()
+	if agent.ConnectionState == ConnectionStateStale {
+		agent.DisconnectReason = ""
+	}
+	changed = agent.ConnectionState != ConnectionStateConnected
+	agent.ConnectionState = ConnectionStateConnected
+	if statusMsg.AgentDisconnect != nil {
+		agent.disconnectAnnounced = true
+	}
+
+	changed = agent.processStatusUpdate(statusMsg, response) || changed
 
 	if statusMsg.ConnectionSettingsRequest != nil {
 		agent.processConnectionSettingsRequest(statusMsg.ConnectionSettingsRequest.Opamp, response)
@@ -127,6 +407,7 @@ func (agent *Agent) UpdateStatus(
 
 	// Notify watcher outside mutex to avoid blocking the mutex for too long.
 	notifyStatusWatchers(statusUpdateWatchers)
+	return changed
 }
 
 func notifyStatusWatchers(statusUpdateWatchers []chan<- struct{}) {
@@ -179,16 +460,18 @@ func (agent *Agent) updateAgentDescription(newStatus *protobufs.AgentToServer) (
 	return agentDescrChanged
 }
 
-func (agent *Agent) updateHealth(newStatus *protobufs.AgentToServer) {
+func (agent *Agent) updateHealth(newStatus *protobufs.AgentToServer) (healthChanged bool) {
 	if newStatus.Health == nil {
-		return
+		return false
 	}
 
+	healthChanged = !proto.Equal(agent.Status.Health, newStatus.Health)
 	agent.Status.Health = newStatus.Health
 
 	if agent.Status != nil && agent.Status.Health != nil && agent.Status.Health.Healthy {
 		agent.StartedAt = time.Unix(0, int64(agent.Status.Health.StartTimeUnixNano)).UTC()
 	}
+	return healthChanged
 }
 
 func (agent *Agent) updateRemoteConfigStatus(newStatus *protobufs.AgentToServer) {
@@ -198,39 +481,52 @@ func (agent *Agent) updateRemoteConfigStatus(newStatus *protobufs.AgentToServer)
 	}
 }
 
-func (agent *Agent) updateStatusField(newStatus *protobufs.AgentToServer) (agentDescrChanged bool) {
+// updateStatusField updates the status with the report and returns whether the
+// description changed, and whether the health or remote config status changed.
+func (agent *Agent) updateStatusField(newStatus *protobufs.AgentToServer) (agentDescrChanged, statusChanged bool) {
 	if agent.Status == nil {
 		// First time this Agent reports a status, remember it.
 		agent.Status = newStatus
 		agentDescrChanged = true
 	}
 
+	// Compare before updateAgentDescription, which also takes the remote
+	// config status over.
+	statusChanged = newStatus.RemoteConfigStatus != nil &&
+		!proto.Equal(agent.Status.RemoteConfigStatus, newStatus.RemoteConfigStatus)
+
 	agentDescrChanged = agent.updateAgentDescription(newStatus) || agentDescrChanged
 	agent.updateRemoteConfigStatus(newStatus)
-	agent.updateHealth(newStatus)
+	statusChanged = agent.updateHealth(newStatus) || statusChanged
 
-	return agentDescrChanged
+	return agentDescrChanged, statusChanged
 }
 
 func (agent *Agent) updateEffectiveConfig(
 	newStatus *protobufs.AgentToServer,
 	response *protobufs.ServerToAgent,
-) {
+) (effectiveConfigChanged bool) {
 	// Update effective config if provided.
 	if newStatus.EffectiveConfig != nil {
 		if newStatus.EffectiveConfig.ConfigMap != nil {
+			effectiveConfigChanged = !proto.Equal(agent.Status.EffectiveConfig, newStatus.EffectiveConfig)
 			agent.Status.EffectiveConfig = newStatus.EffectiveConfig
 
-			// Convert to string for displaying purposes.
-			agent.EffectiveConfig = ""
-			for _, cfg := range newStatus.EffectiveConfig.ConfigMap.ConfigMap {
-				// TODO: we just concatenate parts of effective config as a single
-				// blob to show in the UI. A proper approach is to keep the effective
-				// config as a set and show the set in the UI.
-				agent.EffectiveConfig = agent.EffectiveConfig + string(cfg.Body)
+			configMap := newStatus.EffectiveConfig.ConfigMap.ConfigMap
+			agent.EffectiveConfigFiles = make(map[string]string, len(configMap))
+			for name, cfg := range configMap {
+				agent.EffectiveConfigFiles[name] = string(cfg.Body)
+			}
+
+			// Join the files in merge order for displaying purposes.
+			var parts []string
+			for _, name := range ConfigFileOrder(agent.EffectiveConfigFiles) {
+				parts = append(parts, agent.EffectiveConfigFiles[name])
 			}
+			agent.EffectiveConfig = strings.Join(parts, "\n---\n")
 		}
 	}
+	return effectiveConfigChanged
 }
 
 func (agent *Agent) hasCapability(capability protobufs.AgentCapabilities) bool {
@@ -240,12 +536,12 @@ func (agent *Agent) hasCapability(capability protobufs.AgentCapabilities) bool {
 func (agent *Agent) processStatusUpdate(
 	newStatus *protobufs.AgentToServer,
 	response *protobufs.ServerToAgent,
-) {
+) (changed bool) {
 	// We don't have any status for this Agent, or we lost the previous status update from the Agent, so our
 	// current status is not up-to-date.
 	lostPreviousUpdate := (agent.Status == nil) || (agent.Status != nil && agent.Status.SequenceNum+1 != newStatus.SequenceNum)
 
-	agentDescrChanged := agent.updateStatusField(newStatus)
+	agentDescrChanged, statusChanged := agent.updateStatusField(newStatus)
 
 	// Check if any fields were omitted in the status report.
 	effectiveConfigOmitted := newStatus.EffectiveConfig == nil &&
@@ -275,9 +571,12 @@ func (agent *Agent) processStatusUpdate(
 
 		// We need to recalculate the config.
 		configChanged = agent.calcRemoteConfig()
+	}
 
-		// And set connection settings that are appropriate for the Agent description.
+	if agentDescrChanged || agent.reconnected {
+		// Set connection settings that are appropriate for the Agent description.
 		agent.calcConnectionSettings(response)
+		agent.reconnected = false
 	}
 
 	// If remote config is changed and different from what the Agent has then
@@ -290,7 +589,8 @@ func (agent *Agent) processStatusUpdate(
 		response.RemoteConfig = agent.remoteConfig
 	}
 
-	agent.updateEffectiveConfig(newStatus, response)
+	effectiveConfigChanged := agent.updateEffectiveConfig(newStatus, response)
+	return agentDescrChanged || statusChanged || effectiveConfigChanged
 }
 
 // SetCustomConfig sets a custom config for this Agent.
@@ -306,7 +606,22 @@ func (agent *Agent) SetCustomConfig(
 ) {
 	agent.mux.Lock()
 
-	agent.CustomInstanceConfig = string(config.ConfigMap[""].Body)
+	// Only the files in the config map are replaced. A named file with an
+	// empty body is removed.
+	for name, file := range config.ConfigMap {
+		if name == "" {
+			agent.CustomInstanceConfig = string(file.Body)
+			continue
+		}
+		if len(file.Body) == 0 {
+			delete(agent.CustomConfigFiles, name)
+			continue
+		}
+		if agent.CustomConfigFiles == nil {
+			agent.CustomConfigFiles = map[string]string{}
+		}
+		agent.CustomConfigFiles[name] = string(file.Body)
+	}
 
 	configChanged := agent.calcRemoteConfig()
 	if configChanged {
@@ -337,6 +652,33 @@ func (agent *Agent) SetCustomConfig(
 	}
 }
 
+// SetSharedConfig sets the deployment and group level config for this Agent.
+// The recalculated remote config is sent to the Agent if it changed and the
+// Agent is connected.
+func (agent *Agent) SetSharedConfig(deploymentConfig, groupConfig string) {
+	if agent.setSharedConfig(deploymentConfig, groupConfig) {
+		agent.mux.RLock()
+		msg := &protobufs.ServerToAgent{
+			RemoteConfig: agent.remoteConfig,
+		}
+		agent.mux.RUnlock()
+
+		agent.SendToAgent(msg)
+	}
+}
+
+// setSharedConfig updates the shared config without sending it. The Agent
+// receives it with the response to its next status report since the hash of
+// its remote config no longer matches. It returns true if the config changed.
+func (agent *Agent) setSharedConfig(deploymentConfig, groupConfig string) bool {
+	agent.mux.Lock()
+	defer agent.mux.Unlock()
+
+	agent.deploymentConfig = deploymentConfig
+	agent.groupConfig = groupConfig
+	return agent.calcRemoteConfig()
+}
+
 // calcRemoteConfig calculates the remote config for this Agent. It returns true if
 // the calculated new config is different from the existing config stored in
 // Agent.remoteConfig.
@@ -349,14 +691,24 @@ func (agent *Agent) calcRemoteConfig() bool {
 		},
 	}
 
-	// Add the custom config for this particular Agent instance. Use empty
-	// string as the config file name.
-	cfg.Config.ConfigMap[""] = &protobufs.AgentConfigFile{
-		Body: []byte(agent.CustomInstanceConfig),
+	// Add the config shared by the deployment and the group of this Agent, its
+	// named config files and its instance config. The Agent merges them in name
+	// order, see ConfigFileOrder.
+	for name, body := range agent.configFiles() {
+		cfg.Config.ConfigMap[name] = &protobufs.AgentConfigFile{
+			Body:        []byte(body),
+			ContentType: ConfigContentType,
+		}
 	}
 
-	// Calculate the hash.
-	for k, v := range cfg.Config.ConfigMap {
+	// Calculate the hash. Iterate in name order so the hash is stable.
+	names := make([]string, 0, len(cfg.Config.ConfigMap))
+	for k := range cfg.Config.ConfigMap {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := cfg.Config.ConfigMap[k]
 		hash.Write([]byte(k))
 		hash.Write(v.Body)
 		hash.Write([]byte(v.ContentType))
@@ -371,6 +723,55 @@ func (agent *Agent) calcRemoteConfig() bool {
 	return configChanged
 }
 
+// configFiles returns all the config files of the remote config of this
+// Agent by name. Must be called with the lock held.
+func (agent *Agent) configFiles() map[string]string {
+	files := make(map[string]string, len(agent.CustomConfigFiles)+3)
+	if agent.deploymentConfig != "" {
+		files[DeploymentConfigName] = agent.deploymentConfig
+	}
+	if agent.groupConfig != "" {
+		files[GroupConfigName] = agent.groupConfig
+	}
+	for name, body := range agent.CustomConfigFiles {
+		files[name] = body
+	}
+	files[InstanceConfigName] = agent.CustomInstanceConfig
+	return files
+}
+
+// RemoteConfigFiles returns the config files of the remote config that is
+// given to this Agent, by name.
+func (agent *Agent) RemoteConfigFiles() map[string]string {
+	agent.mux.RLock()
+	defer agent.mux.RUnlock()
+	return agent.configFiles()
+}
+
+// ConfigFileOrder returns the names of the config files in the order an Agent
+// merges them, which is name order. With the names of the remote config this
+// is the deployment config, the group config, the Agent's named files and the
+// instance config last, so the Agent's own files override the shared configs.
+func ConfigFileOrder(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copyConfigFiles(files map[string]string) map[string]string {
+	if files == nil {
+		return nil
+	}
+	result := make(map[string]string, len(files))
+	for name, body := range files {
+		result[name] = body
+	}
+	return result
+}
+
 func isEqualRemoteConfig(c1, c2 *protobufs.AgentRemoteConfig) bool {
 	if c1 == c2 {
 		return true
@@ -439,7 +840,15 @@ func (agent *Agent) calcConnectionSettings(response *protobufs.ServerToAgent) {
 }
 
 func (agent *Agent) SendToAgent(msg *protobufs.ServerToAgent) {
-	agent.conn.Send(context.Background(), msg)
+	agent.mux.RLock()
+	conn := agent.conn
+	agent.mux.RUnlock()
+
+	if conn == nil {
+		// The Agent is offline, it will receive its remote config when it reconnects.
+		return
+	}
+	conn.Send(context.Background(), msg)
 }
 
 func (agent *Agent) OfferConnectionSettings(offers *protobufs.ConnectionSettingsOffers) {
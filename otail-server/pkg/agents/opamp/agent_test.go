@@ -0,0 +1,95 @@
+package opamp
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+func TestConfigFileOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "empty",
+			files: nil,
+			want:  []string{},
+		},
+		{
+			name:  "instance config only",
+			files: []string{InstanceConfigName},
+			want:  []string{InstanceConfigName},
+		},
+		{
+			name:  "named files are sorted before the instance config",
+			files: []string{InstanceConfigName, "zeta", "alpha", "Mid", "0-first"},
+			want:  []string{"0-first", "Mid", "alpha", "zeta", InstanceConfigName},
+		},
+		{
+			name:  "shared files merge first",
+			files: []string{InstanceConfigName, "alpha", GroupConfigName, DeploymentConfigName},
+			want:  []string{DeploymentConfigName, GroupConfigName, "alpha", InstanceConfigName},
+		},
+		{
+			name:  "group config without deployment config",
+			files: []string{"0-first", GroupConfigName},
+			want:  []string{GroupConfigName, "0-first"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string]string, len(tt.files))
+			for _, name := range tt.files {
+				files[name] = "body of " + name
+			}
+			if got := ConfigFileOrder(files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConfigFileOrder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRemoteConfigMergeOrder checks that the remote config sent to an Agent
+// merges, in the supervisor's sorted key order, the shared configs first and
+// the instance config last.
+func TestRemoteConfigMergeOrder(t *testing.T) {
+	agent := NewAgent(uuid.New(), nil)
+	agent.setSharedConfig(
+		"exporters:\n  debug:\n    verbosity: basic\n  otlp:\n    endpoint: deployment:4317\n",
+		"exporters:\n  debug:\n    verbosity: normal\n",
+	)
+	agent.SetCustomConfig(&protobufs.AgentConfigMap{
+		ConfigMap: map[string]*protobufs.AgentConfigFile{
+			"":     {Body: []byte("exporters:\n  debug:\n    verbosity: detailed\n")},
+			"base": {Body: []byte("exporters:\n  otlp:\n    endpoint: base:4317\n")},
+		},
+	}, nil)
+
+	var names []string
+	for name := range agent.remoteConfig.Config.ConfigMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{DeploymentConfigName, GroupConfigName, "base", InstanceConfigName}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("remote config files in supervisor merge order = %q, want %q", names, want)
+	}
+
+	merged, err := mergeConfigFiles(agent.RemoteConfigFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporters := merged["exporters"].(map[string]interface{})
+	if got := exporters["debug"].(map[string]interface{})["verbosity"]; got != "detailed" {
+		t.Errorf("debug verbosity = %v, want the instance config to override the shared configs", got)
+	}
+	if got := exporters["otlp"].(map[string]interface{})["endpoint"]; got != "base:4317" {
+		t.Errorf("otlp endpoint = %v, want the named file to override the deployment config", got)
+	}
+}
@@ -1,9 +1,13 @@
 package opamp
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/registry"
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server/types"
 	"go.uber.org/zap"
@@ -29,7 +33,12 @@ type Agents struct {
 	deploymentIndex map[string]map[uuid.UUID]bool
 	// Map connection to agent ID (one-to-one)
 	connectionToAgent map[types.Connection]uuid.UUID
-	logger            *zap.Logger
+	// Remote config shared by the agents of a group or deployment
+	groupConfigs      map[string]string
+	deploymentConfigs map[string]string
+	// Optional persistence for agent state, nil keeps agents in memory only
+	store  registry.Store
+	logger *zap.Logger
 }
 
 // NewAgents creates a new Agents instance with the given logger
@@ -40,10 +49,103 @@ func NewAgents(logger *zap.Logger) *Agents {
 		groupIndex:        map[string]map[uuid.UUID]bool{},
 		deploymentIndex:   map[string]map[uuid.UUID]bool{},
 		connectionToAgent: map[types.Connection]uuid.UUID{},
+		groupConfigs:      map[string]string{},
+		deploymentConfigs: map[string]string{},
 		logger:            logger,
 	}
 }
 
+// NewPersistentAgents creates a new Agents instance that persists agent state in the given store
+func NewPersistentAgents(store registry.Store, logger *zap.Logger) *Agents {
+	agents := NewAgents(logger)
+	agents.store = store
+	return agents
+}
+
+// Load rehydrates the agents from the store. Restored agents are offline until
+// they reconnect with the same instance UID.
+func (agents *Agents) Load(ctx context.Context) error {
+	if agents.store == nil {
+		return nil
+	}
+
+	records, err := agents.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	agents.mux.Lock()
+	defer agents.mux.Unlock()
+
+	for _, record := range records {
+		agentId, err := uuid.Parse(record.ID)
+		if err != nil {
+			agents.logger.Warn("Skipping agent with invalid ID",
+				zap.String("agent_id", record.ID))
+			continue
+		}
+
+		agent, err := newAgentFromRecord(agentId, record)
+		if err != nil {
+			agents.logger.Warn("Skipping agent that failed to load",
+				zap.String("agent_id", record.ID),
+				zap.Error(err))
+			continue
+		}
+
+		agents.agents[agentId] = &AgentInfo{
+			Agent:        agent,
+			OrgID:        record.OrgID,
+			GroupID:      record.GroupID,
+			DeploymentID: record.DeploymentID,
+		}
+		addToIndex(agents.orgIndex, record.OrgID, agentId)
+		addToIndex(agents.groupIndex, record.GroupID, agentId)
+		addToIndex(agents.deploymentIndex, record.DeploymentID, agentId)
+	}
+
+	agents.logger.Info("Loaded agents from store",
+		zap.Int("agent_count", len(agents.agents)))
+
+	return nil
+}
+
+// SaveAgent persists the current state of the agent
+func (agents *Agents) SaveAgent(ctx context.Context, agentId uuid.UUID) error {
+	if agents.store == nil {
+		return nil
+	}
+
+	agents.mux.RLock()
+	info := agents.agents[agentId]
+	if info == nil {
+		agents.mux.RUnlock()
+		return fmt.Errorf("agent %s not found", agentId)
+	}
+	orgID, groupID, deploymentID := info.OrgID, info.GroupID, info.DeploymentID
+	agents.mux.RUnlock()
+
+	record, err := info.Agent.record()
+	if err != nil {
+		return err
+	}
+	record.OrgID = orgID
+	record.GroupID = groupID
+	record.DeploymentID = deploymentID
+
+	return agents.store.Save(ctx, record)
+}
+
+func addToIndex(index map[string]map[uuid.UUID]bool, key string, agentId uuid.UUID) {
+	if key == "" {
+		return
+	}
+	if index[key] == nil {
+		index[key] = map[uuid.UUID]bool{}
+	}
+	index[key][agentId] = true
+}
+
 // SetConnection sets metadata for a connection and its associated agent
 func (agents *Agents) SetConnection(conn types.Connection, orgID, groupID, deploymentID string) {
 	agents.mux.Lock()
@@ -128,59 +230,153 @@ func (agents *Agents) SetConnection(conn types.Connection, orgID, groupID, deplo
 		}
 		agents.deploymentIndex[deploymentID][agentId] = true
 	}
+
+	// Apply the config shared by the agent's deployment and group
+	info.Agent.setSharedConfig(agents.deploymentConfigs[info.DeploymentID], agents.groupConfigs[info.GroupID])
+}
+
+// SetGroupConfig sets the remote config shared by all agents in the group and
+// pushes it to the connected ones. It returns the IDs of the group's agents.
+func (agents *Agents) SetGroupConfig(groupID string, config []byte) []uuid.UUID {
+	agents.mux.Lock()
+	if len(config) == 0 {
+		delete(agents.groupConfigs, groupID)
+	} else {
+		agents.groupConfigs[groupID] = string(config)
+	}
+	members := agents.sharedConfigMembers(agents.groupIndex[groupID])
+	agents.mux.Unlock()
+
+	return pushSharedConfig(members)
 }
 
-// RemoveConnection removes the connection and its associated agent
+// SetDeploymentConfig sets the remote config shared by all agents in the
+// deployment and pushes it to the connected ones. It returns the IDs of the
+// deployment's agents.
+func (agents *Agents) SetDeploymentConfig(deploymentID string, config []byte) []uuid.UUID {
+	agents.mux.Lock()
+	if len(config) == 0 {
+		delete(agents.deploymentConfigs, deploymentID)
+	} else {
+		agents.deploymentConfigs[deploymentID] = string(config)
+	}
+	members := agents.sharedConfigMembers(agents.deploymentIndex[deploymentID])
+	agents.mux.Unlock()
+
+	return pushSharedConfig(members)
+}
+
+// sharedConfig is the shared config computed for an agent while holding the lock.
+type sharedConfig struct {
+	agentId          uuid.UUID
+	agent            *Agent
+	deploymentConfig string
+	groupConfig      string
+}
+
+// sharedConfigMembers resolves the shared config of every agent in the index.
+// Must be called with the lock held.
+func (agents *Agents) sharedConfigMembers(index map[uuid.UUID]bool) []sharedConfig {
+	members := make([]sharedConfig, 0, len(index))
+	for agentId := range index {
+		info := agents.agents[agentId]
+		if info == nil {
+			continue
+		}
+		members = append(members, sharedConfig{
+			agentId:          agentId,
+			agent:            info.Agent,
+			deploymentConfig: agents.deploymentConfigs[info.DeploymentID],
+			groupConfig:      agents.groupConfigs[info.GroupID],
+		})
+	}
+	return members
+}
+
+// pushSharedConfig applies the shared config to each agent outside the lock,
+// since sending to connected agents blocks on the network.
+func pushSharedConfig(members []sharedConfig) []uuid.UUID {
+	agentIds := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		m.agent.SetSharedConfig(m.deploymentConfig, m.groupConfig)
+		agentIds = append(agentIds, m.agentId)
+	}
+	return agentIds
+}
+
+// RemoveConnection unbinds the connection from its agent and marks the agent
+// offline. The agent itself and its indexes are kept so that its state survives
+// until it reconnects.
 func (agents *Agents) RemoveConnection(conn types.Connection) {
 	agents.mux.Lock()
-	defer agents.mux.Unlock()
 
 	// Get the agent ID for this connection
 	agentId, exists := agents.connectionToAgent[conn]
 	if !exists {
+		agents.mux.Unlock()
 		return
 	}
 
-	// Get the agent info
+	// Remove from connection map
+	delete(agents.connectionToAgent, conn)
+
+	// Only unbind the agent if it was not rebound to a newer connection
 	info := agents.agents[agentId]
-	if info == nil {
+	if info == nil || info.Connection != conn {
+		agents.mux.Unlock()
 		return
 	}
+	info.Connection = nil
+	reason := info.Agent.disconnect()
+	agents.mux.Unlock()
 
-	// Remove from org index
-	if info.OrgID != "" {
-		if orgAgents := agents.orgIndex[info.OrgID]; orgAgents != nil {
-			delete(orgAgents, agentId)
-			if len(orgAgents) == 0 {
-				delete(agents.orgIndex, info.OrgID)
-			}
+	agents.logger.Info("Agent disconnected",
+		zap.String("agent_id", agentId.String()),
+		zap.String("reason", reason))
+
+	if agents.store != nil {
+		err := agents.store.SetDisconnected(context.Background(), agentId.String(),
+			string(ConnectionStateDisconnected), reason, info.Agent.lastSeen())
+		if err != nil {
+			agents.logger.Error("Failed to mark agent offline",
+				zap.String("agent_id", agentId.String()),
+				zap.Error(err))
 		}
 	}
+}
 
-	// Remove from group index
-	if info.GroupID != "" {
-		if groupAgents := agents.groupIndex[info.GroupID]; groupAgents != nil {
-			delete(groupAgents, agentId)
-			if len(groupAgents) == 0 {
-				delete(agents.groupIndex, info.GroupID)
-			}
+// MarkStaleAgents flags connected agents that have not reported within the
+// heartbeat timeout as stale and returns their IDs.
+func (agents *Agents) MarkStaleAgents(heartbeatTimeout time.Duration) []uuid.UUID {
+	deadline := time.Now().Add(-heartbeatTimeout)
+
+	agents.mux.RLock()
+	var stale []uuid.UUID
+	lastSeen := map[uuid.UUID]time.Time{}
+	for agentId, info := range agents.agents {
+		if info.Agent.markStaleIfSilent(deadline) {
+			stale = append(stale, agentId)
+			lastSeen[agentId] = info.Agent.lastSeen()
 		}
 	}
+	agents.mux.RUnlock()
 
-	// Remove from deployment index
-	if info.DeploymentID != "" {
-		if deploymentAgents := agents.deploymentIndex[info.DeploymentID]; deploymentAgents != nil {
-			delete(deploymentAgents, agentId)
-			if len(deploymentAgents) == 0 {
-				delete(agents.deploymentIndex, info.DeploymentID)
+	for _, agentId := range stale {
+		agents.logger.Warn("Agent stopped reporting",
+			zap.String("agent_id", agentId.String()),
+			zap.Duration("heartbeat_timeout", heartbeatTimeout))
+
+		if agents.store != nil {
+			err := agents.store.SetDisconnected(context.Background(), agentId.String(),
+				string(ConnectionStateStale), DisconnectReasonHeartbeatTimeout, lastSeen[agentId])
+			if err != nil {
+				agents.logger.Error("Failed to mark agent stale",
+					zap.String("agent_id", agentId.String()),
+					zap.Error(err))
 			}
 		}
 	}
-
-	// Remove from main storage
-	delete(agents.agents, agentId)
-	// Remove from connection map
-	delete(agents.connectionToAgent, conn)
+	return stale
 }
 
 func (agents *Agents) SetCustomConfigForAgent(
@@ -203,7 +399,11 @@ func (agents *Agents) FindAgent(agentId uuid.UUID) *Agent {
 	return nil
 }
 
-func (agents *Agents) FindOrCreateAgent(agentId uuid.UUID, conn types.Connection) *Agent {
+// FindOrCreateAgent returns the agent with the given instance ID, creating it if
+// it is not known yet. An existing agent is rebound to conn so that its state is
+// kept across reconnects, unless it belongs to another organization than the
+// one of conn, in which case ErrAgentOfOtherOrganization is returned.
+func (agents *Agents) FindOrCreateAgent(agentId uuid.UUID, conn types.Connection, orgID string) (*Agent, error) {
 	agents.mux.Lock()
 	defer agents.mux.Unlock()
 
@@ -212,6 +412,9 @@ func (agents *Agents) FindOrCreateAgent(agentId uuid.UUID, conn types.Connection
 		zap.Any("connection", conn))
 
 	info := agents.agents[agentId]
+	if info != nil && info.OrgID != "" && info.OrgID != orgID {
+		return nil, ErrAgentOfOtherOrganization
+	}
 	if info == nil {
 		agents.logger.Info("Creating new agent",
 			zap.String("agent_id", agentId.String()))
@@ -225,13 +428,22 @@ func (agents *Agents) FindOrCreateAgent(agentId uuid.UUID, conn types.Connection
 		agents.logger.Info("Added agent to connection map",
 			zap.String("agent_id", agentId.String()),
 			zap.Any("connection", conn))
-	} else {
-		agents.logger.Info("Found existing agent",
+	} else if info.Connection != conn {
+		agents.logger.Info("Rebinding existing agent to new connection",
 			zap.String("agent_id", agentId.String()),
 			zap.Any("existing_connection", info.Connection))
+
+		// Drop the stale connection mapping so that closing it later does not
+		// unbind the agent from its new connection.
+		if info.Connection != nil && agents.connectionToAgent[info.Connection] == agentId {
+			delete(agents.connectionToAgent, info.Connection)
+		}
+		info.Connection = conn
+		info.Agent.rebind(conn)
+		agents.connectionToAgent[conn] = agentId
 	}
 
-	return info.Agent
+	return info.Agent, nil
 }
 
 func (agents *Agents) GetAgentReadonlyClone(agentId uuid.UUID) *Agent {
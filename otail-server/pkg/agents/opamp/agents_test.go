@@ -0,0 +1,274 @@
+package opamp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/registry"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// memoryRegistry is a registry.Store that keeps the records in memory
+type memoryRegistry struct {
+	mu      sync.Mutex
+	records map[string]registry.AgentRecord
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{records: map[string]registry.AgentRecord{}}
+}
+
+func (s *memoryRegistry) Save(ctx context.Context, record *registry.AgentRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = *record
+	return nil
+}
+
+func (s *memoryRegistry) Get(ctx context.Context, id string) (*registry.AgentRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *memoryRegistry) List(ctx context.Context) ([]*registry.AgentRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []*registry.AgentRecord
+	for _, record := range s.records {
+		record := record
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+func (s *memoryRegistry) SetDisconnected(ctx context.Context, id, state, reason string, lastSeen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil
+	}
+	record.ConnectionState = state
+	record.DisconnectReason = reason
+	record.LastSeen = lastSeen
+	s.records[id] = record
+	return nil
+}
+
+func (s *memoryRegistry) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// connectAgent registers a new agent of the group "group-1" of org-1 that
+// reports its description and health
+func connectAgent(t *testing.T, agents *Agents) (uuid.UUID, *mockConnection) {
+	t.Helper()
+	agentId := uuid.New()
+	conn := &mockConnection{id: agentId.String()}
+	agent, err := agents.FindOrCreateAgent(agentId, conn, "org-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents.SetConnection(conn, "org-1", "group-1", "")
+	agent.UpdateStatus(&protobufs.AgentToServer{
+		InstanceUid: agentId[:],
+		AgentDescription: &protobufs.AgentDescription{
+			IdentifyingAttributes: []*protobufs.KeyValue{{
+				Key:   "service.version",
+				Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: "0.118.0"}},
+			}},
+		},
+		Health: &protobufs.ComponentHealth{Healthy: true},
+	}, &protobufs.ServerToAgent{})
+	return agentId, conn
+}
+
+func TestPersistentAgentsRehydrate(t *testing.T) {
+	const instanceConfig = "processors:\n  batch: {}\n"
+	ctx := context.Background()
+	store := newMemoryRegistry()
+	agents := NewPersistentAgents(store, zap.NewNop())
+
+	agentId, conn := connectAgent(t, agents)
+	agents.FindAgent(agentId).CustomInstanceConfig = instanceConfig
+	if err := agents.SaveAgent(ctx, agentId); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the connection keeps the agent, offline
+	agents.RemoveConnection(conn)
+	agent := agents.FindAgent(agentId)
+	if agent == nil {
+		t.Fatal("agent was removed when its connection closed")
+	}
+	if agent.IsOnline() || agent.DisconnectReason != DisconnectReasonConnectionClosed {
+		t.Errorf("agent after disconnect is %s (%q), want disconnected", agent.ConnectionState, agent.DisconnectReason)
+	}
+	record, _ := store.Get(ctx, agentId.String())
+	if record == nil || record.ConnectionState != string(ConnectionStateDisconnected) {
+		t.Fatalf("stored record = %+v, want a disconnected agent", record)
+	}
+
+	// A restarted server restores the agent with its state and indexes
+	restarted := NewPersistentAgents(store, zap.NewNop())
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	info := restarted.GetAgentInfo(agentId)
+	if info == nil {
+		t.Fatal("agent was not loaded")
+	}
+	if info.OrgID != "org-1" || info.GroupID != "group-1" || info.Connection != nil {
+		t.Errorf("loaded agent of org %q, group %q, connection %v", info.OrgID, info.GroupID, info.Connection)
+	}
+	loaded := info.Agent
+	if loaded.IsOnline() || loaded.DisconnectReason != DisconnectReasonConnectionClosed {
+		t.Errorf("loaded agent is %s (%q), want disconnected", loaded.ConnectionState, loaded.DisconnectReason)
+	}
+	if loaded.CustomInstanceConfig != instanceConfig {
+		t.Errorf("loaded instance config = %q, want %q", loaded.CustomInstanceConfig, instanceConfig)
+	}
+	if version := loaded.CollectorVersion(); version != "0.118.0" {
+		t.Errorf("loaded collector version = %q, want 0.118.0", version)
+	}
+	if !loaded.IsHealthy() {
+		t.Error("loaded agent lost its health")
+	}
+	if _, ok := restarted.GetAgentsByOrganization("org-1")[agentId]; !ok {
+		t.Error("loaded agent is not indexed by its organization")
+	}
+	if _, ok := restarted.GetAgentsByGroup("group-1")[agentId]; !ok {
+		t.Error("loaded agent is not indexed by its group")
+	}
+
+	// Reconnecting with the same instance UID binds the restored agent
+	reconnected, err := restarted.FindOrCreateAgent(agentId, &mockConnection{id: "reconnect"}, "org-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconnected != loaded || !reconnected.IsOnline() {
+		t.Error("reconnected agent is not the restored one, online")
+	}
+	if _, err := restarted.FindOrCreateAgent(agentId, &mockConnection{id: "other"}, "org-2"); err != ErrAgentOfOtherOrganization {
+		t.Errorf("FindOrCreateAgent for org-2 = %v, want %v", err, ErrAgentOfOtherOrganization)
+	}
+}
+
+func TestLoadAgentConnectedAtShutdown(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryRegistry()
+	agents := NewPersistentAgents(store, zap.NewNop())
+	agentId, _ := connectAgent(t, agents)
+	if err := agents.SaveAgent(ctx, agentId); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewPersistentAgents(store, zap.NewNop())
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	agent := restarted.FindAgent(agentId)
+	if agent == nil {
+		t.Fatal("agent was not loaded")
+	}
+	if agent.ConnectionState != ConnectionStateDisconnected || agent.DisconnectReason != DisconnectReasonServerRestart {
+		t.Errorf("loaded agent is %s (%q), want disconnected by %q",
+			agent.ConnectionState, agent.DisconnectReason, DisconnectReasonServerRestart)
+	}
+}
+
+func TestUpdateStatusReportsChanges(t *testing.T) {
+	agents := NewAgents(zap.NewNop())
+	agentId, _ := connectAgent(t, agents)
+	agent := agents.FindAgent(agentId)
+
+	heartbeat := &protobufs.AgentToServer{InstanceUid: agentId[:]}
+	if agent.UpdateStatus(heartbeat, &protobufs.ServerToAgent{}) {
+		t.Error("heartbeat was reported as a change")
+	}
+	unhealthy := &protobufs.AgentToServer{InstanceUid: agentId[:], Health: &protobufs.ComponentHealth{Healthy: false}}
+	if !agent.UpdateStatus(unhealthy, &protobufs.ServerToAgent{}) {
+		t.Error("health change was not reported as a change")
+	}
+}
+
+func TestMarkStaleAgents(t *testing.T) {
+	const heartbeatTimeout = time.Minute
+	ctx := context.Background()
+	store := newMemoryRegistry()
+	agents := NewPersistentAgents(store, zap.NewNop())
+
+	silentId, _ := connectAgent(t, agents)
+	reportingId, _ := connectAgent(t, agents)
+	closedId, closedConn := connectAgent(t, agents)
+	for _, agentId := range []uuid.UUID{silentId, reportingId, closedId} {
+		if err := agents.SaveAgent(ctx, agentId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	silent := agents.FindAgent(silentId)
+	silent.LastSeen = time.Now().Add(-2 * heartbeatTimeout)
+	agents.RemoveConnection(closedConn)
+	agents.FindAgent(closedId).LastSeen = time.Now().Add(-2 * heartbeatTimeout)
+
+	stale := agents.MarkStaleAgents(heartbeatTimeout)
+	if len(stale) != 1 || stale[0] != silentId {
+		t.Fatalf("MarkStaleAgents() = %v, want only %v", stale, silentId)
+	}
+	if silent.ConnectionState != ConnectionStateStale || silent.DisconnectReason != DisconnectReasonHeartbeatTimeout || silent.IsOnline() {
+		t.Errorf("silent agent is %s (%q), want stale", silent.ConnectionState, silent.DisconnectReason)
+	}
+	if record, _ := store.Get(ctx, silentId.String()); record.ConnectionState != string(ConnectionStateStale) {
+		t.Errorf("stored state of the silent agent = %q, want stale", record.ConnectionState)
+	}
+	if closed := agents.FindAgent(closedId); closed.ConnectionState != ConnectionStateDisconnected {
+		t.Errorf("disconnected agent became %s", closed.ConnectionState)
+	}
+
+	// An agent that is already stale is not flagged again
+	if stale := agents.MarkStaleAgents(heartbeatTimeout); len(stale) != 0 {
+		t.Errorf("second MarkStaleAgents() = %v, want none", stale)
+	}
+
+	// A report brings the agent back online
+	if !silent.UpdateStatus(&protobufs.AgentToServer{InstanceUid: silentId[:]}, &protobufs.ServerToAgent{}) {
+		t.Error("report of a stale agent was not reported as a change")
+	}
+	if !silent.IsOnline() || silent.DisconnectReason != "" {
+		t.Errorf("agent after report is %s (%q), want connected", silent.ConnectionState, silent.DisconnectReason)
+	}
+}
+
+func TestReapStaleAgents(t *testing.T) {
+	agents := NewAgents(zap.NewNop())
+	s, err := NewServer(agents, nil, nil, 20*time.Millisecond, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentId, _ := connectAgent(t, agents)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.reapStaleAgents(ctx)
+
+	agent := agents.FindAgent(agentId)
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.CloneReadonly().ConnectionState != ConnectionStateStale {
+		if time.Now().After(deadline) {
+			t.Fatal("silent agent was not flagged as stale")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
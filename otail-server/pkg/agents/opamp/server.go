@@ -2,18 +2,44 @@ package opamp
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server"
 	"github.com/open-telemetry/opamp-go/server/types"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
 )
 
+var (
+	ErrAgentOffline            = errors.New("agent is offline")
+	ErrRemoteConfigNotReported = errors.New("agent does not report remote config status")
+	ErrInvalidConfigFileName   = errors.New("invalid config file name")
+	ErrConfigFileNotFound      = errors.New("config file not found")
+	// ErrAgentOfOtherOrganization is returned when a connection presents the
+	// instance UID of an agent of another organization
+	ErrAgentOfOtherOrganization = errors.New("agent belongs to another organization")
+)
+
+// configFileNamePattern matches the names of the named config files of agents.
+// Names start with a letter or digit, which keeps them apart from the names of
+// the shared config files and the instance config and makes them sort between
+// those, so named files override the shared configs, see ConfigFileOrder.
+var configFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// DefaultHeartbeatTimeout is used when no heartbeat timeout is configured. It
+// allows agents to miss a few of the default 30s OpAMP heartbeats.
+const DefaultHeartbeatTimeout = 90 * time.Second
+
 type Server struct {
 	logger      *zap.Logger
 	opampServer server.OpAMPServer
@@ -21,12 +47,20 @@ type Server struct {
 	verifyToken func(string) (string, error)
 	// Callback for agent group and deployment verification
 	onAgentConnected func(ctx context.Context, deploymentName, groupName string) (string, string, error)
-	// Map to store connection metadata
-	connectionMetadata map[types.Connection]struct {
-		OrgID        string
-		GroupID      string
-		DeploymentID string
-	}
+	// Agents that do not report within this duration are flagged as stale
+	heartbeatTimeout time.Duration
+	stopReaper       context.CancelFunc
+	// Map to store connection metadata until the agent identifies itself
+	metadataMux        sync.Mutex
+	connectionMetadata map[types.Connection]connectionMetadata
+}
+
+// connectionMetadata holds what we learned about a connection during the
+// handshake, before the agent sent its instance UID.
+type connectionMetadata struct {
+	OrgID        string
+	GroupID      string
+	DeploymentID string
 }
 
 // zapToOpAmpLogger adapts zap.Logger to opamp's logger interface
@@ -46,18 +80,20 @@ func NewServer(
 	agents *Agents,
 	verifyToken func(string) (string, error),
 	onAgentConnected func(ctx context.Context, deploymentName, groupName string) (string, string, error),
+	heartbeatTimeout time.Duration,
 	logger *zap.Logger,
 ) (*Server, error) {
+	if heartbeatTimeout <= 0 {
+		heartbeatTimeout = DefaultHeartbeatTimeout
+	}
+
 	s := &Server{
-		logger:           logger,
-		agents:           agents,
-		verifyToken:      verifyToken,
-		onAgentConnected: onAgentConnected,
-		connectionMetadata: make(map[types.Connection]struct {
-			OrgID        string
-			GroupID      string
-			DeploymentID string
-		}),
+		logger:             logger,
+		agents:             agents,
+		verifyToken:        verifyToken,
+		onAgentConnected:   onAgentConnected,
+		heartbeatTimeout:   heartbeatTimeout,
+		connectionMetadata: make(map[types.Connection]connectionMetadata),
 	}
 
 	// Create the OPAmp server
@@ -110,20 +146,15 @@ func (s *Server) Start() error {
 						Accept: true,
 						ConnectionCallbacks: server.ConnectionCallbacksStruct{
 							OnConnectedFunc: func(ctx context.Context, conn types.Connection) {
-								// Create a new agent for this connection
-								agentId := uuid.New()
-								agent := s.agents.FindOrCreateAgent(agentId, conn)
-								if agent == nil {
-									s.logger.Warn("Failed to create agent",
-										zap.String("agent_id", agentId.String()))
-									return
+								// The agent is identified by the instance UID carried in its
+								// first message, so only remember the metadata for now.
+								s.metadataMux.Lock()
+								s.connectionMetadata[conn] = connectionMetadata{
+									OrgID:        organizationID,
+									GroupID:      groupID,
+									DeploymentID: deploymentID,
 								}
-
-								s.logger.Info("Created new agent",
-									zap.String("agent_id", agentId.String()))
-
-								// Set all connection metadata at once
-								s.agents.SetConnection(conn, organizationID, groupID, deploymentID)
+								s.metadataMux.Unlock()
 							},
 							OnMessageFunc:         s.onMessage,
 							OnConnectionCloseFunc: s.onDisconnect,
@@ -140,90 +171,464 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start OpAMP server: %w", err)
 	}
 
+	reaperCtx, cancel := context.WithCancel(context.Background())
+	s.stopReaper = cancel
+	go s.reapStaleAgents(reaperCtx)
+
 	return nil
 }
 
 func (s *Server) Stop(ctx context.Context) error {
 	s.logger.Info("Stopping OPAmp server...")
+	if s.stopReaper != nil {
+		s.stopReaper()
+	}
 	s.opampServer.Stop(ctx)
 	return nil
 }
 
-func (s *Server) onDisconnect(conn types.Connection) {
-	// Get agent info for this connection
-	agentIds := s.agents.GetAgentIdsByConnection(conn)
-	if len(agentIds) == 0 {
-		s.logger.Warn("No agents found for connection",
-			zap.Any("connection", conn))
-		return
+// reapStaleAgents periodically flags agents that stopped reporting without
+// closing their connection.
+func (s *Server) reapStaleAgents(ctx context.Context) {
+	ticker := time.NewTicker(s.heartbeatTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.agents.MarkStaleAgents(s.heartbeatTimeout)
+		}
 	}
+}
 
-	// Get the first agent's info
-	agentId := agentIds[0]
-	agentInfo := s.agents.GetAgentInfo(agentId)
-	if agentInfo == nil {
-		s.logger.Warn("No agent info found",
-			zap.String("agent_id", agentId.String()))
-		return
-	}
+func (s *Server) onDisconnect(conn types.Connection) {
+	s.metadataMux.Lock()
+	delete(s.connectionMetadata, conn)
+	s.metadataMux.Unlock()
 
-	// Remove the connection
+	// Unbind the connection, the agent is kept until it reconnects
 	s.agents.RemoveConnection(conn)
 }
 
 func (s *Server) onMessage(ctx context.Context, conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
-	// Get agent info for this connection
-	agentIds := s.agents.GetAgentIdsByConnection(conn)
-	if len(agentIds) == 0 {
-		s.logger.Warn("No agents found for connection",
+	response := &protobufs.ServerToAgent{}
+
+	// Get agent info for this connection, registering the agent on its first message
+	var agent *Agent
+	connected := false
+	if agentIds := s.agents.GetAgentIdsByConnection(conn); len(agentIds) > 0 {
+		agent = s.agents.FindAgent(agentIds[0])
+	} else {
+		agent = s.registerAgent(conn, message, response)
+		connected = true
+	}
+	if agent == nil {
+		s.logger.Warn("No agent found for connection",
 			zap.Any("connection", conn))
 		return nil
 	}
 
-	// Get the first agent's info
-	agentId := agentIds[0]
-	agentInfo := s.agents.GetAgentInfo(agentId)
-	if agentInfo == nil {
-		s.logger.Warn("No agent info found",
-			zap.String("agent_id", agentId.String()))
+	// Process the message. Heartbeats that change nothing are not persisted,
+	// disconnects are persisted by the agents when the connection closes.
+	if changed := agent.UpdateStatus(message, response); changed || connected {
+		if err := s.agents.SaveAgent(ctx, agent.InstanceId); err != nil {
+			s.logger.Error("Failed to persist agent",
+				zap.String("agent_id", agent.InstanceIdStr),
+				zap.Error(err))
+		}
+	}
+	return response
+}
+
+// registerAgent binds the connection to the agent identified by the instance UID
+// of its first message. Agents that did not send a valid UID, or the UID of an
+// agent of another organization, are assigned a new one.
+func (s *Server) registerAgent(conn types.Connection, message *protobufs.AgentToServer, response *protobufs.ServerToAgent) *Agent {
+	s.metadataMux.Lock()
+	metadata, exists := s.connectionMetadata[conn]
+	s.metadataMux.Unlock()
+
+	agentId, err := uuid.FromBytes(message.InstanceUid)
+	if err != nil || agentId == uuid.Nil {
+		agentId = s.assignInstanceUid(response)
+	}
+
+	agent, err := s.agents.FindOrCreateAgent(agentId, conn, metadata.OrgID)
+	if errors.Is(err, ErrAgentOfOtherOrganization) {
+		// The UID can't be trusted, keep the agent of the other organization
+		// and its config away from this connection
+		s.logger.Warn("Agent presented the instance UID of an agent of another organization",
+			zap.String("agent_id", agentId.String()),
+			zap.String("org_id", metadata.OrgID))
+		agentId = s.assignInstanceUid(response)
+		agent, err = s.agents.FindOrCreateAgent(agentId, conn, metadata.OrgID)
+	}
+	if err != nil {
+		s.logger.Error("Failed to register agent",
+			zap.String("agent_id", agentId.String()),
+			zap.Error(err))
 		return nil
 	}
 
-	// Process the message
-	response := &protobufs.ServerToAgent{}
-	agentInfo.Agent.UpdateStatus(message, response)
-	return response
+	if exists {
+		// Set all connection metadata at once
+		s.agents.SetConnection(conn, metadata.OrgID, metadata.GroupID, metadata.DeploymentID)
+	}
+
+	return agent
 }
 
-func (s *Server) GetEffectiveConfig(agentId uuid.UUID) (string, error) {
+// assignInstanceUid generates a new instance UID and tells the agent to use it
+func (s *Server) assignInstanceUid(response *protobufs.ServerToAgent) uuid.UUID {
+	agentId := uuid.New()
+	response.AgentIdentification = &protobufs.AgentIdentification{
+		NewInstanceUid: agentId[:],
+	}
+	s.logger.Info("Assigned new instance UID to agent",
+		zap.String("agent_id", agentId.String()))
+	return agentId
+}
+
+// GetEffectiveConfig returns the config files the agent reported as its
+// effective config, by name.
+func (s *Server) GetEffectiveConfig(agentId uuid.UUID) (map[string]string, error) {
 	agent := s.agents.FindAgent(agentId)
-	if agent != nil {
-		return agent.EffectiveConfig, nil
+	if agent == nil {
+		return nil, fmt.Errorf("agent %s not found", agentId)
 	}
-	return "", fmt.Errorf("agent %s not found", agentId)
+	return agent.CloneReadonly().EffectiveConfigFiles, nil
 }
 
+// GetMergedEffectiveConfig returns the effective config of the agent with its
+// config files merged in the order the agent merges them.
+func (s *Server) GetMergedEffectiveConfig(agentId uuid.UUID) (map[string]interface{}, error) {
+	files, err := s.GetEffectiveConfig(agentId)
+	if err != nil {
+		return nil, err
+	}
+	return mergeConfigFiles(files)
+}
+
+// UpdateConfig validates the config and sets it as the instance config of the
+// agent. A *validation.Error is returned if the config is rejected.
 func (s *Server) UpdateConfig(agentId uuid.UUID, config map[string]interface{}, notifyNextStatusUpdate chan<- struct{}) error {
+	return s.SetConfigFile(agentId, "", config, notifyNextStatusUpdate)
+}
+
+// SetConfigFile validates the config and sets it as the named config file of
+// the agent. The empty name is the instance config of the agent.
+func (s *Server) SetConfigFile(agentId uuid.UUID, name string, config map[string]interface{}, notifyNextStatusUpdate chan<- struct{}) error {
+	if name != "" {
+		if err := ValidateConfigFileName(name); err != nil {
+			return err
+		}
+	}
+	if err := s.validateConfigFile(agentId, name, config); err != nil {
+		return err
+	}
+
+	body, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return s.setConfigFiles(agentId, map[string]string{name: string(body)}, notifyNextStatusUpdate)
+}
+
+// DeleteConfigFile removes the named config file of the agent
+func (s *Server) DeleteConfigFile(agentId uuid.UUID, name string, notifyNextStatusUpdate chan<- struct{}) error {
+	if err := ValidateConfigFileName(name); err != nil {
+		return err
+	}
 	agent := s.agents.FindAgent(agentId)
 	if agent == nil {
 		return fmt.Errorf("agent %s not found", agentId)
 	}
+	if _, ok := agent.CloneReadonly().CustomConfigFiles[name]; !ok {
+		return ErrConfigFileNotFound
+	}
+	if err := s.validateConfigFile(agentId, name, nil); err != nil {
+		return err
+	}
+	return s.setConfigFiles(agentId, map[string]string{name: ""}, notifyNextStatusUpdate)
+}
+
+// GetConfigFiles returns the custom config files of the agent by name,
+// including its instance config under the empty name.
+func (s *Server) GetConfigFiles(agentId uuid.UUID) (map[string]string, error) {
+	agent := s.agents.FindAgent(agentId)
+	if agent == nil {
+		return nil, fmt.Errorf("agent %s not found", agentId)
+	}
+	clone := agent.CloneReadonly()
+	files := copyConfigFiles(clone.CustomConfigFiles)
+	if files == nil {
+		files = map[string]string{}
+	}
+	files[""] = clone.CustomInstanceConfig
+	return files, nil
+}
+
+// ValidateConfig checks the config as the instance config of the agent.
+func (s *Server) ValidateConfig(agentId uuid.UUID, config map[string]interface{}) error {
+	return s.validateConfigFile(agentId, "", config)
+}
 
-	configByte, err := json.Marshal(config)
+// validateConfigFile checks the config the way the agent would run it, i.e.
+// as the named file merged with the other files of its remote config, which
+// include the config shared by its deployment and group, on top of its
+// effective config. A nil config checks
+// the remote config without the named file. The empty name is the instance
+// config.
+func (s *Server) validateConfigFile(agentId uuid.UUID, name string, config map[string]interface{}) error {
+	agent := s.agents.FindAgent(agentId)
+	if agent == nil {
+		return fmt.Errorf("agent %s not found", agentId)
+	}
+	if name == "" {
+		name = InstanceConfigName
+	}
+
+	// The supervisor merges the remote config into its local config, which the
+	// server only knows from the effective config the agent reports, so the
+	// remote config is checked on top of it. E.g. a file with only a processor
+	// is valid on an agent whose local config has the pipelines.
+	base, err := mergeConfigFiles(agent.CloneReadonly().EffectiveConfigFiles)
 	if err != nil {
+		return fmt.Errorf("failed to parse effective config of agent %s: %w", agentId, err)
+	}
+
+	files := agent.RemoteConfigFiles()
+	if config == nil {
+		delete(files, name)
+	} else {
+		files[name] = ""
+	}
+
+	configs := []map[string]interface{}{base}
+	for _, fileName := range ConfigFileOrder(files) {
+		if fileName == name {
+			configs = append(configs, config)
+			continue
+		}
+		parsed, err := validation.ParseConfig([]byte(files[fileName]))
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %q of agent %s: %w", fileName, agentId, err)
+		}
+		configs = append(configs, parsed)
+	}
+
+	merged := validation.MergeConfigs(configs...)
+	if err := validation.ValidateConfig(merged); err != nil {
 		return err
 	}
+	// Flag functions the collector of the agent does not have before the
+	// config is pushed
+	return validation.ValidateFunctions(merged, agent.CollectorVersion())
+}
+
+// ValidateGroupConfig checks the config as the config shared by the agents of
+// the group, the way each agent of the group would run it. An empty config
+// checks its removal.
+func (s *Server) ValidateGroupConfig(groupID string, config []byte) error {
+	return s.validateSharedConfig(s.GetAgentsByGroup(groupID), GroupConfigName, config)
+}
+
+// ValidateDeploymentConfig checks the config as the config shared by the
+// agents of the deployment, like ValidateGroupConfig
+func (s *Server) ValidateDeploymentConfig(deploymentID string, config []byte) error {
+	return s.validateSharedConfig(s.GetAgentsByDeployment(deploymentID), DeploymentConfigName, config)
+}
+
+// validateSharedConfig checks the config as the named shared config file of
+// the agents. Without agents only the config itself can be checked.
+func (s *Server) validateSharedConfig(agents map[uuid.UUID]*Agent, name string, config []byte) error {
+	var parsed map[string]interface{}
+	if len(config) > 0 {
+		var err error
+		parsed, err = validation.ParseConfig(config)
+		if err != nil {
+			return &validation.Error{Errors: []validation.FieldError{{Field: "config", Message: err.Error()}}}
+		}
+	}
+
+	if len(agents) == 0 {
+		if parsed == nil {
+			return nil
+		}
+		if err := validation.ValidateSharedConfig(parsed); err != nil {
+			return err
+		}
+		return validation.ValidateFunctions(parsed, "")
+	}
+
+	// Agents mostly share their config, so report each problem once
+	var errs []validation.FieldError
+	seen := make(map[validation.FieldError]bool)
+	for agentId := range agents {
+		err := s.validateConfigFile(agentId, name, parsed)
+		var validationErr *validation.Error
+		if errors.As(err, &validationErr) {
+			for _, fieldErr := range validationErr.Errors {
+				if !seen[fieldErr] {
+					seen[fieldErr] = true
+					errs = append(errs, fieldErr)
+				}
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return &validation.Error{Errors: errs}
+}
+
+// mergeConfigFiles parses the config files and merges them in the order an
+// agent merges them
+func mergeConfigFiles(files map[string]string) (map[string]interface{}, error) {
+	var configs []map[string]interface{}
+	for _, name := range ConfigFileOrder(files) {
+		parsed, err := validation.ParseConfig([]byte(files[name]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file %q: %w", name, err)
+		}
+		configs = append(configs, parsed)
+	}
+	return validation.MergeConfigs(configs...), nil
+}
+
+// ValidateConfigFileName checks that the name can be used for a named config
+// file of an agent
+func ValidateConfigFileName(name string) error {
+	if !configFileNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidConfigFileName, name)
+	}
+	return nil
+}
+
+// ValidateRawConfig checks the named config file as SetConfigFile would,
+// against the current config files and collector version of the agent, e.g.
+// before a previous config is restored. An empty named file checks its
+// removal.
+func (s *Server) ValidateRawConfig(agentId uuid.UUID, name string, config string) error {
+	if name != "" && config == "" {
+		return s.validateConfigFile(agentId, name, nil)
+	}
+	parsed, err := validation.ParseConfig([]byte(config))
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	return s.validateConfigFile(agentId, name, parsed)
+}
+
+// SetRawConfig sets the instance config of the agent as-is, e.g. to restore a
+// previous CustomInstanceConfig.
+func (s *Server) SetRawConfig(agentId uuid.UUID, config string, notifyNextStatusUpdate chan<- struct{}) error {
+	return s.SetRawConfigFile(agentId, "", config, notifyNextStatusUpdate)
+}
+
+// SetRawConfigFile sets the named config file of the agent as-is. A named file
+// with an empty body is removed.
+func (s *Server) SetRawConfigFile(agentId uuid.UUID, name string, config string, notifyNextStatusUpdate chan<- struct{}) error {
+	if name != "" {
+		if err := ValidateConfigFileName(name); err != nil {
+			return err
+		}
+	}
+	return s.setConfigFiles(agentId, map[string]string{name: config}, notifyNextStatusUpdate)
+}
+
+// setConfigFiles replaces the given custom config files of the agent and
+// persists it. A named file with an empty body is removed.
+func (s *Server) setConfigFiles(agentId uuid.UUID, files map[string]string, notifyNextStatusUpdate chan<- struct{}) error {
+	agent := s.agents.FindAgent(agentId)
+	if agent == nil {
+		return fmt.Errorf("agent %s not found", agentId)
+	}
 
 	configMap := &protobufs.AgentConfigMap{
-		ConfigMap: map[string]*protobufs.AgentConfigFile{
-			"": {Body: configByte},
-		},
+		ConfigMap: map[string]*protobufs.AgentConfigFile{},
+	}
+	for name, body := range files {
+		configMap.ConfigMap[name] = &protobufs.AgentConfigFile{
+			Body:        []byte(body),
+			ContentType: ConfigContentType,
+		}
 	}
 
 	s.agents.SetCustomConfigForAgent(agentId, configMap, notifyNextStatusUpdate)
+
+	if err := s.agents.SaveAgent(context.Background(), agentId); err != nil {
+		s.logger.Error("Failed to persist agent config",
+			zap.String("agent_id", agentId.String()),
+			zap.Error(err))
+	}
 	return nil
 }
 
+// WaitForRemoteConfig waits until the agent reports the outcome of applying its
+// current remote config, i.e. APPLIED or FAILED for the matching config hash.
+// It returns the last known status and ctx.Err() if the context is done first.
+func (s *Server) WaitForRemoteConfig(ctx context.Context, agentId uuid.UUID) (protobufs.RemoteConfigStatuses, string, error) {
+	agent := s.agents.FindAgent(agentId)
+	if agent == nil {
+		return protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET, "", fmt.Errorf("agent %s not found", agentId)
+	}
+	if !agent.IsOnline() {
+		return protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET, "", ErrAgentOffline
+	}
+	if !agent.ReportsRemoteConfig() {
+		return protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET, "", ErrRemoteConfigNotReported
+	}
+
+	for {
+		// Register before checking so that an update in between is not missed
+		statusUpdated := make(chan struct{}, 1)
+		agent.addStatusWatcher(statusUpdated)
+
+		status, errMsg := agent.RemoteConfigApplyStatus()
+		switch status {
+		case protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLIED,
+			protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED:
+			return status, errMsg, nil
+		}
+
+		select {
+		case <-statusUpdated:
+		case <-ctx.Done():
+			return status, errMsg, ctx.Err()
+		}
+	}
+}
+
+// GetAgent returns a readonly copy of the agent, or nil if it is unknown
+func (s *Server) GetAgent(agentId uuid.UUID) *Agent {
+	return s.agents.GetAgentReadonlyClone(agentId)
+}
+
+// UpdateGroupConfig sets the remote config shared by the agents of a group and
+// pushes the recomputed remote config to the connected ones.
+func (s *Server) UpdateGroupConfig(groupID string, config []byte) {
+	agentIds := s.agents.SetGroupConfig(groupID, config)
+	s.logger.Info("Updated group config",
+		zap.String("group_id", groupID),
+		zap.Int("agent_count", len(agentIds)))
+}
+
+// UpdateDeploymentConfig sets the remote config shared by the agents of a
+// deployment and pushes the recomputed remote config to the connected ones.
+func (s *Server) UpdateDeploymentConfig(deploymentID string, config []byte) {
+	agentIds := s.agents.SetDeploymentConfig(deploymentID, config)
+	s.logger.Info("Updated deployment config",
+		zap.String("deployment_id", deploymentID),
+		zap.Int("agent_count", len(agentIds)))
+}
+
 func (s *Server) ListAgents() map[uuid.UUID]*Agent {
 	return s.agents.GetAllAgentsReadonlyClone()
 }
@@ -0,0 +1,273 @@
+package opamp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// groupConfig is a complete collector config shared by a group
+const groupConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc: {}
+exporters:
+  debug:
+    verbosity: basic
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [debug]
+`
+
+// newTestServer returns a server with one agent of the group "group-1", which
+// shares groupConfig
+func newTestServer(t *testing.T) (*Server, uuid.UUID) {
+	t.Helper()
+	agents := NewAgents(zap.NewNop())
+	s, err := NewServer(agents, nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentId := uuid.New()
+	conn := &mockConnection{id: agentId.String()}
+	if _, err := agents.FindOrCreateAgent(agentId, conn, "org-1"); err != nil {
+		t.Fatal(err)
+	}
+	agents.SetConnection(conn, "org-1", "group-1", "")
+	agents.SetGroupConfig("group-1", []byte(groupConfig))
+	return s, agentId
+}
+
+func TestNamedConfigFiles(t *testing.T) {
+	s, agentId := newTestServer(t)
+
+	// A named file overrides the group config
+	named := map[string]interface{}{
+		"exporters": map[string]interface{}{
+			"debug": map[string]interface{}{"verbosity": "detailed"},
+		},
+	}
+	if err := s.SetConfigFile(agentId, "debug.yaml", named, nil); err != nil {
+		t.Fatalf("SetConfigFile() = %v", err)
+	}
+	merged, err := mergeConfigFiles(s.GetAgent(agentId).RemoteConfigFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug := merged["exporters"].(map[string]interface{})["debug"].(map[string]interface{})
+	if debug["verbosity"] != "detailed" {
+		t.Errorf("verbosity = %v, want the named file to override the group config", debug["verbosity"])
+	}
+
+	files, err := s.GetConfigFiles(agentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["debug.yaml"]; !ok || len(files) != 2 {
+		t.Errorf("GetConfigFiles() = %v, want debug.yaml and the instance config", files)
+	}
+
+	// A named file that breaks the merged config is rejected
+	broken := map[string]interface{}{
+		"service": map[string]interface{}{
+			"pipelines": map[string]interface{}{
+				"traces": map[string]interface{}{"exporters": []interface{}{"otlp"}},
+			},
+		},
+	}
+	var validationErr *validation.Error
+	if err := s.SetConfigFile(agentId, "broken.yaml", broken, nil); !errors.As(err, &validationErr) {
+		t.Errorf("SetConfigFile(broken.yaml) = %v, want a validation error", err)
+	}
+	if _, ok := s.GetAgent(agentId).CustomConfigFiles["broken.yaml"]; ok {
+		t.Error("rejected file was stored")
+	}
+
+	// Names of the shared configs and of the instance config can't be used
+	for _, name := range []string{GroupConfigName, DeploymentConfigName, InstanceConfigName, "a/b"} {
+		if err := s.SetConfigFile(agentId, name, named, nil); !errors.Is(err, ErrInvalidConfigFileName) {
+			t.Errorf("SetConfigFile(%q) = %v, want %v", name, err, ErrInvalidConfigFileName)
+		}
+	}
+
+	if err := s.DeleteConfigFile(agentId, "debug.yaml", nil); err != nil {
+		t.Fatalf("DeleteConfigFile() = %v", err)
+	}
+	if _, ok := s.GetAgent(agentId).RemoteConfigFiles()["debug.yaml"]; ok {
+		t.Error("deleted file is still in the remote config")
+	}
+	if err := s.DeleteConfigFile(agentId, "debug.yaml", nil); !errors.Is(err, ErrConfigFileNotFound) {
+		t.Errorf("DeleteConfigFile() of a missing file = %v, want %v", err, ErrConfigFileNotFound)
+	}
+}
+
+func TestConfigFunctionsOfAgentVersion(t *testing.T) {
+	tailSampling := map[string]interface{}{
+		"processors": map[string]interface{}{
+			"tail_sampling": map[string]interface{}{
+				"policies": []interface{}{
+					map[string]interface{}{
+						"name":           "weekday",
+						"type":           "ottl_condition",
+						"ottl_condition": map[string]interface{}{"span": []interface{}{`Weekday(Now()) == 1`}},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		version string
+		wantErr bool
+	}{
+		// Weekday was added in v0.121.0
+		{version: "0.120.0", wantErr: true},
+		{version: "0.121.0"},
+		{version: "0.131.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			s, agentId := newTestServer(t)
+			s.agents.FindAgent(agentId).UpdateStatus(&protobufs.AgentToServer{
+				AgentDescription: &protobufs.AgentDescription{
+					IdentifyingAttributes: []*protobufs.KeyValue{{
+						Key:   "service.version",
+						Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: tt.version}},
+					}},
+				},
+			}, &protobufs.ServerToAgent{})
+
+			err := s.SetConfigFile(agentId, "sampling", tailSampling, nil)
+			var validationErr *validation.Error
+			if tt.wantErr != errors.As(err, &validationErr) {
+				t.Errorf("SetConfigFile() = %v, want a validation error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterAgentRebinding(t *testing.T) {
+	const instanceConfig = "processors:\n  batch: {}\n"
+	s, agentId := newTestServer(t)
+	agent := s.agents.FindAgent(agentId)
+	agent.CustomInstanceConfig = instanceConfig
+
+	register := func(orgID string) (*Agent, *protobufs.ServerToAgent) {
+		conn := &mockConnection{id: uuid.NewString()}
+		s.connectionMetadata[conn] = connectionMetadata{OrgID: orgID, GroupID: "group-1"}
+		response := &protobufs.ServerToAgent{}
+		registered := s.registerAgent(conn, &protobufs.AgentToServer{InstanceUid: agentId[:]}, response)
+		if registered == nil {
+			t.Fatalf("registerAgent for %s returned no agent", orgID)
+		}
+		return registered, response
+	}
+
+	// Another organization presenting the UID gets a new agent and UID
+	other, response := register("org-2")
+	if other == agent {
+		t.Fatal("connection of org-2 was bound to the agent of org-1")
+	}
+	if response.AgentIdentification == nil {
+		t.Fatal("connection of org-2 was not assigned a new instance UID")
+	}
+	newId, err := uuid.FromBytes(response.AgentIdentification.NewInstanceUid)
+	if err != nil || newId == agentId {
+		t.Fatalf("new instance UID = %v (%v), want a UID other than %v", newId, err, agentId)
+	}
+	if other.CustomInstanceConfig != "" {
+		t.Errorf("agent of org-2 has instance config %q, want none", other.CustomInstanceConfig)
+	}
+	if info := s.agents.GetAgentInfo(newId); info == nil || info.OrgID != "org-2" {
+		t.Errorf("agent %v is not registered to org-2", newId)
+	}
+	if info := s.agents.GetAgentInfo(agentId); info.OrgID != "org-1" || info.Agent.CustomInstanceConfig != instanceConfig {
+		t.Errorf("agent of org-1 changed to org %q with instance config %q", info.OrgID, info.Agent.CustomInstanceConfig)
+	}
+
+	// The same organization reconnecting keeps the agent and its config
+	same, response := register("org-1")
+	if same != agent || response.AgentIdentification != nil {
+		t.Error("reconnect of org-1 was not bound to its agent")
+	}
+	if same.CustomInstanceConfig != instanceConfig {
+		t.Errorf("instance config after reconnect = %q, want %q", same.CustomInstanceConfig, instanceConfig)
+	}
+}
+
+func TestWaitForRemoteConfig(t *testing.T) {
+	s, agentId := newTestServer(t)
+	agent := s.agents.FindAgent(agentId)
+	agent.mux.RLock()
+	configHash := agent.remoteConfig.ConfigHash
+	agent.mux.RUnlock()
+
+	report := func(hash []byte, status protobufs.RemoteConfigStatuses, errMsg string) {
+		agent.UpdateStatus(&protobufs.AgentToServer{
+			InstanceUid:  agentId[:],
+			Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig),
+			RemoteConfigStatus: &protobufs.RemoteConfigStatus{
+				LastRemoteConfigHash: hash,
+				Status:               status,
+				ErrorMessage:         errMsg,
+			},
+		}, &protobufs.ServerToAgent{})
+	}
+
+	// Agents that don't report the outcome are not waited for
+	if _, _, err := s.WaitForRemoteConfig(context.Background(), agentId); !errors.Is(err, ErrRemoteConfigNotReported) {
+		t.Errorf("WaitForRemoteConfig() without the capability = %v, want %v", err, ErrRemoteConfigNotReported)
+	}
+
+	// The outcome of a previous config is not the outcome of the current one
+	report([]byte("previous"), protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLIED, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	status, _, err := s.WaitForRemoteConfig(ctx, agentId)
+	if !errors.Is(err, context.DeadlineExceeded) || status != protobufs.RemoteConfigStatuses_RemoteConfigStatuses_UNSET {
+		t.Errorf("WaitForRemoteConfig() after a previous config = %v, %v, want UNSET and %v", status, err, context.DeadlineExceeded)
+	}
+
+	// Applying is not an outcome yet
+	type outcome struct {
+		status protobufs.RemoteConfigStatuses
+		errMsg string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		status, errMsg, err := s.WaitForRemoteConfig(context.Background(), agentId)
+		done <- outcome{status, errMsg, err}
+	}()
+	report(configHash, protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLYING, "")
+	select {
+	case got := <-done:
+		t.Fatalf("WaitForRemoteConfig() returned %v while applying", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	report(configHash, protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED, "unknown exporter")
+	select {
+	case got := <-done:
+		if got.err != nil || got.status != protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED || got.errMsg != "unknown exporter" {
+			t.Errorf("WaitForRemoteConfig() = %v, want FAILED with the error message", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForRemoteConfig() did not return after the agent failed to apply the config")
+	}
+
+	// Offline agents can't report the outcome
+	s.agents.RemoveConnection(agent.conn)
+	if _, _, err := s.WaitForRemoteConfig(context.Background(), agentId); !errors.Is(err, ErrAgentOffline) {
+		t.Errorf("WaitForRemoteConfig() of an offline agent = %v, want %v", err, ErrAgentOffline)
+	}
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.uber.org/zap"
+)
+
+// AgentRecord is the persisted state of an OpAMP agent
+type AgentRecord struct {
+	ID           string `bson:"_id" json:"id"`
+	OrgID        string `bson:"org_id" json:"org_id"`
+	GroupID      string `bson:"group_id" json:"group_id"`
+	DeploymentID string `bson:"deployment_id" json:"deployment_id"`
+	// Status is the last protobufs.AgentToServer reported by the agent, including
+	// its agent description, health and remote config status.
+	Status       []byte `bson:"status" json:"status"`
+	CustomConfig string `bson:"custom_config" json:"custom_config"`
+	// CustomConfigFiles are the named config files of the agent besides CustomConfig
+	CustomConfigFiles map[string]string `bson:"custom_config_files" json:"custom_config_files"`
+	ConnectionState   string            `bson:"connection_state" json:"connection_state"`
+	DisconnectReason  string            `bson:"disconnect_reason" json:"disconnect_reason"`
+	StartedAt         time.Time         `bson:"started_at" json:"started_at"`
+	LastSeen          time.Time         `bson:"last_seen" json:"last_seen"`
+	CreatedAt         time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+type Store interface {
+	Save(ctx context.Context, record *AgentRecord) error
+	Get(ctx context.Context, id string) (*AgentRecord, error)
+	List(ctx context.Context) ([]*AgentRecord, error)
+	SetDisconnected(ctx context.Context, id, state, reason string, lastSeen time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+type MongoStore struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+}
+
+func NewMongoStore(db *mongo.Database, logger *zap.Logger) *MongoStore {
+	return &MongoStore{
+		collection: db.Collection("agents"),
+		logger:     logger,
+	}
+}
+
+// Save upserts the record, keeping the original creation time of existing agents
+func (s *MongoStore) Save(ctx context.Context, record *AgentRecord) error {
+	now := time.Now()
+	record.UpdatedAt = now
+
+	_, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": record.ID},
+		bson.M{
+			"$set": bson.M{
+				"org_id":              record.OrgID,
+				"group_id":            record.GroupID,
+				"deployment_id":       record.DeploymentID,
+				"status":              record.Status,
+				"custom_config":       record.CustomConfig,
+				"custom_config_files": record.CustomConfigFiles,
+				"connection_state":    record.ConnectionState,
+				"disconnect_reason":   record.DisconnectReason,
+				"started_at":          record.StartedAt,
+				"last_seen":           record.LastSeen,
+				"updated_at":          record.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoStore) Get(ctx context.Context, id string) (*AgentRecord, error) {
+	var record AgentRecord
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *MongoStore) List(ctx context.Context) ([]*AgentRecord, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []*AgentRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SetDisconnected records that the agent is no longer reporting and when it
+// last did, without touching the rest of its persisted state
+func (s *MongoStore) SetDisconnected(ctx context.Context, id, state, reason string, lastSeen time.Time) error {
+	_, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"connection_state":  state,
+			"disconnect_reason": reason,
+			"last_seen":         lastSeen,
+			"updated_at":        time.Now(),
+		}},
+	)
+	return err
+}
+
+func (s *MongoStore) Delete(ctx context.Context, id string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,154 @@
+package revisions
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// DiffOp is the kind of change of a line in a diff
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = " "
+	DiffInsert DiffOp = "+"
+	DiffDelete DiffOp = "-"
+)
+
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff is the line diff between two revisions of the same target
+type Diff struct {
+	From    *Revision  `json:"from"`
+	To      *Revision  `json:"to"`
+	Lines   []DiffLine `json:"lines"`
+	Added   int        `json:"added"`
+	Removed int        `json:"removed"`
+}
+
+// maxDiffEdits bounds the work of diffing two configs. Configs that differ in
+// more lines are shown as entirely replaced.
+const maxDiffEdits = 1000
+
+// diffConfigs computes a line diff of two configs. JSON configs are indented
+// first so that the diff is per field rather than a single changed line.
+func diffConfigs(from, to string) []DiffLine {
+	a := strings.Split(normalizeConfig(from), "\n")
+	b := strings.Split(normalizeConfig(to), "\n")
+
+	// Only the lines between the common prefix and suffix need to be diffed
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+	lines = append(lines, diffLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script from a to b with Myers'
+// algorithm, in O((len(a)+len(b))·D) time for D edits
+func diffLines(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k = x-y, trace[d] the
+	// diagonals -d..d of v after d edits
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, slices.Clone(v[offset-d:offset+d+1]))
+				return backtrackDiff(a, b, trace)
+			}
+		}
+		trace = append(trace, slices.Clone(v[offset-d:offset+d+1]))
+	}
+
+	lines := make([]DiffLine, 0, n+m)
+	for _, text := range a {
+		lines = append(lines, DiffLine{Op: DiffDelete, Text: text})
+	}
+	for _, text := range b {
+		lines = append(lines, DiffLine{Op: DiffInsert, Text: text})
+	}
+	return lines
+}
+
+// backtrackDiff walks the trace of diffLines back from the end of a and b to
+// build the edit script
+func backtrackDiff(a, b []string, trace [][]int) []DiffLine {
+	var lines []DiffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		// trace[d-1] holds the diagonals -(d-1)..d-1
+		previous := trace[d-1]
+		at := func(k int) int { return previous[k+d-1] }
+
+		k := x - y
+		previousK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			previousK = k + 1
+		}
+		previousX := at(previousK)
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			lines = append(lines, DiffLine{Op: DiffEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == previousX {
+			lines = append(lines, DiffLine{Op: DiffInsert, Text: b[y-1]})
+			y--
+		} else {
+			lines = append(lines, DiffLine{Op: DiffDelete, Text: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: a[x-1]})
+		x--
+	}
+
+	slices.Reverse(lines)
+	return lines
+}
+
+func normalizeConfig(config string) string {
+	var out bytes.Buffer
+	if json.Valid([]byte(config)) && json.Indent(&out, []byte(config), "", "  ") == nil {
+		return out.String()
+	}
+	return config
+}
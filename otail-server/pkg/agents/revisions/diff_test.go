@@ -0,0 +1,114 @@
+package revisions
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     []DiffLine
+	}{
+		{
+			name: "equal",
+			from: "a\nb",
+			to:   "a\nb",
+			want: []DiffLine{{DiffEqual, "a"}, {DiffEqual, "b"}},
+		},
+		{
+			name: "changed line",
+			from: "a\nb\nc",
+			to:   "a\nx\nc",
+			want: []DiffLine{{DiffEqual, "a"}, {DiffDelete, "b"}, {DiffInsert, "x"}, {DiffEqual, "c"}},
+		},
+		{
+			name: "inserted and removed lines",
+			from: "a\nb\nc\nd",
+			to:   "b\nc\nx\nd",
+			want: []DiffLine{{DiffDelete, "a"}, {DiffEqual, "b"}, {DiffEqual, "c"}, {DiffInsert, "x"}, {DiffEqual, "d"}},
+		},
+		{
+			name: "json is indented",
+			from: `{"a":1,"b":2}`,
+			to:   `{"a":1,"b":3}`,
+			want: []DiffLine{{DiffEqual, "{"}, {DiffEqual, `  "a": 1,`}, {DiffDelete, `  "b": 2`}, {DiffInsert, `  "b": 3`}, {DiffEqual, "}"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffConfigs(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffConfigs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffLinesIsShortest checks that the edit scripts of random configs turn
+// one config into the other with the fewest edits
+func TestDiffLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = fmt.Sprint(rng.Intn(4))
+		}
+		return lines
+	}
+
+	for i := 0; i < 200; i++ {
+		a, b := randomLines(), randomLines()
+		var from, to []string
+		edits := 0
+		for _, line := range diffLines(a, b) {
+			if line.Op != DiffInsert {
+				from = append(from, line.Text)
+			}
+			if line.Op != DiffDelete {
+				to = append(to, line.Text)
+			}
+			if line.Op != DiffEqual {
+				edits++
+			}
+		}
+		if strings.Join(from, ",") != strings.Join(a, ",") || strings.Join(to, ",") != strings.Join(b, ",") {
+			t.Fatalf("diff of %q and %q does not reproduce them: %q, %q", a, b, from, to)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("diff of %q and %q has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesLimitsEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i < maxDiffEdits; i++ {
+		a = append(a, fmt.Sprint("a", i))
+		b = append(b, fmt.Sprint("b", i))
+	}
+	lines := diffLines(a, b)
+	if len(lines) != 2*maxDiffEdits || lines[0].Op != DiffDelete || lines[len(lines)-1].Op != DiffInsert {
+		t.Errorf("diff of completely different configs is not a replacement")
+	}
+}
+
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
@@ -0,0 +1,139 @@
+package revisions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/mottibec/otail-server/pkg/auth"
+	"go.uber.org/zap"
+)
+
+type Handler struct {
+	service *Service
+	logger  *zap.Logger
+}
+
+func NewHandler(service *Service, logger *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Get("/", h.ListRevisions)
+	r.Get("/{id}", h.GetRevision)
+	r.Get("/{id}/diff/{otherId}", h.DiffRevisions)
+	r.Post("/{id}/rollback", h.Rollback)
+}
+
+func (h *Handler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	targetType := TargetType(r.URL.Query().Get("target_type"))
+	targetID := r.URL.Query().Get("target_id")
+	if targetID == "" {
+		h.writeError(w, http.StatusBadRequest, "target_id is required")
+		return
+	}
+
+	revisions, err := h.service.List(r.Context(), orgID, targetType, targetID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, revisions)
+}
+
+func (h *Handler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	revision, err := h.service.Get(r.Context(), orgID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, revision)
+}
+
+func (h *Handler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	diff, err := h.service.Diff(r.Context(), orgID, chi.URLParam(r, "id"), chi.URLParam(r, "otherId"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, diff)
+}
+
+func (h *Handler) Rollback(w http.ResponseWriter, r *http.Request) {
+	change := ChangeFromRequest(r)
+
+	// The request body is optional and only carries the change message
+	var req struct {
+		Message string `json:"message"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	change.Message = req.Message
+
+	revision, err := h.service.Rollback(r.Context(), chi.URLParam(r, "id"), change)
+	if err != nil {
+		h.logger.Error("Failed to roll back revision", zap.Error(err))
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, revision)
+}
+
+// ChangeFromRequest returns the organization and author of the authenticated
+// request, with the change message taken from the message query parameter
+func ChangeFromRequest(r *http.Request) Change {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	userID, _ := r.Context().Value(auth.UserIDKey).(string)
+	return Change{
+		OrgID:   orgID,
+		Author:  userID,
+		Message: r.URL.Query().Get("message"),
+	}
+}
+
+// writeServiceError maps service errors to HTTP status codes
+func (h *Handler) writeServiceError(w http.ResponseWriter, err error) {
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		// The config of the revision is no longer valid for its target
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid configuration",
+			"errors": validationErr.Errors,
+		})
+	case errors.Is(err, ErrRevisionNotFound):
+		h.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrTargetMismatch), errors.Is(err, ErrInvalidTargetType):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
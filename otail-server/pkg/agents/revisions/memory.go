@@ -0,0 +1,95 @@
+package revisions
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store that keeps the revisions in memory, for tests
+type MemoryStore struct {
+	mu        sync.Mutex
+	revisions []*Revision
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, revision *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.revisions {
+		if existing.TargetType == revision.TargetType && existing.TargetID == revision.TargetID &&
+			existing.Version == revision.Version {
+			return ErrVersionExists
+		}
+	}
+	revision.ID = uuid.New().String()
+	revision.CreatedAt = time.Now()
+	stored := *revision
+	s.revisions = append(s.revisions, &stored)
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, revision := range s.revisions {
+		if revision.ID == id {
+			found := *revision
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Latest(ctx context.Context, targetType TargetType, targetID string) (*Revision, error) {
+	return s.latest(func(revision *Revision) bool {
+		return revision.TargetType == targetType && revision.TargetID == targetID
+	}), nil
+}
+
+func (s *MemoryStore) LatestOfFile(ctx context.Context, targetType TargetType, targetID, file string) (*Revision, error) {
+	return s.latest(func(revision *Revision) bool {
+		return revision.TargetType == targetType && revision.TargetID == targetID && revision.File == file
+	}), nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, targetType TargetType, targetID string) ([]*Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revisions []*Revision
+	for _, revision := range s.revisions {
+		if revision.TargetType == targetType && revision.TargetID == targetID {
+			found := *revision
+			revisions = append(revisions, &found)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Version > revisions[j].Version })
+	return revisions, nil
+}
+
+// latest returns the revision with the highest version of those matching
+func (s *MemoryStore) latest(match func(*Revision) bool) *Revision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *Revision
+	for _, revision := range s.revisions {
+		if match(revision) && (latest == nil || revision.Version > latest.Version) {
+			latest = revision
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	found := *latest
+	return &found
+}
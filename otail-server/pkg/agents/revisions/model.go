@@ -0,0 +1,181 @@
+package revisions
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrRevisionNotFound  = errors.New("revision not found")
+	ErrTargetMismatch    = errors.New("revisions belong to different targets")
+	ErrInvalidTargetType = errors.New("invalid target type")
+	// ErrVersionExists is returned by Store.Create when another revision of
+	// the target was created with the same version
+	ErrVersionExists = errors.New("revision version already exists")
+)
+
+// TargetType is the kind of entity a config revision was pushed to
+type TargetType string
+
+const (
+	TargetAgent TargetType = "agent"
+	TargetGroup TargetType = "group"
+)
+
+func (t TargetType) valid() bool {
+	return t == TargetAgent || t == TargetGroup
+}
+
+// Revision is an immutable snapshot of a config pushed to an agent or a group
+type Revision struct {
+	ID         string     `bson:"_id" json:"id"`
+	OrgID      string     `bson:"org_id" json:"org_id"`
+	TargetType TargetType `bson:"target_type" json:"target_type"`
+	TargetID   string     `bson:"target_id" json:"target_id"`
+	File       string     `bson:"file,omitempty" json:"file,omitempty"`
+	Version    int        `bson:"version" json:"version"`
+	Hash       string     `bson:"hash" json:"hash"`
+	Config     string     `bson:"config" json:"config"`
+	Author     string     `bson:"author" json:"author"`
+	Message    string     `bson:"message" json:"message"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+}
+
+// Change describes who pushed a config and why
+type Change struct {
+	OrgID   string
+	Author  string
+	Message string
+	// File is the named config file of an agent the config was pushed to,
+	// empty for the instance config of the agent and for groups. An empty
+	// config of a named file records its removal.
+	File string
+}
+
+type Store interface {
+	// Create stores the revision, or returns ErrVersionExists if the target
+	// already has a revision of its version
+	Create(ctx context.Context, revision *Revision) error
+	Get(ctx context.Context, id string) (*Revision, error)
+	// Latest returns the revision of the target with the highest version
+	Latest(ctx context.Context, targetType TargetType, targetID string) (*Revision, error)
+	// LatestOfFile returns the latest revision of the config file of the target
+	LatestOfFile(ctx context.Context, targetType TargetType, targetID, file string) (*Revision, error)
+	List(ctx context.Context, targetType TargetType, targetID string) ([]*Revision, error)
+}
+
+type MongoStore struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+}
+
+// NewMongoStore creates the revisions store. Versions are unique per target so
+// that concurrent changes can't record the same version twice.
+func NewMongoStore(ctx context.Context, db *mongo.Database, logger *zap.Logger) (*MongoStore, error) {
+	collection := db.Collection("config_revisions")
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "target_type", Value: 1},
+				{Key: "target_id", Value: 1},
+				{Key: "version", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return nil, err
+	}
+
+	return &MongoStore{
+		collection: collection,
+		logger:     logger,
+	}, nil
+}
+
+func (s *MongoStore) Create(ctx context.Context, revision *Revision) error {
+	revision.ID = uuid.New().String()
+	revision.CreatedAt = time.Now()
+
+	_, err := s.collection.InsertOne(ctx, revision)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionExists
+	}
+	return err
+}
+
+func (s *MongoStore) Get(ctx context.Context, id string) (*Revision, error) {
+	var revision Revision
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&revision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (s *MongoStore) Latest(ctx context.Context, targetType TargetType, targetID string) (*Revision, error) {
+	var revision Revision
+	err := s.collection.FindOne(
+		ctx,
+		bson.M{"target_type": targetType, "target_id": targetID},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&revision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (s *MongoStore) LatestOfFile(ctx context.Context, targetType TargetType, targetID, file string) (*Revision, error) {
+	// The file of instance and group configs is empty and not stored
+	filter := bson.M{"target_type": targetType, "target_id": targetID, "file": file}
+	if file == "" {
+		filter["file"] = bson.M{"$exists": false}
+	}
+
+	var revision Revision
+	err := s.collection.FindOne(
+		ctx,
+		filter,
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&revision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (s *MongoStore) List(ctx context.Context, targetType TargetType, targetID string) ([]*Revision, error) {
+	cursor, err := s.collection.Find(
+		ctx,
+		bson.M{"target_type": targetType, "target_id": targetID},
+		options.Find().SetSort(bson.D{{Key: "version", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var revisions []*Revision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
@@ -0,0 +1,179 @@
+package revisions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ApplyFunc pushes a config to the agent or group with the given ID, as the
+// named config file of an agent if file is not empty
+type ApplyFunc func(ctx context.Context, targetID, file, config string) error
+
+// Service records config revisions and rolls targets back to them
+type Service struct {
+	store      Store
+	applyAgent ApplyFunc
+	applyGroup ApplyFunc
+	logger     *zap.Logger
+}
+
+// NewService creates a new revisions service. The apply functions are used to
+// push the config of a revision when rolling back.
+func NewService(store Store, applyAgent, applyGroup ApplyFunc, logger *zap.Logger) *Service {
+	return &Service{
+		store:      store,
+		applyAgent: applyAgent,
+		applyGroup: applyGroup,
+		logger:     logger,
+	}
+}
+
+// maxRecordAttempts bounds how often Record retries when concurrent changes
+// of the same target take the version it allocated
+const maxRecordAttempts = 5
+
+// Record stores the config as a new revision of the target. Nothing is recorded
+// if the config is identical to the latest revision of the same file.
+func (s *Service) Record(ctx context.Context, targetType TargetType, targetID, config string, change Change) (*Revision, error) {
+	if !targetType.valid() {
+		return nil, ErrInvalidTargetType
+	}
+
+	sum := sha256.Sum256([]byte(config))
+	hash := hex.EncodeToString(sum[:])
+
+	for attempt := 1; ; attempt++ {
+		previous, err := s.store.LatestOfFile(ctx, targetType, targetID, change.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest revision of file: %w", err)
+		}
+		if previous != nil && previous.Hash == hash {
+			return previous, nil
+		}
+
+		latest, err := s.store.Latest(ctx, targetType, targetID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest revision: %w", err)
+		}
+
+		revision := &Revision{
+			OrgID:      change.OrgID,
+			TargetType: targetType,
+			TargetID:   targetID,
+			File:       change.File,
+			Version:    1,
+			Hash:       hash,
+			Config:     config,
+			Author:     change.Author,
+			Message:    change.Message,
+		}
+		if latest != nil {
+			revision.Version = latest.Version + 1
+		}
+
+		err = s.store.Create(ctx, revision)
+		if errors.Is(err, ErrVersionExists) && attempt < maxRecordAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create revision: %w", err)
+		}
+
+		s.logger.Info("Recorded config revision",
+			zap.String("target_type", string(targetType)),
+			zap.String("target_id", targetID),
+			zap.Int("version", revision.Version))
+
+		return revision, nil
+	}
+}
+
+// List returns the revisions of the target, newest first
+func (s *Service) List(ctx context.Context, orgID string, targetType TargetType, targetID string) ([]*Revision, error) {
+	if !targetType.valid() {
+		return nil, ErrInvalidTargetType
+	}
+
+	revisions, err := s.store.List(ctx, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Revision{}
+	for _, revision := range revisions {
+		if revision.OrgID == orgID {
+			result = append(result, revision)
+		}
+	}
+	return result, nil
+}
+
+// Get returns the revision if it belongs to the organization
+func (s *Service) Get(ctx context.Context, orgID, id string) (*Revision, error) {
+	revision, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil || revision.OrgID != orgID {
+		return nil, ErrRevisionNotFound
+	}
+	return revision, nil
+}
+
+// Diff returns the line diff between two revisions of the same target
+func (s *Service) Diff(ctx context.Context, orgID, fromID, toID string) (*Diff, error) {
+	from, err := s.Get(ctx, orgID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.Get(ctx, orgID, toID)
+	if err != nil {
+		return nil, err
+	}
+	if from.TargetType != to.TargetType || from.TargetID != to.TargetID || from.File != to.File {
+		return nil, ErrTargetMismatch
+	}
+
+	diff := &Diff{
+		From:  from,
+		To:    to,
+		Lines: diffConfigs(from.Config, to.Config),
+	}
+	for _, line := range diff.Lines {
+		switch line.Op {
+		case DiffInsert:
+			diff.Added++
+		case DiffDelete:
+			diff.Removed++
+		}
+	}
+	return diff, nil
+}
+
+// Rollback pushes the config of the revision to its target again and records
+// it as a new revision
+func (s *Service) Rollback(ctx context.Context, id string, change Change) (*Revision, error) {
+	revision, err := s.Get(ctx, change.OrgID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := s.applyAgent
+	if revision.TargetType == TargetGroup {
+		apply = s.applyGroup
+	}
+	if err := apply(ctx, revision.TargetID, revision.File, revision.Config); err != nil {
+		return nil, fmt.Errorf("failed to apply revision %d: %w", revision.Version, err)
+	}
+
+	if change.Message == "" {
+		change.Message = fmt.Sprintf("Rollback to version %d", revision.Version)
+	}
+	change.File = revision.File
+	return s.Record(ctx, revision.TargetType, revision.TargetID, revision.Config, change)
+}
@@ -0,0 +1,162 @@
+package revisions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/mottibec/otail-server/pkg/auth"
+	"go.uber.org/zap"
+)
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(NewMemoryStore(), nil, nil, zap.NewNop())
+	record := func(file, config string) *Revision {
+		t.Helper()
+		revision, err := s.Record(ctx, TargetAgent, "agent-1", config, Change{OrgID: "org-1", File: file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revision
+	}
+
+	steps := []struct {
+		file        string
+		config      string
+		wantVersion int
+	}{
+		{file: "", config: "a: 1", wantVersion: 1},
+		{file: "", config: "a: 1", wantVersion: 1},
+		{file: "exporters", config: "b: 1", wantVersion: 2},
+		// Interleaved changes of other files don't hide unchanged configs
+		{file: "", config: "a: 1", wantVersion: 1},
+		{file: "exporters", config: "b: 1", wantVersion: 2},
+		{file: "exporters", config: "b: 2", wantVersion: 3},
+		{file: "", config: "a: 2", wantVersion: 4},
+		// Removing a named file records an empty config
+		{file: "exporters", config: "", wantVersion: 5},
+	}
+	for i, step := range steps {
+		if got := record(step.file, step.config); got.Version != step.wantVersion {
+			t.Errorf("step %d: recorded %q to %q as version %d, want %d", i, step.config, step.file, got.Version, step.wantVersion)
+		}
+	}
+
+	// Other targets are versioned independently
+	if got, err := s.Record(ctx, TargetGroup, "agent-1", "a: 1", Change{OrgID: "org-1"}); err != nil || got.Version != 1 {
+		t.Errorf("first revision of group = %+v, %v, want version 1", got, err)
+	}
+	if _, err := s.Record(ctx, TargetType("deployment"), "agent-1", "a: 1", Change{}); !errors.Is(err, ErrInvalidTargetType) {
+		t.Errorf("Record of deployment = %v, want %v", err, ErrInvalidTargetType)
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := NewService(store, nil, nil, zap.NewNop())
+
+	const changes = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, changes)
+	for i := 0; i < changes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Some changes fail after exhausting their attempts, but none
+			// may share a version
+			_, err := s.Record(ctx, TargetGroup, "group-1", fmt.Sprintf("n: %d", i), Change{OrgID: "org-1"})
+			if err != nil && !errors.Is(err, ErrVersionExists) {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	list, err := store.List(ctx, TargetGroup, "group-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make(map[int]bool)
+	for _, revision := range list {
+		if versions[revision.Version] {
+			t.Errorf("version %d recorded twice", revision.Version)
+		}
+		versions[revision.Version] = true
+	}
+}
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	type push struct{ targetID, file, config string }
+	var pushed []push
+	var applyErr error
+	apply := func(ctx context.Context, targetID, file, config string) error {
+		if applyErr != nil {
+			return applyErr
+		}
+		pushed = append(pushed, push{targetID, file, config})
+		return nil
+	}
+	s := NewService(NewMemoryStore(), apply, nil, zap.NewNop())
+
+	first, err := s.Record(ctx, TargetAgent, "agent-1", "a: 1", Change{OrgID: "org-1", File: "exporters"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Record(ctx, TargetAgent, "agent-1", "a: 2", Change{OrgID: "org-1", File: "exporters"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Rollback(ctx, first.ID, Change{OrgID: "org-2"}); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Rollback by another organization = %v, want %v", err, ErrRevisionNotFound)
+	}
+
+	rolledBack, err := s.Rollback(ctx, first.ID, Change{OrgID: "org-1", Author: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != 1 || pushed[0] != (push{"agent-1", "exporters", "a: 1"}) {
+		t.Errorf("pushed %+v, want the config of version 1 to the exporters file of agent-1", pushed)
+	}
+	if rolledBack.Version != 3 || rolledBack.File != "exporters" || rolledBack.Config != "a: 1" ||
+		rolledBack.Author != "user-1" || rolledBack.Message != "Rollback to version 1" {
+		t.Errorf("rollback recorded %+v", rolledBack)
+	}
+
+	// A revision that is no longer valid for its target is not recorded
+	applyErr = &validation.Error{Errors: []validation.FieldError{{Field: "config", Message: "invalid"}}}
+	var validationErr *validation.Error
+	if _, err := s.Rollback(ctx, first.ID, Change{OrgID: "org-1"}); !errors.As(err, &validationErr) {
+		t.Errorf("Rollback of invalid config = %v, want a validation error", err)
+	}
+	list, err := s.List(ctx, "org-1", TargetAgent, "agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Errorf("revisions after failed rollback = %d, want 3", len(list))
+	}
+
+	// The handler reports invalid configs like the other handlers
+	r := chi.NewRouter()
+	NewHandler(s, zap.NewNop()).RegisterRoutes(r)
+	req := httptest.NewRequest(http.MethodPost, "/"+first.ID+"/rollback", nil)
+	req = req.WithContext(context.WithValue(req.Context(), auth.OrganizationIDKey, "org-1"))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("rollback of invalid config: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
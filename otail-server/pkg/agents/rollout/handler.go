@@ -0,0 +1,134 @@
+package rollout
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/mottibec/otail-server/pkg/auth"
+	"go.uber.org/zap"
+)
+
+type Handler struct {
+	service *Service
+	logger  *zap.Logger
+}
+
+func NewHandler(service *Service, logger *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+type startRolloutRequest struct {
+	GroupID  string                 `json:"group_id"`
+	Config   map[string]interface{} `json:"config"`
+	Strategy Strategy               `json:"strategy"`
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Get("/", h.ListRollouts)
+	r.Post("/", h.StartRollout)
+	r.Get("/{id}", h.GetRollout)
+	r.Post("/{id}/pause", h.PauseRollout)
+	r.Post("/{id}/resume", h.ResumeRollout)
+	r.Post("/{id}/abort", h.AbortRollout)
+}
+
+func (h *Handler) ListRollouts(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	groupID := r.URL.Query().Get("group_id")
+	h.writeJSON(w, h.service.List(orgID, groupID))
+}
+
+func (h *Handler) StartRollout(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	userID, _ := r.Context().Value(auth.UserIDKey).(string)
+
+	var req startRolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rollout, err := h.service.Start(orgID, req.GroupID, userID, req.Config, req.Strategy)
+	if err != nil {
+		h.logger.Error("Failed to start rollout", zap.Error(err))
+		h.writeServiceError(w, err)
+		return
+	}
+
+	h.writeJSON(w, rollout)
+}
+
+func (h *Handler) GetRollout(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	rollout, err := h.service.Get(orgID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, rollout)
+}
+
+func (h *Handler) PauseRollout(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, h.service.Pause)
+}
+
+func (h *Handler) ResumeRollout(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, h.service.Resume)
+}
+
+func (h *Handler) AbortRollout(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, h.service.Abort)
+}
+
+func (h *Handler) control(w http.ResponseWriter, r *http.Request, fn func(orgID, id string) (*Rollout, error)) {
+	orgID, _ := r.Context().Value(auth.OrganizationIDKey).(string)
+	rollout, err := fn(orgID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, rollout)
+}
+
+// writeServiceError maps service errors to HTTP status codes
+func (h *Handler) writeServiceError(w http.ResponseWriter, err error) {
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid configuration",
+			"errors": validationErr.Errors,
+		})
+	case errors.Is(err, ErrRolloutNotFound):
+		h.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrGroupRollingOut):
+		h.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvalidStrategy), errors.Is(err, ErrNoAgentsInGroup),
+		errors.Is(err, ErrGroupIDRequired), errors.Is(err, ErrConfigIsRequired):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,136 @@
+package rollout
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRolloutNotFound  = errors.New("rollout not found")
+	ErrInvalidStrategy  = errors.New("either count or percentage (1-100) is required")
+	ErrNoAgentsInGroup  = errors.New("no connected agents in group that report the status of their remote config")
+	ErrInvalidState     = errors.New("operation not allowed in the current rollout state")
+	ErrGroupRollingOut  = errors.New("group already has a rollout in progress")
+	ErrGroupIDRequired  = errors.New("group ID is required")
+	ErrConfigIsRequired = errors.New("config is required")
+)
+
+type State string
+
+const (
+	StateRunning     State = "running"
+	StatePaused      State = "paused"
+	StateRollingBack State = "rolling_back"
+	StateSucceeded   State = "succeeded"
+	StateFailed      State = "failed"
+	StateAborted     State = "aborted"
+)
+
+// IsDone returns true if the rollout reached a final state
+func (s State) IsDone() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateAborted
+}
+
+type AgentState string
+
+const (
+	AgentStatePending    AgentState = "pending"
+	AgentStateApplying   AgentState = "applying"
+	AgentStateApplied    AgentState = "applied"
+	AgentStateFailed     AgentState = "failed"
+	AgentStateRolledBack AgentState = "rolled_back"
+)
+
+// Strategy controls how many agents receive the config in each wave and how
+// long each wave has to stay healthy before the next one starts
+type Strategy struct {
+	// Number of agents per wave, takes precedence over Percentage
+	Count int `json:"count"`
+	// Percentage of the group's agents per wave
+	Percentage int `json:"percentage"`
+	// How long the agents of a wave must stay healthy after applying the config
+	BakeSeconds int `json:"bake_seconds"`
+	// How long to wait for the agents of a wave to report the config as applied
+	ApplyTimeoutSeconds int `json:"apply_timeout_seconds"`
+}
+
+const (
+	defaultBakeTime     = time.Minute
+	defaultApplyTimeout = 2 * time.Minute
+)
+
+func (s Strategy) validate() error {
+	if s.Count > 0 {
+		return nil
+	}
+	if s.Percentage <= 0 || s.Percentage > 100 {
+		return ErrInvalidStrategy
+	}
+	return nil
+}
+
+// waveSize returns the number of agents per wave for a group of the given size
+func (s Strategy) waveSize(agentCount int) int {
+	size := s.Count
+	if size <= 0 {
+		size = (agentCount*s.Percentage + 99) / 100
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+func (s Strategy) bakeTime() time.Duration {
+	if s.BakeSeconds > 0 {
+		return time.Duration(s.BakeSeconds) * time.Second
+	}
+	return defaultBakeTime
+}
+
+func (s Strategy) applyTimeout() time.Duration {
+	if s.ApplyTimeoutSeconds > 0 {
+		return time.Duration(s.ApplyTimeoutSeconds) * time.Second
+	}
+	return defaultApplyTimeout
+}
+
+// AgentRollout tracks the rollout of the config to a single agent
+type AgentRollout struct {
+	AgentID uuid.UUID  `json:"agent_id"`
+	Wave    int        `json:"wave"`
+	State   AgentState `json:"state"`
+	Error   string     `json:"error,omitempty"`
+	// Custom config of the agent before the rollout, restored on rollback
+	previousConfig string
+}
+
+// Rollout applies a config to the agents of a group in waves
+type Rollout struct {
+	ID          string                 `json:"id"`
+	OrgID       string                 `json:"org_id"`
+	GroupID     string                 `json:"group_id"`
+	Config      map[string]interface{} `json:"config"`
+	Strategy    Strategy               `json:"strategy"`
+	State       State                  `json:"state"`
+	CurrentWave int                    `json:"current_wave"`
+	TotalWaves  int                    `json:"total_waves"`
+	Agents      []*AgentRollout        `json:"agents"`
+	Error       string                 `json:"error,omitempty"`
+	CreatedBy   string                 `json:"created_by"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+// clone returns a copy of the rollout that is safe to read without the lock
+func (r *Rollout) clone() *Rollout {
+	c := *r
+	c.Agents = make([]*AgentRollout, len(r.Agents))
+	for i, a := range r.Agents {
+		agent := *a
+		c.Agents[i] = &agent
+	}
+	return &c
+}
@@ -0,0 +1,453 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// How often a running rollout checks the agents and its own state
+const pollInterval = time.Second
+
+// How long finished rollouts are kept
+const finishedRetention = 24 * time.Hour
+
+var errAborted = errors.New("aborted by user")
+
+// Service runs staged rollouts of a config across the agents of a group.
+// Rollouts are kept in memory until finishedRetention after they finished and
+// do not survive a server restart.
+type Service struct {
+	logger      *zap.Logger
+	opampServer *opamp.Server
+	revisions   *revisions.Service
+
+	mux      sync.Mutex
+	rollouts map[string]*Rollout
+	// Rollouts that were asked to abort
+	aborting map[string]bool
+}
+
+// NewService creates a new rollout service. The configs pushed to agents are
+// recorded as revisions of the agents.
+func NewService(logger *zap.Logger, opampServer *opamp.Server, revisions *revisions.Service) *Service {
+	return &Service{
+		logger:      logger,
+		opampServer: opampServer,
+		revisions:   revisions,
+		rollouts:    map[string]*Rollout{},
+		aborting:    map[string]bool{},
+	}
+}
+
+// Start begins rolling out the config to the connected agents of the group
+func (s *Service) Start(orgID, groupID, createdBy string, config map[string]interface{}, strategy Strategy) (*Rollout, error) {
+	if groupID == "" {
+		return nil, ErrGroupIDRequired
+	}
+	if config == nil {
+		return nil, ErrConfigIsRequired
+	}
+	if err := strategy.validate(); err != nil {
+		return nil, err
+	}
+
+	// Only roll out to agents that can acknowledge the config, the others
+	// would fail their wave when it times out
+	var agentIds []uuid.UUID
+	for id, agent := range s.opampServer.GetAgentsByGroup(groupID) {
+		if !agent.IsOnline() {
+			continue
+		}
+		if !agent.ReportsRemoteConfig() {
+			s.logger.Warn("Skipping agent that does not report remote config status in rollout",
+				zap.String("group_id", groupID),
+				zap.String("agent_id", id.String()))
+			continue
+		}
+		agentIds = append(agentIds, id)
+	}
+	if len(agentIds) == 0 {
+		return nil, ErrNoAgentsInGroup
+	}
+
+	// Reject the rollout up front rather than failing its first wave
+	for _, id := range agentIds {
+		if err := s.opampServer.ValidateConfig(id, config); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(agentIds, func(i, j int) bool {
+		return agentIds[i].String() < agentIds[j].String()
+	})
+
+	waveSize := strategy.waveSize(len(agentIds))
+	now := time.Now()
+	r := &Rollout{
+		ID:         uuid.New().String(),
+		OrgID:      orgID,
+		GroupID:    groupID,
+		Config:     config,
+		Strategy:   strategy,
+		State:      StateRunning,
+		TotalWaves: (len(agentIds) + waveSize - 1) / waveSize,
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	for i, id := range agentIds {
+		r.Agents = append(r.Agents, &AgentRollout{
+			AgentID: id,
+			Wave:    i/waveSize + 1,
+			State:   AgentStatePending,
+		})
+	}
+
+	s.mux.Lock()
+	s.evictFinished(now)
+	for _, existing := range s.rollouts {
+		if existing.GroupID == groupID && !existing.State.IsDone() {
+			s.mux.Unlock()
+			return nil, ErrGroupRollingOut
+		}
+	}
+	s.rollouts[r.ID] = r
+	result := r.clone()
+	s.mux.Unlock()
+
+	s.logger.Info("Starting rollout",
+		zap.String("rollout_id", r.ID),
+		zap.String("group_id", groupID),
+		zap.Int("agent_count", len(agentIds)),
+		zap.Int("wave_count", r.TotalWaves))
+
+	go s.run(r)
+
+	return result, nil
+}
+
+// Get returns the rollout with the given ID
+func (s *Service) Get(orgID, id string) (*Rollout, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	r, err := s.find(orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.clone(), nil
+}
+
+// List returns the rollouts of the organization, optionally filtered by group
+func (s *Service) List(orgID, groupID string) []*Rollout {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	result := []*Rollout{}
+	for _, r := range s.rollouts {
+		if r.OrgID != orgID || (groupID != "" && r.GroupID != groupID) {
+			continue
+		}
+		result = append(result, r.clone())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Pause stops the rollout before its next wave
+func (s *Service) Pause(orgID, id string) (*Rollout, error) {
+	return s.transition(orgID, id, func(r *Rollout) error {
+		if r.State != StateRunning {
+			return ErrInvalidState
+		}
+		r.State = StatePaused
+		return nil
+	})
+}
+
+// Resume continues a paused rollout
+func (s *Service) Resume(orgID, id string) (*Rollout, error) {
+	return s.transition(orgID, id, func(r *Rollout) error {
+		if r.State != StatePaused {
+			return ErrInvalidState
+		}
+		r.State = StateRunning
+		return nil
+	})
+}
+
+// Abort stops the rollout and rolls back the agents that already received the config
+func (s *Service) Abort(orgID, id string) (*Rollout, error) {
+	return s.transition(orgID, id, func(r *Rollout) error {
+		if r.State != StateRunning && r.State != StatePaused {
+			return ErrInvalidState
+		}
+		s.aborting[r.ID] = true
+		return nil
+	})
+}
+
+func (s *Service) transition(orgID, id string, fn func(r *Rollout) error) (*Rollout, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	r, err := s.find(orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(r); err != nil {
+		return nil, err
+	}
+	r.UpdatedAt = time.Now()
+	return r.clone(), nil
+}
+
+// evictFinished removes the rollouts that finished more than
+// finishedRetention ago. Must be called with the lock held.
+func (s *Service) evictFinished(now time.Time) {
+	for id, r := range s.rollouts {
+		if r.State.IsDone() && now.Sub(r.UpdatedAt) > finishedRetention {
+			delete(s.rollouts, id)
+		}
+	}
+}
+
+// find returns the rollout if it belongs to the organization. Must be called with the lock held.
+func (s *Service) find(orgID, id string) (*Rollout, error) {
+	r, ok := s.rollouts[id]
+	if !ok || r.OrgID != orgID {
+		return nil, ErrRolloutNotFound
+	}
+	return r, nil
+}
+
+// update modifies the rollout under the lock
+func (s *Service) update(r *Rollout, fn func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	fn()
+	r.UpdatedAt = time.Now()
+}
+
+// checkState returns errAborted if the rollout was asked to abort, and whether it is paused
+func (s *Service) checkState(r *Rollout) (paused bool, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.aborting[r.ID] {
+		return false, errAborted
+	}
+	return r.State == StatePaused, nil
+}
+
+func (s *Service) run(r *Rollout) {
+	for wave := 1; wave <= r.TotalWaves; wave++ {
+		if err := s.waitWhilePaused(r); err != nil {
+			s.rollback(r, StateAborted, err)
+			return
+		}
+
+		s.update(r, func() { r.CurrentWave = wave })
+		s.logger.Info("Starting rollout wave",
+			zap.String("rollout_id", r.ID),
+			zap.Int("wave", wave))
+
+		if err := s.applyWave(r, wave); err != nil {
+			s.rollback(r, finalState(err), err)
+			return
+		}
+		if err := s.bake(r, wave); err != nil {
+			s.rollback(r, finalState(err), err)
+			return
+		}
+	}
+
+	s.update(r, func() { r.State = StateSucceeded })
+	s.logger.Info("Rollout succeeded", zap.String("rollout_id", r.ID))
+}
+
+func finalState(err error) State {
+	if errors.Is(err, errAborted) {
+		return StateAborted
+	}
+	return StateFailed
+}
+
+func (s *Service) waitWhilePaused(r *Rollout) error {
+	for {
+		paused, err := s.checkState(r)
+		if err != nil {
+			return err
+		}
+		if !paused {
+			return nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// waveAgents returns the agents of the given wave
+func waveAgents(r *Rollout, wave int) []*AgentRollout {
+	var agents []*AgentRollout
+	for _, a := range r.Agents {
+		if a.Wave == wave {
+			agents = append(agents, a)
+		}
+	}
+	return agents
+}
+
+// applyWave pushes the config to the agents of the wave and waits until all of
+// them report it as applied
+func (s *Service) applyWave(r *Rollout, wave int) error {
+	agents := waveAgents(r, wave)
+
+	for _, a := range agents {
+		agent := s.opampServer.GetAgent(a.AgentID)
+		if agent == nil {
+			s.update(r, func() { a.State, a.Error = AgentStateFailed, "agent not found" })
+			return fmt.Errorf("agent %s not found", a.AgentID)
+		}
+
+		s.update(r, func() {
+			a.previousConfig = agent.CustomInstanceConfig
+			a.State = AgentStateApplying
+		})
+		if err := s.opampServer.UpdateConfig(a.AgentID, r.Config, nil); err != nil {
+			s.update(r, func() { a.State, a.Error = AgentStateFailed, err.Error() })
+			return fmt.Errorf("failed to push config to agent %s: %w", a.AgentID, err)
+		}
+		s.recordRevision(r, a.AgentID, fmt.Sprintf("Rollout %s, wave %d", r.ID, wave))
+	}
+
+	deadline := time.Now().Add(r.Strategy.applyTimeout())
+	for {
+		if _, err := s.checkState(r); err != nil {
+			return err
+		}
+
+		pending := 0
+		for _, a := range agents {
+			if a.State != AgentStateApplying {
+				continue
+			}
+
+			agent := s.opampServer.GetAgent(a.AgentID)
+			if agent == nil || !agent.IsOnline() {
+				s.update(r, func() { a.State, a.Error = AgentStateFailed, "agent went offline" })
+				return fmt.Errorf("agent %s went offline", a.AgentID)
+			}
+
+			status, errMsg := agent.RemoteConfigApplyStatus()
+			switch status {
+			case protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLIED:
+				s.update(r, func() { a.State = AgentStateApplied })
+			case protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED:
+				s.update(r, func() { a.State, a.Error = AgentStateFailed, errMsg })
+				return fmt.Errorf("agent %s failed to apply config: %s", a.AgentID, errMsg)
+			default:
+				pending++
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d agents to apply config", pending)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// bake waits for the bake time and fails if an agent of the wave becomes unhealthy
+func (s *Service) bake(r *Rollout, wave int) error {
+	agents := waveAgents(r, wave)
+
+	deadline := time.Now().Add(r.Strategy.bakeTime())
+	for time.Now().Before(deadline) {
+		if _, err := s.checkState(r); err != nil {
+			return err
+		}
+
+		for _, a := range agents {
+			agent := s.opampServer.GetAgent(a.AgentID)
+			if agent == nil || !agent.IsOnline() {
+				s.update(r, func() { a.State, a.Error = AgentStateFailed, "agent went offline" })
+				return fmt.Errorf("agent %s went offline", a.AgentID)
+			}
+			// Agents that don't report their health can only fail by
+			// going offline
+			if agent.ReportsHealth() && !agent.IsHealthy() {
+				s.update(r, func() { a.State, a.Error = AgentStateFailed, "agent became unhealthy" })
+				return fmt.Errorf("agent %s became unhealthy", a.AgentID)
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+	return nil
+}
+
+// rollback restores the previous config on every agent that received the new one
+func (s *Service) rollback(r *Rollout, state State, cause error) {
+	s.logger.Warn("Rolling back rollout",
+		zap.String("rollout_id", r.ID),
+		zap.Error(cause))
+
+	s.update(r, func() {
+		r.State = StateRollingBack
+		r.Error = cause.Error()
+	})
+
+	for _, a := range r.Agents {
+		if a.State == AgentStatePending || a.State == AgentStateRolledBack {
+			continue
+		}
+		if err := s.opampServer.SetRawConfig(a.AgentID, a.previousConfig, nil); err != nil {
+			s.logger.Error("Failed to roll back agent config",
+				zap.String("rollout_id", r.ID),
+				zap.String("agent_id", a.AgentID.String()),
+				zap.Error(err))
+			continue
+		}
+		s.update(r, func() { a.State = AgentStateRolledBack })
+		s.recordRevision(r, a.AgentID, fmt.Sprintf("Rollback of rollout %s", r.ID))
+	}
+
+	s.update(r, func() {
+		r.State = state
+		delete(s.aborting, r.ID)
+	})
+}
+
+// recordRevision records the instance config of the agent as a new revision
+// of the agent, on behalf of the creator of the rollout
+func (s *Service) recordRevision(r *Rollout, agentID uuid.UUID, message string) {
+	agent := s.opampServer.GetAgent(agentID)
+	if agent == nil {
+		return
+	}
+
+	change := revisions.Change{
+		OrgID:   r.OrgID,
+		Author:  r.CreatedBy,
+		Message: message,
+	}
+	_, err := s.revisions.Record(context.Background(), revisions.TargetAgent, agentID.String(), agent.CustomInstanceConfig, change)
+	if err != nil {
+		s.logger.Error("Failed to record config revision",
+			zap.String("rollout_id", r.ID),
+			zap.String("agent_id", agentID.String()),
+			zap.Error(err))
+	}
+}
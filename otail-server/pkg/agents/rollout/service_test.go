@@ -0,0 +1,285 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+func TestTransitions(t *testing.T) {
+	type operation func(s *Service, orgID, id string) (*Rollout, error)
+	pause := (*Service).Pause
+	resume := (*Service).Resume
+	abort := (*Service).Abort
+
+	tests := []struct {
+		name         string
+		state        State
+		op           operation
+		wantState    State
+		wantErr      error
+		wantAborting bool
+	}{
+		{name: "pause running", state: StateRunning, op: pause, wantState: StatePaused},
+		{name: "pause paused", state: StatePaused, op: pause, wantErr: ErrInvalidState},
+		{name: "pause rolling back", state: StateRollingBack, op: pause, wantErr: ErrInvalidState},
+		{name: "pause succeeded", state: StateSucceeded, op: pause, wantErr: ErrInvalidState},
+		{name: "resume paused", state: StatePaused, op: resume, wantState: StateRunning},
+		{name: "resume running", state: StateRunning, op: resume, wantErr: ErrInvalidState},
+		{name: "resume failed", state: StateFailed, op: resume, wantErr: ErrInvalidState},
+		// Aborting only flags the rollout, the run loop rolls it back
+		{name: "abort running", state: StateRunning, op: abort, wantState: StateRunning, wantAborting: true},
+		{name: "abort paused", state: StatePaused, op: abort, wantState: StatePaused, wantAborting: true},
+		{name: "abort rolling back", state: StateRollingBack, op: abort, wantErr: ErrInvalidState},
+		{name: "abort aborted", state: StateAborted, op: abort, wantErr: ErrInvalidState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(zap.NewNop(), nil, nil)
+			s.rollouts["r1"] = &Rollout{ID: "r1", OrgID: "org", State: tt.state}
+
+			got, err := tt.op(s, "org", "r1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if s.rollouts["r1"].State != tt.state {
+					t.Errorf("state changed to %s on error", s.rollouts["r1"].State)
+				}
+				return
+			}
+			if got.State != tt.wantState {
+				t.Errorf("state = %s, want %s", got.State, tt.wantState)
+			}
+			if s.aborting["r1"] != tt.wantAborting {
+				t.Errorf("aborting = %v, want %v", s.aborting["r1"], tt.wantAborting)
+			}
+		})
+	}
+}
+
+func TestTransitionOtherOrganization(t *testing.T) {
+	s := NewService(zap.NewNop(), nil, nil)
+	s.rollouts["r1"] = &Rollout{ID: "r1", OrgID: "org", State: StateRunning}
+
+	if _, err := s.Pause("other", "r1"); !errors.Is(err, ErrRolloutNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrRolloutNotFound)
+	}
+	if s.rollouts["r1"].State != StateRunning {
+		t.Errorf("state = %s, want %s", s.rollouts["r1"].State, StateRunning)
+	}
+}
+
+func TestFinalState(t *testing.T) {
+	tests := []struct {
+		err  error
+		want State
+	}{
+		{err: errAborted, want: StateAborted},
+		{err: fmt.Errorf("wave 2: %w", errAborted), want: StateAborted},
+		{err: errors.New("agent failed to apply the config"), want: StateFailed},
+	}
+	for _, tt := range tests {
+		if got := finalState(tt.err); got != tt.want {
+			t.Errorf("finalState(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		agents   int
+		wantErr  error
+		wantWave int
+	}{
+		{name: "count", strategy: Strategy{Count: 2}, agents: 5, wantWave: 2},
+		{name: "count takes precedence", strategy: Strategy{Count: 3, Percentage: 50}, agents: 10, wantWave: 3},
+		{name: "percentage rounds up", strategy: Strategy{Percentage: 25}, agents: 5, wantWave: 2},
+		{name: "at least one agent", strategy: Strategy{Percentage: 1}, agents: 10, wantWave: 1},
+		{name: "all agents", strategy: Strategy{Percentage: 100}, agents: 7, wantWave: 7},
+		{name: "no count or percentage", strategy: Strategy{}, wantErr: ErrInvalidStrategy},
+		{name: "percentage above 100", strategy: Strategy{Percentage: 101}, wantErr: ErrInvalidStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.strategy.validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validate() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got := tt.strategy.waveSize(tt.agents); got != tt.wantWave {
+				t.Errorf("waveSize(%d) = %d, want %d", tt.agents, got, tt.wantWave)
+			}
+		})
+	}
+}
+
+// testConnection is the connection of a test agent
+type testConnection struct{}
+
+func (c *testConnection) Connection() net.Conn                                         { return nil }
+func (c *testConnection) Send(ctx context.Context, msg *protobufs.ServerToAgent) error { return nil }
+func (c *testConnection) Disconnect() error                                            { return nil }
+
+const agentConfig = `
+receivers:
+  otlp: {}
+exporters:
+  debug: {}
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [debug]
+`
+
+// newTestService returns a service with a connected agent in group-1 for each
+// of the capabilities, which the agent reports along with its health
+func newTestService(t *testing.T, healthy bool, capabilities ...protobufs.AgentCapabilities) (*Service, *revisions.MemoryStore, []uuid.UUID) {
+	t.Helper()
+	agents := opamp.NewAgents(zap.NewNop())
+	var ids []uuid.UUID
+	for _, capability := range capabilities {
+		id := uuid.New()
+		conn := &testConnection{}
+		agent, err := agents.FindOrCreateAgent(id, conn, "org-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		agents.SetConnection(conn, "org-1", "group-1", "")
+		agent.UpdateStatus(&protobufs.AgentToServer{
+			Capabilities: uint64(capability),
+			Health:       &protobufs.ComponentHealth{Healthy: healthy},
+		}, &protobufs.ServerToAgent{})
+		ids = append(ids, id)
+	}
+
+	server, err := opamp.NewServer(agents, nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := revisions.NewMemoryStore()
+	return NewService(zap.NewNop(), server, revisions.NewService(store, nil, nil, zap.NewNop())), store, ids
+}
+
+func testConfig(t *testing.T) map[string]interface{} {
+	t.Helper()
+	config, err := validation.ParseConfig([]byte(agentConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestStartSkipsAgentsWithoutRemoteConfigStatus(t *testing.T) {
+	s, _, ids := newTestService(t, true,
+		protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig,
+		protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth)
+
+	r, err := s.Start("org-1", "group-1", "user-1", testConfig(t), Strategy{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Abort("org-1", r.ID)
+	if len(r.Agents) != 1 || r.Agents[0].AgentID != ids[0] {
+		t.Errorf("rollout agents = %+v, want only %s", r.Agents, ids[0])
+	}
+
+	s, _, _ = newTestService(t, true, protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth)
+	if _, err := s.Start("org-1", "group-1", "user-1", testConfig(t), Strategy{Count: 1}); !errors.Is(err, ErrNoAgentsInGroup) {
+		t.Errorf("Start without agents reporting remote config = %v, want %v", err, ErrNoAgentsInGroup)
+	}
+}
+
+func TestBakeChecksHealthOfReportingAgents(t *testing.T) {
+	tests := []struct {
+		name       string
+		capability protobufs.AgentCapabilities
+		healthy    bool
+		wantErr    bool
+	}{
+		{name: "healthy", capability: protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth, healthy: true},
+		{name: "unhealthy", capability: protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth, wantErr: true},
+		{name: "health not reported", capability: protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, ids := newTestService(t, tt.healthy, tt.capability)
+			r := &Rollout{
+				ID:       "r1",
+				Strategy: Strategy{BakeSeconds: 1},
+				Agents:   []*AgentRollout{{AgentID: ids[0], Wave: 1, State: AgentStateApplied}},
+			}
+			if err := s.bake(r, 1); (err != nil) != tt.wantErr {
+				t.Errorf("bake() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyWaveRecordsRevisions(t *testing.T) {
+	s, store, ids := newTestService(t, true, protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig)
+	r := &Rollout{
+		ID:        "r1",
+		OrgID:     "org-1",
+		Config:    testConfig(t),
+		Strategy:  Strategy{ApplyTimeoutSeconds: 1},
+		CreatedBy: "user-1",
+		Agents:    []*AgentRollout{{AgentID: ids[0], Wave: 1, State: AgentStatePending}},
+	}
+	s.rollouts[r.ID] = r
+
+	// The agent never reports the config as applied, so the wave times out
+	// after the push and the rollback restores the empty config
+	if err := s.applyWave(r, 1); err == nil {
+		t.Fatal("applyWave() succeeded, want a timeout")
+	}
+	s.rollback(r, StateFailed, errors.New("timed out"))
+
+	list, err := store.List(context.Background(), revisions.TargetAgent, ids[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("recorded %d revisions, want the push and the rollback", len(list))
+	}
+	pushed, restored := list[1], list[0]
+	if pushed.Author != "user-1" || pushed.Message != "Rollout r1, wave 1" || pushed.Config == "" {
+		t.Errorf("push recorded as %+v", pushed)
+	}
+	if restored.Message != "Rollback of rollout r1" || restored.Config != "" {
+		t.Errorf("rollback recorded as %+v", restored)
+	}
+}
+
+func TestEvictFinished(t *testing.T) {
+	s := NewService(zap.NewNop(), nil, nil)
+	now := time.Now()
+	s.rollouts["old"] = &Rollout{ID: "old", State: StateSucceeded, UpdatedAt: now.Add(-finishedRetention - time.Minute)}
+	s.rollouts["recent"] = &Rollout{ID: "recent", State: StateFailed, UpdatedAt: now.Add(-time.Minute)}
+	s.rollouts["stuck"] = &Rollout{ID: "stuck", State: StatePaused, UpdatedAt: now.Add(-finishedRetention - time.Minute)}
+
+	s.evictFinished(now)
+	if _, ok := s.rollouts["old"]; ok {
+		t.Error("rollout finished before the retention was kept")
+	}
+	for _, id := range []string{"recent", "stuck"} {
+		if _, ok := s.rollouts[id]; !ok {
+			t.Errorf("rollout %s was evicted", id)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package simulation
+
+import (
+	"context"
+
+	"github.com/mottibec/otail/wasm/ottl/evaluator"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// TraceResult is the sampling decision for a single trace
+type TraceResult struct {
+	TraceID   string                     `json:"trace_id"`
+	SpanCount int64                      `json:"span_count"`
+	Decision  evaluator.SamplingDecision `json:"decision"`
+	// Policy is the policy that sampled the trace
+	Policy          string                                `json:"policy,omitempty"`
+	PolicyDecisions map[string]evaluator.SamplingDecision `json:"policy_decisions"`
+}
+
+// PolicyResult counts the decisions of a top-level policy over all traces
+type PolicyResult struct {
+	Name       string               `json:"name"`
+	Type       evaluator.PolicyType `json:"type"`
+	Matched    int                  `json:"matched"`
+	NotMatched int                  `json:"not_matched"`
+	Errors     int                  `json:"errors"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// Summary aggregates the decisions of all traces
+type Summary struct {
+	Traces        int     `json:"traces"`
+	SampledTraces int     `json:"sampled_traces"`
+	Spans         int64   `json:"spans"`
+	SampledSpans  int64   `json:"sampled_spans"`
+	SampleRate    float64 `json:"sample_rate"`
+}
+
+// Result is the outcome of a simulation
+type Result struct {
+	Traces   []TraceResult  `json:"traces"`
+	Policies []PolicyResult `json:"policies"`
+	Summary  Summary        `json:"summary"`
+}
+
+// DecisionMap is the decision for each trace, by trace ID
+type DecisionMap struct {
+	Decisions map[string]evaluator.SamplingDecision `json:"decisions"`
+	Summary   Summary                               `json:"summary"`
+}
+
+// DecisionMap returns the decision of each trace without the policy details
+func (r *Result) DecisionMap() *DecisionMap {
+	decisions := &DecisionMap{
+		Decisions: make(map[string]evaluator.SamplingDecision, len(r.Traces)),
+		Summary:   r.Summary,
+	}
+	for _, t := range r.Traces {
+		decisions.Decisions[t.TraceID] = t.Decision
+	}
+	return decisions
+}
+
+// Engine evaluates traces against a tail_sampling policy set with the
+// evaluator of the playground, so that both make the same decisions
+type Engine struct {
+	policies []evaluator.PolicyConfig
+	sampler  *evaluator.TailSampler
+}
+
+// NewEngine creates an engine for the policies, which must have been validated
+func NewEngine(policies []evaluator.PolicyConfig, logger *zap.Logger) (*Engine, error) {
+	sampler, err := evaluator.NewTailSampler(policies, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{policies: policies, sampler: sampler}, nil
+}
+
+// Run groups the spans by trace ID and evaluates each trace. Traces are
+// evaluated in the order they started, so the rate limiting policies see the
+// traces in the order the processor would.
+func (e *Engine) Run(ctx context.Context, td ptrace.Traces) *Result {
+	traces := evaluator.GroupByTrace(td)
+
+	result := &Result{
+		Traces:   make([]TraceResult, 0, len(traces)),
+		Policies: make([]PolicyResult, len(e.policies)),
+	}
+	for i, policy := range e.policies {
+		result.Policies[i] = PolicyResult{Name: policy.Name, Type: policy.Type}
+	}
+
+	for _, t := range traces {
+		sampling := e.sampler.EvaluateTrace(ctx, t)
+		traceResult := TraceResult{
+			TraceID:         t.TraceID.String(),
+			SpanCount:       t.SpanCount,
+			Decision:        sampling.Decision,
+			Policy:          sampling.SampledBy,
+			PolicyDecisions: make(map[string]evaluator.SamplingDecision, len(sampling.Policies)),
+		}
+
+		// The policy decisions are in the order of the policies
+		for i, decision := range sampling.Policies {
+			switch decision.Decision {
+			case evaluator.Error:
+				result.Policies[i].Errors++
+				if result.Policies[i].Error == "" {
+					result.Policies[i].Error = decision.Error
+				}
+			case evaluator.Sampled, evaluator.InvertSampled:
+				result.Policies[i].Matched++
+			default:
+				result.Policies[i].NotMatched++
+			}
+			traceResult.PolicyDecisions[decision.Policy] = decision.Decision
+		}
+		result.Traces = append(result.Traces, traceResult)
+
+		result.Summary.Traces++
+		result.Summary.Spans += t.SpanCount
+		if traceResult.Decision == evaluator.Sampled {
+			result.Summary.SampledTraces++
+			result.Summary.SampledSpans += t.SpanCount
+		}
+	}
+	if result.Summary.Traces > 0 {
+		result.Summary.SampleRate = float64(result.Summary.SampledTraces) / float64(result.Summary.Traces)
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package simulation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mottibec/otail/wasm/ottl/evaluator"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// testTraces returns two traces: trace 1 has a span of the checkout service
+// with an error status, trace 2 a span of the cart service
+func testTraces() ptrace.Traces {
+	td := ptrace.NewTraces()
+	for i, service := range []string{"checkout", "cart"} {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", service)
+		span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID{byte(i + 1)})
+		span.SetSpanID(pcommon.SpanID{byte(i + 1)})
+		span.SetName(service)
+		span.SetStartTimestamp(pcommon.Timestamp(i + 1))
+		if service == "checkout" {
+			span.Status().SetCode(ptrace.StatusCodeError)
+		}
+	}
+	return td
+}
+
+func TestEngineRun(t *testing.T) {
+	trace1 := pcommon.TraceID{1}.String()
+	trace2 := pcommon.TraceID{2}.String()
+
+	tests := []struct {
+		name          string
+		policies      string
+		wantDecisions map[string]evaluator.SamplingDecision
+		wantMatched   []int
+	}{
+		{
+			name: "ottl condition",
+			policies: `[{"name": "checkout", "type": "ottl_condition",
+				"ottl_condition": {"span": ["resource.attributes[\"service.name\"] == \"checkout\""]}}]`,
+			wantDecisions: map[string]evaluator.SamplingDecision{trace1: evaluator.Sampled, trace2: evaluator.NotSampled},
+			wantMatched:   []int{1},
+		},
+		{
+			name: "drop wins over a match",
+			policies: `[{"name": "all", "type": "always_sample"},
+				{"name": "drop-cart", "type": "drop", "drop": {"drop_sub_policy": [
+					{"name": "cart", "type": "string_attribute", "string_attribute": {"key": "service.name", "values": ["cart"]}}]}}]`,
+			wantDecisions: map[string]evaluator.SamplingDecision{trace1: evaluator.Sampled, trace2: evaluator.NotSampled},
+			wantMatched:   []int{2, 0},
+		},
+		{
+			name:          "status code",
+			policies:      `[{"name": "errors", "type": "status_code", "status_code": {"status_codes": ["ERROR"]}}]`,
+			wantDecisions: map[string]evaluator.SamplingDecision{trace1: evaluator.Sampled, trace2: evaluator.NotSampled},
+			wantMatched:   []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := evaluator.ParsePolicies([]byte(tt.policies))
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine, err := NewEngine(policies, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := engine.Run(context.Background(), testTraces())
+			decisions := result.DecisionMap().Decisions
+			for traceID, want := range tt.wantDecisions {
+				if decisions[traceID] != want {
+					t.Errorf("decision of %s = %s, want %s", traceID, decisions[traceID], want)
+				}
+			}
+			for i, want := range tt.wantMatched {
+				if got := result.Policies[i]; got.Matched != want || got.Errors != 0 {
+					t.Errorf("policy %s matched %d with %d errors, want %d", got.Name, got.Matched, got.Errors, want)
+				}
+			}
+			if result.Summary.Traces != 2 || result.Summary.SampledTraces != 1 {
+				t.Errorf("summary = %+v, want 2 traces with 1 sampled", result.Summary)
+			}
+		})
+	}
+}
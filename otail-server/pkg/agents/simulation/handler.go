@@ -0,0 +1,100 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"go.uber.org/zap"
+)
+
+type Handler struct {
+	service *Service
+	logger  *zap.Logger
+}
+
+func NewHandler(service *Service, logger *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Post("/simulate", h.Simulate)
+	r.Post("/decisions", h.Decisions)
+}
+
+func (h *Handler) Simulate(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.service.Simulate(r.Context(), req)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, result)
+}
+
+// Decisions simulates the policies like Simulate, but only returns the
+// decision of each trace by trace ID, for large batches
+func (h *Handler) Decisions(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.service.Simulate(r.Context(), req)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, result.DecisionMap())
+}
+
+// writeServiceError maps service errors to HTTP status codes
+func (h *Handler) writeServiceError(w http.ResponseWriter, err error) {
+	var validationErr *validation.Error
+	var tracesErr *InvalidTracesError
+	switch {
+	case errors.As(err, &validationErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid configuration",
+			"errors": validationErr.Errors,
+		})
+	case errors.As(err, &tracesErr),
+		errors.Is(err, ErrTailSamplingRequired),
+		errors.Is(err, ErrInvalidPolicies),
+		errors.Is(err, ErrTracesRequired),
+		errors.Is(err, ErrInvalidTimeRange):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrTracesUnavailable):
+		h.writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		h.logger.Error("Failed to simulate sampling", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,83 @@
+package simulation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail/wasm/ottl/evaluator"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+func TestDecisions(t *testing.T) {
+	r := chi.NewRouter()
+	NewHandler(NewService(nil, zap.NewNop()), zap.NewNop()).RegisterRoutes(r)
+
+	traces, err := (&ptrace.JSONMarshaler{}).MarshalTraces(testTraces())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const tailSampling = `{"policies": [{"name": "errors", "type": "status_code", "status_code": {"status_codes": ["ERROR"]}}]}`
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/decisions", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"tail_sampling": ` + tailSampling + `, "traces": ` + string(traces) + `}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var got DecisionMap
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := DecisionMap{
+		Decisions: map[string]evaluator.SamplingDecision{
+			pcommon.TraceID{1}.String(): evaluator.Sampled,
+			pcommon.TraceID{2}.String(): evaluator.NotSampled,
+		},
+		Summary: Summary{Traces: 2, SampledTraces: 1, Spans: 2, SampledSpans: 1, SampleRate: 0.5},
+	}
+	if len(got.Decisions) != len(want.Decisions) || got.Summary != want.Summary {
+		t.Errorf("decisions = %+v, want %+v", got, want)
+	}
+	for traceID, decision := range want.Decisions {
+		if got.Decisions[traceID] != decision {
+			t.Errorf("decision of %s = %s, want %s", traceID, got.Decisions[traceID], decision)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "invalid body", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "no tail_sampling", body: `{"traces": ` + string(traces) + `}`, wantStatus: http.StatusBadRequest},
+		{name: "no traces", body: `{"tail_sampling": ` + tailSampling + `}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid traces", body: `{"tail_sampling": ` + tailSampling + `, "traces": {"resourceSpans": 1}}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "invalid policy",
+			body:       `{"tail_sampling": {"policies": [{"name": "p", "type": "sometimes"}]}, "traces": ` + string(traces) + `}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// Stored traces can't be loaded without ClickHouse
+			name:       "time range",
+			body:       `{"tail_sampling": ` + tailSampling + `, "time_range": {"start": "2024-01-01T00:00:00Z", "end": "2024-01-02T00:00:00Z"}}`,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		if rec := post(tt.body); rec.Code != tt.wantStatus {
+			t.Errorf("status of %s = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/mottibec/otail/wasm/ottl/evaluator"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrTailSamplingRequired = errors.New("tail_sampling is required")
+	ErrTracesRequired       = errors.New("either traces or time_range is required")
+	ErrInvalidTimeRange     = errors.New("time_range end must be after start")
+	// ErrInvalidPolicies is returned for validated policies the evaluator
+	// cannot decode, e.g. a fractional numeric_attribute bound
+	ErrInvalidPolicies = errors.New("invalid policies")
+	// ErrTracesUnavailable is returned for a time range when the server has
+	// no ClickHouse client or is not connected to ClickHouse
+	ErrTracesUnavailable = errors.New("trace storage is not available")
+)
+
+// InvalidTracesError is returned when the traces are not valid OTLP JSON
+type InvalidTracesError struct {
+	Err error
+}
+
+func (e *InvalidTracesError) Error() string {
+	return fmt.Sprintf("invalid OTLP JSON traces: %v", e.Err)
+}
+
+func (e *InvalidTracesError) Unwrap() error {
+	return e.Err
+}
+
+// Request is a simulation of a tail_sampling policy set against either the
+// given traces or the traces stored in ClickHouse for a time range
+type Request struct {
+	// TailSampling is the tail_sampling processor block, as in the collector
+	// config
+	TailSampling map[string]interface{} `json:"tail_sampling"`
+	// Traces is an OTLP JSON traces payload
+	Traces    json.RawMessage `json:"traces,omitempty"`
+	TimeRange *TimeRange      `json:"time_range,omitempty"`
+}
+
+type TimeRange struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	ServiceName string    `json:"service_name,omitempty"`
+	// Limit is the maximum number of traces
+	Limit int `json:"limit,omitempty"`
+}
+
+// Service simulates tail sampling decisions, to test a policy change against
+// real data before it is pushed to the agents
+type Service struct {
+	clickhouse *clickhouse.Client
+	logger     *zap.Logger
+}
+
+// NewService creates a new simulation service. The ClickHouse client may be
+// nil, in which case only the traces of the request can be simulated.
+func NewService(clickhouse *clickhouse.Client, logger *zap.Logger) *Service {
+	return &Service{
+		clickhouse: clickhouse,
+		logger:     logger,
+	}
+}
+
+// Simulate evaluates every trace against the policies and returns the
+// decision for each trace and the number of traces matched by each policy
+func (s *Service) Simulate(ctx context.Context, req Request) (*Result, error) {
+	if req.TailSampling == nil {
+		return nil, ErrTailSamplingRequired
+	}
+	if err := validation.ValidateTailSampling(req.TailSampling); err != nil {
+		return nil, err
+	}
+	policies, err := decodePolicies(req.TailSampling)
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := NewEngine(policies, s.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	traces, err := s.loadTraces(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Run(ctx, traces), nil
+}
+
+func (s *Service) loadTraces(ctx context.Context, req Request) (ptrace.Traces, error) {
+	if len(req.Traces) > 0 {
+		unmarshaler := &ptrace.JSONUnmarshaler{}
+		traces, err := unmarshaler.UnmarshalTraces(req.Traces)
+		if err != nil {
+			return ptrace.Traces{}, &InvalidTracesError{Err: err}
+		}
+		return traces, nil
+	}
+
+	if req.TimeRange == nil {
+		return ptrace.Traces{}, ErrTracesRequired
+	}
+	if !req.TimeRange.End.After(req.TimeRange.Start) {
+		return ptrace.Traces{}, ErrInvalidTimeRange
+	}
+	if s.clickhouse == nil {
+		return ptrace.Traces{}, ErrTracesUnavailable
+	}
+
+	traces, err := s.clickhouse.QueryTraces(ctx, clickhouse.TraceQuery{
+		Start:       req.TimeRange.Start,
+		End:         req.TimeRange.End,
+		ServiceName: req.TimeRange.ServiceName,
+		Limit:       req.TimeRange.Limit,
+	})
+	if errors.Is(err, clickhouse.ErrUnavailable) {
+		return ptrace.Traces{}, ErrTracesUnavailable
+	}
+	if err != nil {
+		return ptrace.Traces{}, fmt.Errorf("failed to load traces: %w", err)
+	}
+	return traces, nil
+}
+
+// decodePolicies converts the validated policies to the configs of the
+// evaluator
+func decodePolicies(tailSampling map[string]interface{}) ([]evaluator.PolicyConfig, error) {
+	data, err := json.Marshal(tailSampling)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policies: %w", err)
+	}
+	policies, err := evaluator.ParsePolicies(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicies, err)
+	}
+	return policies, nil
+}
@@ -0,0 +1,219 @@
+package tailsampling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"go.uber.org/zap"
+)
+
+// Handler serves the tail sampling policies of agents and groups as
+// individual resources
+type Handler struct {
+	service *Service
+	logger  *zap.Logger
+}
+
+func NewHandler(service *Service, logger *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+type reorderPoliciesRequest struct {
+	Order []string `json:"order"`
+}
+
+// RegisterRoutes registers the policy routes. The target type is either agents
+// or groups.
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Get("/{targetType}/{targetId}", h.ListPolicies)
+	r.Post("/{targetType}/{targetId}", h.CreatePolicy)
+	r.Put("/{targetType}/{targetId}", h.ReorderPolicies)
+	r.Get("/{targetType}/{targetId}/{name}", h.GetPolicy)
+	r.Put("/{targetType}/{targetId}/{name}", h.UpdatePolicy)
+	r.Delete("/{targetType}/{targetId}/{name}", h.DeletePolicy)
+}
+
+func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	policies, err := h.service.ListPolicies(r.Context(), targetType, chi.URLParam(r, "targetId"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, policies)
+}
+
+func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.service.GetPolicy(r.Context(), targetType, chi.URLParam(r, "targetId"), chi.URLParam(r, "name"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, policy)
+}
+
+func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	var policy Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if policy.Name == "" {
+		h.writeError(w, http.StatusBadRequest, ErrPolicyNameRequired.Error())
+		return
+	}
+
+	err := h.service.CreatePolicy(r.Context(), targetType, chi.URLParam(r, "targetId"), policy, revisions.ChangeFromRequest(r))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	var policy Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if policy.Name == "" {
+		policy.Name = name
+	}
+
+	err := h.service.UpdatePolicy(r.Context(), targetType, chi.URLParam(r, "targetId"), name, policy, revisions.ChangeFromRequest(r))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, policy)
+}
+
+func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DeletePolicy(r.Context(), targetType, chi.URLParam(r, "targetId"), chi.URLParam(r, "name"), revisions.ChangeFromRequest(r))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderPolicies changes the evaluation order of the policies
+func (h *Handler) ReorderPolicies(w http.ResponseWriter, r *http.Request) {
+	targetType, ok := h.targetType(w, r)
+	if !ok {
+		return
+	}
+
+	var req reorderPoliciesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	targetID := chi.URLParam(r, "targetId")
+	if err := h.service.ReorderPolicies(r.Context(), targetType, targetID, req.Order, revisions.ChangeFromRequest(r)); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	policies, err := h.service.ListPolicies(r.Context(), targetType, targetID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, policies)
+}
+
+// targetType maps the target type of the URL to the revision target type
+func (h *Handler) targetType(w http.ResponseWriter, r *http.Request) (revisions.TargetType, bool) {
+	switch chi.URLParam(r, "targetType") {
+	case "agents":
+		return revisions.TargetAgent, true
+	case "groups":
+		return revisions.TargetGroup, true
+	default:
+		h.writeError(w, http.StatusNotFound, "Unknown policy target, must be agents or groups")
+		return "", false
+	}
+}
+
+// writeServiceError maps service errors to HTTP status codes
+func (h *Handler) writeServiceError(w http.ResponseWriter, err error) {
+	var applyErr *ConfigApplyError
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid configuration",
+			"errors": validationErr.Errors,
+		})
+	case errors.Is(err, ErrPolicyNotFound), errors.Is(err, ErrGroupNotFound), errors.Is(err, ErrAgentNotFound):
+		h.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrPolicyExists), errors.Is(err, ErrNoEffectiveConfig):
+		h.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvalidPolicyOrder), errors.Is(err, ErrInvalidAgentID):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &applyErr):
+		h.writeError(w, http.StatusUnprocessableEntity, applyErr.Error())
+	case errors.Is(err, ErrConfigPending):
+		// The config is stored and will be applied once the agent reports back
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending", "message": err.Error()})
+	default:
+		h.logger.Error("Failed to manage tail sampling policies", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
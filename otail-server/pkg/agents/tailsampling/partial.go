@@ -0,0 +1,227 @@
+package tailsampling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+)
+
+var (
+	// ErrNoEffectiveConfig is returned when a partial update is requested for an
+	// agent that has not reported its effective config yet
+	ErrNoEffectiveConfig  = errors.New("agent has not reported its effective config")
+	ErrPolicyNameRequired = errors.New("policy name is required")
+	ErrAgentNotFound      = errors.New("agent not found")
+)
+
+// UpdateTailSampling merges the tail_sampling block into the tail_sampling
+// processor of the agent and pushes the agent's instance config, so that the
+// receivers, exporters and other processors of the agent are kept. Settings
+// that are not in the block keep their current value, while lists such as the
+// policies are replaced.
+func (s *Service) UpdateTailSampling(ctx context.Context, agentID uuid.UUID, block map[string]interface{}, change revisions.Change) error {
+	return s.updateTailSampling(ctx, agentID, change, func(tailSampling map[string]interface{}) (map[string]interface{}, error) {
+		return validation.MergeConfigs(tailSampling, block), nil
+	})
+}
+
+// UpsertPolicy adds the policy to the tail_sampling processor of the agent, or
+// replaces the policy with the same name, and pushes the merged config like
+// UpdateTailSampling
+func (s *Service) UpsertPolicy(ctx context.Context, agentID uuid.UUID, policy map[string]interface{}, change revisions.Change) error {
+	name, _ := policy["name"].(string)
+	if name == "" {
+		return ErrPolicyNameRequired
+	}
+
+	return s.updateTailSampling(ctx, agentID, change, func(tailSampling map[string]interface{}) (map[string]interface{}, error) {
+		policies, _ := tailSampling["policies"].([]interface{})
+
+		replaced := false
+		result := make([]interface{}, 0, len(policies)+1)
+		for _, existing := range policies {
+			if existingPolicy, ok := existing.(map[string]interface{}); ok && existingPolicy["name"] == name {
+				existing = policy
+				replaced = true
+			}
+			result = append(result, existing)
+		}
+		if !replaced {
+			result = append(result, policy)
+		}
+
+		tailSampling["policies"] = result
+		return tailSampling, nil
+	})
+}
+
+// updateTailSampling applies the update to the tail_sampling processor of the
+// agent and pushes the result as the agent's instance config. Only the
+// instance config is written back, the config shared by the agent's
+// deployment and group keeps coming from their own config files. The processor
+// is added to the traces pipelines if none of them runs it yet.
+func (s *Service) updateTailSampling(
+	ctx context.Context,
+	agentID uuid.UUID,
+	change revisions.Change,
+	update func(tailSampling map[string]interface{}) (map[string]interface{}, error),
+) error {
+	if err := s.pushTailSampling(ctx, agentID, change, update); err != nil {
+		return err
+	}
+	return s.waitForApply(ctx, agentID)
+}
+
+// pushTailSampling reads, updates and pushes the instance config of the agent
+// while holding the lock of the agent, so concurrent updates are applied one
+// after the other. The lock is not held while waiting for the agent.
+func (s *Service) pushTailSampling(
+	ctx context.Context,
+	agentID uuid.UUID,
+	change revisions.Change,
+	update func(tailSampling map[string]interface{}) (map[string]interface{}, error),
+) error {
+	defer s.lockTarget(revisions.TargetAgent, agentID.String())()
+
+	config, err := s.agentTailSamplingConfig(agentID)
+	if err != nil {
+		return err
+	}
+	if err := updateTailSamplingBlock(config, update); err != nil {
+		return err
+	}
+
+	effective, err := s.opampServer.GetMergedEffectiveConfig(agentID)
+	if err != nil {
+		return fmt.Errorf("failed to get effective config: %w", err)
+	}
+	addToTracesPipelines(config, validation.MergeConfigs(effective, config))
+
+	return s.pushConfig(ctx, agentID, config, change)
+}
+
+// agentTailSamplingConfig returns the instance config of the agent with the
+// tail_sampling processor the agent runs. While the instance config has no
+// tail_sampling processor of its own, the processor is taken from the effective
+// config, e.g. from the group config. Policies are lists, which replace each
+// other when the files are merged, so once written the agent's policies
+// override the ones of its group.
+func (s *Service) agentTailSamplingConfig(agentID uuid.UUID) (map[string]interface{}, error) {
+	agent := s.opampServer.GetAgent(agentID)
+	if agent == nil {
+		return nil, ErrAgentNotFound
+	}
+	config, err := validation.ParseConfig([]byte(agent.CustomInstanceConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse instance config: %w", err)
+	}
+	if tailSamplingBlock(config) != nil {
+		return config, nil
+	}
+
+	effective, err := s.opampServer.GetMergedEffectiveConfig(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get effective config: %w", err)
+	}
+	if len(effective) == 0 {
+		return nil, ErrNoEffectiveConfig
+	}
+	if tailSampling := tailSamplingBlock(effective); tailSampling != nil {
+		processors, _ := config["processors"].(map[string]interface{})
+		if processors == nil {
+			processors = map[string]interface{}{}
+			config["processors"] = processors
+		}
+		processors["tail_sampling"] = tailSampling
+	}
+	return config, nil
+}
+
+// tailSamplingBlock returns processors.tail_sampling of the config, or nil
+func tailSamplingBlock(config map[string]interface{}) map[string]interface{} {
+	processors, _ := config["processors"].(map[string]interface{})
+	tailSampling, _ := processors["tail_sampling"].(map[string]interface{})
+	return tailSampling
+}
+
+// updateTailSamplingBlock applies the update to processors.tail_sampling of
+// the config, creating the block if needed
+func updateTailSamplingBlock(config map[string]interface{}, update func(tailSampling map[string]interface{}) (map[string]interface{}, error)) error {
+	processors, _ := config["processors"].(map[string]interface{})
+	if processors == nil {
+		processors = map[string]interface{}{}
+		config["processors"] = processors
+	}
+	tailSampling, _ := processors["tail_sampling"].(map[string]interface{})
+	if tailSampling == nil {
+		tailSampling = map[string]interface{}{}
+	}
+
+	updated, err := update(tailSampling)
+	if err != nil {
+		return err
+	}
+	processors["tail_sampling"] = updated
+	return nil
+}
+
+// addToTracesPipelines adds the tail_sampling processor to the traces pipelines
+// of the config, unless a traces pipeline of the merged config the agent would
+// run already has it. The processors of a pipeline are a list, which replaces
+// the one of the earlier config files, so the list of the merged config is
+// written with tail_sampling before the batch processor.
+func addToTracesPipelines(config, merged map[string]interface{}) {
+	service, _ := merged["service"].(map[string]interface{})
+	pipelines, _ := service["pipelines"].(map[string]interface{})
+
+	traces := make(map[string][]interface{})
+	for id, value := range pipelines {
+		if typ, _, _ := strings.Cut(id, "/"); typ != "traces" {
+			continue
+		}
+		pipeline, _ := value.(map[string]interface{})
+		processors, _ := pipeline["processors"].([]interface{})
+		for _, processor := range processors {
+			if processor == "tail_sampling" {
+				return
+			}
+		}
+		traces[id] = processors
+	}
+
+	for id, processors := range traces {
+		updated := make([]interface{}, 0, len(processors)+1)
+		added := false
+		for _, processor := range processors {
+			if name, _ := processor.(string); !added && (name == "batch" || strings.HasPrefix(name, "batch/")) {
+				updated = append(updated, "tail_sampling")
+				added = true
+			}
+			updated = append(updated, processor)
+		}
+		if !added {
+			updated = append(updated, "tail_sampling")
+		}
+		setPipelineProcessors(config, id, updated)
+	}
+}
+
+// setPipelineProcessors sets service.pipelines.<id>.processors of the config,
+// creating the maps if needed
+func setPipelineProcessors(config map[string]interface{}, id string, processors []interface{}) {
+	section := config
+	for _, key := range []string{"service", "pipelines", id} {
+		next, _ := section[key].(map[string]interface{})
+		if next == nil {
+			next = map[string]interface{}{}
+			section[key] = next
+		}
+		section = next
+	}
+	section["processors"] = processors
+}
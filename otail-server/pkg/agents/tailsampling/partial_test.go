@@ -0,0 +1,185 @@
+package tailsampling
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// localConfig is the config an agent runs from its supervisor's local config
+// file, without a tail_sampling processor
+const localConfig = `
+receivers:
+  otlp: {}
+processors:
+  batch: {}
+exporters:
+  debug: {}
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [debug]
+    logs:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [debug]
+`
+
+// newTestService returns a service with one agent that runs localConfig and
+// has an empty instance config. The agent does not report the status of its
+// remote config, so pushes return ErrConfigPending.
+func newTestService(t *testing.T) (*Service, uuid.UUID) {
+	t.Helper()
+	agents := opamp.NewAgents(zap.NewNop())
+	server, err := opamp.NewServer(agents, nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentID := uuid.New()
+	agent, err := agents.FindOrCreateAgent(agentID, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.UpdateStatus(&protobufs.AgentToServer{
+		EffectiveConfig: &protobufs.EffectiveConfig{
+			ConfigMap: &protobufs.AgentConfigMap{
+				ConfigMap: map[string]*protobufs.AgentConfigFile{
+					"": {Body: []byte(localConfig)},
+				},
+			},
+		},
+	}, &protobufs.ServerToAgent{})
+
+	revisionService := revisions.NewService(revisions.NewMemoryStore(), nil, nil, zap.NewNop())
+	return NewService(zap.NewNop(), server, revisionService, nil), agentID
+}
+
+// instanceConfig returns the parsed instance config of the agent
+func instanceConfig(t *testing.T, s *Service, agentID uuid.UUID) map[string]interface{} {
+	t.Helper()
+	config, err := validation.ParseConfig([]byte(s.opampServer.GetAgent(agentID).CustomInstanceConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestUpdateTailSamplingFromEmptyInstanceConfig(t *testing.T) {
+	s, agentID := newTestService(t)
+
+	block := map[string]interface{}{
+		"decision_wait": "10s",
+		"policies": []interface{}{
+			map[string]interface{}{"name": "all", "type": "always_sample"},
+		},
+	}
+	err := s.UpdateTailSampling(context.Background(), agentID, block, revisions.Change{OrgID: "org-1"})
+	if !errors.Is(err, ErrConfigPending) {
+		t.Fatalf("UpdateTailSampling() = %v, want %v", err, ErrConfigPending)
+	}
+
+	config := instanceConfig(t, s, agentID)
+	if got := tailSamplingBlock(config); !reflect.DeepEqual(got, block) {
+		t.Errorf("tail_sampling = %v, want %v", got, block)
+	}
+	// Only the traces pipeline runs the processor, before the batch processor
+	pipelines := config["service"].(map[string]interface{})["pipelines"].(map[string]interface{})
+	want := map[string]interface{}{
+		"traces": map[string]interface{}{"processors": []interface{}{"tail_sampling", "batch"}},
+	}
+	if !reflect.DeepEqual(pipelines, want) {
+		t.Errorf("pipelines = %v, want %v", pipelines, want)
+	}
+
+	// The pushed config was recorded as a revision of the agent
+	list, err := s.revisions.List(context.Background(), "org-1", revisions.TargetAgent, agentID.String())
+	if err != nil || len(list) != 1 {
+		t.Fatalf("revisions = %v, %v, want one revision", list, err)
+	}
+
+	// A second update keeps the pipelines and replaces the policies
+	upsert := map[string]interface{}{"name": "errors", "type": "status_code",
+		"status_code": map[string]interface{}{"status_codes": []interface{}{"ERROR"}}}
+	if err := s.UpsertPolicy(context.Background(), agentID, upsert, revisions.Change{}); !errors.Is(err, ErrConfigPending) {
+		t.Fatalf("UpsertPolicy() = %v, want %v", err, ErrConfigPending)
+	}
+	config = instanceConfig(t, s, agentID)
+	if policies := tailSamplingBlock(config)["policies"].([]interface{}); len(policies) != 2 {
+		t.Errorf("policies = %v, want all and errors", policies)
+	}
+	if got := config["service"].(map[string]interface{})["pipelines"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("pipelines after upsert = %v, want %v", got, want)
+	}
+}
+
+func TestUpdateTailSamplingRejectsInvalidPolicies(t *testing.T) {
+	s, agentID := newTestService(t)
+
+	block := map[string]interface{}{
+		"policies": []interface{}{
+			map[string]interface{}{"name": "bad", "type": "no_such_policy"},
+		},
+	}
+	var validationErr *validation.Error
+	if err := s.UpdateTailSampling(context.Background(), agentID, block, revisions.Change{}); !errors.As(err, &validationErr) {
+		t.Fatalf("UpdateTailSampling() = %v, want a validation error", err)
+	}
+	if config := s.opampServer.GetAgent(agentID).CustomInstanceConfig; config != "" {
+		t.Errorf("instance config = %q, want the rejected config not to be pushed", config)
+	}
+}
+
+func TestAddToTracesPipelines(t *testing.T) {
+	tests := []struct {
+		name      string
+		pipelines map[string]interface{}
+		want      map[string]interface{}
+	}{
+		{
+			name: "already wired",
+			pipelines: map[string]interface{}{
+				"traces/app": map[string]interface{}{"processors": []interface{}{"tail_sampling"}},
+				"traces":     map[string]interface{}{"processors": []interface{}{"batch"}},
+			},
+		},
+		{
+			name: "every traces pipeline",
+			pipelines: map[string]interface{}{
+				"traces/app": map[string]interface{}{"processors": []interface{}{"memory_limiter", "batch/app"}},
+				"traces":     map[string]interface{}{},
+				"metrics":    map[string]interface{}{"processors": []interface{}{"batch"}},
+			},
+			want: map[string]interface{}{
+				"traces/app": map[string]interface{}{"processors": []interface{}{"memory_limiter", "tail_sampling", "batch/app"}},
+				"traces":     map[string]interface{}{"processors": []interface{}{"tail_sampling"}},
+			},
+		},
+		{name: "no pipelines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			merged := map[string]interface{}{
+				"service": map[string]interface{}{"pipelines": tt.pipelines},
+			}
+			addToTracesPipelines(config, merged)
+
+			service, _ := config["service"].(map[string]interface{})
+			got, _ := service["pipelines"].(map[string]interface{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pipelines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
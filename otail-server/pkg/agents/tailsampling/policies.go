@@ -0,0 +1,253 @@
+package tailsampling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrPolicyNotFound     = errors.New("policy not found")
+	ErrPolicyExists       = errors.New("a policy with this name already exists")
+	ErrInvalidPolicyOrder = errors.New("policy order must list every policy exactly once")
+	ErrGroupNotFound      = errors.New("agent group not found")
+	ErrInvalidAgentID     = errors.New("invalid agent ID")
+)
+
+// ListPolicies returns the tail sampling policies of the agent or group in
+// evaluation order
+func (s *Service) ListPolicies(ctx context.Context, targetType revisions.TargetType, targetID string) ([]Policy, error) {
+	tailSampling, err := s.loadTailSampling(ctx, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, _ := tailSampling["policies"].([]interface{})
+	result := make([]Policy, 0, len(policies))
+	for _, value := range policies {
+		policy, err := policyFromMap(value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, policy)
+	}
+	return result, nil
+}
+
+// GetPolicy returns the tail sampling policy with the given name
+func (s *Service) GetPolicy(ctx context.Context, targetType revisions.TargetType, targetID, name string) (*Policy, error) {
+	policies, err := s.ListPolicies(ctx, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		if policy.Name == name {
+			return &policy, nil
+		}
+	}
+	return nil, ErrPolicyNotFound
+}
+
+// CreatePolicy appends the policy to the policies of the agent or group
+func (s *Service) CreatePolicy(ctx context.Context, targetType revisions.TargetType, targetID string, policy Policy, change revisions.Change) error {
+	value, err := policyToMap(policy)
+	if err != nil {
+		return err
+	}
+
+	return s.modifyPolicies(ctx, targetType, targetID, change, func(policies []interface{}) ([]interface{}, error) {
+		if findPolicy(policies, policy.Name) >= 0 {
+			return nil, ErrPolicyExists
+		}
+		return append(policies, value), nil
+	})
+}
+
+// UpdatePolicy replaces the policy with the given name, keeping its position.
+// The policy may be renamed.
+func (s *Service) UpdatePolicy(ctx context.Context, targetType revisions.TargetType, targetID, name string, policy Policy, change revisions.Change) error {
+	value, err := policyToMap(policy)
+	if err != nil {
+		return err
+	}
+
+	return s.modifyPolicies(ctx, targetType, targetID, change, func(policies []interface{}) ([]interface{}, error) {
+		i := findPolicy(policies, name)
+		if i < 0 {
+			return nil, ErrPolicyNotFound
+		}
+		if policy.Name != name && findPolicy(policies, policy.Name) >= 0 {
+			return nil, ErrPolicyExists
+		}
+		policies[i] = value
+		return policies, nil
+	})
+}
+
+// DeletePolicy removes the policy with the given name
+func (s *Service) DeletePolicy(ctx context.Context, targetType revisions.TargetType, targetID, name string, change revisions.Change) error {
+	return s.modifyPolicies(ctx, targetType, targetID, change, func(policies []interface{}) ([]interface{}, error) {
+		i := findPolicy(policies, name)
+		if i < 0 {
+			return nil, ErrPolicyNotFound
+		}
+		return append(policies[:i], policies[i+1:]...), nil
+	})
+}
+
+// ReorderPolicies changes the order of the policies to the order of the names,
+// which must list every policy exactly once
+func (s *Service) ReorderPolicies(ctx context.Context, targetType revisions.TargetType, targetID string, names []string, change revisions.Change) error {
+	return s.modifyPolicies(ctx, targetType, targetID, change, func(policies []interface{}) ([]interface{}, error) {
+		if len(names) != len(policies) {
+			return nil, ErrInvalidPolicyOrder
+		}
+		result := make([]interface{}, 0, len(policies))
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			i := findPolicy(policies, name)
+			if i < 0 || seen[name] {
+				return nil, ErrInvalidPolicyOrder
+			}
+			seen[name] = true
+			result = append(result, policies[i])
+		}
+		return result, nil
+	})
+}
+
+// loadTailSampling returns the tail_sampling block the policies of the agent or
+// group are modified in: the one of the agent's instance config, or the one it
+// runs until it has its own, or the one of the group config
+func (s *Service) loadTailSampling(ctx context.Context, targetType revisions.TargetType, targetID string) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	switch targetType {
+	case revisions.TargetAgent:
+		agentID, err := uuid.Parse(targetID)
+		if err != nil {
+			return nil, ErrInvalidAgentID
+		}
+		config, err = s.agentTailSamplingConfig(agentID)
+		if errors.Is(err, ErrNoEffectiveConfig) {
+			// The agent has no policies yet
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	case revisions.TargetGroup:
+		group, err := s.groups.GetByID(ctx, targetID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get agent group: %w", err)
+		}
+		if group == nil {
+			return nil, ErrGroupNotFound
+		}
+		config, err = validation.ParseConfig(group.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse group config: %w", err)
+		}
+	default:
+		return nil, revisions.ErrInvalidTargetType
+	}
+
+	return tailSamplingBlock(config), nil
+}
+
+// modifyPolicies applies the change to the policies of the agent or group and
+// pushes the resulting config. For agents only the instance config is pushed,
+// see updateTailSampling. Changes to the same agent or group are serialized,
+// so concurrent changes are not lost.
+func (s *Service) modifyPolicies(
+	ctx context.Context,
+	targetType revisions.TargetType,
+	targetID string,
+	change revisions.Change,
+	modify func(policies []interface{}) ([]interface{}, error),
+) error {
+	update := func(tailSampling map[string]interface{}) (map[string]interface{}, error) {
+		policies, _ := tailSampling["policies"].([]interface{})
+		policies, err := modify(policies)
+		if err != nil {
+			return nil, err
+		}
+		tailSampling["policies"] = policies
+		return tailSampling, nil
+	}
+
+	switch targetType {
+	case revisions.TargetAgent:
+		agentID, err := uuid.Parse(targetID)
+		if err != nil {
+			return ErrInvalidAgentID
+		}
+		return s.updateTailSampling(ctx, agentID, change, update)
+	case revisions.TargetGroup:
+		return s.updateGroupTailSampling(ctx, targetID, change, update)
+	default:
+		return revisions.ErrInvalidTargetType
+	}
+}
+
+// updateGroupTailSampling applies the update to the tail_sampling block of the
+// group config, then stores and pushes the group config to the group's agents
+func (s *Service) updateGroupTailSampling(
+	ctx context.Context,
+	groupID string,
+	change revisions.Change,
+	update func(tailSampling map[string]interface{}) (map[string]interface{}, error),
+) error {
+	defer s.lockTarget(revisions.TargetGroup, groupID)()
+
+	group, err := s.groups.GetByID(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get agent group: %w", err)
+	}
+	if group == nil {
+		return ErrGroupNotFound
+	}
+
+	config, err := validation.ParseConfig(group.Config)
+	if err != nil {
+		return fmt.Errorf("failed to parse group config: %w", err)
+	}
+	if err := updateTailSamplingBlock(config, update); err != nil {
+		return err
+	}
+
+	body, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal group config: %w", err)
+	}
+	if err := s.opampServer.ValidateGroupConfig(group.ID, body); err != nil {
+		return err
+	}
+	group.Config = body
+	if err := s.groups.Update(ctx, group); err != nil {
+		return fmt.Errorf("failed to update agent group: %w", err)
+	}
+	s.opampServer.UpdateGroupConfig(group.ID, group.Config)
+
+	if _, err := s.revisions.Record(ctx, revisions.TargetGroup, group.ID, string(group.Config), change); err != nil {
+		s.logger.Error("Failed to record group config revision",
+			zap.String("group_id", group.ID),
+			zap.Error(err))
+	}
+	return nil
+}
+
+// findPolicy returns the index of the policy with the given name, or -1
+func findPolicy(policies []interface{}, name string) int {
+	for i, value := range policies {
+		if policy, ok := value.(map[string]interface{}); ok && policy["name"] == name {
+			return i
+		}
+	}
+	return -1
+}
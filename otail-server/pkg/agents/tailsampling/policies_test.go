@@ -0,0 +1,109 @@
+package tailsampling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+)
+
+// policyNames returns the names of the policies of the agent in order
+func policyNames(t *testing.T, s *Service, targetID string) []string {
+	t.Helper()
+	policies, err := s.ListPolicies(context.Background(), revisions.TargetAgent, targetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+	return names
+}
+
+func TestAgentPolicyCRUD(t *testing.T) {
+	s, agentID := newTestService(t)
+	ctx := context.Background()
+	target := agentID.String()
+
+	// pending is the outcome of every push, the test agent doesn't report
+	// whether it applied its config
+	pending := func(err error) bool { return errors.Is(err, ErrConfigPending) }
+
+	if names := policyNames(t, s, target); len(names) != 0 {
+		t.Fatalf("policies of a default agent = %q, want none", names)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		policy := Policy{Name: name, Type: PolicyAlwaysSample}
+		if err := s.CreatePolicy(ctx, revisions.TargetAgent, target, policy, revisions.Change{}); !pending(err) {
+			t.Fatalf("CreatePolicy(%s) = %v", name, err)
+		}
+	}
+	if names := policyNames(t, s, target); !reflect.DeepEqual(names, []string{"first", "second"}) {
+		t.Errorf("policies after create = %q", names)
+	}
+
+	// Conflicts with existing policies are rejected without a push
+	duplicate := Policy{Name: "first", Type: PolicyAlwaysSample}
+	if err := s.CreatePolicy(ctx, revisions.TargetAgent, target, duplicate, revisions.Change{}); !errors.Is(err, ErrPolicyExists) {
+		t.Errorf("CreatePolicy() of an existing name = %v, want %v", err, ErrPolicyExists)
+	}
+	if err := s.UpdatePolicy(ctx, revisions.TargetAgent, target, "second", duplicate, revisions.Change{}); !errors.Is(err, ErrPolicyExists) {
+		t.Errorf("UpdatePolicy() renaming to an existing name = %v, want %v", err, ErrPolicyExists)
+	}
+	if err := s.UpdatePolicy(ctx, revisions.TargetAgent, target, "missing", duplicate, revisions.Change{}); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("UpdatePolicy() of a missing policy = %v, want %v", err, ErrPolicyNotFound)
+	}
+
+	// Updates keep the position of the policy
+	renamed := Policy{Name: "errors", Type: PolicyStatusCode, StatusCode: &StatusCodeConfig{StatusCodes: []string{"ERROR"}}}
+	if err := s.UpdatePolicy(ctx, revisions.TargetAgent, target, "first", renamed, revisions.Change{}); !pending(err) {
+		t.Fatalf("UpdatePolicy() = %v", err)
+	}
+	policy, err := s.GetPolicy(ctx, revisions.TargetAgent, target, "errors")
+	if err != nil || policy.Type != PolicyStatusCode {
+		t.Errorf("GetPolicy(errors) = %+v, %v", policy, err)
+	}
+	if names := policyNames(t, s, target); !reflect.DeepEqual(names, []string{"errors", "second"}) {
+		t.Errorf("policies after update = %q", names)
+	}
+
+	if err := s.DeletePolicy(ctx, revisions.TargetAgent, target, "errors", revisions.Change{}); !pending(err) {
+		t.Fatalf("DeletePolicy() = %v", err)
+	}
+	if err := s.DeletePolicy(ctx, revisions.TargetAgent, target, "errors", revisions.Change{}); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("DeletePolicy() of a deleted policy = %v, want %v", err, ErrPolicyNotFound)
+	}
+	if names := policyNames(t, s, target); !reflect.DeepEqual(names, []string{"second"}) {
+		t.Errorf("policies after delete = %q", names)
+	}
+}
+
+func TestConcurrentPolicyChanges(t *testing.T) {
+	s, agentID := newTestService(t)
+	target := agentID.String()
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			policy := Policy{Name: fmt.Sprintf("policy-%d", i), Type: PolicyAlwaysSample}
+			err := s.CreatePolicy(context.Background(), revisions.TargetAgent, target, policy, revisions.Change{})
+			if !errors.Is(err, ErrConfigPending) {
+				t.Errorf("CreatePolicy(%s) = %v", policy.Name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if names := policyNames(t, s, target); len(names) != n {
+		t.Errorf("got %d policies after %d concurrent creates, want all of them: %q", len(names), n, names)
+	}
+}
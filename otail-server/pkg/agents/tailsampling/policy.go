@@ -0,0 +1,154 @@
+package tailsampling
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+)
+
+// PolicyType is the type of a tail sampling policy
+type PolicyType string
+
+const (
+	PolicyAlwaysSample    PolicyType = validation.PolicyAlwaysSample
+	PolicyAnd             PolicyType = validation.PolicyAnd
+	PolicyBooleanAttr     PolicyType = validation.PolicyBooleanAttr
+	PolicyComposite       PolicyType = validation.PolicyComposite
+	PolicyDrop            PolicyType = validation.PolicyDrop
+	PolicyLatency         PolicyType = validation.PolicyLatency
+	PolicyNumericAttr     PolicyType = validation.PolicyNumericAttr
+	PolicyOTTLCondition   PolicyType = validation.PolicyOTTLCondition
+	PolicyProbabilistic   PolicyType = validation.PolicyProbabilistic
+	PolicyRateLimiting    PolicyType = validation.PolicyRateLimiting
+	PolicySpanCount       PolicyType = validation.PolicySpanCount
+	PolicyStatusCode      PolicyType = validation.PolicyStatusCode
+	PolicyStringAttribute PolicyType = validation.PolicyStringAttribute
+	PolicyTraceState      PolicyType = validation.PolicyTraceState
+)
+
+// Policy is a policy of the tail_sampling processor. The settings of the
+// policy are in the field named after its type, as in the collector config.
+type Policy struct {
+	Name string     `json:"name"`
+	Type PolicyType `json:"type"`
+
+	Latency          *LatencyConfig          `json:"latency,omitempty"`
+	NumericAttribute *NumericAttributeConfig `json:"numeric_attribute,omitempty"`
+	Probabilistic    *ProbabilisticConfig    `json:"probabilistic,omitempty"`
+	StatusCode       *StatusCodeConfig       `json:"status_code,omitempty"`
+	StringAttribute  *StringAttributeConfig  `json:"string_attribute,omitempty"`
+	RateLimiting     *RateLimitingConfig     `json:"rate_limiting,omitempty"`
+	SpanCount        *SpanCountConfig        `json:"span_count,omitempty"`
+	TraceState       *TraceStateConfig       `json:"trace_state,omitempty"`
+	BooleanAttribute *BooleanAttributeConfig `json:"boolean_attribute,omitempty"`
+	OTTLCondition    *OTTLConditionConfig    `json:"ottl_condition,omitempty"`
+	And              *AndConfig              `json:"and,omitempty"`
+	Composite        *CompositeConfig        `json:"composite,omitempty"`
+	Drop             *DropConfig             `json:"drop,omitempty"`
+}
+
+type LatencyConfig struct {
+	ThresholdMs      int64 `json:"threshold_ms"`
+	UpperThresholdMs int64 `json:"upper_threshold_ms,omitempty"`
+}
+
+// NumericAttributeConfig matches values between MinValue and MaxValue,
+// inclusive. A bound that is not set is unbounded.
+type NumericAttributeConfig struct {
+	Key         string `json:"key"`
+	MinValue    *int64 `json:"min_value,omitempty"`
+	MaxValue    *int64 `json:"max_value,omitempty"`
+	InvertMatch bool   `json:"invert_match,omitempty"`
+}
+
+type ProbabilisticConfig struct {
+	HashSalt           string  `json:"hash_salt,omitempty"`
+	SamplingPercentage float64 `json:"sampling_percentage"`
+}
+
+type StatusCodeConfig struct {
+	StatusCodes []string `json:"status_codes"`
+}
+
+type StringAttributeConfig struct {
+	Key                  string   `json:"key"`
+	Values               []string `json:"values"`
+	EnabledRegexMatching bool     `json:"enabled_regex_matching,omitempty"`
+	CacheMaxSize         int      `json:"cache_max_size,omitempty"`
+	InvertMatch          bool     `json:"invert_match,omitempty"`
+}
+
+type RateLimitingConfig struct {
+	SpansPerSecond int64 `json:"spans_per_second"`
+}
+
+type SpanCountConfig struct {
+	MinSpans int32 `json:"min_spans"`
+	MaxSpans int32 `json:"max_spans,omitempty"`
+}
+
+type TraceStateConfig struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+type BooleanAttributeConfig struct {
+	Key         string `json:"key"`
+	Value       bool   `json:"value"`
+	InvertMatch bool   `json:"invert_match,omitempty"`
+}
+
+type OTTLConditionConfig struct {
+	ErrorMode           string   `json:"error_mode,omitempty"`
+	SpanConditions      []string `json:"span,omitempty"`
+	SpanEventConditions []string `json:"spanevent,omitempty"`
+}
+
+type AndConfig struct {
+	SubPolicies []Policy `json:"and_sub_policy"`
+}
+
+type CompositeConfig struct {
+	MaxTotalSpansPerSecond int64            `json:"max_total_spans_per_second"`
+	PolicyOrder            []string         `json:"policy_order,omitempty"`
+	SubPolicies            []Policy         `json:"composite_sub_policy"`
+	RateAllocation         []RateAllocation `json:"rate_allocation,omitempty"`
+}
+
+type RateAllocation struct {
+	Policy  string `json:"policy"`
+	Percent int64  `json:"percent"`
+}
+
+type DropConfig struct {
+	SubPolicies []Policy `json:"drop_sub_policy"`
+}
+
+// policyToMap converts the policy to the untyped form of the collector config
+func policyToMap(policy Policy) (map[string]interface{}, error) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal policy: %w", err)
+	}
+	return result, nil
+}
+
+// policyFromMap converts a policy of the collector config to its typed form.
+// Settings the typed form does not know about are dropped, so existing
+// policies are kept in their untyped form when the config is updated.
+func policyFromMap(value interface{}) (Policy, error) {
+	var policy Policy
+	data, err := json.Marshal(value)
+	if err != nil {
+		return policy, fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("failed to unmarshal policy: %w", err)
+	}
+	return policy, nil
+}
@@ -1,46 +1,90 @@
 package tailsampling
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/groups"
 	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/open-telemetry/opamp-go/protobufs"
 	"go.uber.org/zap"
-	"gopkg.in/yaml.v2"
 )
 
+// configApplyTimeout is how long UpdateConfig waits for the agent to report
+// whether it applied the config
+const configApplyTimeout = 30 * time.Second
+
+var (
+	// ErrConfigPending is returned when the config was pushed but the agent did
+	// not report whether it applied it
+	ErrConfigPending = errors.New("config was pushed but the agent has not applied it yet")
+)
+
+// ConfigApplyError is returned when the agent reports that it failed to apply
+// the pushed config
+type ConfigApplyError struct {
+	AgentID uuid.UUID
+	Message string
+}
+
+func (e *ConfigApplyError) Error() string {
+	return fmt.Sprintf("agent %s failed to apply config: %s", e.AgentID, e.Message)
+}
+
 // Service handles tail sampling specific operations
 type Service struct {
 	logger      *zap.Logger
 	opampServer *opamp.Server
+	revisions   *revisions.Service
+	groups      groups.Store
+
+	// Locks serializing the read-modify-write of the tail sampling config of
+	// each agent and group, by target type and ID
+	locksMu sync.Mutex
+	locks   map[string]*sync.Mutex
 }
 
 // NewService creates a new tail sampling service
-func NewService(logger *zap.Logger, opampServer *opamp.Server) *Service {
+func NewService(logger *zap.Logger, opampServer *opamp.Server, revisions *revisions.Service, groups groups.Store) *Service {
 	return &Service{
 		logger:      logger,
 		opampServer: opampServer,
+		revisions:   revisions,
+		groups:      groups,
+		locks:       make(map[string]*sync.Mutex),
 	}
 }
 
+// lockTarget locks the tail sampling config of the agent or group until the
+// returned function is called, so that concurrent changes are not lost
+func (s *Service) lockTarget(targetType revisions.TargetType, targetID string) func() {
+	key := string(targetType) + "/" + targetID
+	s.locksMu.Lock()
+	lock := s.locks[key]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		s.locks[key] = lock
+	}
+	s.locksMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
 // GetConfig retrieves the tail sampling configuration for a specific agent
 func (s *Service) GetConfig(agentID uuid.UUID) (string, error) {
-	config, err := s.opampServer.GetEffectiveConfig(agentID)
+	configMap, err := s.opampServer.GetMergedEffectiveConfig(agentID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get tail sampling config: %w", err)
 	}
 
-	var yamlConfig map[interface{}]interface{}
-	if err := yaml.Unmarshal([]byte(config), &yamlConfig); err != nil {
-		return "", fmt.Errorf("failed to unmarshal config: %w", err)
-	}
-
-	// Convert the YAML map to a string-keyed map
-	configMap := convertToStringMap(yamlConfig)
-	s.logger.Info("Parsed tail sampling config", zap.Any("configMap", configMap))
-
 	processors, ok := configMap["processors"].(map[string]interface{})
 	if !ok {
 		// Return empty JSON object if processors doesn't exist or is not a map
@@ -68,20 +112,125 @@ func (s *Service) GetConfig(agentID uuid.UUID) (string, error) {
 }
 
 // UpdateConfig updates the tail sampling configuration for a specific agent
-func (s *Service) UpdateConfig(agentID uuid.UUID, config map[string]interface{}) error {
-	notifyNextStatusUpdate := make(chan struct{}, 1)
-	if err := s.opampServer.UpdateConfig(agentID, config, notifyNextStatusUpdate); err != nil {
+// and records the pushed config as a new revision of the agent. It waits for
+// the agent to report the outcome and returns a *ConfigApplyError if the agent
+// failed to apply the config, or ErrConfigPending if the outcome is not known
+// within configApplyTimeout.
+func (s *Service) UpdateConfig(ctx context.Context, agentID uuid.UUID, config map[string]interface{}, change revisions.Change) error {
+	if err := s.pushConfig(ctx, agentID, config, change); err != nil {
+		return err
+	}
+	return s.waitForApply(ctx, agentID)
+}
+
+// pushConfig sets the config as the instance config of the agent and records
+// it as a new revision of the agent
+func (s *Service) pushConfig(ctx context.Context, agentID uuid.UUID, config map[string]interface{}, change revisions.Change) error {
+	if err := s.opampServer.UpdateConfig(agentID, config, nil); err != nil {
 		return fmt.Errorf("failed to update tail sampling config: %w", err)
 	}
-	timer := time.NewTicker(time.Second * 5)
+	change.File = ""
+	s.recordRevision(ctx, agentID, change)
+	return nil
+}
 
-	select {
-	case <-notifyNextStatusUpdate:
-	case <-timer.C:
+// recordRevision records the pushed config file named by the change as a new
+// revision of the agent. A removed file is recorded with an empty config.
+func (s *Service) recordRevision(ctx context.Context, agentID uuid.UUID, change revisions.Change) {
+	agent := s.opampServer.GetAgent(agentID)
+	if agent == nil {
+		return
+	}
+	config := agent.CustomInstanceConfig
+	if change.File != "" {
+		config = agent.CustomConfigFiles[change.File]
+	}
+
+	if _, err := s.revisions.Record(ctx, revisions.TargetAgent, agentID.String(), config, change); err != nil {
+		s.logger.Error("Failed to record config revision",
+			zap.String("agent_id", agentID.String()),
+			zap.String("file", change.File),
+			zap.Error(err))
+	}
+}
+
+// GetConfigFiles returns the custom config files of the agent by name. The
+// instance config is returned under the empty name.
+func (s *Service) GetConfigFiles(agentID uuid.UUID) (map[string]string, error) {
+	return s.opampServer.GetConfigFiles(agentID)
+}
+
+// UpdateConfigFile sets a named config file of the agent, records it as a new
+// revision of the agent and waits for the agent to report the outcome, like
+// UpdateConfig
+func (s *Service) UpdateConfigFile(ctx context.Context, agentID uuid.UUID, name string, config map[string]interface{}, change revisions.Change) error {
+	if err := s.opampServer.SetConfigFile(agentID, name, config, nil); err != nil {
+		return fmt.Errorf("failed to update config file: %w", err)
+	}
+	change.File = name
+	s.recordRevision(ctx, agentID, change)
+	return s.waitForApply(ctx, agentID)
+}
+
+// DeleteConfigFile removes a named config file of the agent, records the
+// removal as a new revision of the agent and waits for the agent to report the
+// outcome, like UpdateConfig
+func (s *Service) DeleteConfigFile(ctx context.Context, agentID uuid.UUID, name string, change revisions.Change) error {
+	if err := s.opampServer.DeleteConfigFile(agentID, name, nil); err != nil {
+		return fmt.Errorf("failed to delete config file: %w", err)
+	}
+	change.File = name
+	s.recordRevision(ctx, agentID, change)
+	return s.waitForApply(ctx, agentID)
+}
+
+// waitForApply waits for the agent to report whether it applied its current
+// remote config
+func (s *Service) waitForApply(ctx context.Context, agentID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, configApplyTimeout)
+	defer cancel()
+
+	status, errMsg, err := s.opampServer.WaitForRemoteConfig(ctx, agentID)
+	if err != nil {
+		s.logger.Info("Config outcome not reported by agent",
+			zap.String("agent_id", agentID.String()),
+			zap.Error(err))
+		return fmt.Errorf("%w: %v", ErrConfigPending, err)
+	}
+	if status == protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED {
+		return &ConfigApplyError{AgentID: agentID, Message: errMsg}
 	}
 	return nil
 }
 
+// ConfigFailure describes an agent whose current remote config failed to apply
+type ConfigFailure struct {
+	AgentID      uuid.UUID `json:"agent_id"`
+	ErrorMessage string    `json:"error_message"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// GetConfigFailures returns the agents of the organization whose current
+// remote config failed to apply
+func (s *Service) GetConfigFailures(organizationID string) []ConfigFailure {
+	failures := []ConfigFailure{}
+	for id, agent := range s.opampServer.GetAgentsByOrganization(organizationID) {
+		status, errMsg := agent.RemoteConfigApplyStatus()
+		if status != protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED {
+			continue
+		}
+		failures = append(failures, ConfigFailure{
+			AgentID:      id,
+			ErrorMessage: errMsg,
+			LastSeen:     agent.CloneReadonly().LastSeen,
+		})
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].AgentID.String() < failures[j].AgentID.String()
+	})
+	return failures
+}
+
 // ListAgents returns a list of all connected agents
 func (s *Service) ListAgents() map[uuid.UUID]*opamp.Agent {
 	return s.opampServer.ListAgents()
@@ -108,35 +257,3 @@ func (s *Service) GetAgentsByGroup(groupID string) map[uuid.UUID]*opamp.Agent {
 	}
 	return result
 }
-
-// convertToStringMap converts map[interface{}]interface{} to map[string]interface{}
-func convertToStringMap(m map[interface{}]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for k, v := range m {
-		switch val := v.(type) {
-		case map[interface{}]interface{}:
-			result[fmt.Sprint(k)] = convertToStringMap(val)
-		case []interface{}:
-			result[fmt.Sprint(k)] = convertToInterfaceSlice(val)
-		default:
-			result[fmt.Sprint(k)] = v
-		}
-	}
-	return result
-}
-
-// convertToInterfaceSlice handles conversion of slice elements
-func convertToInterfaceSlice(slice []interface{}) []interface{} {
-	result := make([]interface{}, len(slice))
-	for i, v := range slice {
-		switch val := v.(type) {
-		case map[interface{}]interface{}:
-			result[i] = convertToStringMap(val)
-		case []interface{}:
-			result[i] = convertToInterfaceSlice(val)
-		default:
-			result[i] = v
-		}
-	}
-	return result
-}
@@ -0,0 +1,101 @@
+package tailsampling
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mottibec/otail-server/pkg/agents/opamp"
+	"github.com/mottibec/otail-server/pkg/agents/revisions"
+	"github.com/mottibec/otail-server/pkg/agents/validation"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// reportingConnection answers each pushed remote config with the outcome of
+// applying it, like an agent that reports its remote config status
+type reportingConnection struct {
+	agent  *opamp.Agent
+	status protobufs.RemoteConfigStatuses
+	errMsg string
+}
+
+func (c *reportingConnection) Connection() net.Conn { return nil }
+func (c *reportingConnection) Disconnect() error    { return nil }
+
+func (c *reportingConnection) Send(ctx context.Context, msg *protobufs.ServerToAgent) error {
+	if msg.RemoteConfig == nil {
+		return nil
+	}
+	go c.agent.UpdateStatus(&protobufs.AgentToServer{
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig),
+		RemoteConfigStatus: &protobufs.RemoteConfigStatus{
+			LastRemoteConfigHash: msg.RemoteConfig.ConfigHash,
+			Status:               c.status,
+			ErrorMessage:         c.errMsg,
+		},
+	}, &protobufs.ServerToAgent{})
+	return nil
+}
+
+// newReportingService returns a service with one agent of org-1 that reports
+// the status of its remote config through conn
+func newReportingService(t *testing.T, conn *reportingConnection) (*Service, uuid.UUID) {
+	t.Helper()
+	agents := opamp.NewAgents(zap.NewNop())
+	server, err := opamp.NewServer(agents, nil, nil, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentID := uuid.New()
+	agent, err := agents.FindOrCreateAgent(agentID, conn, "org-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents.SetConnection(conn, "org-1", "", "")
+	agent.UpdateStatus(&protobufs.AgentToServer{
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig),
+	}, &protobufs.ServerToAgent{})
+	conn.agent = agent
+
+	revisionService := revisions.NewService(revisions.NewMemoryStore(), nil, nil, zap.NewNop())
+	return NewService(zap.NewNop(), server, revisionService, nil), agentID
+}
+
+func TestUpdateConfigWaitsForOutcome(t *testing.T) {
+	config, err := validation.ParseConfig([]byte(localConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := &reportingConnection{status: protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLIED}
+	s, agentID := newReportingService(t, applied)
+	if err := s.UpdateConfig(context.Background(), agentID, config, revisions.Change{}); err != nil {
+		t.Errorf("UpdateConfig() of an applied config = %v", err)
+	}
+	if failures := s.GetConfigFailures("org-1"); len(failures) != 0 {
+		t.Errorf("GetConfigFailures() = %v, want none", failures)
+	}
+
+	failed := &reportingConnection{
+		status: protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED,
+		errMsg: "processor \"batch\" failed to start",
+	}
+	s, agentID = newReportingService(t, failed)
+	err = s.UpdateConfig(context.Background(), agentID, config, revisions.Change{})
+	var applyErr *ConfigApplyError
+	if !errors.As(err, &applyErr) || applyErr.AgentID != agentID || applyErr.Message != failed.errMsg {
+		t.Fatalf("UpdateConfig() of a failing config = %v, want a ConfigApplyError with the agent's message", err)
+	}
+
+	failures := s.GetConfigFailures("org-1")
+	if len(failures) != 1 || failures[0].AgentID != agentID || failures[0].ErrorMessage != failed.errMsg {
+		t.Errorf("GetConfigFailures() = %+v, want the failing agent", failures)
+	}
+	if failures := s.GetConfigFailures("org-2"); len(failures) != 0 {
+		t.Errorf("GetConfigFailures() of another organization = %v, want none", failures)
+	}
+}
@@ -0,0 +1,196 @@
+package traces
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+	// defaultRange is the time range queried when no start is given
+	defaultRange = time.Hour
+)
+
+// Handler serves the traces stored in ClickHouse as OTLP JSON, so they can be
+// fed directly into the sampling playground
+type Handler struct {
+	clickhouse *clickhouse.Client
+	logger     *zap.Logger
+}
+
+// NewHandler creates a new traces handler. Requests fail with 503 while the
+// ClickHouse client is nil or not connected.
+func NewHandler(clickhouse *clickhouse.Client, logger *zap.Logger) *Handler {
+	return &Handler{
+		clickhouse: clickhouse,
+		logger:     logger,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Get("/", h.QueryTraces)
+	r.Get("/{traceId}", h.GetTrace)
+}
+
+// QueryTraces returns the traces with a span matching the filters of the
+// query parameters:
+//
+//	start, end      RFC3339 time range, the last hour by default
+//	service         service name
+//	span_name       span name
+//	min_duration    minimum span duration, e.g. 500ms
+//	max_duration    maximum span duration
+//	status          span status: ok, error or unset
+//	attribute       key=value span or resource attribute, can be repeated
+//	limit           maximum number of traces, 100 by default
+func (h *Handler) QueryTraces(w http.ResponseWriter, r *http.Request) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "trace storage is not available")
+		return
+	}
+
+	query, err := parseTraceQuery(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	td, err := h.clickhouse.QueryTraces(r.Context(), query)
+	if errors.Is(err, clickhouse.ErrUnavailable) {
+		h.writeError(w, http.StatusServiceUnavailable, "trace storage is not available")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to query traces", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to query traces")
+		return
+	}
+	h.writeTraces(w, td)
+}
+
+// GetTrace returns every span of a trace
+func (h *Handler) GetTrace(w http.ResponseWriter, r *http.Request) {
+	if h.clickhouse == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "trace storage is not available")
+		return
+	}
+
+	traceID := chi.URLParam(r, "traceId")
+	if b, err := hex.DecodeString(traceID); err != nil || len(b) != 16 {
+		h.writeError(w, http.StatusBadRequest, "trace ID must be 32 hex characters")
+		return
+	}
+
+	td, err := h.clickhouse.GetTrace(r.Context(), traceID)
+	if errors.Is(err, clickhouse.ErrUnavailable) {
+		h.writeError(w, http.StatusServiceUnavailable, "trace storage is not available")
+		return
+	}
+	if errors.Is(err, clickhouse.ErrTraceNotFound) {
+		h.writeError(w, http.StatusNotFound, "Trace not found")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get trace", zap.String("trace_id", traceID), zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to get trace")
+		return
+	}
+	h.writeTraces(w, td)
+}
+
+func parseTraceQuery(params url.Values) (clickhouse.TraceQuery, error) {
+	q := clickhouse.TraceQuery{
+		End:         time.Now(),
+		ServiceName: params.Get("service"),
+		SpanName:    params.Get("span_name"),
+		Limit:       defaultLimit,
+	}
+
+	var err error
+	if v := params.Get("end"); v != "" {
+		if q.End, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	q.Start = q.End.Add(-defaultRange)
+	if v := params.Get("start"); v != "" {
+		if q.Start, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+	if !q.End.After(q.Start) {
+		return q, errors.New("end must be after start")
+	}
+
+	if v := params.Get("min_duration"); v != "" {
+		if q.MinDuration, err = time.ParseDuration(v); err != nil || q.MinDuration < 0 {
+			return q, fmt.Errorf("invalid min_duration %q", v)
+		}
+	}
+	if v := params.Get("max_duration"); v != "" {
+		if q.MaxDuration, err = time.ParseDuration(v); err != nil || q.MaxDuration < 0 {
+			return q, fmt.Errorf("invalid max_duration %q", v)
+		}
+	}
+
+	if v := params.Get("status"); v != "" {
+		switch strings.ToLower(v) {
+		case "ok", "error", "unset":
+			q.StatusCode = v
+		default:
+			return q, fmt.Errorf("invalid status %q, must be ok, error or unset", v)
+		}
+	}
+
+	for _, attribute := range params["attribute"] {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok || key == "" {
+			return q, fmt.Errorf("invalid attribute %q, must be key=value", attribute)
+		}
+		if q.Attributes == nil {
+			q.Attributes = make(map[string]string)
+		}
+		q.Attributes[key] = value
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// writeTraces writes the traces as an OTLP JSON payload
+func (h *Handler) writeTraces(w http.ResponseWriter, td ptrace.Traces) {
+	marshaler := &ptrace.JSONMarshaler{}
+	body, err := marshaler.MarshalTraces(td)
+	if err != nil {
+		h.logger.Error("Failed to marshal traces", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,122 @@
+package traces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mottibec/otail-server/pkg/agents/clickhouse"
+	"go.uber.org/zap"
+)
+
+func TestParseTraceQuery(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+
+	tests := []struct {
+		name    string
+		params  string
+		want    clickhouse.TraceQuery
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			params: "end=2024-05-01T10:30:00Z",
+			want:   clickhouse.TraceQuery{Start: end.Add(-defaultRange), End: end, Limit: defaultLimit},
+		},
+		{
+			name: "all filters",
+			params: "start=2024-05-01T10:00:00Z&end=2024-05-01T10:30:00Z&service=checkout&span_name=GET+%2Fcart" +
+				"&min_duration=500ms&max_duration=2s&status=error&attribute=http.route%3D%2Fcart&attribute=env%3Dprod&limit=20",
+			want: clickhouse.TraceQuery{
+				Start:       start,
+				End:         end,
+				ServiceName: "checkout",
+				SpanName:    "GET /cart",
+				MinDuration: 500 * time.Millisecond,
+				MaxDuration: 2 * time.Second,
+				StatusCode:  "error",
+				Attributes:  map[string]string{"http.route": "/cart", "env": "prod"},
+				Limit:       20,
+			},
+		},
+		{name: "invalid start", params: "start=yesterday", wantErr: true},
+		{name: "end before start", params: "start=2024-05-01T10:30:00Z&end=2024-05-01T10:00:00Z", wantErr: true},
+		{name: "invalid duration", params: "min_duration=fast", wantErr: true},
+		{name: "negative duration", params: "max_duration=-1s", wantErr: true},
+		{name: "invalid status", params: "status=failed", wantErr: true},
+		{name: "attribute without value", params: "attribute=env", wantErr: true},
+		{name: "attribute without key", params: "attribute=%3Dprod", wantErr: true},
+		{name: "limit too large", params: "limit=1001", wantErr: true},
+		{name: "limit not a number", params: "limit=all", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseTraceQuery(params)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTraceQuery(%q) = %+v, want an error", tt.params, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTraceQuery(%q) = %v", tt.params, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTraceQuery(%q) = %+v, want %+v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+// unavailableClient returns a client of a ClickHouse that can't be reached
+func unavailableClient(t *testing.T) *clickhouse.Client {
+	t.Helper()
+	client, err := clickhouse.NewClient("clickhouse://127.0.0.1:1/default?dial_timeout=100ms", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestTraceHandlerStatus(t *testing.T) {
+	newRouter := func(client *clickhouse.Client) http.Handler {
+		r := chi.NewRouter()
+		NewHandler(client, zap.NewNop()).RegisterRoutes(r)
+		return r
+	}
+	withoutClickHouse := newRouter(nil)
+	unavailable := newRouter(unavailableClient(t))
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		target     string
+		wantStatus int
+	}{
+		{name: "query without ClickHouse", handler: withoutClickHouse, target: "/", wantStatus: http.StatusServiceUnavailable},
+		{name: "trace without ClickHouse", handler: withoutClickHouse, target: "/5b8efff798038103d269b633813fc60c", wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid query", handler: unavailable, target: "/?status=failed", wantStatus: http.StatusBadRequest},
+		{name: "invalid trace ID", handler: unavailable, target: "/5b8efff7", wantStatus: http.StatusBadRequest},
+		{name: "query while unavailable", handler: unavailable, target: "/?service=checkout", wantStatus: http.StatusServiceUnavailable},
+		{name: "trace while unavailable", handler: unavailable, target: "/5b8efff798038103d269b633813fc60c", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+)
+
+// ValidateFunctions checks that the OTTL conditions of the tail_sampling
+// processors in the config only call functions available in the
+// collector-contrib version, as registered in filter.DefaultFunctions. The
+// functions of versions newer than the linked one are not known, so calls of
+// functions that are not linked are accepted for them. An empty version is
+// checked against the linked functions.
+func ValidateFunctions(config map[string]interface{}, version string) error {
+	newer := filter.IsNewerThanLinked(version)
+
+	// Conditions that do not compile for other reasons are reported by the
+	// syntax check instead
+	var errs errorList
+	walkOTTLConditions(config, func(field, context, condition string) {
+		var err error
+		switch context {
+		case "span":
+			err = parseSpanCondition(filter.SpanFuncs(version), condition)
+		case "spanevent":
+			err = parseSpanEventCondition(filter.SpanEventFuncs(version), condition)
+		}
+		name, ok := undefinedFunction(err)
+		if !ok || newer {
+			return
+		}
+		if version == "" {
+			errs.add(field, "function %s is not available in collector-contrib %s and the collector version of the agent is unknown", name, filter.LinkedVersion)
+			return
+		}
+		errs.add(field, "function %s is not available in collector-contrib %s", name, version)
+	})
+	return errs.err()
+}
+
+// walkOTTLConditions calls fn with every span and spanevent condition of the
+// ottl_condition policies of the tail_sampling processors in the config,
+// including sub-policies
+func walkOTTLConditions(config map[string]interface{}, fn func(field, context, condition string)) {
+	processors, _ := asMap(config[kindProcessors])
+	for _, id := range sortedKeys(processors) {
+		if componentType(id) != "tail_sampling" {
+			continue
+		}
+		cfg, _ := asMap(processors[id])
+		policies, _ := cfg["policies"].([]interface{})
+		walkPolicyConditions(kindProcessors+"."+id+".policies", policies, fn)
+	}
+}
+
+func walkPolicyConditions(field string, policies []interface{}, fn func(field, context, condition string)) {
+	for i, value := range policies {
+		policyField := fmt.Sprintf("%s[%d]", field, i)
+		policy, _ := asMap(value)
+		typ, _ := policy["type"].(string)
+		settings, _ := asMap(policy[typ])
+
+		switch typ {
+		case PolicyOTTLCondition:
+			for _, key := range []string{"span", "spanevent"} {
+				conditions, _ := settings[key].([]interface{})
+				for j, condition := range conditions {
+					if s, ok := condition.(string); ok {
+						fn(fmt.Sprintf("%s.%s.%s[%d]", policyField, typ, key, j), key, s)
+					}
+				}
+			}
+		case PolicyAnd, PolicyDrop, PolicyComposite:
+			key := typ + "_sub_policy"
+			subPolicies, _ := settings[key].([]interface{})
+			walkPolicyConditions(policyField+"."+typ+"."+key, subPolicies, fn)
+		}
+	}
+}
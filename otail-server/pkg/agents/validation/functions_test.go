@@ -0,0 +1,98 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+)
+
+func TestValidateFunctions(t *testing.T) {
+	config := map[string]interface{}{
+		"processors": map[string]interface{}{
+			"tail_sampling": map[string]interface{}{
+				"policies": []interface{}{
+					map[string]interface{}{
+						"name": "root",
+						"type": "ottl_condition",
+						"ottl_condition": map[string]interface{}{
+							"span": []interface{}{
+								`IsRootSpan()`,
+								`Weekday(Now()) == 1`,
+								`AddedLater(name)`,
+								`name ==`,
+							},
+							"spanevent": []interface{}{`Len(attributes) > 1`},
+						},
+					},
+					map[string]interface{}{
+						"name": "and",
+						"type": "and",
+						"and": map[string]interface{}{
+							"and_sub_policy": []interface{}{
+								map[string]interface{}{
+									"name":           "sub",
+									"type":           "ottl_condition",
+									"ottl_condition": map[string]interface{}{"span": []interface{}{`IsBool(name)`}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// The condition with invalid syntax is left to ValidateTailSampling
+	tests := []struct {
+		version    string
+		wantFields []string
+	}{
+		{
+			// Older than every version of the manifest, checked against the
+			// oldest one, which lacks Weekday and IsBool
+			version: "v0.80.0",
+			wantFields: []string{
+				"processors.tail_sampling.policies[0].ottl_condition.span[1]",
+				"processors.tail_sampling.policies[0].ottl_condition.span[2]",
+				"processors.tail_sampling.policies[1].and.and_sub_policy[0].ottl_condition.span[0]",
+			},
+		},
+		{
+			version: "v0.95.0",
+			wantFields: []string{
+				"processors.tail_sampling.policies[0].ottl_condition.span[1]",
+				"processors.tail_sampling.policies[0].ottl_condition.span[2]",
+			},
+		},
+		{
+			version:    filter.LinkedVersion,
+			wantFields: []string{"processors.tail_sampling.policies[0].ottl_condition.span[2]"},
+		},
+		{
+			version:    "",
+			wantFields: []string{"processors.tail_sampling.policies[0].ottl_condition.span[2]"},
+		},
+		// Newer versions may have functions that are not linked
+		{version: "v0.131.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			err := ValidateFunctions(config, tt.version)
+
+			var fields []string
+			var validationErr *Error
+			if errors.As(err, &validationErr) {
+				for _, fieldErr := range validationErr.Errors {
+					fields = append(fields, fieldErr.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %q, want %q (%v)", fields, tt.wantFields, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"regexp"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspan"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspanevent"
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+)
+
+// Conditions are compiled by the OTTL parser of collector-contrib with the
+// functions of the tail_sampling processor, the same ones the evaluator uses.
+// Type errors, e.g. comparing a map to an int, only occur when a condition is
+// evaluated and are caught by the collector itself.
+
+var telemetrySettings = component.TelemetrySettings{Logger: zap.NewNop()}
+
+// undefinedFunctionPattern matches the error of the OTTL parser for a call of
+// a function it does not have
+var undefinedFunctionPattern = regexp.MustCompile(`undefined function "([^"]+)"`)
+
+// undefinedFunction returns the name of the function the condition calls but
+// the parser does not have, if that is why the condition did not compile.
+// Whether the function exists depends on the collector-contrib version of the
+// agent, which ValidateFunctions checks.
+func undefinedFunction(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	m := undefinedFunctionPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// validErrorMode reports whether the collector accepts the OTTL error mode
+func validErrorMode(mode string) bool {
+	var m ottl.ErrorMode
+	return m.UnmarshalText([]byte(mode)) == nil
+}
+
+// compileSpanCondition compiles an OTTL condition in the span context
+func compileSpanCondition(condition string) error {
+	return parseSpanCondition(filter.StandardSpanFuncs(), condition)
+}
+
+// compileSpanEventCondition compiles an OTTL condition in the span event context
+func compileSpanEventCondition(condition string) error {
+	return parseSpanEventCondition(filter.StandardSpanEventFuncs(), condition)
+}
+
+func parseSpanCondition(functions map[string]ottl.Factory[ottlspan.TransformContext], condition string) error {
+	parser, err := ottlspan.NewParser(functions, telemetrySettings)
+	if err != nil {
+		return err
+	}
+	_, err = parser.ParseCondition(condition)
+	return err
+}
+
+func parseSpanEventCondition(functions map[string]ottl.Factory[ottlspanevent.TransformContext], condition string) error {
+	parser, err := ottlspanevent.NewParser(functions, telemetrySettings)
+	if err != nil {
+		return err
+	}
+	_, err = parser.ParseCondition(condition)
+	return err
+}
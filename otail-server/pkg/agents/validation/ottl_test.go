@@ -0,0 +1,132 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileSpanCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		wantErr   bool
+	}{
+		{condition: `attributes["http.method"] == "GET"`},
+		{condition: `name == "checkout" and kind == SPAN_KIND_SERVER`},
+		{condition: `status.code == STATUS_CODE_ERROR or end_time_unix_nano - start_time_unix_nano > 1000000`},
+		{condition: `resource.attributes["service.name"] != nil`},
+		{condition: `IsMatch(name, "^GET /api/.*")`},
+		{condition: `IsRootSpan()`},
+		{condition: `not IsRootSpan() and Len(attributes) > 3`},
+		{condition: ``, wantErr: true},
+		{condition: `name ==`, wantErr: true},
+		{condition: `name == "unterminated`, wantErr: true},
+		{condition: `(name == "a"`, wantErr: true},
+		{condition: `NoSuchFunction(name)`, wantErr: true},
+		{condition: `no_such_path == "a"`, wantErr: true},
+		{condition: `kind == NO_SUCH_ENUM`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			err := compileSpanCondition(tt.condition)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileSpanCondition(%q) = %v, wantErr %v", tt.condition, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileSpanEventCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		wantErr   bool
+	}{
+		{condition: `name == "exception"`},
+		{condition: `attributes["exception.type"] == "java.lang.NullPointerException"`},
+		{condition: `span.name == "checkout"`},
+		{condition: `IsMatch(name, "^exc")`},
+		// IsRootSpan is only added to the span context
+		{condition: `IsRootSpan()`, wantErr: true},
+		{condition: `kind == SPAN_KIND_SERVER`, wantErr: true},
+		{condition: `name = "exception"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			err := compileSpanEventCondition(tt.condition)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileSpanEventCondition(%q) = %v, wantErr %v", tt.condition, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateOTTLConditionPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        map[string]interface{}
+		wantFields []string
+	}{
+		{
+			name: "valid",
+			cfg: map[string]interface{}{
+				"error_mode": "ignore",
+				"span":       []interface{}{`name == "a"`},
+				"spanevent":  []interface{}{`name == "b"`},
+			},
+		},
+		{
+			name: "error mode is not case sensitive",
+			cfg: map[string]interface{}{
+				"error_mode": "PROPAGATE",
+				"span":       []interface{}{`name == "a"`},
+			},
+		},
+		{
+			name: "invalid error mode",
+			cfg: map[string]interface{}{
+				"error_mode": "fail",
+				"span":       []interface{}{`name == "a"`},
+			},
+			wantFields: []string{"p.error_mode"},
+		},
+		{
+			name:       "no conditions",
+			cfg:        map[string]interface{}{},
+			wantFields: []string{"p"},
+		},
+		{
+			name: "every invalid condition is reported",
+			cfg: map[string]interface{}{
+				"span":      []interface{}{`name == "a"`, `name ==`},
+				"spanevent": []interface{}{`IsMatch(name)`},
+			},
+			wantFields: []string{"p.span[1]", "p.spanevent[0]"},
+		},
+		{
+			name: "functions that are not linked are left to ValidateFunctions",
+			cfg: map[string]interface{}{
+				"span": []interface{}{`AddedLater(name)`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs errorList
+			validateOTTLConditionPolicy(&errs, "p", tt.cfg)
+			err := errs.err()
+
+			var fields []string
+			var validationErr *Error
+			if errors.As(err, &validationErr) {
+				for _, fieldErr := range validationErr.Errors {
+					fields = append(fields, fieldErr.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %q, want %q (%v)", fields, tt.wantFields, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+// Policy types supported by the tail_sampling processor
+const (
+	PolicyAlwaysSample    = "always_sample"
+	PolicyAnd             = "and"
+	PolicyBooleanAttr     = "boolean_attribute"
+	PolicyComposite       = "composite"
+	PolicyDrop            = "drop"
+	PolicyLatency         = "latency"
+	PolicyNumericAttr     = "numeric_attribute"
+	PolicyOTTLCondition   = "ottl_condition"
+	PolicyProbabilistic   = "probabilistic"
+	PolicyRateLimiting    = "rate_limiting"
+	PolicySpanCount       = "span_count"
+	PolicyStatusCode      = "status_code"
+	PolicyStringAttribute = "string_attribute"
+	PolicyTraceState      = "trace_state"
+)
+
+// policyValidator returns the function that validates the settings of a
+// policy, found under the key named after its type. Types without settings
+// return a nil function.
+func policyValidator(typ string) (validate func(errs *errorList, field string, cfg map[string]interface{}), known bool) {
+	switch typ {
+	case PolicyAlwaysSample:
+		return nil, true
+	case PolicyAnd:
+		return validateAndPolicy, true
+	case PolicyBooleanAttr:
+		return validateBooleanAttributePolicy, true
+	case PolicyComposite:
+		return validateCompositePolicy, true
+	case PolicyDrop:
+		return validateDropPolicy, true
+	case PolicyLatency:
+		return validateLatencyPolicy, true
+	case PolicyNumericAttr:
+		return validateNumericAttributePolicy, true
+	case PolicyOTTLCondition:
+		return validateOTTLConditionPolicy, true
+	case PolicyProbabilistic:
+		return validateProbabilisticPolicy, true
+	case PolicyRateLimiting:
+		return validateRateLimitingPolicy, true
+	case PolicySpanCount:
+		return validateSpanCountPolicy, true
+	case PolicyStatusCode:
+		return validateStatusCodePolicy, true
+	case PolicyStringAttribute:
+		return validateStringAttributePolicy, true
+	case PolicyTraceState:
+		return validateTraceStatePolicy, true
+	default:
+		return nil, false
+	}
+}
+
+// Policy types that hold sub-policies and cannot themselves be sub-policies
+var compoundPolicies = map[string]bool{
+	PolicyAnd:       true,
+	PolicyComposite: true,
+	PolicyDrop:      true,
+}
+
+var statusCodes = map[string]bool{
+	"OK":    true,
+	"ERROR": true,
+	"UNSET": true,
+}
+
+// ValidateTailSampling checks the config of a tail_sampling processor
+func ValidateTailSampling(config map[string]interface{}) error {
+	var errs errorList
+	validateTailSampling(&errs, "tail_sampling", config)
+	return errs.err()
+}
+
+func validateTailSampling(errs *errorList, field string, value interface{}) {
+	cfg, ok := asMap(value)
+	if !ok {
+		errs.add(field, "must be a map")
+		return
+	}
+
+	if v, ok := cfg["decision_wait"]; ok {
+		s, isString := v.(string)
+		if d, err := time.ParseDuration(s); !isString || err != nil || d <= 0 {
+			errs.add(field+".decision_wait", "must be a positive duration such as 10s")
+		}
+	}
+	for _, key := range []string{"num_traces", "expected_new_traces_per_sec"} {
+		if v, ok := cfg[key]; ok {
+			if n, isNumber := asNumber(v); !isNumber || n < 0 {
+				errs.add(field+"."+key, "must be a non-negative number")
+			}
+		}
+	}
+
+	policies, ok := cfg["policies"].([]interface{})
+	if !ok || len(policies) == 0 {
+		errs.add(field+".policies", "at least one policy is required")
+		return
+	}
+	validatePolicies(errs, field+".policies", policies, "")
+}
+
+// validatePolicies validates a list of policies and checks that their names
+// are unique. parent is the type of the policy the list belongs to, empty at
+// the top level. Compound policies are only allowed at the top level, except
+// for and policies under a composite policy, whose sub-policies the collector
+// decodes with the settings of an and policy.
+func validatePolicies(errs *errorList, field string, policies []interface{}, parent string) {
+	names := make(map[string]bool)
+	for i, value := range policies {
+		policyField := fmt.Sprintf("%s[%d]", field, i)
+		policy, ok := asMap(value)
+		if !ok || value == nil {
+			errs.add(policyField, "must be a map")
+			continue
+		}
+
+		name, _ := policy["name"].(string)
+		if name == "" {
+			errs.add(policyField+".name", "is required")
+		} else if names[name] {
+			errs.add(policyField+".name", "duplicate policy name %q", name)
+		}
+		names[name] = true
+
+		typ, _ := policy["type"].(string)
+		validate, known := policyValidator(typ)
+		switch {
+		case typ == "":
+			errs.add(policyField+".type", "is required")
+			continue
+		case !known:
+			errs.add(policyField+".type", "unknown policy type %q", typ)
+			continue
+		case compoundPolicies[typ] && parent != "" && (parent != PolicyComposite || typ != PolicyAnd):
+			errs.add(policyField+".type", "policy type %q cannot be used as a sub-policy", typ)
+			continue
+		}
+
+		if validate == nil {
+			continue
+		}
+		settings, ok := asMap(policy[typ])
+		if !ok {
+			errs.add(policyField+"."+typ, "must be a map")
+			continue
+		}
+		validate(errs, policyField+"."+typ, settings)
+	}
+}
+
+func validateAndPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	validateSubPolicies(errs, field+".and_sub_policy", cfg["and_sub_policy"], PolicyAnd)
+}
+
+func validateDropPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	validateSubPolicies(errs, field+".drop_sub_policy", cfg["drop_sub_policy"], PolicyDrop)
+}
+
+func validateCompositePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	if n, ok := asNumber(cfg["max_total_spans_per_second"]); !ok || n <= 0 {
+		errs.add(field+".max_total_spans_per_second", "must be a positive number")
+	}
+
+	subPolicies, _ := cfg["composite_sub_policy"].([]interface{})
+	validateSubPolicies(errs, field+".composite_sub_policy", cfg["composite_sub_policy"], PolicyComposite)
+
+	names := make(map[string]bool)
+	for _, value := range subPolicies {
+		if policy, ok := asMap(value); ok {
+			if name, ok := policy["name"].(string); ok {
+				names[name] = true
+			}
+		}
+	}
+
+	if order, ok := cfg["policy_order"]; ok {
+		items, isList := order.([]interface{})
+		if !isList {
+			errs.add(field+".policy_order", "must be a list of sub-policy names")
+		}
+		for i, item := range items {
+			if name, _ := item.(string); !names[name] {
+				errs.add(fmt.Sprintf("%s.policy_order[%d]", field, i), "references undefined sub-policy %v", item)
+			}
+		}
+	}
+
+	if allocation, ok := cfg["rate_allocation"]; ok {
+		items, isList := allocation.([]interface{})
+		if !isList {
+			errs.add(field+".rate_allocation", "must be a list")
+		}
+		total := 0.0
+		for i, item := range items {
+			itemField := fmt.Sprintf("%s.rate_allocation[%d]", field, i)
+			entry, _ := asMap(item)
+			if name, _ := entry["policy"].(string); !names[name] {
+				errs.add(itemField+".policy", "references undefined sub-policy %v", entry["policy"])
+			}
+			percent, ok := asNumber(entry["percent"])
+			if !ok || percent <= 0 || percent > 100 {
+				errs.add(itemField+".percent", "must be between 0 and 100")
+				continue
+			}
+			total += percent
+		}
+		if total > 100 {
+			errs.add(field+".rate_allocation", "percentages add up to %g, must not exceed 100", total)
+		}
+	}
+}
+
+func validateSubPolicies(errs *errorList, field string, value interface{}, parent string) {
+	subPolicies, ok := value.([]interface{})
+	if !ok || len(subPolicies) == 0 {
+		errs.add(field, "at least one sub-policy is required")
+		return
+	}
+	validatePolicies(errs, field, subPolicies, parent)
+}
+
+func validateBooleanAttributePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	requireString(errs, field, cfg, "key")
+	if _, ok := cfg["value"].(bool); !ok {
+		errs.add(field+".value", "must be true or false")
+	}
+}
+
+func validateLatencyPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	threshold, hasThreshold := asNumber(cfg["threshold_ms"])
+	upper, hasUpper := asNumber(cfg["upper_threshold_ms"])
+	if cfg["threshold_ms"] != nil && (!hasThreshold || threshold < 0) {
+		errs.add(field+".threshold_ms", "must be a non-negative number")
+	}
+	if cfg["upper_threshold_ms"] != nil && (!hasUpper || upper < 0) {
+		errs.add(field+".upper_threshold_ms", "must be a non-negative number")
+	}
+	if threshold <= 0 && upper <= 0 {
+		errs.add(field+".threshold_ms", "threshold_ms or upper_threshold_ms must be set")
+	}
+	if upper > 0 && upper < threshold {
+		errs.add(field+".upper_threshold_ms", "must be greater than threshold_ms")
+	}
+}
+
+func validateNumericAttributePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	requireString(errs, field, cfg, "key")
+	// The collector decodes the bounds into int64
+	minValue, hasMin := asInteger(cfg["min_value"])
+	maxValue, hasMax := asInteger(cfg["max_value"])
+	if cfg["min_value"] != nil && !hasMin {
+		errs.add(field+".min_value", "must be an integer")
+	}
+	if cfg["max_value"] != nil && !hasMax {
+		errs.add(field+".max_value", "must be an integer")
+	}
+	if !hasMin && !hasMax {
+		errs.add(field, "min_value or max_value must be set")
+	}
+	if hasMin && hasMax && minValue > maxValue {
+		errs.add(field+".max_value", "must be greater than or equal to min_value")
+	}
+}
+
+func validateOTTLConditionPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	if v, ok := cfg["error_mode"]; ok {
+		if mode, _ := v.(string); !validErrorMode(mode) {
+			errs.add(field+".error_mode", "must be one of ignore, silent or propagate")
+		}
+	}
+
+	spanConditions := stringList(errs, field+".span", cfg["span"])
+	spanEventConditions := stringList(errs, field+".spanevent", cfg["spanevent"])
+	if len(spanConditions) == 0 && len(spanEventConditions) == 0 {
+		errs.add(field, "at least one span or spanevent condition is required")
+		return
+	}
+
+	// Calls of functions the linked parser lacks are left to ValidateFunctions,
+	// agents newer than the linked collector-contrib version may have them
+	for i, condition := range spanConditions {
+		if err := compileSpanCondition(condition); err != nil {
+			if _, ok := undefinedFunction(err); !ok {
+				errs.add(fmt.Sprintf("%s.span[%d]", field, i), "%v", err)
+			}
+		}
+	}
+	for i, condition := range spanEventConditions {
+		if err := compileSpanEventCondition(condition); err != nil {
+			if _, ok := undefinedFunction(err); !ok {
+				errs.add(fmt.Sprintf("%s.spanevent[%d]", field, i), "%v", err)
+			}
+		}
+	}
+}
+
+func validateProbabilisticPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	if n, ok := asNumber(cfg["sampling_percentage"]); !ok || n <= 0 || n > 100 {
+		errs.add(field+".sampling_percentage", "must be greater than 0 and at most 100")
+	}
+	if v, ok := cfg["hash_salt"]; ok {
+		if _, isString := v.(string); !isString {
+			errs.add(field+".hash_salt", "must be a string")
+		}
+	}
+}
+
+func validateRateLimitingPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	if n, ok := asNumber(cfg["spans_per_second"]); !ok || n <= 0 {
+		errs.add(field+".spans_per_second", "must be a positive number")
+	}
+}
+
+func validateSpanCountPolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	minSpans, ok := asNumber(cfg["min_spans"])
+	if !ok || minSpans < 0 {
+		errs.add(field+".min_spans", "must be a non-negative number")
+	}
+	if v, ok := cfg["max_spans"]; ok {
+		maxSpans, isNumber := asNumber(v)
+		if !isNumber || maxSpans < 0 {
+			errs.add(field+".max_spans", "must be a non-negative number")
+		} else if maxSpans > 0 && maxSpans < minSpans {
+			errs.add(field+".max_spans", "must be greater than or equal to min_spans")
+		}
+	}
+}
+
+func validateStatusCodePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	codes := stringList(errs, field+".status_codes", cfg["status_codes"])
+	if len(codes) == 0 {
+		errs.add(field+".status_codes", "at least one status code is required")
+	}
+	for i, code := range codes {
+		if !statusCodes[code] {
+			errs.add(fmt.Sprintf("%s.status_codes[%d]", field, i), "unknown status code %q, must be OK, ERROR or UNSET", code)
+		}
+	}
+}
+
+func validateStringAttributePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	requireString(errs, field, cfg, "key")
+	values := stringList(errs, field+".values", cfg["values"])
+	if len(values) == 0 {
+		errs.add(field+".values", "at least one value is required")
+	}
+	if regexMatching, _ := cfg["enabled_regex_matching"].(bool); regexMatching {
+		for i, value := range values {
+			if _, err := regexp.Compile(value); err != nil {
+				errs.add(fmt.Sprintf("%s.values[%d]", field, i), "invalid regular expression: %v", err)
+			}
+		}
+	}
+	if v, ok := cfg["cache_max_size"]; ok {
+		if n, isNumber := asNumber(v); !isNumber || n < 0 {
+			errs.add(field+".cache_max_size", "must be a non-negative number")
+		}
+	}
+}
+
+func validateTraceStatePolicy(errs *errorList, field string, cfg map[string]interface{}) {
+	requireString(errs, field, cfg, "key")
+	if values := stringList(errs, field+".values", cfg["values"]); len(values) == 0 {
+		errs.add(field+".values", "at least one value is required")
+	}
+}
+
+func requireString(errs *errorList, field string, cfg map[string]interface{}, key string) {
+	if s, _ := cfg[key].(string); s == "" {
+		errs.add(field+"."+key, "is required")
+	}
+}
+
+// stringList returns the strings of a list value, reporting items that are
+// not strings
+func stringList(errs *errorList, field string, value interface{}) []string {
+	if value == nil {
+		return nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		errs.add(field, "must be a list of strings")
+		return nil
+	}
+	var result []string
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "must be a string")
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// asNumber returns the value as a float64, accepting both the float64 values
+// decoded from JSON and the integers decoded from YAML
+func asNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// asInteger returns the value as an int64 if it is a whole number in range
+func asInteger(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	n, ok := asNumber(value)
+	if !ok || n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(n), true
+}
@@ -0,0 +1,107 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateTailSamplingPolicies(t *testing.T) {
+	alwaysSample := map[string]interface{}{"name": "all", "type": "always_sample"}
+	and := func(name string, subPolicies ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "type": "and",
+			"and": map[string]interface{}{"and_sub_policy": subPolicies}}
+	}
+	drop := func(name string, subPolicies ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "type": "drop",
+			"drop": map[string]interface{}{"drop_sub_policy": subPolicies}}
+	}
+	composite := func(name string, subPolicies ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "type": "composite",
+			"composite": map[string]interface{}{"max_total_spans_per_second": 100, "composite_sub_policy": subPolicies}}
+	}
+	numeric := func(min, max interface{}) map[string]interface{} {
+		settings := map[string]interface{}{"key": "http.status_code"}
+		if min != nil {
+			settings["min_value"] = min
+		}
+		if max != nil {
+			settings["max_value"] = max
+		}
+		return map[string]interface{}{"name": "numeric", "type": "numeric_attribute", "numeric_attribute": settings}
+	}
+
+	tests := []struct {
+		name       string
+		policy     map[string]interface{}
+		wantFields []string
+	}{
+		{
+			name:   "and under composite",
+			policy: composite("c", and("a", alwaysSample)),
+		},
+		{
+			name:       "compound policies under and under composite",
+			policy:     composite("c", and("a", drop("d", alwaysSample))),
+			wantFields: []string{"p[0].composite.composite_sub_policy[0].and.and_sub_policy[0].type"},
+		},
+		{
+			name:       "composite under composite",
+			policy:     composite("c", composite("inner", alwaysSample)),
+			wantFields: []string{"p[0].composite.composite_sub_policy[0].type"},
+		},
+		{
+			name:       "drop under composite",
+			policy:     composite("c", drop("d", alwaysSample)),
+			wantFields: []string{"p[0].composite.composite_sub_policy[0].type"},
+		},
+		{
+			name:       "and under and",
+			policy:     and("outer", and("inner", alwaysSample)),
+			wantFields: []string{"p[0].and.and_sub_policy[0].type"},
+		},
+		{
+			name:       "and under drop",
+			policy:     drop("d", and("a", alwaysSample)),
+			wantFields: []string{"p[0].drop.drop_sub_policy[0].type"},
+		},
+		{
+			name:   "integer numeric bounds",
+			policy: numeric(400, int64(599)),
+		},
+		{
+			name:   "whole float numeric bound",
+			policy: numeric(float64(500), nil),
+		},
+		{
+			name:       "fractional numeric bounds",
+			policy:     numeric(1.5, 2.5),
+			wantFields: []string{"p[0].numeric_attribute.min_value", "p[0].numeric_attribute.max_value", "p[0].numeric_attribute"},
+		},
+		{
+			name:       "numeric bound out of range",
+			policy:     numeric(nil, 1e19),
+			wantFields: []string{"p[0].numeric_attribute.max_value", "p[0].numeric_attribute"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs errorList
+			validatePolicies(&errs, "p", []interface{}{tt.policy}, "")
+			err := errs.err()
+
+			var fields []string
+			var validationErr *Error
+			if errors.As(err, &validationErr) {
+				for _, fieldErr := range validationErr.Errors {
+					fields = append(fields, fieldErr.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %q, want %q (%v)", fields, tt.wantFields, err)
+			}
+		})
+	}
+}
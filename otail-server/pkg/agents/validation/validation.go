@@ -0,0 +1,299 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FieldError describes a single problem in a config and where it was found
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is returned when a config is rejected. It carries every problem found
+// rather than only the first one.
+type Error struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+// errorList collects field errors while walking a config
+type errorList []FieldError
+
+func (l *errorList) add(field, format string, args ...interface{}) {
+	*l = append(*l, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l errorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return &Error{Errors: l}
+}
+
+// Component kinds of a collector config
+const (
+	kindReceivers  = "receivers"
+	kindProcessors = "processors"
+	kindExporters  = "exporters"
+	kindConnectors = "connectors"
+	kindExtensions = "extensions"
+)
+
+var pipelineTypes = map[string]bool{
+	"traces":   true,
+	"metrics":  true,
+	"logs":     true,
+	"profiles": true,
+}
+
+// ValidateConfig checks that the config is a valid OpenTelemetry Collector
+// config: component sections are maps, pipelines only reference defined
+// components and the tail_sampling processors have valid policies. The config
+// must be complete, i.e. already merged with any shared config.
+func ValidateConfig(config map[string]interface{}) error {
+	return validateConfig(config, true)
+}
+
+// ValidateSharedConfig checks a config that is only a part of the config of
+// its agents, e.g. the config of a group without agents, like ValidateConfig
+// but without the service, whose pipelines may reference components defined
+// elsewhere.
+func ValidateSharedConfig(config map[string]interface{}) error {
+	return validateConfig(config, false)
+}
+
+func validateConfig(config map[string]interface{}, complete bool) error {
+	var errs errorList
+
+	components := make(map[string]map[string]interface{})
+	for _, kind := range []string{kindReceivers, kindProcessors, kindExporters, kindConnectors, kindExtensions} {
+		section, ok := asMap(config[kind])
+		if !ok && config[kind] != nil {
+			errs.add(kind, "must be a map of component IDs to their config")
+			continue
+		}
+		for _, id := range sortedKeys(section) {
+			if err := validateComponentID(id); err != nil {
+				errs.add(kind+"."+id, "%v", err)
+			}
+		}
+		components[kind] = section
+	}
+
+	processors := components[kindProcessors]
+	for _, id := range sortedKeys(processors) {
+		if componentType(id) == "tail_sampling" {
+			validateTailSampling(&errs, kindProcessors+"."+id, processors[id])
+		}
+	}
+
+	if complete {
+		validateService(&errs, config["service"], components)
+	}
+
+	return errs.err()
+}
+
+func validateService(errs *errorList, value interface{}, components map[string]map[string]interface{}) {
+	if value == nil {
+		errs.add("service", "is required")
+		return
+	}
+	service, ok := asMap(value)
+	if !ok {
+		errs.add("service", "must be a map")
+		return
+	}
+
+	if extensions, ok := service["extensions"]; ok {
+		validateReferences(errs, "service.extensions", extensions, components[kindExtensions])
+	}
+
+	pipelines, ok := asMap(service["pipelines"])
+	if !ok || len(pipelines) == 0 {
+		errs.add("service.pipelines", "at least one pipeline is required")
+		return
+	}
+
+	for _, id := range sortedKeys(pipelines) {
+		field := "service.pipelines." + id
+		if !pipelineTypes[componentType(id)] {
+			errs.add(field, "unknown pipeline type %q", componentType(id))
+		}
+
+		pipeline, ok := asMap(pipelines[id])
+		if !ok {
+			errs.add(field, "must be a map")
+			continue
+		}
+
+		// Connectors act as exporters of one pipeline and receivers of another
+		receivers := union(components[kindReceivers], components[kindConnectors])
+		exporters := union(components[kindExporters], components[kindConnectors])
+
+		if n := validateReferences(errs, field+".receivers", pipeline["receivers"], receivers); n == 0 {
+			errs.add(field+".receivers", "at least one receiver is required")
+		}
+		validateReferences(errs, field+".processors", pipeline["processors"], components[kindProcessors])
+		if n := validateReferences(errs, field+".exporters", pipeline["exporters"], exporters); n == 0 {
+			errs.add(field+".exporters", "at least one exporter is required")
+		}
+	}
+}
+
+// validateReferences checks that every component ID in the list is defined and
+// returns the number of IDs in the list
+func validateReferences(errs *errorList, field string, value interface{}, defined map[string]interface{}) int {
+	if value == nil {
+		return 0
+	}
+	ids, ok := value.([]interface{})
+	if !ok {
+		errs.add(field, "must be a list of component IDs")
+		return 0
+	}
+
+	seen := make(map[string]bool)
+	for i, v := range ids {
+		id, ok := v.(string)
+		if !ok {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "must be a component ID")
+			continue
+		}
+		if _, ok := defined[id]; !ok {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "references undefined component %q", id)
+		}
+		if seen[id] {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "component %q is referenced more than once", id)
+		}
+		seen[id] = true
+	}
+	return len(ids)
+}
+
+// validateComponentID checks the type[/name] format of a component ID
+func validateComponentID(id string) error {
+	typ, name, hasName := strings.Cut(id, "/")
+	if typ == "" {
+		return fmt.Errorf("component type must not be empty")
+	}
+	if hasName && name == "" {
+		return fmt.Errorf("component name must not be empty when a '/' is used")
+	}
+	return nil
+}
+
+func componentType(id string) string {
+	typ, _, _ := strings.Cut(id, "/")
+	return typ
+}
+
+// MergeConfigs deep merges the configs in order, the way the supervisor merges
+// the files of a remote config: maps are merged and any other value of a later
+// config replaces the earlier one.
+func MergeConfigs(configs ...map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, config := range configs {
+		mergeInto(result, config)
+	}
+	return result
+}
+
+func mergeInto(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeInto(dstMap, srcMap)
+			continue
+		}
+		if srcIsMap {
+			copied := make(map[string]interface{})
+			mergeInto(copied, srcMap)
+			value = copied
+		}
+		dst[key] = value
+	}
+}
+
+// ParseConfig parses a YAML or JSON config into string-keyed maps
+func ParseConfig(data []byte) (map[string]interface{}, error) {
+	var config map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	result, _ := normalize(config).(map[string]interface{})
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	return result, nil
+}
+
+// normalize converts the map[interface{}]interface{} values produced by the
+// YAML decoder to map[string]interface{}
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalize(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalize(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalize(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// asMap returns the value as a map. A nil value is an empty map, as a
+// component without settings is written as `otlp:` in YAML.
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	if value == nil {
+		return map[string]interface{}{}, true
+	}
+	m, ok := normalize(value).(map[string]interface{})
+	return m, ok
+}
+
+func union(a, b map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range a {
+		result[k] = v
+	}
+	for k, v := range b {
+		result[k] = v
+	}
+	return result
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,12 +1,32 @@
 import { Trace, Decision } from "@/types/trace";
 import { BaseAsyncPolicyEvaluator } from "./BaseEvaluator";
 
+export interface OttlMatch {
+  resource: number;
+  scope: number;
+  span: number;
+  event: number;
+  spanId?: string;
+  spanName?: string;
+  eventName?: string;
+  matched: boolean;
+  values?: Record<string, unknown>;
+}
+
+export interface OttlConditionExplanation {
+  condition: string;
+  context: 'span' | 'spanevent';
+  matches: OttlMatch[];
+  errors?: (OttlMatch & { error: string; swallowed: boolean })[];
+}
+
 declare global {
   interface Window {
-    evaluateOTTL: (traceJSON: string, spanConditions: string, spanEventConditions: string, errorMode: string) => {
+    evaluateOTTL: (traceJSON: string, spanConditions: string, spanEventConditions: string, errorMode: string, explain?: boolean) => {
       error: boolean;
       decision: string;
       message?: string;
+      conditions?: OttlConditionExplanation[];
     };
     Go: any;
   }
@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// BatchStats aggregates the decisions of all the traces of a batch
+type BatchStats struct {
+	Traces        int     `json:"traces"`
+	SampledTraces int     `json:"sampledTraces"`
+	Spans         int64   `json:"spans"`
+	SampledSpans  int64   `json:"sampledSpans"`
+	SampleRate    float64 `json:"sampleRate"`
+}
+
+// BatchResult is the decision for each trace of a batch, by trace ID
+type BatchResult struct {
+	Decisions map[string]SamplingDecision `json:"decisions"`
+	// Errors are the evaluation errors, by trace ID
+	Errors map[string]string `json:"errors,omitempty"`
+	Stats  BatchStats        `json:"stats"`
+}
+
+func newBatchResult() *BatchResult {
+	return &BatchResult{
+		Decisions: make(map[string]SamplingDecision),
+		Errors:    make(map[string]string),
+	}
+}
+
+func (r *BatchResult) add(trace *TraceData, decision SamplingDecision, err error) {
+	traceID := trace.TraceID.String()
+	r.Decisions[traceID] = decision
+	if err != nil {
+		r.Errors[traceID] = err.Error()
+	}
+
+	r.Stats.Traces++
+	r.Stats.Spans += trace.SpanCount
+	if decision == Sampled {
+		r.Stats.SampledTraces++
+		r.Stats.SampledSpans += trace.SpanCount
+	}
+	r.Stats.SampleRate = float64(r.Stats.SampledTraces) / float64(r.Stats.Traces)
+}
+
+// EvaluateBatch groups the spans by trace ID and evaluates each trace on its
+// own
+func (e *OTTLEvaluator) EvaluateBatch(ctx context.Context, traces ptrace.Traces) *BatchResult {
+	result := newBatchResult()
+	for _, trace := range GroupByTrace(traces) {
+		decision, err := e.Evaluate(ctx, trace.Batches)
+		result.add(trace, decision, err)
+	}
+	return result
+}
+
+// EvaluateBatch groups the spans by trace ID and evaluates each trace, in the
+// order the traces started, so that the rate limiting policies see the traces
+// in the order the processor would
+func (s *TailSampler) EvaluateBatch(ctx context.Context, traces ptrace.Traces) *BatchResult {
+	result := newBatchResult()
+	for _, trace := range GroupByTrace(traces) {
+		result.add(trace, s.EvaluateTrace(ctx, trace).Decision, nil)
+	}
+	return result
+}
+
+// GroupByTrace splits the spans into traces like the tail_sampling processor,
+// keeping the resource and scope of each span. The traces are sorted by their
+// start time.
+func GroupByTrace(td ptrace.Traces) []*TraceData {
+	byID := make(map[pcommon.TraceID]ptrace.Traces)
+	var ids []pcommon.TraceID
+
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			// Spans of one trace within a scope are appended to the same batch
+			scopeSpans := make(map[pcommon.TraceID]ptrace.SpanSlice)
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+
+				batches, ok := byID[span.TraceID()]
+				if !ok {
+					batches = ptrace.NewTraces()
+					byID[span.TraceID()] = batches
+					ids = append(ids, span.TraceID())
+				}
+
+				spans, ok := scopeSpans[span.TraceID()]
+				if !ok {
+					batch := batches.ResourceSpans().AppendEmpty()
+					rs.Resource().CopyTo(batch.Resource())
+					batch.SetSchemaUrl(rs.SchemaUrl())
+					scope := batch.ScopeSpans().AppendEmpty()
+					ss.Scope().CopyTo(scope.Scope())
+					scope.SetSchemaUrl(ss.SchemaUrl())
+					spans = scope.Spans()
+					scopeSpans[span.TraceID()] = spans
+				}
+				span.CopyTo(spans.AppendEmpty())
+			}
+		}
+	}
+
+	traces := make([]*TraceData, 0, len(ids))
+	for _, id := range ids {
+		traces = append(traces, NewTraceData(byID[id]))
+	}
+	sort.SliceStable(traces, func(i, j int) bool {
+		return traces[i].StartTime < traces[j].StartTime
+	})
+	return traces
+}
@@ -0,0 +1,103 @@
+package evaluator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// batchTraces returns two traces whose spans are interleaved over two
+// services. Trace 2 starts first and has an error in the cart service.
+func batchTraces() ptrace.Traces {
+	td := ptrace.NewTraces()
+	for _, service := range []string{"checkout", "cart"} {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", service)
+		spans := rs.ScopeSpans().AppendEmpty().Spans()
+		for i, traceID := range []pcommon.TraceID{{1}, {2}} {
+			span := spans.AppendEmpty()
+			span.SetTraceID(traceID)
+			span.SetSpanID(pcommon.SpanID{byte(len(service)), byte(i)})
+			span.SetName(service)
+			span.SetStartTimestamp(pcommon.Timestamp(10 - int(traceID[0])))
+			if service == "cart" && traceID[0] == 2 {
+				span.Status().SetCode(ptrace.StatusCodeError)
+			}
+		}
+	}
+	return td
+}
+
+func TestGroupByTrace(t *testing.T) {
+	traces := GroupByTrace(batchTraces())
+	if len(traces) != 2 {
+		t.Fatalf("GroupByTrace() returned %d traces, want 2", len(traces))
+	}
+	// Sorted by start time
+	if traces[0].TraceID != (pcommon.TraceID{2}) || traces[1].TraceID != (pcommon.TraceID{1}) {
+		t.Errorf("trace IDs = %v, %v, want trace 2 first", traces[0].TraceID, traces[1].TraceID)
+	}
+	for _, trace := range traces {
+		if trace.SpanCount != 2 {
+			t.Errorf("trace %v has %d spans, want 2", trace.TraceID, trace.SpanCount)
+		}
+		// Each span keeps its resource
+		var services []string
+		for i := 0; i < trace.Batches.ResourceSpans().Len(); i++ {
+			rs := trace.Batches.ResourceSpans().At(i)
+			service, _ := rs.Resource().Attributes().Get("service.name")
+			services = append(services, service.Str())
+			if name := rs.ScopeSpans().At(0).Spans().At(0).Name(); name != service.Str() {
+				t.Errorf("span %q is in the resource of %s", name, service.Str())
+			}
+		}
+		if !reflect.DeepEqual(services, []string{"checkout", "cart"}) {
+			t.Errorf("services of trace %v = %v, want checkout and cart", trace.TraceID, services)
+		}
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	trace1 := pcommon.TraceID{1}.String()
+	trace2 := pcommon.TraceID{2}.String()
+	want := &BatchResult{
+		Decisions: map[string]SamplingDecision{trace1: NotSampled, trace2: Sampled},
+		Errors:    map[string]string{},
+		Stats: BatchStats{
+			Traces:        2,
+			SampledTraces: 1,
+			Spans:         4,
+			SampledSpans:  2,
+			SampleRate:    0.5,
+		},
+	}
+
+	e, err := NewOTTLConditionEvaluator([]string{`status.code == STATUS_CODE_ERROR`}, nil, ottl.PropagateError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.EvaluateBatch(context.Background(), batchTraces()); !reflect.DeepEqual(got, want) {
+		t.Errorf("OTTLEvaluator.EvaluateBatch() = %+v, want %+v", got, want)
+	}
+
+	policies, err := ParsePolicies([]byte(`[{"name": "errors", "type": "status_code", "status_code": {"status_codes": ["ERROR"]}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler, err := NewTailSampler(policies, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sampler.EvaluateBatch(context.Background(), batchTraces()); !reflect.DeepEqual(got, want) {
+		t.Errorf("TailSampler.EvaluateBatch() = %+v, want %+v", got, want)
+	}
+
+	if got := sampler.EvaluateBatch(context.Background(), ptrace.NewTraces()); got.Stats != (BatchStats{}) || len(got.Decisions) != 0 {
+		t.Errorf("EvaluateBatch() of no traces = %+v, want no decisions", got)
+	}
+}
@@ -0,0 +1,146 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// PolicyType is the type of a tail_sampling policy
+type PolicyType string
+
+const (
+	AlwaysSample     PolicyType = "always_sample"
+	Latency          PolicyType = "latency"
+	NumericAttribute PolicyType = "numeric_attribute"
+	Probabilistic    PolicyType = "probabilistic"
+	StatusCode       PolicyType = "status_code"
+	StringAttribute  PolicyType = "string_attribute"
+	RateLimiting     PolicyType = "rate_limiting"
+	SpanCount        PolicyType = "span_count"
+	TraceState       PolicyType = "trace_state"
+	BooleanAttribute PolicyType = "boolean_attribute"
+	OTTLCondition    PolicyType = "ottl_condition"
+	And              PolicyType = "and"
+	Composite        PolicyType = "composite"
+	Drop             PolicyType = "drop"
+)
+
+// PolicyConfig is a policy of the tail_sampling processor, with the same
+// keys as the collector config
+type PolicyConfig struct {
+	Name string     `json:"name"`
+	Type PolicyType `json:"type"`
+
+	LatencyCfg          LatencyConfig          `json:"latency"`
+	NumericAttributeCfg NumericAttributeConfig `json:"numeric_attribute"`
+	ProbabilisticCfg    ProbabilisticConfig    `json:"probabilistic"`
+	StatusCodeCfg       StatusCodeConfig       `json:"status_code"`
+	StringAttributeCfg  StringAttributeConfig  `json:"string_attribute"`
+	RateLimitingCfg     RateLimitingConfig     `json:"rate_limiting"`
+	SpanCountCfg        SpanCountConfig        `json:"span_count"`
+	TraceStateCfg       TraceStateConfig       `json:"trace_state"`
+	BooleanAttributeCfg BooleanAttributeConfig `json:"boolean_attribute"`
+	OTTLConditionCfg    OTTLConditionConfig    `json:"ottl_condition"`
+	AndCfg              AndConfig              `json:"and"`
+	CompositeCfg        CompositeConfig        `json:"composite"`
+	DropCfg             DropConfig             `json:"drop"`
+}
+
+type LatencyConfig struct {
+	ThresholdMs      int64 `json:"threshold_ms"`
+	UpperThresholdMs int64 `json:"upper_threshold_ms"`
+}
+
+// NumericAttributeConfig matches values between MinValue and MaxValue,
+// inclusive. A bound that is not set is unbounded.
+type NumericAttributeConfig struct {
+	Key         string `json:"key"`
+	MinValue    *int64 `json:"min_value"`
+	MaxValue    *int64 `json:"max_value"`
+	InvertMatch bool   `json:"invert_match"`
+}
+
+type ProbabilisticConfig struct {
+	HashSalt           string  `json:"hash_salt"`
+	SamplingPercentage float64 `json:"sampling_percentage"`
+}
+
+type StatusCodeConfig struct {
+	StatusCodes []string `json:"status_codes"`
+}
+
+type StringAttributeConfig struct {
+	Key                  string   `json:"key"`
+	Values               []string `json:"values"`
+	EnabledRegexMatching bool     `json:"enabled_regex_matching"`
+	CacheMaxSize         int      `json:"cache_max_size"`
+	InvertMatch          bool     `json:"invert_match"`
+}
+
+type RateLimitingConfig struct {
+	SpansPerSecond int64 `json:"spans_per_second"`
+}
+
+type SpanCountConfig struct {
+	MinSpans int32 `json:"min_spans"`
+	MaxSpans int32 `json:"max_spans"`
+}
+
+type TraceStateConfig struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+type BooleanAttributeConfig struct {
+	Key         string `json:"key"`
+	Value       bool   `json:"value"`
+	InvertMatch bool   `json:"invert_match"`
+}
+
+type OTTLConditionConfig struct {
+	ErrorMode           string   `json:"error_mode"`
+	SpanConditions      []string `json:"span"`
+	SpanEventConditions []string `json:"spanevent"`
+}
+
+type AndConfig struct {
+	SubPolicies []PolicyConfig `json:"and_sub_policy"`
+}
+
+type CompositeConfig struct {
+	MaxTotalSpansPerSecond int64            `json:"max_total_spans_per_second"`
+	PolicyOrder            []string         `json:"policy_order"`
+	SubPolicies            []PolicyConfig   `json:"composite_sub_policy"`
+	RateAllocation         []RateAllocation `json:"rate_allocation"`
+}
+
+type RateAllocation struct {
+	Policy  string `json:"policy"`
+	Percent int64  `json:"percent"`
+}
+
+type DropConfig struct {
+	SubPolicies []PolicyConfig `json:"drop_sub_policy"`
+}
+
+// ParsePolicies parses the policies from JSON, either the tail_sampling
+// processor block or just its list of policies
+func ParsePolicies(data []byte) ([]PolicyConfig, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var policies []PolicyConfig
+		if err := json.Unmarshal(trimmed, &policies); err != nil {
+			return nil, fmt.Errorf("invalid policies: %w", err)
+		}
+		return policies, nil
+	}
+
+	var block struct {
+		Policies []PolicyConfig `json:"policies"`
+	}
+	if err := json.Unmarshal(trimmed, &block); err != nil {
+		return nil, fmt.Errorf("invalid tail_sampling config: %w", err)
+	}
+	return block.Policies, nil
+}
@@ -19,6 +19,12 @@ type SamplingDecision string
 const (
 	Sampled    SamplingDecision = "Sampled"
 	NotSampled SamplingDecision = "NotSampled"
+	// InvertSampled, InvertNotSampled, Dropped and Error are only returned by
+	// tail sampling policies, see TailSampler
+	InvertSampled    SamplingDecision = "InvertSampled"
+	InvertNotSampled SamplingDecision = "InvertNotSampled"
+	Dropped          SamplingDecision = "Dropped"
+	Error            SamplingDecision = "Error"
 )
 
 // OTTLEvaluator handles the evaluation of OTTL expressions against trace data
@@ -26,6 +32,12 @@ type OTTLEvaluator struct {
 	spanBoolExpr      *ottl.ConditionSequence[ottlspan.TransformContext]
 	spanEventBoolExpr *ottl.ConditionSequence[ottlspanevent.TransformContext]
 	traces            ptrace.Traces
+
+	// The conditions are parsed again one by one when explaining
+	spanConditions      []string
+	spanEventConditions []string
+	errMode             ottl.ErrorMode
+	settings            component.TelemetrySettings
 }
 
 // NewOTTLEvaluator creates a new evaluator with the provided OTTL conditions
@@ -34,6 +46,24 @@ func NewOTTLEvaluator(
 	spanEventCondition string,
 	errMode ottl.ErrorMode,
 	logger *zap.Logger,
+) (*OTTLEvaluator, error) {
+	var spanConditions, spanEventConditions []string
+	if spanCondition != "" {
+		spanConditions = []string{spanCondition}
+	}
+	if spanEventCondition != "" {
+		spanEventConditions = []string{spanEventCondition}
+	}
+	return NewOTTLConditionEvaluator(spanConditions, spanEventConditions, errMode, logger)
+}
+
+// NewOTTLConditionEvaluator creates an evaluator that samples when any of the
+// span or span event conditions match, like the ottl_condition policy
+func NewOTTLConditionEvaluator(
+	spanConditions []string,
+	spanEventConditions []string,
+	errMode ottl.ErrorMode,
+	logger *zap.Logger,
 ) (*OTTLEvaluator, error) {
 	settings := component.TelemetrySettings{}
 	settings.Logger = logger
@@ -41,10 +71,10 @@ func NewOTTLEvaluator(
 	var spanBoolExpr *ottl.ConditionSequence[ottlspan.TransformContext]
 	var spanEventBoolExpr *ottl.ConditionSequence[ottlspanevent.TransformContext]
 
-	if spanCondition != "" {
+	if len(spanConditions) > 0 {
 		var err error
 		spanBoolExpr, err = filter.NewBoolExprForSpan(
-			[]string{spanCondition},
+			spanConditions,
 			filter.StandardSpanFuncs(),
 			errMode,
 			settings,
@@ -54,10 +84,10 @@ func NewOTTLEvaluator(
 		}
 	}
 
-	if spanEventCondition != "" {
+	if len(spanEventConditions) > 0 {
 		var err error
 		spanEventBoolExpr, err = filter.NewBoolExprForSpanEvent(
-			[]string{spanEventCondition},
+			spanEventConditions,
 			filter.StandardSpanEventFuncs(),
 			errMode,
 			settings,
@@ -68,8 +98,12 @@ func NewOTTLEvaluator(
 	}
 
 	return &OTTLEvaluator{
-		spanBoolExpr:      spanBoolExpr,
-		spanEventBoolExpr: spanEventBoolExpr,
+		spanBoolExpr:        spanBoolExpr,
+		spanEventBoolExpr:   spanEventBoolExpr,
+		spanConditions:      spanConditions,
+		spanEventConditions: spanEventConditions,
+		errMode:             errMode,
+		settings:            settings,
 	}, nil
 }
 
@@ -0,0 +1,527 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspan"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspanevent"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Match is a resource, span or span event that a policy or condition looked
+// at. The indexes locate it in the OTLP JSON; Scope and Span are -1 for a
+// resource and Event is -1 unless it is a span event.
+type Match struct {
+	Resource  int    `json:"resource"`
+	Scope     int    `json:"scope"`
+	Span      int    `json:"span"`
+	Event     int    `json:"event"`
+	SpanID    string `json:"spanId,omitempty"`
+	SpanName  string `json:"spanName,omitempty"`
+	EventName string `json:"eventName,omitempty"`
+	Matched   bool   `json:"matched"`
+	// Values are the evaluated values, keyed by OTTL path or attribute key
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// ConditionError is an error evaluating a condition against a span or span
+// event. Swallowed errors are the ones the error mode ignores.
+type ConditionError struct {
+	Match
+	Error     string `json:"error"`
+	Swallowed bool   `json:"swallowed"`
+}
+
+// ConditionExplanation lists the spans or span events an OTTL condition was
+// evaluated against
+type ConditionExplanation struct {
+	Condition string           `json:"condition"`
+	Context   string           `json:"context"`
+	Matches   []Match          `json:"matches"`
+	Errors    []ConditionError `json:"errors,omitempty"`
+}
+
+// OTTLExplanation is the decision of an OTTLEvaluator with the details of
+// every condition
+type OTTLExplanation struct {
+	Decision   SamplingDecision       `json:"decision"`
+	Error      string                 `json:"error"`
+	Conditions []ConditionExplanation `json:"conditions"`
+}
+
+// PolicyExplanation is the decision of a policy and what it was based on
+type PolicyExplanation struct {
+	Policy   string           `json:"policy"`
+	Type     PolicyType       `json:"type"`
+	Decision SamplingDecision `json:"decision"`
+	Error    string           `json:"error,omitempty"`
+	// Values are values computed for the whole trace, e.g. its duration
+	Values      map[string]interface{} `json:"values,omitempty"`
+	Matches     []Match                `json:"matches,omitempty"`
+	Conditions  []ConditionExplanation `json:"conditions,omitempty"`
+	SubPolicies []PolicyExplanation    `json:"subPolicies,omitempty"`
+
+	err error
+}
+
+// TailSamplingExplanation is the final decision for a trace with the
+// explanation of each policy
+type TailSamplingExplanation struct {
+	Decision  SamplingDecision    `json:"decision"`
+	SampledBy string              `json:"sampledBy,omitempty"`
+	Policies  []PolicyExplanation `json:"policies"`
+}
+
+// policyDescriber is implemented by policies without state, which describe
+// what their decision is based on without evaluating themselves again
+type policyDescriber interface {
+	describe(ctx context.Context, trace *TraceData, exp *PolicyExplanation)
+}
+
+// policyExplainer is implemented by policies with state or sub-policies. It
+// evaluates the policy like Evaluate, so the state is only updated once, and
+// records what the decision is based on.
+type policyExplainer interface {
+	explain(ctx context.Context, trace *TraceData, exp *PolicyExplanation)
+}
+
+// explainPolicy evaluates the policy and explains its decision
+func explainPolicy(ctx context.Context, policy namedPolicy, trace *TraceData) PolicyExplanation {
+	exp := PolicyExplanation{Policy: policy.name, Type: policy.typ}
+	if explainer, ok := policy.evaluator.(policyExplainer); ok {
+		explainer.explain(ctx, trace, &exp)
+		return exp
+	}
+
+	exp.setDecision(policy.evaluator.Evaluate(ctx, trace))
+	if describer, ok := policy.evaluator.(policyDescriber); ok {
+		describer.describe(ctx, trace, &exp)
+	}
+	return exp
+}
+
+func (exp *PolicyExplanation) setDecision(decision SamplingDecision, err error) {
+	exp.Decision = decision
+	exp.err = err
+	if err != nil {
+		exp.Decision = Error
+		exp.Error = err.Error()
+	}
+}
+
+// explainSubPolicy is a subPolicyFunc that adds the explanation of the
+// sub-policy
+func (exp *PolicyExplanation) explainSubPolicy(ctx context.Context, sub namedPolicy, trace *TraceData) (SamplingDecision, error) {
+	subExp := explainPolicy(ctx, sub, trace)
+	exp.SubPolicies = append(exp.SubPolicies, subExp)
+	return subExp.Decision, subExp.err
+}
+
+// Explain evaluates every condition against every span and span event, not
+// just up to the first match, and records the matches, the values of the
+// paths each condition uses and the errors, including the ones swallowed by
+// the error mode. The decision and error are the ones of Evaluate.
+func (e *OTTLEvaluator) Explain(ctx context.Context, traces ptrace.Traces) OTTLExplanation {
+	exp := OTTLExplanation{}
+	decision, err := e.Evaluate(ctx, traces)
+	exp.Decision = decision
+	if err != nil {
+		exp.Error = err.Error()
+	}
+
+	conditions, err := e.explainConditions(ctx, traces)
+	if err != nil && exp.Error == "" {
+		exp.Error = err.Error()
+	}
+	exp.Conditions = conditions
+	return exp
+}
+
+func (e *OTTLEvaluator) explainConditions(ctx context.Context, traces ptrace.Traces) ([]ConditionExplanation, error) {
+	spanParser, err := ottlspan.NewParser(filter.StandardSpanFuncs(), e.settings)
+	if err != nil {
+		return nil, err
+	}
+	spanConditions, err := parseExplainedConditions(spanParser, e.spanConditions)
+	if err != nil {
+		return nil, err
+	}
+	spanEventParser, err := ottlspanevent.NewParser(filter.StandardSpanEventFuncs(), e.settings)
+	if err != nil {
+		return nil, err
+	}
+	spanEventConditions, err := parseExplainedConditions(spanEventParser, e.spanEventConditions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ConditionExplanation, 0, len(spanConditions)+len(spanEventConditions))
+	for _, c := range spanConditions {
+		result = append(result, ConditionExplanation{Condition: c.text, Context: "span", Matches: []Match{}})
+	}
+	for _, c := range spanEventConditions {
+		result = append(result, ConditionExplanation{Condition: c.text, Context: "spanevent", Matches: []Match{}})
+	}
+	spanResults := result[:len(spanConditions)]
+	spanEventResults := result[len(spanConditions):]
+
+	swallowed := e.errMode != ottl.PropagateError
+	walkSpans(traces, func(loc Match, rs ptrace.ResourceSpans, ss ptrace.ScopeSpans, span ptrace.Span) {
+		for i, c := range spanConditions {
+			tCtx := ottlspan.NewTransformContext(span, ss.Scope(), rs.Resource(), ss, rs)
+			c.explain(ctx, tCtx, loc, &spanResults[i], swallowed)
+		}
+
+		for l := 0; l < span.Events().Len(); l++ {
+			event := span.Events().At(l)
+			eventLoc := loc
+			eventLoc.Event = l
+			eventLoc.EventName = event.Name()
+			for i, c := range spanEventConditions {
+				tCtx := ottlspanevent.NewTransformContext(event, span, ss.Scope(), rs.Resource(), ss, rs)
+				c.explain(ctx, tCtx, eventLoc, &spanEventResults[i], swallowed)
+			}
+		}
+	})
+	return result, nil
+}
+
+// explainedCondition is a condition together with the paths it uses
+type explainedCondition[K any] struct {
+	text      string
+	condition *ottl.Condition[K]
+	paths     []string
+	values    []*ottl.ValueExpression[K]
+}
+
+func parseExplainedConditions[K any](parser ottl.Parser[K], conditions []string) ([]explainedCondition[K], error) {
+	result := make([]explainedCondition[K], 0, len(conditions))
+	for _, text := range conditions {
+		condition, err := parser.ParseCondition(text)
+		if err != nil {
+			return nil, err
+		}
+
+		c := explainedCondition[K]{text: text, condition: condition}
+		for _, path := range conditionPaths(text) {
+			value, err := parser.ParseValueExpression(path)
+			if err != nil {
+				// Not a path of this context, e.g. an editor name
+				continue
+			}
+			c.paths = append(c.paths, path)
+			c.values = append(c.values, value)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func (c *explainedCondition[K]) explain(ctx context.Context, tCtx K, match Match, exp *ConditionExplanation, swallowed bool) {
+	matched, err := c.condition.Eval(ctx, tCtx)
+	if err != nil {
+		exp.Errors = append(exp.Errors, ConditionError{Match: match, Error: err.Error(), Swallowed: swallowed})
+		return
+	}
+
+	match.Matched = matched
+	if len(c.paths) > 0 {
+		match.Values = make(map[string]interface{}, len(c.paths))
+		for i, path := range c.paths {
+			value, err := c.values[i].Eval(ctx, tCtx)
+			if err != nil {
+				match.Values[path] = "error: " + err.Error()
+				continue
+			}
+			match.Values[path] = explainValue(value)
+		}
+	}
+	exp.Matches = append(exp.Matches, match)
+}
+
+// conditionPaths returns the paths used by the condition, e.g. name or
+// resource.attributes["service.name"], skipping strings, function names,
+// enums and keywords
+func conditionPaths(condition string) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(condition); {
+		ch := condition[i]
+		switch {
+		case ch == '"':
+			i = skipString(condition, i)
+		case isIdentStart(ch):
+			start := i
+			i = skipIdent(condition, i)
+			for i < len(condition) {
+				if condition[i] == '.' && i+1 < len(condition) && isIdentStart(condition[i+1]) {
+					i = skipIdent(condition, i+1)
+				} else if condition[i] == '[' {
+					i = skipKey(condition, i)
+				} else {
+					break
+				}
+			}
+			path := condition[start:i]
+			if isConditionPath(condition, path, i) && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		default:
+			i++
+		}
+	}
+	return paths
+}
+
+func isConditionPath(condition, path string, end int) bool {
+	// Function names are followed by their arguments
+	rest := strings.TrimLeft(condition[end:], " \t\n")
+	if strings.HasPrefix(rest, "(") {
+		return false
+	}
+	switch path {
+	case "and", "or", "not", "true", "false", "nil":
+		return false
+	}
+	// Enums are upper case
+	return strings.ToUpper(path) != path
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func skipIdent(s string, i int) int {
+	for i < len(s) && (isIdentStart(s[i]) || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	return i
+}
+
+// skipString returns the index after the string starting at i
+func skipString(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipKey returns the index after the key starting at i, e.g. ["key"]
+func skipKey(s string, i int) int {
+	for i++; i < len(s); {
+		switch s[i] {
+		case '"':
+			i = skipString(s, i)
+		case ']':
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(s)
+}
+
+// explainValue converts an OTTL value to a value that can be encoded as JSON
+func explainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, bool, int64, float64, time.Time, time.Duration:
+		return v
+	case pcommon.Map:
+		return v.AsRaw()
+	case pcommon.Slice:
+		return v.AsRaw()
+	case pcommon.Value:
+		return v.AsRaw()
+	case pcommon.TraceID:
+		return v.String()
+	case pcommon.SpanID:
+		return v.String()
+	case []byte:
+		return hex.EncodeToString(v)
+	case ptrace.Status:
+		return map[string]interface{}{"code": v.Code().String(), "message": v.Message()}
+	case ptrace.SpanEventSlice:
+		return fmt.Sprintf("%d events", v.Len())
+	case ptrace.SpanLinkSlice:
+		return fmt.Sprintf("%d links", v.Len())
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// walkSpans calls fn for each span with its location
+func walkSpans(td ptrace.Traces, fn func(loc Match, rs ptrace.ResourceSpans, ss ptrace.ScopeSpans, span ptrace.Span)) {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+				fn(Match{
+					Resource: i,
+					Scope:    j,
+					Span:     k,
+					Event:    -1,
+					SpanID:   span.SpanID().String(),
+					SpanName: span.Name(),
+				}, rs, ss, span)
+			}
+		}
+	}
+}
+
+// attributeMatches lists the resources and spans that have the attribute
+func attributeMatches(td ptrace.Traces, key string, matches func(v pcommon.Value) bool) []Match {
+	var result []Match
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		if v, ok := td.ResourceSpans().At(i).Resource().Attributes().Get(key); ok {
+			result = append(result, Match{
+				Resource: i,
+				Scope:    -1,
+				Span:     -1,
+				Event:    -1,
+				Matched:  matches(v),
+				Values:   map[string]interface{}{key: v.AsRaw()},
+			})
+		}
+	}
+	walkSpans(td, func(loc Match, _ ptrace.ResourceSpans, _ ptrace.ScopeSpans, span ptrace.Span) {
+		if v, ok := span.Attributes().Get(key); ok {
+			loc.Matched = matches(v)
+			loc.Values = map[string]interface{}{key: v.AsRaw()}
+			result = append(result, loc)
+		}
+	})
+	return result
+}
+
+func (p *latencyPolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	var minTime, maxTime pcommon.Timestamp
+	forEachSpan(trace.Batches, func(span ptrace.Span) bool {
+		if minTime == 0 || span.StartTimestamp() < minTime {
+			minTime = span.StartTimestamp()
+		}
+		if span.EndTimestamp() > maxTime {
+			maxTime = span.EndTimestamp()
+		}
+		return true
+	})
+	exp.Values = map[string]interface{}{
+		"durationMs": maxTime.AsTime().Sub(minTime.AsTime()).Milliseconds(),
+	}
+}
+
+func (p *numericAttributePolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.Matches = attributeMatches(trace.Batches, p.key, func(v pcommon.Value) bool {
+		return v.Int() >= p.minValue && v.Int() <= p.maxValue
+	})
+}
+
+func (p *booleanAttributePolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.Matches = attributeMatches(trace.Batches, p.cfg.Key, func(v pcommon.Value) bool {
+		return v.Bool() == p.cfg.Value
+	})
+}
+
+func (p *stringAttributePolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.Matches = attributeMatches(trace.Batches, p.key, func(v pcommon.Value) bool {
+		return p.matchesValue(v.Str())
+	})
+}
+
+func (p *probabilisticPolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	traceID := traceIDOf(trace.Batches)
+	// Formatted as strings, as JavaScript numbers can't hold every uint64
+	exp.Values = map[string]interface{}{
+		"traceId":   traceID.String(),
+		"hash":      strconv.FormatUint(hashTraceID(p.hashSalt, traceID[:]), 10),
+		"threshold": strconv.FormatUint(p.threshold, 10),
+	}
+}
+
+func (p *spanCountPolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.Values = map[string]interface{}{"spanCount": trace.SpanCount}
+}
+
+func (p *statusCodePolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	walkSpans(trace.Batches, func(loc Match, _ ptrace.ResourceSpans, _ ptrace.ScopeSpans, span ptrace.Span) {
+		loc.Matched = p.codes[span.Status().Code()]
+		loc.Values = map[string]interface{}{"status.code": span.Status().Code().String()}
+		exp.Matches = append(exp.Matches, loc)
+	})
+}
+
+func (p *traceStatePolicy) describe(_ context.Context, trace *TraceData, exp *PolicyExplanation) {
+	walkSpans(trace.Batches, func(loc Match, _ ptrace.ResourceSpans, _ ptrace.ScopeSpans, span ptrace.Span) {
+		traceState, err := oteltrace.ParseTraceState(span.TraceState().AsRaw())
+		if err != nil {
+			return
+		}
+		value := traceState.Get(p.key)
+		if value == "" {
+			return
+		}
+		loc.Matched = p.values[value]
+		loc.Values = map[string]interface{}{"trace_state." + p.key: value}
+		exp.Matches = append(exp.Matches, loc)
+	})
+}
+
+func (p *ottlConditionPolicy) describe(ctx context.Context, trace *TraceData, exp *PolicyExplanation) {
+	conditions, err := p.eval.explainConditions(ctx, trace.Batches)
+	if err != nil {
+		return
+	}
+	exp.Conditions = conditions
+}
+
+func (p *rateLimitingPolicy) explain(ctx context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.setDecision(p.Evaluate(ctx, trace))
+	exp.Values = map[string]interface{}{
+		"spanCount":            trace.SpanCount,
+		"spansPerSecond":       p.spansPerSecond,
+		"sampledSpansInSecond": p.spansInCurrentSecond,
+	}
+}
+
+func (p *andPolicy) explain(ctx context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.setDecision(allSubPolicies(ctx, trace, p.subs, Sampled, exp.explainSubPolicy))
+}
+
+func (p *dropPolicy) explain(ctx context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.setDecision(allSubPolicies(ctx, trace, p.subs, Dropped, exp.explainSubPolicy))
+}
+
+func (p *compositePolicy) explain(ctx context.Context, trace *TraceData, exp *PolicyExplanation) {
+	exp.setDecision(p.evaluate(ctx, trace, exp.explainSubPolicy))
+
+	allocated := make(map[string]interface{}, len(p.subs))
+	sampled := make(map[string]interface{}, len(p.subs))
+	for _, sub := range p.subs {
+		allocated[sub.name] = sub.allocatedSPS
+		sampled[sub.name] = sub.sampledSPS
+	}
+	exp.Values = map[string]interface{}{
+		"spanCount":               trace.SpanCount,
+		"maxTotalSpansPerSecond":  p.maxTotalSPS,
+		"allocatedSpansPerSecond": allocated,
+		"sampledSpansInSecond":    sampled,
+	}
+}
@@ -0,0 +1,135 @@
+package evaluator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// checkoutTrace is a trace of a checkout service in OTLP JSON, with a
+// successful span and a failing span that recorded an exception
+const checkoutTrace = `{"resourceSpans": [{
+	"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+	"scopeSpans": [{"spans": [
+		{
+			"traceId": "5b8efff798038103d269b633813fc60c",
+			"spanId": "eee19b7ec3c1b174",
+			"name": "GET /cart",
+			"startTimeUnixNano": "1700000000000000000",
+			"endTimeUnixNano": "1700000000100000000",
+			"attributes": [{"key": "http.status_code", "value": {"intValue": "200"}}]
+		},
+		{
+			"traceId": "5b8efff798038103d269b633813fc60c",
+			"spanId": "eee19b7ec3c1b175",
+			"name": "POST /checkout",
+			"startTimeUnixNano": "1700000000000000000",
+			"endTimeUnixNano": "1700000000300000000",
+			"attributes": [{"key": "http.status_code", "value": {"intValue": "503"}}],
+			"status": {"code": 2},
+			"events": [{"name": "exception", "timeUnixNano": "1700000000200000000"}]
+		}
+	]}]
+}]}`
+
+func unmarshalTraces(t *testing.T, data string) ptrace.Traces {
+	t.Helper()
+	traces, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return traces
+}
+
+func TestOTTLEvaluatorExplain(t *testing.T) {
+	e, err := NewOTTLConditionEvaluator(
+		[]string{`attributes["http.status_code"] >= 500`, `Len(attributes["http.status_code"]) > 0`},
+		[]string{`name == "exception"`},
+		ottl.IgnoreError,
+		zap.NewNop(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := e.Explain(context.Background(), unmarshalTraces(t, checkoutTrace))
+	if exp.Decision != Sampled || exp.Error != "" {
+		t.Fatalf("decision = %s (%q), want %s", exp.Decision, exp.Error, Sampled)
+	}
+	if len(exp.Conditions) != 3 {
+		t.Fatalf("explained %d conditions, want 3", len(exp.Conditions))
+	}
+
+	// Every span is explained, not just up to the first match
+	status := exp.Conditions[0]
+	if status.Context != "span" || len(status.Matches) != 2 {
+		t.Fatalf("status condition = %+v, want the two spans", status)
+	}
+	for i, want := range []struct {
+		matched bool
+		code    int64
+	}{{false, 200}, {true, 503}} {
+		match := status.Matches[i]
+		if match.Span != i || match.Event != -1 || match.Matched != want.matched {
+			t.Errorf("match %d = %+v, want span %d matched %v", i, match, i, want.matched)
+		}
+		if got := match.Values[`attributes["http.status_code"]`]; got != want.code {
+			t.Errorf("value of match %d = %v, want %d", i, got, want.code)
+		}
+	}
+
+	// The error mode swallows the errors of a condition that can't be evaluated
+	length := exp.Conditions[1]
+	if len(length.Matches) != 0 || len(length.Errors) != 2 || !length.Errors[0].Swallowed {
+		t.Errorf("length condition = %+v, want two swallowed errors", length)
+	}
+
+	event := exp.Conditions[2]
+	if event.Context != "spanevent" || len(event.Matches) != 1 {
+		t.Fatalf("event condition = %+v, want the exception event", event)
+	}
+	if match := event.Matches[0]; !match.Matched || match.Span != 1 || match.Event != 0 || match.EventName != "exception" {
+		t.Errorf("event match = %+v, want the event of POST /checkout", match)
+	}
+}
+
+func TestTailSamplerExplain(t *testing.T) {
+	policies, err := ParsePolicies([]byte(`[
+		{"name": "errors", "type": "status_code", "status_code": {"status_codes": ["ERROR"]}},
+		{"name": "payments", "type": "string_attribute", "string_attribute": {"key": "service.name", "values": ["payments"]}},
+		{"name": "slow", "type": "latency", "latency": {"threshold_ms": 1000}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler, err := NewTailSampler(policies, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := sampler.Explain(context.Background(), unmarshalTraces(t, checkoutTrace))
+	if exp.Decision != Sampled || exp.SampledBy != "errors" {
+		t.Fatalf("decision = %s by %q, want %s by errors", exp.Decision, exp.SampledBy, Sampled)
+	}
+	if len(exp.Policies) != 3 {
+		t.Fatalf("explained %d policies, want 3", len(exp.Policies))
+	}
+
+	failing := exp.Policies[0]
+	if failing.Decision != Sampled || len(failing.Matches) != 2 || !failing.Matches[1].Matched || failing.Matches[0].Matched {
+		t.Errorf("errors policy = %+v, want only POST /checkout to match", failing)
+	}
+	payments := exp.Policies[1]
+	if payments.Decision != NotSampled || len(payments.Matches) != 1 || payments.Matches[0].Span != -1 {
+		t.Errorf("payments policy = %+v, want the resource not to match", payments)
+	}
+	if got := payments.Matches[0].Values["service.name"]; got != "checkout" {
+		t.Errorf("service.name of the payments policy = %v, want checkout", got)
+	}
+	if slow := exp.Policies[2]; slow.Decision != NotSampled || slow.Values["durationMs"] != int64(300) {
+		t.Errorf("slow policy = %+v, want a duration of 300ms", slow)
+	}
+}
@@ -0,0 +1,617 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"regexp"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// The policies follow the tail_sampling processor (collector-contrib v0.121),
+// including the order in which resources and spans are checked, so that
+// inverted matches give the same decision as in the collector.
+
+// defaultHashSalt is the hash salt of the probabilistic policy when none is set
+const defaultHashSalt = "default-hash-seed"
+
+// TraceData holds the spans of a single trace
+type TraceData struct {
+	// TraceID is the trace ID of the first span
+	TraceID   pcommon.TraceID
+	Batches   ptrace.Traces
+	SpanCount int64
+	// StartTime is the earliest span start. The rate limiting policies count
+	// the spans of each second by the start time, as the playground has no
+	// arrival time.
+	StartTime pcommon.Timestamp
+}
+
+// NewTraceData wraps the spans of a single trace
+func NewTraceData(batches ptrace.Traces) *TraceData {
+	trace := &TraceData{Batches: batches, TraceID: traceIDOf(batches)}
+	forEachSpan(batches, func(span ptrace.Span) bool {
+		trace.SpanCount++
+		if trace.StartTime == 0 || span.StartTimestamp() < trace.StartTime {
+			trace.StartTime = span.StartTimestamp()
+		}
+		return true
+	})
+	return trace
+}
+
+// PolicyEvaluator makes the sampling decision of a single policy
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, trace *TraceData) (SamplingDecision, error)
+}
+
+// NewPolicyEvaluator creates the evaluator for the policy
+func NewPolicyEvaluator(cfg PolicyConfig, logger *zap.Logger) (PolicyEvaluator, error) {
+	switch cfg.Type {
+	case And:
+		subs, err := newSubPolicyEvaluators(cfg.AndCfg.SubPolicies, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &andPolicy{subs: subs}, nil
+	case Drop:
+		subs, err := newSubPolicyEvaluators(cfg.DropCfg.SubPolicies, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &dropPolicy{subs: subs}, nil
+	case Composite:
+		return newCompositePolicy(cfg.CompositeCfg, logger)
+	case AlwaysSample:
+		return alwaysSamplePolicy{}, nil
+	case Latency:
+		return &latencyPolicy{cfg: cfg.LatencyCfg}, nil
+	case NumericAttribute:
+		return newNumericAttributePolicy(cfg.NumericAttributeCfg), nil
+	case Probabilistic:
+		return newProbabilisticPolicy(cfg.ProbabilisticCfg), nil
+	case StatusCode:
+		return newStatusCodePolicy(cfg.StatusCodeCfg)
+	case StringAttribute:
+		return newStringAttributePolicy(cfg.StringAttributeCfg)
+	case RateLimiting:
+		return &rateLimitingPolicy{spansPerSecond: cfg.RateLimitingCfg.SpansPerSecond}, nil
+	case SpanCount:
+		return &spanCountPolicy{cfg: cfg.SpanCountCfg}, nil
+	case TraceState:
+		return newTraceStatePolicy(cfg.TraceStateCfg), nil
+	case BooleanAttribute:
+		return &booleanAttributePolicy{cfg: cfg.BooleanAttributeCfg}, nil
+	case OTTLCondition:
+		eval, err := NewOTTLConditionEvaluator(
+			cfg.OTTLConditionCfg.SpanConditions,
+			cfg.OTTLConditionCfg.SpanEventConditions,
+			ottl.ErrorMode(cfg.OTTLConditionCfg.ErrorMode),
+			logger,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &ottlConditionPolicy{eval: eval}, nil
+	default:
+		return nil, fmt.Errorf("unknown policy type %q", cfg.Type)
+	}
+}
+
+// namedPolicy is the evaluator of a policy together with its name and type
+type namedPolicy struct {
+	name      string
+	typ       PolicyType
+	evaluator PolicyEvaluator
+}
+
+func newNamedPolicy(cfg PolicyConfig, logger *zap.Logger) (namedPolicy, error) {
+	eval, err := NewPolicyEvaluator(cfg, logger)
+	if err != nil {
+		return namedPolicy{}, err
+	}
+	return namedPolicy{name: cfg.Name, typ: cfg.Type, evaluator: eval}, nil
+}
+
+func newSubPolicyEvaluators(cfgs []PolicyConfig, logger *zap.Logger) ([]namedPolicy, error) {
+	subs := make([]namedPolicy, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		sub, err := newNamedPolicy(cfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("sub-policy %s: %w", cfg.Name, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// subPolicyFunc evaluates a sub-policy of an and, drop or composite policy
+type subPolicyFunc func(ctx context.Context, sub namedPolicy, trace *TraceData) (SamplingDecision, error)
+
+func evaluateSubPolicy(ctx context.Context, sub namedPolicy, trace *TraceData) (SamplingDecision, error) {
+	return sub.evaluator.Evaluate(ctx, trace)
+}
+
+func forEachSpan(td ptrace.Traces, fn func(span ptrace.Span) bool) {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		if !forEachScopeSpan(td.ResourceSpans().At(i).ScopeSpans(), fn) {
+			return
+		}
+	}
+}
+
+// forEachScopeSpan calls fn for each span until it returns false, and reports
+// whether every call returned true
+func forEachScopeSpan(scopeSpans ptrace.ScopeSpansSlice, fn func(span ptrace.Span) bool) bool {
+	for i := 0; i < scopeSpans.Len(); i++ {
+		spans := scopeSpans.At(i).Spans()
+		for j := 0; j < spans.Len(); j++ {
+			if !fn(spans.At(j)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasResourceOrSpanWithCondition samples the trace if a resource or a span
+// matches
+func hasResourceOrSpanWithCondition(td ptrace.Traces, resourceMatches func(pcommon.Resource) bool, spanMatches func(ptrace.Span) bool) SamplingDecision {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		if resourceMatches(rs.Resource()) {
+			return Sampled
+		}
+		if hasScopeSpanWithCondition(rs.ScopeSpans(), spanMatches) {
+			return Sampled
+		}
+	}
+	return NotSampled
+}
+
+// invertHasResourceOrSpanWithCondition rejects the trace as soon as a resource
+// or a span is not kept, and samples it otherwise
+func invertHasResourceOrSpanWithCondition(td ptrace.Traces, resourceKept func(pcommon.Resource) bool, spanKept func(ptrace.Span) bool) SamplingDecision {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		if !resourceKept(rs.Resource()) {
+			return InvertNotSampled
+		}
+		if !forEachScopeSpan(rs.ScopeSpans(), spanKept) {
+			return InvertNotSampled
+		}
+	}
+	return InvertSampled
+}
+
+func hasSpanWithCondition(td ptrace.Traces, spanMatches func(ptrace.Span) bool) SamplingDecision {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		if hasScopeSpanWithCondition(td.ResourceSpans().At(i).ScopeSpans(), spanMatches) {
+			return Sampled
+		}
+	}
+	return NotSampled
+}
+
+func hasScopeSpanWithCondition(scopeSpans ptrace.ScopeSpansSlice, spanMatches func(ptrace.Span) bool) bool {
+	return !forEachScopeSpan(scopeSpans, func(span ptrace.Span) bool {
+		return !spanMatches(span)
+	})
+}
+
+// evaluateAttribute checks the attributes of the resources and spans. matches
+// reports whether the attribute was found and whether its value matches. With
+// invertMatch, the trace is rejected if any attribute matches.
+func evaluateAttribute(td ptrace.Traces, invertMatch bool, matches func(attrs pcommon.Map) (found, matched bool)) SamplingDecision {
+	if invertMatch {
+		kept := func(attrs pcommon.Map) bool {
+			found, matched := matches(attrs)
+			return !found || !matched
+		}
+		return invertHasResourceOrSpanWithCondition(td,
+			func(resource pcommon.Resource) bool { return kept(resource.Attributes()) },
+			func(span ptrace.Span) bool { return kept(span.Attributes()) },
+		)
+	}
+
+	sample := func(attrs pcommon.Map) bool {
+		_, matched := matches(attrs)
+		return matched
+	}
+	return hasResourceOrSpanWithCondition(td,
+		func(resource pcommon.Resource) bool { return sample(resource.Attributes()) },
+		func(span ptrace.Span) bool { return sample(span.Attributes()) },
+	)
+}
+
+type alwaysSamplePolicy struct{}
+
+func (alwaysSamplePolicy) Evaluate(context.Context, *TraceData) (SamplingDecision, error) {
+	return Sampled, nil
+}
+
+// latencyPolicy samples traces whose duration, from the first span start to
+// the last span end, is above the threshold, or within the thresholds when an
+// upper threshold is set
+type latencyPolicy struct {
+	cfg LatencyConfig
+}
+
+func (p *latencyPolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	var minTime, maxTime pcommon.Timestamp
+	return hasSpanWithCondition(trace.Batches, func(span ptrace.Span) bool {
+		if minTime == 0 || span.StartTimestamp() < minTime {
+			minTime = span.StartTimestamp()
+		}
+		if maxTime == 0 || span.EndTimestamp() > maxTime {
+			maxTime = span.EndTimestamp()
+		}
+
+		duration := maxTime.AsTime().Sub(minTime.AsTime()).Milliseconds()
+		if p.cfg.UpperThresholdMs == 0 {
+			return duration >= p.cfg.ThresholdMs
+		}
+		return p.cfg.ThresholdMs < duration && duration <= p.cfg.UpperThresholdMs
+	}), nil
+}
+
+type numericAttributePolicy struct {
+	key         string
+	minValue    int64
+	maxValue    int64
+	invertMatch bool
+}
+
+func newNumericAttributePolicy(cfg NumericAttributeConfig) *numericAttributePolicy {
+	p := &numericAttributePolicy{
+		key:         cfg.Key,
+		minValue:    math.MinInt64,
+		maxValue:    math.MaxInt64,
+		invertMatch: cfg.InvertMatch,
+	}
+	if cfg.MinValue != nil {
+		p.minValue = *cfg.MinValue
+	}
+	if cfg.MaxValue != nil {
+		p.maxValue = *cfg.MaxValue
+	}
+	return p
+}
+
+func (p *numericAttributePolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	return evaluateAttribute(trace.Batches, p.invertMatch, func(attrs pcommon.Map) (bool, bool) {
+		v, ok := attrs.Get(p.key)
+		if !ok {
+			return false, false
+		}
+		value := v.Int()
+		return true, value >= p.minValue && value <= p.maxValue
+	}), nil
+}
+
+type booleanAttributePolicy struct {
+	cfg BooleanAttributeConfig
+}
+
+func (p *booleanAttributePolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	return evaluateAttribute(trace.Batches, p.cfg.InvertMatch, func(attrs pcommon.Map) (bool, bool) {
+		v, ok := attrs.Get(p.cfg.Key)
+		if !ok {
+			return false, false
+		}
+		return true, v.Bool() == p.cfg.Value
+	}), nil
+}
+
+type stringAttributePolicy struct {
+	key         string
+	values      map[string]bool
+	regexes     []*regexp.Regexp
+	invertMatch bool
+}
+
+func newStringAttributePolicy(cfg StringAttributeConfig) (*stringAttributePolicy, error) {
+	p := &stringAttributePolicy{key: cfg.Key, invertMatch: cfg.InvertMatch}
+	if !cfg.EnabledRegexMatching {
+		p.values = make(map[string]bool, len(cfg.Values))
+		for _, value := range cfg.Values {
+			p.values[value] = true
+		}
+		return p, nil
+	}
+
+	for _, value := range cfg.Values {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", value, err)
+		}
+		p.regexes = append(p.regexes, re)
+	}
+	return p, nil
+}
+
+func (p *stringAttributePolicy) matchesValue(value string) bool {
+	if p.values != nil {
+		return p.values[value]
+	}
+	for _, re := range p.regexes {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *stringAttributePolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	return evaluateAttribute(trace.Batches, p.invertMatch, func(attrs pcommon.Map) (bool, bool) {
+		v, ok := attrs.Get(p.key)
+		if !ok {
+			return false, false
+		}
+		return true, p.matchesValue(v.Str())
+	}), nil
+}
+
+// probabilisticPolicy samples a percentage of the traces by hashing the trace
+// ID with the salt, so that collectors with the same salt make the same
+// decision
+type probabilisticPolicy struct {
+	threshold uint64
+	hashSalt  string
+}
+
+func newProbabilisticPolicy(cfg ProbabilisticConfig) *probabilisticPolicy {
+	hashSalt := cfg.HashSalt
+	if hashSalt == "" {
+		hashSalt = defaultHashSalt
+	}
+	return &probabilisticPolicy{
+		threshold: calculateThreshold(cfg.SamplingPercentage / 100),
+		hashSalt:  hashSalt,
+	}
+}
+
+func (p *probabilisticPolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	traceID := traceIDOf(trace.Batches)
+	if hashTraceID(p.hashSalt, traceID[:]) <= p.threshold {
+		return Sampled, nil
+	}
+	return NotSampled, nil
+}
+
+// traceIDOf returns the trace ID of the first span
+func traceIDOf(td ptrace.Traces) pcommon.TraceID {
+	var traceID pcommon.TraceID
+	forEachSpan(td, func(span ptrace.Span) bool {
+		traceID = span.TraceID()
+		return false
+	})
+	return traceID
+}
+
+// calculateThreshold uses big.Float because math.MaxUint64 does not fit in the
+// digits of a float64
+func calculateThreshold(ratio float64) uint64 {
+	boundary := new(big.Float).SetInt(new(big.Int).SetUint64(math.MaxUint64))
+	threshold, _ := boundary.Mul(boundary, big.NewFloat(ratio)).Uint64()
+	return threshold
+}
+
+func hashTraceID(salt string, traceID []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(salt))
+	hasher.Write(traceID)
+	return hasher.Sum64()
+}
+
+// rateLimitingPolicy samples traces until the spans sampled in the current
+// second reach the limit
+type rateLimitingPolicy struct {
+	spansPerSecond       int64
+	currentSecond        int64
+	spansInCurrentSecond int64
+}
+
+func (p *rateLimitingPolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	second := trace.StartTime.AsTime().Unix()
+	if p.currentSecond != second {
+		p.currentSecond = second
+		p.spansInCurrentSecond = 0
+	}
+
+	spansInSecondIfSampled := p.spansInCurrentSecond + trace.SpanCount
+	if spansInSecondIfSampled < p.spansPerSecond {
+		p.spansInCurrentSecond = spansInSecondIfSampled
+		return Sampled, nil
+	}
+	return NotSampled, nil
+}
+
+type spanCountPolicy struct {
+	cfg SpanCountConfig
+}
+
+func (p *spanCountPolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	if trace.SpanCount >= int64(p.cfg.MinSpans) && (p.cfg.MaxSpans == 0 || trace.SpanCount <= int64(p.cfg.MaxSpans)) {
+		return Sampled, nil
+	}
+	return NotSampled, nil
+}
+
+type statusCodePolicy struct {
+	codes map[ptrace.StatusCode]bool
+}
+
+func newStatusCodePolicy(cfg StatusCodeConfig) (*statusCodePolicy, error) {
+	p := &statusCodePolicy{codes: make(map[ptrace.StatusCode]bool, len(cfg.StatusCodes))}
+	for _, code := range cfg.StatusCodes {
+		switch code {
+		case "OK":
+			p.codes[ptrace.StatusCodeOk] = true
+		case "ERROR":
+			p.codes[ptrace.StatusCodeError] = true
+		case "UNSET":
+			p.codes[ptrace.StatusCodeUnset] = true
+		default:
+			return nil, fmt.Errorf("unknown status code %q, supported: OK, ERROR, UNSET", code)
+		}
+	}
+	return p, nil
+}
+
+func (p *statusCodePolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	return hasSpanWithCondition(trace.Batches, func(span ptrace.Span) bool {
+		return p.codes[span.Status().Code()]
+	}), nil
+}
+
+type traceStatePolicy struct {
+	key    string
+	values map[string]bool
+}
+
+func newTraceStatePolicy(cfg TraceStateConfig) *traceStatePolicy {
+	p := &traceStatePolicy{key: cfg.Key, values: make(map[string]bool, len(cfg.Values))}
+	for _, value := range cfg.Values {
+		p.values[value] = true
+	}
+	return p
+}
+
+func (p *traceStatePolicy) Evaluate(_ context.Context, trace *TraceData) (SamplingDecision, error) {
+	return hasSpanWithCondition(trace.Batches, func(span ptrace.Span) bool {
+		traceState, err := oteltrace.ParseTraceState(span.TraceState().AsRaw())
+		if err != nil {
+			return false
+		}
+		return p.values[traceState.Get(p.key)]
+	}), nil
+}
+
+type ottlConditionPolicy struct {
+	eval *OTTLEvaluator
+}
+
+func (p *ottlConditionPolicy) Evaluate(ctx context.Context, trace *TraceData) (SamplingDecision, error) {
+	decision, err := p.eval.Evaluate(ctx, trace.Batches)
+	if err != nil {
+		return Error, err
+	}
+	return decision, nil
+}
+
+// andPolicy samples the trace if every sub-policy samples it
+type andPolicy struct {
+	subs []namedPolicy
+}
+
+func (p *andPolicy) Evaluate(ctx context.Context, trace *TraceData) (SamplingDecision, error) {
+	return allSubPolicies(ctx, trace, p.subs, Sampled, evaluateSubPolicy)
+}
+
+// dropPolicy drops the trace if every sub-policy samples it. A dropped trace
+// is not sampled, whatever the other policies decide.
+type dropPolicy struct {
+	subs []namedPolicy
+}
+
+func (p *dropPolicy) Evaluate(ctx context.Context, trace *TraceData) (SamplingDecision, error) {
+	return allSubPolicies(ctx, trace, p.subs, Dropped, evaluateSubPolicy)
+}
+
+func allSubPolicies(ctx context.Context, trace *TraceData, subs []namedPolicy, decision SamplingDecision, evaluate subPolicyFunc) (SamplingDecision, error) {
+	for _, sub := range subs {
+		subDecision, err := evaluate(ctx, sub, trace)
+		if err != nil {
+			return Error, err
+		}
+		if subDecision == NotSampled || subDecision == InvertNotSampled {
+			return NotSampled, nil
+		}
+	}
+	return decision, nil
+}
+
+// compositePolicy samples the trace with the first sub-policy that samples it,
+// as long as the spans sampled by that sub-policy in the current second stay
+// within its share of the rate
+type compositePolicy struct {
+	maxTotalSPS   int64
+	subs          []*compositeSubPolicy
+	currentSecond int64
+}
+
+type compositeSubPolicy struct {
+	namedPolicy
+	allocatedSPS int64
+	sampledSPS   int64
+}
+
+func newCompositePolicy(cfg CompositeConfig, logger *zap.Logger) (*compositePolicy, error) {
+	// Sub-policies without a rate allocation get no share of the rate
+	allocations := make(map[string]float64, len(cfg.RateAllocation))
+	if len(cfg.SubPolicies) > 0 {
+		defaultSPS := float64(cfg.MaxTotalSpansPerSecond) / float64(len(cfg.SubPolicies))
+		for _, allocation := range cfg.RateAllocation {
+			if allocation.Percent > 0 {
+				allocations[allocation.Policy] = float64(allocation.Percent) / 100 * float64(cfg.MaxTotalSpansPerSecond)
+			} else {
+				allocations[allocation.Policy] = defaultSPS
+			}
+		}
+	}
+
+	p := &compositePolicy{maxTotalSPS: cfg.MaxTotalSpansPerSecond}
+	for _, subCfg := range cfg.SubPolicies {
+		sub, err := newNamedPolicy(subCfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("sub-policy %s: %w", subCfg.Name, err)
+		}
+		p.subs = append(p.subs, &compositeSubPolicy{
+			namedPolicy:  sub,
+			allocatedSPS: int64(allocations[subCfg.Name]),
+		})
+	}
+	return p, nil
+}
+
+func (p *compositePolicy) Evaluate(ctx context.Context, trace *TraceData) (SamplingDecision, error) {
+	return p.evaluate(ctx, trace, evaluateSubPolicy)
+}
+
+func (p *compositePolicy) evaluate(ctx context.Context, trace *TraceData, evaluate subPolicyFunc) (SamplingDecision, error) {
+	second := trace.StartTime.AsTime().Unix()
+	if p.currentSecond != second {
+		p.currentSecond = second
+		for _, sub := range p.subs {
+			sub.sampledSPS = 0
+		}
+	}
+
+	for _, sub := range p.subs {
+		decision, err := evaluate(ctx, sub.namedPolicy, trace)
+		if err != nil {
+			return Error, err
+		}
+		if decision != Sampled && decision != InvertSampled {
+			continue
+		}
+
+		// A trace over the rate is not sampled by the next sub-policies, but
+		// it is not counted either, so a smaller trace may still fit
+		spansInSecondIfSampled := sub.sampledSPS + trace.SpanCount
+		if spansInSecondIfSampled <= sub.allocatedSPS && spansInSecondIfSampled <= p.maxTotalSPS {
+			sub.sampledSPS = spansInSecondIfSampled
+			return Sampled, nil
+		}
+		return NotSampled, nil
+	}
+	return NotSampled, nil
+}
@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// PolicyDecision is the decision of a single top-level policy
+type PolicyDecision struct {
+	Policy   string           `json:"policy"`
+	Decision SamplingDecision `json:"decision"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// TailSamplingResult is the final decision for a trace together with the
+// decision of each policy
+type TailSamplingResult struct {
+	Decision SamplingDecision `json:"decision"`
+	// SampledBy is the first policy that sampled the trace
+	SampledBy string           `json:"sampledBy,omitempty"`
+	Policies  []PolicyDecision `json:"policies"`
+}
+
+// TailSampler evaluates traces against a tail_sampling policy set the way the
+// processor does. It keeps the state of the rate limiting policies between
+// traces.
+type TailSampler struct {
+	policies []namedPolicy
+}
+
+// NewTailSampler creates a sampler for the policies, in the order of the
+// processor config
+func NewTailSampler(cfgs []PolicyConfig, logger *zap.Logger) (*TailSampler, error) {
+	s := &TailSampler{}
+	for _, cfg := range cfgs {
+		policy, err := newNamedPolicy(cfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", cfg.Name, err)
+		}
+		s.policies = append(s.policies, policy)
+	}
+	return s, nil
+}
+
+// Evaluate treats the traces as the spans of a single trace, like
+// OTTLEvaluator.Evaluate, and returns the sampling decision
+func (s *TailSampler) Evaluate(ctx context.Context, traces ptrace.Traces) TailSamplingResult {
+	return s.EvaluateTrace(ctx, NewTraceData(traces))
+}
+
+// EvaluateTrace evaluates every policy before combining the decisions, as the
+// rate limiting policies count every trace they see. Policy errors do not
+// affect the decision.
+func (s *TailSampler) EvaluateTrace(ctx context.Context, trace *TraceData) TailSamplingResult {
+	result := TailSamplingResult{Policies: make([]PolicyDecision, 0, len(s.policies))}
+	for _, policy := range s.policies {
+		decision, err := policy.evaluator.Evaluate(ctx, trace)
+		policyDecision := PolicyDecision{Policy: policy.name, Decision: decision}
+		if err != nil {
+			policyDecision.Decision = Error
+			policyDecision.Error = err.Error()
+		}
+		result.Policies = append(result.Policies, policyDecision)
+	}
+
+	result.Decision, result.SampledBy = combineDecisions(result.Policies)
+	return result
+}
+
+// Explain treats the traces as the spans of a single trace, like Evaluate,
+// and explains the decision of each policy
+func (s *TailSampler) Explain(ctx context.Context, traces ptrace.Traces) TailSamplingExplanation {
+	return s.ExplainTrace(ctx, NewTraceData(traces))
+}
+
+// ExplainTrace makes the same decision as EvaluateTrace, and updates the state
+// of the rate limiting policies in the same way
+func (s *TailSampler) ExplainTrace(ctx context.Context, trace *TraceData) TailSamplingExplanation {
+	result := TailSamplingExplanation{Policies: make([]PolicyExplanation, 0, len(s.policies))}
+	decisions := make([]PolicyDecision, 0, len(s.policies))
+	for _, policy := range s.policies {
+		exp := explainPolicy(ctx, policy, trace)
+		result.Policies = append(result.Policies, exp)
+		decisions = append(decisions, PolicyDecision{Policy: exp.Policy, Decision: exp.Decision})
+	}
+
+	result.Decision, result.SampledBy = combineDecisions(decisions)
+	return result
+}
+
+// combineDecisions makes the final decision from the decisions of the
+// policies. A drop or an inverted non-match rejects the trace, then any match
+// samples it, and an inverted match only samples it if no other policy
+// rejected it. It returns the first policy that sampled the trace.
+func combineDecisions(decisions []PolicyDecision) (SamplingDecision, string) {
+	first := make(map[SamplingDecision]string)
+	for _, decision := range decisions {
+		if _, ok := first[decision.Decision]; !ok {
+			first[decision.Decision] = decision.Policy
+		}
+	}
+
+	_, dropped := first[Dropped]
+	_, invertNotSampled := first[InvertNotSampled]
+	_, notSampled := first[NotSampled]
+	sampledBy, sampled := first[Sampled]
+	invertSampledBy, invertSampled := first[InvertSampled]
+	switch {
+	case dropped, invertNotSampled:
+		return NotSampled, ""
+	case sampled:
+		return Sampled, sampledBy
+	case invertSampled && !notSampled:
+		return Sampled, invertSampledBy
+	}
+	return NotSampled, ""
+}
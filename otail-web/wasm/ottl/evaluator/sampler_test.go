@@ -0,0 +1,37 @@
+package evaluator
+
+import "testing"
+
+func TestCombineDecisions(t *testing.T) {
+	tests := []struct {
+		name          string
+		decisions     []SamplingDecision
+		wantDecision  SamplingDecision
+		wantSampledBy string
+	}{
+		{name: "no policies", wantDecision: NotSampled},
+		{name: "not sampled", decisions: []SamplingDecision{NotSampled, NotSampled}, wantDecision: NotSampled},
+		{name: "sampled", decisions: []SamplingDecision{NotSampled, Sampled}, wantDecision: Sampled, wantSampledBy: "p1"},
+		{name: "first sampling policy", decisions: []SamplingDecision{Sampled, Sampled}, wantDecision: Sampled, wantSampledBy: "p0"},
+		{name: "drop wins over sampled", decisions: []SamplingDecision{Sampled, Dropped}, wantDecision: NotSampled},
+		{name: "inverted non-match wins over sampled", decisions: []SamplingDecision{Sampled, InvertNotSampled}, wantDecision: NotSampled},
+		{name: "sampled wins over inverted match", decisions: []SamplingDecision{InvertSampled, Sampled}, wantDecision: Sampled, wantSampledBy: "p1"},
+		{name: "inverted match alone", decisions: []SamplingDecision{InvertSampled}, wantDecision: Sampled, wantSampledBy: "p0"},
+		{name: "inverted match with not sampled", decisions: []SamplingDecision{InvertSampled, NotSampled}, wantDecision: NotSampled},
+		{name: "errors are ignored", decisions: []SamplingDecision{Error, Sampled}, wantDecision: Sampled, wantSampledBy: "p1"},
+		{name: "only errors", decisions: []SamplingDecision{Error}, wantDecision: NotSampled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := make([]PolicyDecision, len(tt.decisions))
+			for i, decision := range tt.decisions {
+				decisions[i] = PolicyDecision{Policy: "p" + string(rune('0'+i)), Decision: decision}
+			}
+			decision, sampledBy := combineDecisions(decisions)
+			if decision != tt.wantDecision || sampledBy != tt.wantSampledBy {
+				t.Errorf("combineDecisions() = %s, %q, want %s, %q", decision, sampledBy, tt.wantDecision, tt.wantSampledBy)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottldatapoint"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottllog"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlmetric"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// LogRecordIndex locates a log record in the OTLP JSON
+type LogRecordIndex struct {
+	Resource  int `json:"resource"`
+	Scope     int `json:"scope"`
+	LogRecord int `json:"logRecord"`
+}
+
+// LogResult lists the log records matched by the conditions
+type LogResult struct {
+	Matched []LogRecordIndex `json:"matched"`
+	Total   int              `json:"total"`
+}
+
+// LogEvaluator evaluates the log_record conditions of the filter processor
+type LogEvaluator struct {
+	logRecordBoolExpr *ottl.ConditionSequence[ottllog.TransformContext]
+}
+
+// NewLogEvaluator creates an evaluator that matches the log records for which
+// any of the conditions is true
+func NewLogEvaluator(logRecordConditions []string, errMode ottl.ErrorMode, logger *zap.Logger) (*LogEvaluator, error) {
+	settings := component.TelemetrySettings{}
+	settings.Logger = logger
+
+	e := &LogEvaluator{}
+	if len(logRecordConditions) > 0 {
+		var err error
+		e.logRecordBoolExpr, err = filter.NewBoolExprForLog(
+			logRecordConditions,
+			filter.StandardLogFuncs(),
+			errMode,
+			settings,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Evaluate returns the log records matched by the conditions
+func (e *LogEvaluator) Evaluate(ctx context.Context, logs plog.Logs) (*LogResult, error) {
+	result := &LogResult{Matched: []LogRecordIndex{}}
+	for i := 0; i < logs.ResourceLogs().Len(); i++ {
+		rl := logs.ResourceLogs().At(i)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			sl := rl.ScopeLogs().At(j)
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				result.Total++
+				if e.logRecordBoolExpr == nil {
+					continue
+				}
+
+				logCtx := ottllog.NewTransformContext(sl.LogRecords().At(k), sl.Scope(), rl.Resource(), sl, rl)
+				match, err := e.logRecordBoolExpr.Eval(ctx, logCtx)
+				if err != nil {
+					return nil, err
+				}
+				if match {
+					result.Matched = append(result.Matched, LogRecordIndex{Resource: i, Scope: j, LogRecord: k})
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// MetricIndex locates a metric or one of its data points in the OTLP JSON.
+// DataPoint is -1 when the metric condition matched the whole metric.
+type MetricIndex struct {
+	Resource  int    `json:"resource"`
+	Scope     int    `json:"scope"`
+	Metric    int    `json:"metric"`
+	DataPoint int    `json:"dataPoint"`
+	Name      string `json:"name"`
+}
+
+// MetricResult lists the metrics and data points matched by the conditions
+type MetricResult struct {
+	Matched         []MetricIndex `json:"matched"`
+	TotalMetrics    int           `json:"totalMetrics"`
+	TotalDataPoints int           `json:"totalDataPoints"`
+}
+
+// MetricEvaluator evaluates the metric and datapoint conditions of the filter
+// processor
+type MetricEvaluator struct {
+	metricBoolExpr    *ottl.ConditionSequence[ottlmetric.TransformContext]
+	dataPointBoolExpr *ottl.ConditionSequence[ottldatapoint.TransformContext]
+}
+
+// NewMetricEvaluator creates an evaluator that matches the metrics and data
+// points for which any of the conditions is true
+func NewMetricEvaluator(metricConditions, dataPointConditions []string, errMode ottl.ErrorMode, logger *zap.Logger) (*MetricEvaluator, error) {
+	settings := component.TelemetrySettings{}
+	settings.Logger = logger
+
+	e := &MetricEvaluator{}
+	if len(metricConditions) > 0 {
+		var err error
+		e.metricBoolExpr, err = filter.NewBoolExprForMetric(
+			metricConditions,
+			filter.StandardMetricFuncs(),
+			errMode,
+			settings,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(dataPointConditions) > 0 {
+		var err error
+		e.dataPointBoolExpr, err = filter.NewBoolExprForDataPoint(
+			dataPointConditions,
+			filter.StandardDataPointFuncs(),
+			errMode,
+			settings,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Evaluate returns the metrics and data points matched by the conditions. As
+// in the filter processor, the data points of a matched metric are not
+// evaluated, since the whole metric is dropped.
+func (e *MetricEvaluator) Evaluate(ctx context.Context, metrics pmetric.Metrics) (*MetricResult, error) {
+	result := &MetricResult{Matched: []MetricIndex{}}
+	for i := 0; i < metrics.ResourceMetrics().Len(); i++ {
+		rm := metrics.ResourceMetrics().At(i)
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				metric := sm.Metrics().At(k)
+				result.TotalMetrics++
+				dps := dataPoints(metric)
+				result.TotalDataPoints += len(dps)
+				index := MetricIndex{Resource: i, Scope: j, Metric: k, DataPoint: -1, Name: metric.Name()}
+
+				if e.metricBoolExpr != nil {
+					metricCtx := ottlmetric.NewTransformContext(metric, sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm)
+					match, err := e.metricBoolExpr.Eval(ctx, metricCtx)
+					if err != nil {
+						return nil, err
+					}
+					if match {
+						result.Matched = append(result.Matched, index)
+						continue
+					}
+				}
+
+				if e.dataPointBoolExpr == nil {
+					continue
+				}
+				for l, dp := range dps {
+					dataPointCtx := ottldatapoint.NewTransformContext(dp, metric, sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm)
+					match, err := e.dataPointBoolExpr.Eval(ctx, dataPointCtx)
+					if err != nil {
+						return nil, err
+					}
+					if match {
+						index.DataPoint = l
+						result.Matched = append(result.Matched, index)
+					}
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// dataPoints returns the data points of the metric, whatever its type
+func dataPoints(metric pmetric.Metric) []any {
+	var result []any
+	switch metric.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < metric.Gauge().DataPoints().Len(); i++ {
+			result = append(result, metric.Gauge().DataPoints().At(i))
+		}
+	case pmetric.MetricTypeSum:
+		for i := 0; i < metric.Sum().DataPoints().Len(); i++ {
+			result = append(result, metric.Sum().DataPoints().At(i))
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < metric.Histogram().DataPoints().Len(); i++ {
+			result = append(result, metric.Histogram().DataPoints().At(i))
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := 0; i < metric.ExponentialHistogram().DataPoints().Len(); i++ {
+			result = append(result, metric.ExponentialHistogram().DataPoints().At(i))
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < metric.Summary().DataPoints().Len(); i++ {
+			result = append(result, metric.Summary().DataPoints().At(i))
+		}
+	}
+	return result
+}
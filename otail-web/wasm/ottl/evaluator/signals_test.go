@@ -0,0 +1,146 @@
+package evaluator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// checkoutLogs are the logs of two services in OTLP JSON
+const checkoutLogs = `{"resourceLogs": [
+	{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+		"scopeLogs": [{"logRecords": [
+			{"severityText": "INFO", "severityNumber": 9, "body": {"stringValue": "order placed"}},
+			{"severityText": "ERROR", "severityNumber": 17, "body": {"stringValue": "payment declined"}}
+		]}]
+	},
+	{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "cart"}}]},
+		"scopeLogs": [{"logRecords": [
+			{"severityText": "DEBUG", "severityNumber": 5, "body": {"stringValue": "cart loaded"}}
+		]}]
+	}
+]}`
+
+// checkoutMetrics are a gauge and a sum with two data points in OTLP JSON
+const checkoutMetrics = `{"resourceMetrics": [{
+	"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+	"scopeMetrics": [{"metrics": [
+		{"name": "queue.size", "gauge": {"dataPoints": [{"asInt": "3"}]}},
+		{"name": "http.requests", "sum": {"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [
+			{"asInt": "120", "attributes": [{"key": "http.route", "value": {"stringValue": "/cart"}}]},
+			{"asInt": "7", "attributes": [{"key": "http.route", "value": {"stringValue": "/health"}}]}
+		]}}
+	]}]
+}]}`
+
+func TestLogEvaluator(t *testing.T) {
+	logs, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs([]byte(checkoutLogs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		conditions []string
+		want       []LogRecordIndex
+	}{
+		{name: "no conditions", want: []LogRecordIndex{}},
+		{
+			name:       "severity",
+			conditions: []string{`severity_number >= SEVERITY_NUMBER_ERROR`},
+			want:       []LogRecordIndex{{Resource: 0, Scope: 0, LogRecord: 1}},
+		},
+		{
+			name:       "any condition",
+			conditions: []string{`severity_number >= SEVERITY_NUMBER_ERROR`, `resource.attributes["service.name"] == "cart"`},
+			want:       []LogRecordIndex{{Resource: 0, Scope: 0, LogRecord: 1}, {Resource: 1, Scope: 0, LogRecord: 0}},
+		},
+		{
+			name:       "body",
+			conditions: []string{`IsMatch(body, "^order")`},
+			want:       []LogRecordIndex{{Resource: 0, Scope: 0, LogRecord: 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewLogEvaluator(tt.conditions, ottl.PropagateError, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := e.Evaluate(context.Background(), logs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != 3 {
+				t.Errorf("total = %d, want 3", result.Total)
+			}
+			if !reflect.DeepEqual(result.Matched, tt.want) {
+				t.Errorf("matched = %v, want %v", result.Matched, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewLogEvaluator([]string{`severity_number >=`}, ottl.PropagateError, zap.NewNop()); err == nil {
+		t.Error("NewLogEvaluator() of an invalid condition succeeded")
+	}
+}
+
+func TestMetricEvaluator(t *testing.T) {
+	metrics, err := (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics([]byte(checkoutMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                string
+		metricConditions    []string
+		dataPointConditions []string
+		want                []MetricIndex
+	}{
+		{
+			name:             "metric",
+			metricConditions: []string{`name == "queue.size"`},
+			want:             []MetricIndex{{Metric: 0, DataPoint: -1, Name: "queue.size"}},
+		},
+		{
+			name:                "data point",
+			dataPointConditions: []string{`attributes["http.route"] == "/health"`},
+			want:                []MetricIndex{{Metric: 1, DataPoint: 1, Name: "http.requests"}},
+		},
+		{
+			// The data points of a matched metric are dropped with it
+			name:                "metric and data points",
+			metricConditions:    []string{`name == "http.requests"`},
+			dataPointConditions: []string{`value_int > 0`},
+			want: []MetricIndex{
+				{Metric: 0, DataPoint: 0, Name: "queue.size"},
+				{Metric: 1, DataPoint: -1, Name: "http.requests"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewMetricEvaluator(tt.metricConditions, tt.dataPointConditions, ottl.PropagateError, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := e.Evaluate(context.Background(), metrics)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.TotalMetrics != 2 || result.TotalDataPoints != 3 {
+				t.Errorf("totals = %d metrics, %d data points, want 2 and 3", result.TotalMetrics, result.TotalDataPoints)
+			}
+			if !reflect.DeepEqual(result.Matched, tt.want) {
+				t.Errorf("matched = %v, want %v", result.Matched, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/mottibec/otail/wasm/ottl/filter"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottldatapoint"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottllog"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlmetric"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspan"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspanevent"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// The contexts of the transform processor statements
+const (
+	SpanContext      = "span"
+	SpanEventContext = "spanevent"
+	LogContext       = "log"
+	MetricContext    = "metric"
+	DataPointContext = "datapoint"
+)
+
+// ContextStatements are the statements of a context, as in the
+// trace_statements, log_statements and metric_statements of the transform
+// processor
+type ContextStatements struct {
+	Context    string   `json:"context"`
+	Statements []string `json:"statements"`
+}
+
+// Change is a value added, removed or updated by a statement. The path is in
+// the OTLP JSON, with attributes by key, e.g.
+// resourceSpans[0].scopeSpans[0].spans[1].attributes["http.method"].
+type Change struct {
+	Path   string      `json:"path"`
+	Op     string      `json:"op"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// StatementResult lists the changes made by a statement
+type StatementResult struct {
+	Context   string `json:"context"`
+	Statement string `json:"statement"`
+	// Applied is the number of records the statement's function ran on
+	Applied int      `json:"applied"`
+	Changes []Change `json:"changes"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// TransformResult is the transformed OTLP JSON with the changes made by each
+// statement
+type TransformResult struct {
+	Data       json.RawMessage   `json:"data"`
+	Statements []StatementResult `json:"statements"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Transformer applies transform processor statements to OTLP data.
+//
+// Each statement is applied to all the records before the next one, so that
+// the changes of each statement can be shown. The processor applies all the
+// statements to a record before the next record, which only makes a
+// difference when a statement reads a value that a later statement changes
+// through another record, e.g. a resource attribute.
+type Transformer[D any] struct {
+	steps   []statementStep[D]
+	errMode ottl.ErrorMode
+	marshal func(D) ([]byte, error)
+}
+
+// statementStep applies a statement to every record of the data
+type statementStep[D any] struct {
+	context   string
+	statement string
+	apply     func(ctx context.Context, data D) (applied int, errs []error)
+}
+
+// NewTraceTransformer creates a transformer for span and spanevent statements
+func NewTraceTransformer(groups []ContextStatements, errMode ottl.ErrorMode, logger *zap.Logger) (*Transformer[ptrace.Traces], error) {
+	settings := telemetrySettings(logger)
+	t := &Transformer[ptrace.Traces]{
+		errMode: errMode,
+		marshal: (&ptrace.JSONMarshaler{}).MarshalTraces,
+	}
+	for _, group := range groups {
+		var err error
+		switch group.Context {
+		case SpanContext:
+			var parser ottl.Parser[ottlspan.TransformContext]
+			parser, err = ottlspan.NewParser(filter.StandardSpanStatementFuncs(), settings)
+			if err == nil {
+				t.steps, err = appendStatementSteps(t.steps, parser, group, forEachSpanContext)
+			}
+		case SpanEventContext:
+			var parser ottl.Parser[ottlspanevent.TransformContext]
+			parser, err = ottlspanevent.NewParser(filter.StandardStatementFuncs[ottlspanevent.TransformContext](), settings)
+			if err == nil {
+				t.steps, err = appendStatementSteps(t.steps, parser, group, forEachSpanEventContext)
+			}
+		default:
+			err = fmt.Errorf("unsupported context %q for traces, supported: %s, %s", group.Context, SpanContext, SpanEventContext)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// NewLogTransformer creates a transformer for log statements
+func NewLogTransformer(groups []ContextStatements, errMode ottl.ErrorMode, logger *zap.Logger) (*Transformer[plog.Logs], error) {
+	settings := telemetrySettings(logger)
+	t := &Transformer[plog.Logs]{
+		errMode: errMode,
+		marshal: (&plog.JSONMarshaler{}).MarshalLogs,
+	}
+	for _, group := range groups {
+		if group.Context != LogContext {
+			return nil, fmt.Errorf("unsupported context %q for logs, supported: %s", group.Context, LogContext)
+		}
+		parser, err := ottllog.NewParser(filter.StandardStatementFuncs[ottllog.TransformContext](), settings)
+		if err != nil {
+			return nil, err
+		}
+		t.steps, err = appendStatementSteps(t.steps, parser, group, forEachLogContext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// NewMetricTransformer creates a transformer for metric and datapoint
+// statements. The metric functions of the transform processor, such as
+// convert_sum_to_gauge, are not available.
+func NewMetricTransformer(groups []ContextStatements, errMode ottl.ErrorMode, logger *zap.Logger) (*Transformer[pmetric.Metrics], error) {
+	settings := telemetrySettings(logger)
+	t := &Transformer[pmetric.Metrics]{
+		errMode: errMode,
+		marshal: (&pmetric.JSONMarshaler{}).MarshalMetrics,
+	}
+	for _, group := range groups {
+		var err error
+		switch group.Context {
+		case MetricContext:
+			var parser ottl.Parser[ottlmetric.TransformContext]
+			parser, err = ottlmetric.NewParser(filter.StandardStatementFuncs[ottlmetric.TransformContext](), settings)
+			if err == nil {
+				t.steps, err = appendStatementSteps(t.steps, parser, group, forEachMetricContext)
+			}
+		case DataPointContext:
+			var parser ottl.Parser[ottldatapoint.TransformContext]
+			parser, err = ottldatapoint.NewParser(filter.StandardStatementFuncs[ottldatapoint.TransformContext](), settings)
+			if err == nil {
+				t.steps, err = appendStatementSteps(t.steps, parser, group, forEachDataPointContext)
+			}
+		default:
+			err = fmt.Errorf("unsupported context %q for metrics, supported: %s, %s", group.Context, MetricContext, DataPointContext)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func telemetrySettings(logger *zap.Logger) component.TelemetrySettings {
+	settings := component.TelemetrySettings{}
+	settings.Logger = logger
+	return settings
+}
+
+// appendStatementSteps parses the statements of the group. forEach calls fn
+// with the transform context of each record of the data.
+func appendStatementSteps[D, K any](steps []statementStep[D], parser ottl.Parser[K], group ContextStatements, forEach func(data D, fn func(tCtx K))) ([]statementStep[D], error) {
+	for _, text := range group.Statements {
+		statement, err := parser.ParseStatement(text)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, statementStep[D]{
+			context:   group.Context,
+			statement: text,
+			apply: func(ctx context.Context, data D) (int, []error) {
+				applied := 0
+				var errs []error
+				forEach(data, func(tCtx K) {
+					_, ran, err := statement.Execute(ctx, tCtx)
+					if err != nil {
+						errs = append(errs, err)
+						return
+					}
+					if ran {
+						applied++
+					}
+				})
+				return applied, errs
+			},
+		})
+	}
+	return steps, nil
+}
+
+// Transform applies the statements to the data, in place. With the propagate
+// error mode, a statement that fails stops the transformation and the
+// remaining statements are not applied.
+func (t *Transformer[D]) Transform(ctx context.Context, data D) (*TransformResult, error) {
+	raw, before, err := t.snapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransformResult{Statements: make([]StatementResult, 0, len(t.steps))}
+	for _, step := range t.steps {
+		applied, errs := step.apply(ctx, data)
+		statementResult := StatementResult{
+			Context:   step.context,
+			Statement: step.statement,
+			Applied:   applied,
+		}
+		for _, err := range errs {
+			statementResult.Errors = append(statementResult.Errors, err.Error())
+		}
+
+		var after interface{}
+		raw, after, err = t.snapshot(data)
+		if err != nil {
+			return nil, err
+		}
+		statementResult.Changes = diffValues("", before, after, []Change{})
+		before = after
+		result.Statements = append(result.Statements, statementResult)
+
+		if len(errs) > 0 && t.errMode == ottl.PropagateError {
+			result.Error = fmt.Sprintf("failed to execute statement %q: %v", step.statement, errs[0])
+			break
+		}
+	}
+	result.Data = raw
+	return result, nil
+}
+
+// snapshot returns the OTLP JSON of the data, and its decoded form with the
+// attributes by key
+func (t *Transformer[D]) snapshot(data D) ([]byte, interface{}, error) {
+	raw, err := t.marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, nil, err
+	}
+	return raw, keyAttributes(decoded), nil
+}
+
+// attributeMap is an OTLP JSON list of key values, by key
+type attributeMap map[string]interface{}
+
+func keyAttributes(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = keyAttributes(child)
+		}
+		return v
+	case []interface{}:
+		if attrs, ok := asAttributeMap(v); ok {
+			return attrs
+		}
+		for i, child := range v {
+			v[i] = keyAttributes(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func asAttributeMap(list []interface{}) (attributeMap, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+	attrs := make(attributeMap, len(list))
+	for _, item := range list {
+		kv, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		key, ok := kv["key"].(string)
+		if !ok || len(kv) > 2 {
+			return nil, false
+		}
+		if _, ok := kv["value"]; len(kv) == 2 && !ok {
+			return nil, false
+		}
+		attrs[key] = keyAttributes(kv["value"])
+	}
+	return attrs, true
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// diffValues appends the changes from before to after
+func diffValues(path string, before, after interface{}, changes []Change) []Change {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		if a, ok := after.(map[string]interface{}); ok {
+			return diffMaps(b, a, func(key string) string {
+				if identifierRegexp.MatchString(key) {
+					if path == "" {
+						return key
+					}
+					return path + "." + key
+				}
+				return path + "[" + strconv.Quote(key) + "]"
+			}, changes)
+		}
+	case attributeMap:
+		if a, ok := after.(attributeMap); ok {
+			return diffMaps(b, a, func(key string) string {
+				return path + "[" + strconv.Quote(key) + "]"
+			}, changes)
+		}
+	case []interface{}:
+		if a, ok := after.([]interface{}); ok {
+			for i := 0; i < len(b) || i < len(a); i++ {
+				itemPath := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(a):
+					changes = append(changes, Change{Path: itemPath, Op: "remove", Before: b[i]})
+				case i >= len(b):
+					changes = append(changes, Change{Path: itemPath, Op: "add", After: a[i]})
+				default:
+					changes = diffValues(itemPath, b[i], a[i], changes)
+				}
+			}
+			return changes
+		}
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		changes = append(changes, Change{Path: path, Op: "update", Before: before, After: after})
+	}
+	return changes
+}
+
+func diffMaps[M ~map[string]interface{}](before, after M, childPath func(key string) string, changes []Change) []Change {
+	keys := make([]string, 0, len(before))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b, inBefore := before[key]
+		a, inAfter := after[key]
+		switch {
+		case !inAfter:
+			changes = append(changes, Change{Path: childPath(key), Op: "remove", Before: b})
+		case !inBefore:
+			changes = append(changes, Change{Path: childPath(key), Op: "add", After: a})
+		default:
+			changes = diffValues(childPath(key), b, a, changes)
+		}
+	}
+	return changes
+}
+
+func forEachSpanContext(td ptrace.Traces, fn func(tCtx ottlspan.TransformContext)) {
+	walkSpans(td, func(_ Match, rs ptrace.ResourceSpans, ss ptrace.ScopeSpans, span ptrace.Span) {
+		fn(ottlspan.NewTransformContext(span, ss.Scope(), rs.Resource(), ss, rs))
+	})
+}
+
+func forEachSpanEventContext(td ptrace.Traces, fn func(tCtx ottlspanevent.TransformContext)) {
+	walkSpans(td, func(_ Match, rs ptrace.ResourceSpans, ss ptrace.ScopeSpans, span ptrace.Span) {
+		for i := 0; i < span.Events().Len(); i++ {
+			fn(ottlspanevent.NewTransformContext(span.Events().At(i), span, ss.Scope(), rs.Resource(), ss, rs))
+		}
+	})
+}
+
+func forEachLogContext(ld plog.Logs, fn func(tCtx ottllog.TransformContext)) {
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			sl := rl.ScopeLogs().At(j)
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				fn(ottllog.NewTransformContext(sl.LogRecords().At(k), sl.Scope(), rl.Resource(), sl, rl))
+			}
+		}
+	}
+}
+
+func forEachMetricContext(md pmetric.Metrics, fn func(tCtx ottlmetric.TransformContext)) {
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				fn(ottlmetric.NewTransformContext(sm.Metrics().At(k), sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm))
+			}
+		}
+	}
+}
+
+func forEachDataPointContext(md pmetric.Metrics, fn func(tCtx ottldatapoint.TransformContext)) {
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				metric := sm.Metrics().At(k)
+				for _, dp := range dataPoints(metric) {
+					fn(ottldatapoint.NewTransformContext(dp, metric, sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm))
+				}
+			}
+		}
+	}
+}
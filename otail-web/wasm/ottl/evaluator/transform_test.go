@@ -0,0 +1,197 @@
+package evaluator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+const spansPath = "resourceSpans[0].scopeSpans[0].spans"
+
+func TestTraceTransformer(t *testing.T) {
+	transformer, err := NewTraceTransformer([]ContextStatements{
+		{Context: SpanContext, Statements: []string{
+			`set(attributes["env"], "prod")`,
+			`delete_key(attributes, "http.status_code") where name == "GET /cart"`,
+			`replace_pattern(name, "^POST ", "post ")`,
+		}},
+		{Context: SpanEventContext, Statements: []string{
+			`set(attributes["handled"], false) where name == "exception"`,
+		}},
+	}, ottl.PropagateError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := transformer.Transform(context.Background(), unmarshalTraces(t, checkoutTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" {
+		t.Fatalf("error = %q", result.Error)
+	}
+
+	want := []StatementResult{
+		{
+			Context:   SpanContext,
+			Statement: `set(attributes["env"], "prod")`,
+			Applied:   2,
+			Changes: []Change{
+				{Path: spansPath + `[0].attributes["env"]`, Op: "add", After: map[string]interface{}{"stringValue": "prod"}},
+				{Path: spansPath + `[1].attributes["env"]`, Op: "add", After: map[string]interface{}{"stringValue": "prod"}},
+			},
+		},
+		{
+			Context:   SpanContext,
+			Statement: `delete_key(attributes, "http.status_code") where name == "GET /cart"`,
+			Applied:   1,
+			Changes: []Change{
+				{Path: spansPath + `[0].attributes["http.status_code"]`, Op: "remove", Before: map[string]interface{}{"intValue": "200"}},
+			},
+		},
+		{
+			Context:   SpanContext,
+			Statement: `replace_pattern(name, "^POST ", "post ")`,
+			Applied:   2,
+			Changes: []Change{
+				{Path: spansPath + `[1].name`, Op: "update", Before: "POST /checkout", After: "post /checkout"},
+			},
+		},
+		{
+			Context:   SpanEventContext,
+			Statement: `set(attributes["handled"], false) where name == "exception"`,
+			Applied:   1,
+			Changes: []Change{
+				{Path: spansPath + `[1].events[0].attributes`, Op: "add", After: attributeMap{
+					"handled": map[string]interface{}{"boolValue": false},
+				}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result.Statements, want) {
+		t.Errorf("statements = %+v, want %+v", result.Statements, want)
+	}
+
+	transformed, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(result.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := transformed.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(1)
+	if span.Name() != "post /checkout" {
+		t.Errorf("transformed span name = %q, want post /checkout", span.Name())
+	}
+}
+
+func TestTransformErrorMode(t *testing.T) {
+	statements := []ContextStatements{{Context: SpanContext, Statements: []string{
+		`set(attributes["length"], Len(attributes["http.status_code"]))`,
+		`set(attributes["env"], "prod")`,
+	}}}
+
+	propagate, err := NewTraceTransformer(statements, ottl.PropagateError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := propagate.Transform(context.Background(), unmarshalTraces(t, checkoutTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Error, "Len") || len(result.Statements) != 1 {
+		t.Errorf("propagated result = %+v, want the error of the first statement only", result)
+	}
+
+	ignore, err := NewTraceTransformer(statements, ottl.IgnoreError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = ignore.Transform(context.Background(), unmarshalTraces(t, checkoutTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" || len(result.Statements) != 2 {
+		t.Fatalf("ignored result = %+v, want both statements applied", result)
+	}
+	if first := result.Statements[0]; len(first.Errors) != 2 || len(first.Changes) != 0 {
+		t.Errorf("first statement = %+v, want an error for each span", first)
+	}
+	if second := result.Statements[1]; second.Applied != 2 || len(second.Changes) != 2 {
+		t.Errorf("second statement = %+v, want it applied to both spans", second)
+	}
+}
+
+func TestLogAndMetricTransformers(t *testing.T) {
+	logTransformer, err := NewLogTransformer([]ContextStatements{{Context: LogContext, Statements: []string{
+		`set(severity_text, "WARN") where severity_number == SEVERITY_NUMBER_ERROR`,
+	}}}, ottl.PropagateError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs([]byte(checkoutLogs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := logTransformer.Transform(context.Background(), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLogChanges := []Change{{
+		Path:   "resourceLogs[0].scopeLogs[0].logRecords[1].severityText",
+		Op:     "update",
+		Before: "ERROR",
+		After:  "WARN",
+	}}
+	if len(result.Statements) != 1 || !reflect.DeepEqual(result.Statements[0].Changes, wantLogChanges) {
+		t.Errorf("log statements = %+v, want %+v", result.Statements, wantLogChanges)
+	}
+
+	metricTransformer, err := NewMetricTransformer([]ContextStatements{
+		{Context: MetricContext, Statements: []string{`set(description, "Size of the order queue") where name == "queue.size"`}},
+		{Context: DataPointContext, Statements: []string{`delete_key(attributes, "http.route")`}},
+	}, ottl.PropagateError, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics([]byte(checkoutMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = metricTransformer.Transform(context.Background(), metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Statements) != 2 {
+		t.Fatalf("metric statements = %+v, want 2", result.Statements)
+	}
+	const metricsPath = "resourceMetrics[0].scopeMetrics[0].metrics"
+	if changes := result.Statements[0].Changes; len(changes) != 1 || changes[0].Path != metricsPath+"[0].description" {
+		t.Errorf("metric changes = %+v, want the description of queue.size", changes)
+	}
+	if dataPoints := result.Statements[1]; dataPoints.Applied != 3 || len(dataPoints.Changes) != 2 {
+		t.Errorf("data point statement = %+v, want the routes of both sum data points removed", dataPoints)
+	}
+}
+
+func TestTransformerContexts(t *testing.T) {
+	log := []ContextStatements{{Context: LogContext, Statements: []string{`set(attributes["a"], "b")`}}}
+	if _, err := NewTraceTransformer(log, ottl.PropagateError, zap.NewNop()); err == nil {
+		t.Error("NewTraceTransformer() of log statements succeeded")
+	}
+	if _, err := NewMetricTransformer(log, ottl.PropagateError, zap.NewNop()); err == nil {
+		t.Error("NewMetricTransformer() of log statements succeeded")
+	}
+	span := []ContextStatements{{Context: SpanContext, Statements: []string{`set(attributes["a"], "b")`}}}
+	if _, err := NewLogTransformer(span, ottl.PropagateError, zap.NewNop()); err == nil {
+		t.Error("NewLogTransformer() of span statements succeeded")
+	}
+	invalid := []ContextStatements{{Context: SpanContext, Statements: []string{`set(attributes["a"]`}}}
+	if _, err := NewTraceTransformer(invalid, ottl.PropagateError, zap.NewNop()); err == nil {
+		t.Error("NewTraceTransformer() of an invalid statement succeeded")
+	}
+}
@@ -0,0 +1,332 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottldatapoint"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottllog"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlmetric"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlresource"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlscope"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspan"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspanevent"
+)
+
+// ExpressionKind is whether an expression is a condition, as in the filter
+// processor, or a statement, as in the transform processor
+type ExpressionKind string
+
+const (
+	ConditionExpression ExpressionKind = "condition"
+	StatementExpression ExpressionKind = "statement"
+)
+
+// Position is a 1-based line and column in an expression
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Range is the part of an expression a diagnostic is about. End is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic is an error found in an expression
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Range    Range  `json:"range"`
+}
+
+// Completion is a suggestion for the partial expression before the cursor.
+// Start and End are the byte offsets of the text it replaces.
+type Completion struct {
+	Label  string `json:"label"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+var conditionKeywords = []string{"and", "or", "not", "true", "false", "nil"}
+
+// contextAnalyzer parses the expressions of an OTTL context
+type contextAnalyzer struct {
+	parseCondition     func(condition string) error
+	parseStatement     func(statement string) error
+	conditionFunctions []FunctionInfo
+	statementFunctions []FunctionInfo
+	paths              []PathInfo
+	enums              []string
+}
+
+func newAnalyzer[K any](
+	conditionFuncs map[string]ottl.Factory[K],
+	statementFuncs map[string]ottl.Factory[K],
+	newParser func(map[string]ottl.Factory[K]) (ottl.Parser[K], error),
+	paths []PathInfo,
+	enums []string,
+) (*contextAnalyzer, error) {
+	conditionParser, err := newParser(conditionFuncs)
+	if err != nil {
+		return nil, err
+	}
+	statementParser, err := newParser(statementFuncs)
+	if err != nil {
+		return nil, err
+	}
+	return &contextAnalyzer{
+		parseCondition: func(condition string) error {
+			_, err := conditionParser.ParseCondition(condition)
+			return err
+		},
+		parseStatement: func(statement string) error {
+			_, err := statementParser.ParseStatement(statement)
+			return err
+		},
+		conditionFunctions: functionInfos(conditionFuncs),
+		statementFunctions: functionInfos(statementFuncs),
+		paths:              paths,
+		enums:              enums,
+	}, nil
+}
+
+var (
+	analyzersMu sync.Mutex
+	// analyzers are created once per context, since creating the parsers
+	// is too slow to do on every keystroke
+	analyzers = make(map[string]*contextAnalyzer)
+)
+
+// getContextAnalyzer returns the analyzer of the context
+func getContextAnalyzer(contextName string) (*contextAnalyzer, error) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	if analyzer, ok := analyzers[contextName]; ok {
+		return analyzer, nil
+	}
+	analyzer, err := newContextAnalyzer(contextName)
+	if err != nil {
+		return nil, err
+	}
+	analyzers[contextName] = analyzer
+	return analyzer, nil
+}
+
+// newContextAnalyzer creates the analyzer of the context, with the functions
+// of the filter processor for conditions and of the transform processor for
+// statements
+func newContextAnalyzer(contextName string) (*contextAnalyzer, error) {
+	set := component.TelemetrySettings{}
+	set.Logger = zap.NewNop()
+
+	switch contextName {
+	case ottlspan.ContextName:
+		return newAnalyzer(StandardSpanFuncs(), StandardSpanStatementFuncs(),
+			func(functions map[string]ottl.Factory[ottlspan.TransformContext]) (ottl.Parser[ottlspan.TransformContext], error) {
+				return ottlspan.NewParser(functions, set)
+			},
+			joinPaths(spanPaths, withPrefix("resource", resourcePaths), withPrefix("instrumentation_scope", scopePaths)),
+			spanEnums,
+		)
+	case ottlspanevent.ContextName:
+		return newAnalyzer(StandardSpanEventFuncs(), StandardStatementFuncs[ottlspanevent.TransformContext](),
+			func(functions map[string]ottl.Factory[ottlspanevent.TransformContext]) (ottl.Parser[ottlspanevent.TransformContext], error) {
+				return ottlspanevent.NewParser(functions, set)
+			},
+			joinPaths(spanEventPaths, withPrefix("span", spanPaths), withPrefix("resource", resourcePaths), withPrefix("instrumentation_scope", scopePaths)),
+			spanEnums,
+		)
+	case ottllog.ContextName:
+		return newAnalyzer(StandardLogFuncs(), StandardStatementFuncs[ottllog.TransformContext](),
+			func(functions map[string]ottl.Factory[ottllog.TransformContext]) (ottl.Parser[ottllog.TransformContext], error) {
+				return ottllog.NewParser(functions, set)
+			},
+			joinPaths(logPaths, withPrefix("resource", resourcePaths), withPrefix("instrumentation_scope", scopePaths)),
+			logEnums,
+		)
+	case ottlmetric.ContextName:
+		return newAnalyzer(StandardMetricFuncs(), StandardStatementFuncs[ottlmetric.TransformContext](),
+			func(functions map[string]ottl.Factory[ottlmetric.TransformContext]) (ottl.Parser[ottlmetric.TransformContext], error) {
+				return ottlmetric.NewParser(functions, set)
+			},
+			joinPaths(metricPaths, withPrefix("resource", resourcePaths), withPrefix("instrumentation_scope", scopePaths)),
+			metricEnums,
+		)
+	case ottldatapoint.ContextName:
+		return newAnalyzer(StandardDataPointFuncs(), StandardStatementFuncs[ottldatapoint.TransformContext](),
+			func(functions map[string]ottl.Factory[ottldatapoint.TransformContext]) (ottl.Parser[ottldatapoint.TransformContext], error) {
+				return ottldatapoint.NewParser(functions, set)
+			},
+			joinPaths(dataPointPaths, withPrefix("metric", metricPaths), withPrefix("resource", resourcePaths), withPrefix("instrumentation_scope", scopePaths)),
+			dataPointEnums,
+		)
+	case ottlresource.ContextName:
+		return newAnalyzer(StandardResourceFuncs(), StandardStatementFuncs[ottlresource.TransformContext](),
+			func(functions map[string]ottl.Factory[ottlresource.TransformContext]) (ottl.Parser[ottlresource.TransformContext], error) {
+				return ottlresource.NewParser(functions, set)
+			},
+			resourcePaths,
+			nil,
+		)
+	case ottlscope.ContextName:
+		return newAnalyzer(StandardScopeFuncs(), StandardStatementFuncs[ottlscope.TransformContext](),
+			func(functions map[string]ottl.Factory[ottlscope.TransformContext]) (ottl.Parser[ottlscope.TransformContext], error) {
+				return ottlscope.NewParser(functions, set)
+			},
+			joinPaths(scopePaths, withPrefix("resource", resourcePaths)),
+			nil,
+		)
+	default:
+		return nil, fmt.Errorf("unknown context %q", contextName)
+	}
+}
+
+func (a *contextAnalyzer) functions(kind ExpressionKind) []FunctionInfo {
+	if kind == StatementExpression {
+		return a.statementFunctions
+	}
+	return a.conditionFunctions
+}
+
+// GetCatalogue lists the paths, functions and enums of the context
+func GetCatalogue(contextName string, kind ExpressionKind) (*Catalogue, error) {
+	analyzer, err := getContextAnalyzer(contextName)
+	if err != nil {
+		return nil, err
+	}
+	enums := analyzer.enums
+	if enums == nil {
+		enums = []string{}
+	}
+	return &Catalogue{
+		Context:   contextName,
+		Paths:     analyzer.paths,
+		Functions: analyzer.functions(kind),
+		Enums:     enums,
+	}, nil
+}
+
+// Analyze parses the expression and returns its errors with their position.
+// The error is only returned for an unknown context.
+func Analyze(contextName string, expression string, kind ExpressionKind) ([]Diagnostic, error) {
+	analyzer, err := getContextAnalyzer(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	parse := analyzer.parseCondition
+	if kind == StatementExpression {
+		parse = analyzer.parseStatement
+	}
+	diagnostics := []Diagnostic{}
+	if strings.TrimSpace(expression) == "" {
+		return diagnostics, nil
+	}
+	if err := parse(expression); err != nil {
+		for _, err := range joinedErrors(err) {
+			diagnostics = append(diagnostics, Diagnostic{
+				Severity: "error",
+				Message:  err.Error(),
+				Range:    errorRange(expression, err),
+			})
+		}
+	}
+	return diagnostics, nil
+}
+
+// joinedErrors returns the errors the parser joined into err
+func joinedErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, joinedErrors(err)...)
+	}
+	return errs
+}
+
+// Complete suggests the paths, functions, enums and keywords that start with
+// the partial path or name before the cursor, a byte offset in the
+// expression
+func Complete(contextName string, expression string, cursor int, kind ExpressionKind) ([]Completion, error) {
+	analyzer, err := getContextAnalyzer(contextName)
+	if err != nil {
+		return nil, err
+	}
+	if cursor < 0 || cursor > len(expression) {
+		return nil, errors.New("cursor out of range")
+	}
+
+	completions := []Completion{}
+	before := expression[:cursor]
+	if inStringLiteral(before) {
+		return completions, nil
+	}
+	start := cursor
+	for start > 0 && (isNameChar(before[start-1]) || before[start-1] == '.') {
+		start--
+	}
+	prefix := strings.ToLower(before[start:])
+
+	add := func(label, completionKind, detail string) {
+		if strings.HasPrefix(strings.ToLower(label), prefix) {
+			completions = append(completions, Completion{
+				Label:  label,
+				Kind:   completionKind,
+				Detail: detail,
+				Start:  start,
+				End:    cursor,
+			})
+		}
+	}
+	for _, path := range analyzer.paths {
+		add(path.Path, "path", path.Type)
+	}
+	// Functions, enums and keywords are not part of a path
+	if strings.Contains(prefix, ".") {
+		return completions, nil
+	}
+	for _, function := range analyzer.functions(kind) {
+		add(function.Name, function.Kind, function.Signature)
+	}
+	for _, enum := range analyzer.enums {
+		add(enum, "enum", "")
+	}
+	for _, keyword := range conditionKeywords {
+		add(keyword, "keyword", "")
+	}
+	if kind == StatementExpression {
+		add("where", "keyword", "")
+	}
+	return completions, nil
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+func inStringLiteral(text string) bool {
+	inString := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case inString && text[i] == '\\':
+			i++
+		case text[i] == '"':
+			inString = !inString
+		}
+	}
+	return inString
+}
@@ -0,0 +1,69 @@
+package filter
+
+import (
+	"testing"
+)
+
+func TestAnalyzeErrorRanges(t *testing.T) {
+	span := func(line, start, end int) Range {
+		return Range{Start: Position{Line: line, Column: start}, End: Position{Line: line, Column: end}}
+	}
+	tests := []struct {
+		name       string
+		expression string
+		want       Range
+	}{
+		{name: "unexpected token", expression: `name == == 1`, want: span(1, 9, 11)},
+		{name: "unexpected end", expression: `isMatch(name, "a")`, want: span(1, 19, 20)},
+		{name: "undefined function", expression: `name == "Foo" or Foo(name) == 1`, want: span(1, 18, 21)},
+		{name: "invalid argument", expression: `IsMatch(name, 1)`, want: span(1, 15, 16)},
+		{name: "invalid nested argument", expression: `name == 1 and IsMatch(attributes["x"], Concat(["a", 1], 2))`, want: span(1, 40, 59)},
+		{name: "wrong number of arguments", expression: `IsMatch(name)`, want: span(1, 1, 8)},
+		{name: "invalid path segment", expression: `span.foo == 1`, want: span(1, 1, 5)},
+		{name: "path inside other names", expression: `attributes["foo.bar"] == "x" and foo.bar == 1`, want: span(1, 34, 37)},
+		{name: "indexed path", expression: `name["x"] == 1`, want: span(1, 1, 5)},
+		{name: "unknown enum", expression: `attributes["SPAN_KIND_FOO"] == SPAN_KIND_FOO`, want: span(1, 32, 45)},
+		{name: "second line", expression: "name == \"a\" and\nfoo.bar == 1", want: span(2, 1, 4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics, err := Analyze("span", tt.expression, ConditionExpression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diagnostics) != 1 {
+				t.Fatalf("Analyze(%q) = %+v, want one diagnostic", tt.expression, diagnostics)
+			}
+			if got := diagnostics[0].Range; got != tt.want {
+				t.Errorf("range of %q = %+v, want %+v", diagnostics[0].Message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeValidExpression(t *testing.T) {
+	diagnostics, err := Analyze("span", `IsMatch(name, "^GET ") and kind == SPAN_KIND_SERVER`, ConditionExpression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("Analyze() = %+v, want no diagnostics", diagnostics)
+	}
+	if _, err := Analyze("nothing", "name", ConditionExpression); err == nil {
+		t.Error("Analyze() of an unknown context succeeded")
+	}
+}
+
+func TestContextAnalyzerIsCached(t *testing.T) {
+	first, err := getContextAnalyzer("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getContextAnalyzer("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("analyzer of the context was created again")
+	}
+}
@@ -0,0 +1,266 @@
+package filter
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+)
+
+// PathInfo is a path of an OTTL context. Keyed paths take a key, e.g.
+// attributes["http.method"].
+type PathInfo struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Keyed bool   `json:"keyed,omitempty"`
+}
+
+// ParameterInfo is a parameter of an OTTL function
+type ParameterInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// FunctionInfo is an OTTL function. Editors can only be used in statements.
+type FunctionInfo struct {
+	Name       string          `json:"name"`
+	Kind       string          `json:"kind"`
+	Parameters []ParameterInfo `json:"parameters"`
+	Signature  string          `json:"signature"`
+}
+
+// Catalogue lists the paths, functions and enums of an OTTL context
+type Catalogue struct {
+	Context   string         `json:"context"`
+	Paths     []PathInfo     `json:"paths"`
+	Functions []FunctionInfo `json:"functions"`
+	Enums     []string       `json:"enums"`
+}
+
+// The paths of the contexts, following ottl v0.121
+
+var resourcePaths = []PathInfo{
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "dropped_attributes_count", Type: "int"},
+	{Path: "schema_url", Type: "string"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var scopePaths = []PathInfo{
+	{Path: "name", Type: "string"},
+	{Path: "version", Type: "string"},
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "dropped_attributes_count", Type: "int"},
+	{Path: "schema_url", Type: "string"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var spanPaths = []PathInfo{
+	{Path: "trace_id", Type: "bytes"},
+	{Path: "trace_id.string", Type: "string"},
+	{Path: "span_id", Type: "bytes"},
+	{Path: "span_id.string", Type: "string"},
+	{Path: "parent_span_id", Type: "bytes"},
+	{Path: "parent_span_id.string", Type: "string"},
+	{Path: "trace_state", Type: "string", Keyed: true},
+	{Path: "name", Type: "string"},
+	{Path: "kind", Type: "enum"},
+	{Path: "kind.string", Type: "string"},
+	{Path: "kind.deprecated_string", Type: "string"},
+	{Path: "start_time_unix_nano", Type: "int"},
+	{Path: "end_time_unix_nano", Type: "int"},
+	{Path: "start_time", Type: "time"},
+	{Path: "end_time", Type: "time"},
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "dropped_attributes_count", Type: "int"},
+	{Path: "events", Type: "slice"},
+	{Path: "dropped_events_count", Type: "int"},
+	{Path: "links", Type: "slice"},
+	{Path: "dropped_links_count", Type: "int"},
+	{Path: "status", Type: "status"},
+	{Path: "status.code", Type: "enum"},
+	{Path: "status.message", Type: "string"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var spanEventPaths = []PathInfo{
+	{Path: "time_unix_nano", Type: "int"},
+	{Path: "time", Type: "time"},
+	{Path: "name", Type: "string"},
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "dropped_attributes_count", Type: "int"},
+	{Path: "event_index", Type: "int"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var logPaths = []PathInfo{
+	{Path: "time_unix_nano", Type: "int"},
+	{Path: "observed_time_unix_nano", Type: "int"},
+	{Path: "time", Type: "time"},
+	{Path: "observed_time", Type: "time"},
+	{Path: "severity_number", Type: "enum"},
+	{Path: "severity_text", Type: "string"},
+	{Path: "body", Type: "any", Keyed: true},
+	{Path: "body.string", Type: "string"},
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "dropped_attributes_count", Type: "int"},
+	{Path: "flags", Type: "int"},
+	{Path: "trace_id", Type: "bytes"},
+	{Path: "trace_id.string", Type: "string"},
+	{Path: "span_id", Type: "bytes"},
+	{Path: "span_id.string", Type: "string"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var metricPaths = []PathInfo{
+	{Path: "name", Type: "string"},
+	{Path: "description", Type: "string"},
+	{Path: "unit", Type: "string"},
+	{Path: "type", Type: "enum"},
+	{Path: "aggregation_temporality", Type: "enum"},
+	{Path: "is_monotonic", Type: "bool"},
+	{Path: "data_points", Type: "slice"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var dataPointPaths = []PathInfo{
+	{Path: "attributes", Type: "map", Keyed: true},
+	{Path: "start_time_unix_nano", Type: "int"},
+	{Path: "time_unix_nano", Type: "int"},
+	{Path: "start_time", Type: "time"},
+	{Path: "time", Type: "time"},
+	{Path: "value_double", Type: "float"},
+	{Path: "value_int", Type: "int"},
+	{Path: "exemplars", Type: "slice"},
+	{Path: "flags", Type: "int"},
+	{Path: "count", Type: "int"},
+	{Path: "sum", Type: "float"},
+	{Path: "bucket_counts", Type: "slice"},
+	{Path: "explicit_bounds", Type: "slice"},
+	{Path: "scale", Type: "int"},
+	{Path: "zero_count", Type: "int"},
+	{Path: "positive", Type: "buckets"},
+	{Path: "positive.offset", Type: "int"},
+	{Path: "positive.bucket_counts", Type: "slice"},
+	{Path: "negative", Type: "buckets"},
+	{Path: "negative.offset", Type: "int"},
+	{Path: "negative.bucket_counts", Type: "slice"},
+	{Path: "quantile_values", Type: "slice"},
+	{Path: "cache", Type: "map", Keyed: true},
+}
+
+var (
+	spanEnums = []string{
+		"SPAN_KIND_UNSPECIFIED", "SPAN_KIND_INTERNAL", "SPAN_KIND_SERVER", "SPAN_KIND_CLIENT",
+		"SPAN_KIND_PRODUCER", "SPAN_KIND_CONSUMER",
+		"STATUS_CODE_UNSET", "STATUS_CODE_OK", "STATUS_CODE_ERROR",
+	}
+	logEnums = []string{
+		"SEVERITY_NUMBER_UNSPECIFIED",
+		"SEVERITY_NUMBER_TRACE", "SEVERITY_NUMBER_TRACE2", "SEVERITY_NUMBER_TRACE3", "SEVERITY_NUMBER_TRACE4",
+		"SEVERITY_NUMBER_DEBUG", "SEVERITY_NUMBER_DEBUG2", "SEVERITY_NUMBER_DEBUG3", "SEVERITY_NUMBER_DEBUG4",
+		"SEVERITY_NUMBER_INFO", "SEVERITY_NUMBER_INFO2", "SEVERITY_NUMBER_INFO3", "SEVERITY_NUMBER_INFO4",
+		"SEVERITY_NUMBER_WARN", "SEVERITY_NUMBER_WARN2", "SEVERITY_NUMBER_WARN3", "SEVERITY_NUMBER_WARN4",
+		"SEVERITY_NUMBER_ERROR", "SEVERITY_NUMBER_ERROR2", "SEVERITY_NUMBER_ERROR3", "SEVERITY_NUMBER_ERROR4",
+		"SEVERITY_NUMBER_FATAL", "SEVERITY_NUMBER_FATAL2", "SEVERITY_NUMBER_FATAL3", "SEVERITY_NUMBER_FATAL4",
+	}
+	metricEnums = []string{
+		"AGGREGATION_TEMPORALITY_UNSPECIFIED", "AGGREGATION_TEMPORALITY_DELTA", "AGGREGATION_TEMPORALITY_CUMULATIVE",
+		"METRIC_DATA_TYPE_NONE", "METRIC_DATA_TYPE_GAUGE", "METRIC_DATA_TYPE_SUM", "METRIC_DATA_TYPE_HISTOGRAM",
+		"METRIC_DATA_TYPE_EXPONENTIAL_HISTOGRAM", "METRIC_DATA_TYPE_SUMMARY",
+	}
+	dataPointEnums = append([]string{"FLAG_NONE", "FLAG_NO_RECORDED_VALUE"}, metricEnums...)
+)
+
+// withPrefix returns the paths of a higher context, e.g. resource.attributes.
+// The cache can only be accessed in its own context.
+func withPrefix(prefix string, paths []PathInfo) []PathInfo {
+	var result []PathInfo
+	for _, path := range paths {
+		if path.Path == "cache" {
+			continue
+		}
+		path.Path = prefix + "." + path.Path
+		result = append(result, path)
+	}
+	return result
+}
+
+func joinPaths(paths ...[]PathInfo) []PathInfo {
+	var result []PathInfo
+	for _, p := range paths {
+		result = append(result, p...)
+	}
+	return result
+}
+
+// functionInfos describes the functions from the arguments they take
+func functionInfos[K any](functions map[string]ottl.Factory[K]) []FunctionInfo {
+	result := make([]FunctionInfo, 0, len(functions))
+	for name, factory := range functions {
+		info := FunctionInfo{Name: name, Kind: "editor", Parameters: []ParameterInfo{}}
+		if unicode.IsUpper([]rune(name)[0]) {
+			info.Kind = "converter"
+		}
+
+		if args := factory.CreateDefaultArguments(); args != nil {
+			argsType := reflect.TypeOf(args)
+			if argsType.Kind() == reflect.Ptr {
+				argsType = argsType.Elem()
+			}
+			if argsType.Kind() == reflect.Struct {
+				for i := 0; i < argsType.NumField(); i++ {
+					info.Parameters = append(info.Parameters, parameterInfo(argsType.Field(i)))
+				}
+			}
+		}
+
+		params := make([]string, 0, len(info.Parameters))
+		for _, param := range info.Parameters {
+			if param.Optional {
+				params = append(params, "Optional "+param.Name+" "+param.Type)
+			} else {
+				params = append(params, param.Name+" "+param.Type)
+			}
+		}
+		info.Signature = name + "(" + strings.Join(params, ", ") + ")"
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+var (
+	contextTypeParam = regexp.MustCompile(`\[[^\[\]]*TransformContext\]`)
+	packagePrefix    = regexp.MustCompile(`[\w.\-]+/|ottl\.`)
+	optionalType     = regexp.MustCompile(`^Optional\[(.*)\]$`)
+)
+
+func parameterInfo(field reflect.StructField) ParameterInfo {
+	typ := contextTypeParam.ReplaceAllString(field.Type.String(), "")
+	typ = packagePrefix.ReplaceAllString(typ, "")
+	param := ParameterInfo{Name: snakeCase(field.Name), Type: typ}
+	if m := optionalType.FindStringSubmatch(typ); m != nil {
+		param.Type = m[1]
+		param.Optional = true
+	}
+	return param
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -18,14 +18,26 @@ import (
 )
 
 func StandardSpanFuncs() map[string]ottl.Factory[ottlspan.TransformContext] {
+	return SpanFuncs(CollectorVersion())
+}
+
+func StandardSpanEventFuncs() map[string]ottl.Factory[ottlspanevent.TransformContext] {
+	return SpanEventFuncs(CollectorVersion())
+}
+
+// SpanFuncs returns the span condition functions of the collector-contrib
+// version
+func SpanFuncs(version string) map[string]ottl.Factory[ottlspan.TransformContext] {
 	m := ottlfuncs.StandardConverters[ottlspan.TransformContext]()
 	isRootSpanFactory := ottlfuncs.NewIsRootSpanFactory()
 	m[isRootSpanFactory.Name()] = isRootSpanFactory
-	return m
+	return forVersion(version, m)
 }
 
-func StandardSpanEventFuncs() map[string]ottl.Factory[ottlspanevent.TransformContext] {
-	return ottlfuncs.StandardConverters[ottlspanevent.TransformContext]()
+// SpanEventFuncs returns the span event condition functions of the
+// collector-contrib version
+func SpanEventFuncs(version string) map[string]ottl.Factory[ottlspanevent.TransformContext] {
+	return forVersion(version, ottlfuncs.StandardConverters[ottlspanevent.TransformContext]())
 }
 
 func StandardMetricFuncs() map[string]ottl.Factory[ottlmetric.TransformContext] {
@@ -34,23 +46,36 @@ func StandardMetricFuncs() map[string]ottl.Factory[ottlmetric.TransformContext]
 	hasAttributeKeyOnDatapointFactory := newHasAttributeKeyOnDatapointFactory()
 	m[hasAttributeOnDatapointFactory.Name()] = hasAttributeOnDatapointFactory
 	m[hasAttributeKeyOnDatapointFactory.Name()] = hasAttributeKeyOnDatapointFactory
-	return m
+	return forVersion(CollectorVersion(), m)
 }
 
 func StandardDataPointFuncs() map[string]ottl.Factory[ottldatapoint.TransformContext] {
-	return ottlfuncs.StandardConverters[ottldatapoint.TransformContext]()
+	return forVersion(CollectorVersion(), ottlfuncs.StandardConverters[ottldatapoint.TransformContext]())
 }
 
 func StandardScopeFuncs() map[string]ottl.Factory[ottlscope.TransformContext] {
-	return ottlfuncs.StandardConverters[ottlscope.TransformContext]()
+	return forVersion(CollectorVersion(), ottlfuncs.StandardConverters[ottlscope.TransformContext]())
 }
 
 func StandardLogFuncs() map[string]ottl.Factory[ottllog.TransformContext] {
-	return ottlfuncs.StandardConverters[ottllog.TransformContext]()
+	return forVersion(CollectorVersion(), ottlfuncs.StandardConverters[ottllog.TransformContext]())
 }
 
 func StandardResourceFuncs() map[string]ottl.Factory[ottlresource.TransformContext] {
-	return ottlfuncs.StandardConverters[ottlresource.TransformContext]()
+	return forVersion(CollectorVersion(), ottlfuncs.StandardConverters[ottlresource.TransformContext]())
+}
+
+// StandardStatementFuncs are the functions of transform processor statements,
+// editors and converters
+func StandardStatementFuncs[K any]() map[string]ottl.Factory[K] {
+	return forVersion(CollectorVersion(), ottlfuncs.StandardFuncs[K]())
+}
+
+func StandardSpanStatementFuncs() map[string]ottl.Factory[ottlspan.TransformContext] {
+	m := ottlfuncs.StandardFuncs[ottlspan.TransformContext]()
+	isRootSpanFactory := ottlfuncs.NewIsRootSpanFactory()
+	m[isRootSpanFactory.Name()] = isRootSpanFactory
+	return forVersion(CollectorVersion(), m)
 }
 
 type hasAttributeOnDatapointArguments struct {
@@ -0,0 +1,252 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/alecthomas/participle/v2"
+)
+
+// The OTTL parser only reports the position of syntax errors. The other
+// errors are located by the path, function, argument or enum they name,
+// which are looked up in the tokens of the expression.
+var (
+	// A call of which an argument is invalid, e.g.
+	// error while parsing arguments for call to "IsMatch": invalid argument at position 1: must be a string
+	argumentErrorRegexp = regexp.MustCompile(`call to "([^"]+)": invalid argument at position (\d+): `)
+	callErrorRegexp     = regexp.MustCompile(`call to "([^"]+)"`)
+	functionErrorRegexp = regexp.MustCompile(`undefined function "?([A-Za-z_][A-Za-z0-9_]*)"?|but got '([A-Za-z_][A-Za-z0-9_]*)'`)
+	segmentErrorRegexp  = regexp.MustCompile(`segment "([^"]+)" from path "([^"]+)"`)
+	pathErrorRegexp     = regexp.MustCompile(`(?:from path|for path|path section|keys indexing) "([^"]+)"`)
+	enumErrorRegexp     = regexp.MustCompile(`enum symbol, ([A-Za-z0-9_]+), not found`)
+)
+
+type tokenKind int
+
+const (
+	nameToken tokenKind = iota
+	stringToken
+	symbolToken
+)
+
+// token is a name, string literal or symbol of an expression. start and end
+// are byte offsets, end is exclusive.
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+// tokenize splits the expression into names, which include numbers and
+// keywords, string literals and single character symbols
+func tokenize(expression string) []token {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		start := i
+		switch ch := expression[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+			continue
+		case ch == '"':
+			for i++; i < len(expression) && expression[i] != '"'; i++ {
+				if expression[i] == '\\' {
+					i++
+				}
+			}
+			i = min(i+1, len(expression))
+			tokens = append(tokens, token{kind: stringToken, text: expression[start:i], start: start, end: i})
+		case isNameChar(ch):
+			for i < len(expression) && isNameChar(expression[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: nameToken, text: expression[start:i], start: start, end: i})
+		default:
+			i++
+			tokens = append(tokens, token{kind: symbolToken, text: expression[start:i], start: start, end: i})
+		}
+	}
+	return tokens
+}
+
+// isSymbol returns true if the i-th token is the symbol
+func isSymbol(tokens []token, i int, symbol string) bool {
+	return i >= 0 && i < len(tokens) && tokens[i].kind == symbolToken && tokens[i].text == symbol
+}
+
+// findCall returns the index of the name of the first call of the function
+// with more than argument arguments, or -1
+func findCall(tokens []token, function string, argument int) int {
+	for i, t := range tokens {
+		if t.kind == nameToken && t.text == function && isSymbol(tokens, i+1, "(") &&
+			len(callArguments(tokens, i)) > argument {
+			return i
+		}
+	}
+	return -1
+}
+
+// callArguments returns the tokens of each argument of the call whose name is
+// the i-th token
+func callArguments(tokens []token, i int) [][]token {
+	var arguments [][]token
+	depth := 0
+	start := i + 2
+	for j := i + 1; j < len(tokens); j++ {
+		if tokens[j].kind != symbolToken {
+			continue
+		}
+		switch tokens[j].text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				if j > start {
+					arguments = append(arguments, tokens[start:j])
+				}
+				return arguments
+			}
+		case ",":
+			if depth == 1 {
+				arguments = append(arguments, tokens[start:j])
+				start = j + 1
+			}
+		}
+	}
+	// An unterminated call ends with the expression
+	if len(tokens) > start {
+		arguments = append(arguments, tokens[start:])
+	}
+	return arguments
+}
+
+// findPath returns the tokens of the first occurrence of the path, without
+// its keys, or nil
+func findPath(tokens []token, path string) []token {
+	for i := range tokens {
+		if tokens[i].kind != nameToken || isSymbol(tokens, i-1, ".") {
+			continue
+		}
+		text := tokens[i].text
+		end := i + 1
+		for isSymbol(tokens, end, ".") && end+1 < len(tokens) && tokens[end+1].kind == nameToken && len(text) < len(path) {
+			text += "." + tokens[end+1].text
+			end += 2
+		}
+		if text == path {
+			return tokens[i:end]
+		}
+	}
+	return nil
+}
+
+// findEnum returns the tokens of the first occurrence of the enum symbol,
+// which is neither part of a path nor a call, or nil
+func findEnum(tokens []token, symbol string) []token {
+	for i, t := range tokens {
+		if t.kind == nameToken && t.text == symbol && !isSymbol(tokens, i-1, ".") &&
+			!isSymbol(tokens, i+1, ".") && !isSymbol(tokens, i+1, "(") && !isSymbol(tokens, i+1, "[") {
+			return tokens[i : i+1]
+		}
+	}
+	return nil
+}
+
+// locate returns the tokens of the part of the expression the error message
+// is about, narrowed down to the innermost invalid argument, or nil
+func locate(tokens []token, message string) []token {
+	// Each invalid argument may itself be a call with an invalid argument
+	var located []token
+	for {
+		m := argumentErrorRegexp.FindStringSubmatchIndex(message)
+		if m == nil {
+			break
+		}
+		function := message[m[2]:m[3]]
+		argument, _ := strconv.Atoi(message[m[4]:m[5]])
+		call := findCall(tokens, function, argument)
+		if call < 0 {
+			break
+		}
+		tokens = callArguments(tokens, call)[argument]
+		located = tokens
+		message = message[m[1]:]
+	}
+
+	if m := segmentErrorRegexp.FindStringSubmatch(message); m != nil {
+		for _, t := range findPath(tokens, m[2]) {
+			if t.text == m[1] {
+				return []token{t}
+			}
+		}
+	}
+	if m := pathErrorRegexp.FindStringSubmatch(message); m != nil {
+		if path := findPath(tokens, m[1]); path != nil {
+			return path
+		}
+	}
+	if m := enumErrorRegexp.FindStringSubmatch(message); m != nil {
+		if enum := findEnum(tokens, m[1]); enum != nil {
+			return enum
+		}
+	}
+	if m := functionErrorRegexp.FindStringSubmatch(message); m != nil {
+		function := m[1] + m[2]
+		if call := findCall(tokens, function, -1); call >= 0 {
+			return tokens[call : call+1]
+		}
+	}
+	if m := callErrorRegexp.FindStringSubmatch(message); m != nil {
+		if call := findCall(tokens, m[1], -1); call >= 0 {
+			return tokens[call : call+1]
+		}
+	}
+	return located
+}
+
+// errorRange finds the part of the expression the error is about, or the
+// whole expression
+func errorRange(expression string, err error) Range {
+	var syntaxErr participle.Error
+	if errors.As(err, &syntaxErr) {
+		offset := syntaxErr.Position().Offset
+		length := 1
+		var unexpected *participle.UnexpectedTokenError
+		if errors.As(err, &unexpected) && !unexpected.Unexpected.EOF() {
+			length = max(len(unexpected.Unexpected.Value), 1)
+		}
+		return offsetRange(expression, offset, offset+length)
+	}
+
+	if located := locate(tokenize(expression), err.Error()); len(located) > 0 {
+		return offsetRange(expression, located[0].start, located[len(located)-1].end)
+	}
+	return offsetRange(expression, 0, len(expression))
+}
+
+// offsetRange returns the range of the byte offsets. An end past the
+// expression, e.g. of an error at its end, ends one column after the start.
+func offsetRange(expression string, start, end int) Range {
+	start = min(max(start, 0), len(expression))
+	r := Range{Start: positionAt(expression, start)}
+	if end > len(expression) {
+		r.End = Position{Line: r.Start.Line, Column: r.Start.Column + 1}
+	} else {
+		r.End = positionAt(expression, end)
+	}
+	return r
+}
+
+func positionAt(expression string, offset int) Position {
+	position := Position{Line: 1, Column: 1}
+	for _, ch := range expression[:offset] {
+		if ch == '\n' {
+			position.Line++
+			position.Column = 1
+		} else {
+			position.Column++
+		}
+	}
+	return position
+}
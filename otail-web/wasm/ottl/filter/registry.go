@@ -0,0 +1,228 @@
+package filter
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+)
+
+// The functions above are those of the linked collector-contrib version. An
+// agent may run another version with fewer functions, so function sets can be
+// registered per version and the functions restricted to the set of the
+// version the agent reports. A version uses the functions of the closest
+// registered version at or below it and versions older than every registered
+// one use the oldest set. The empty version and versions newer than the linked
+// one use every linked function, although newer versions may have more.
+//
+// functions.json lists the condition functions of the collector-contrib
+// versions before the linked one in which they changed. It is registered with
+// every new registry.
+
+// LinkedVersion is the collector-contrib version the functions are linked from
+const LinkedVersion = "v0.121.0"
+
+//go:embed functions.json
+var manifest []byte
+
+// FunctionRegistry records the OTTL functions available in each
+// collector-contrib version
+type FunctionRegistry struct {
+	mu       sync.RWMutex
+	versions []functionSet
+}
+
+type functionSet struct {
+	version collectorVersion
+	name    string
+	// funcs is nil for the linked version, which has every function
+	funcs map[string]bool
+}
+
+// DefaultFunctions is the registry the functions of a version are taken from.
+// Both the playground and the server register their versions here.
+var DefaultFunctions = NewFunctionRegistry()
+
+// NewFunctionRegistry creates a registry that knows the linked version and the
+// versions of functions.json
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{
+		versions: []functionSet{{version: mustParseCollectorVersion(LinkedVersion), name: LinkedVersion}},
+	}
+	if err := r.load(manifest); err != nil {
+		panic("invalid functions.json: " + err.Error())
+	}
+	return r
+}
+
+// Register sets the functions available in a collector-contrib version,
+// replacing any functions registered for it before
+func (r *FunctionRegistry) Register(version string, functions []string) error {
+	v, ok := parseCollectorVersion(version)
+	if !ok {
+		return fmt.Errorf("invalid collector version %q", version)
+	}
+	set := functionSet{version: v, name: version, funcs: make(map[string]bool, len(functions))}
+	for _, name := range functions {
+		set.funcs[name] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.versions {
+		if existing.version == v {
+			r.versions[i] = set
+			return nil
+		}
+	}
+	r.versions = append(r.versions, set)
+	sort.Slice(r.versions, func(i, j int) bool {
+		return r.versions[i].version.less(r.versions[j].version)
+	})
+	return nil
+}
+
+// LoadFile registers the function sets of a JSON file mapping collector-contrib
+// versions to function names, e.g. {"v0.122.0": ["IsMatch", ...]}
+func (r *FunctionRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := r.load(data); err != nil {
+		return fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return nil
+}
+
+func (r *FunctionRegistry) load(data []byte) error {
+	var sets map[string][]string
+	if err := json.Unmarshal(data, &sets); err != nil {
+		return err
+	}
+	for version, functions := range sets {
+		if err := r.Register(version, functions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Functions returns the functions available in the collector-contrib version,
+// or nil if every linked function is available
+func (r *FunctionRegistry) Functions(version string) map[string]bool {
+	v, ok := parseCollectorVersion(version)
+	if !ok {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		if !v.less(r.versions[i].version) {
+			return r.versions[i].funcs
+		}
+	}
+	return r.versions[0].funcs
+}
+
+// IsNewerThanLinked reports whether the collector-contrib version is newer than
+// the linked one, i.e. it may have functions that are not linked
+func IsNewerThanLinked(version string) bool {
+	v, ok := parseCollectorVersion(version)
+	return ok && mustParseCollectorVersion(LinkedVersion).less(v)
+}
+
+// RegisterFunctions sets the functions available in a collector-contrib
+// version of DefaultFunctions
+func RegisterFunctions(version string, functions []string) error {
+	return DefaultFunctions.Register(version, functions)
+}
+
+var current struct {
+	sync.RWMutex
+	version string
+}
+
+// SetCollectorVersion restricts the Standard functions to those available in
+// the collector-contrib version. The empty version uses every linked function.
+func SetCollectorVersion(version string) error {
+	if version != "" {
+		if _, ok := parseCollectorVersion(version); !ok {
+			return fmt.Errorf("invalid collector version %q", version)
+		}
+	}
+	current.Lock()
+	defer current.Unlock()
+	current.version = version
+	return nil
+}
+
+// CollectorVersion returns the version set by SetCollectorVersion
+func CollectorVersion() string {
+	current.RLock()
+	defer current.RUnlock()
+	return current.version
+}
+
+// forVersion removes the functions the collector-contrib version lacks
+func forVersion[K any](version string, functions map[string]ottl.Factory[K]) map[string]ottl.Factory[K] {
+	available := DefaultFunctions.Functions(version)
+	if available == nil {
+		return functions
+	}
+	for name := range functions {
+		if !available[name] {
+			delete(functions, name)
+		}
+	}
+	return functions
+}
+
+// collectorVersion is a major.minor.patch collector-contrib version
+type collectorVersion [3]int
+
+// parseCollectorVersion parses versions such as v0.121.0, 0.121.0 or
+// 0.121.0-dev. Pre-release and build suffixes are ignored.
+func parseCollectorVersion(s string) (collectorVersion, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return collectorVersion{}, false
+	}
+	var v collectorVersion
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return collectorVersion{}, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func mustParseCollectorVersion(s string) collectorVersion {
+	v, ok := parseCollectorVersion(s)
+	if !ok {
+		panic("invalid collector version " + s)
+	}
+	return v
+}
+
+func (v collectorVersion) less(other collectorVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
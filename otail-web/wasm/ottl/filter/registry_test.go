@@ -0,0 +1,159 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCollectorVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    collectorVersion
+		wantOK  bool
+	}{
+		{version: "v0.121.0", want: collectorVersion{0, 121, 0}, wantOK: true},
+		{version: "0.121.0", want: collectorVersion{0, 121, 0}, wantOK: true},
+		{version: " v0.121.1-dev ", want: collectorVersion{0, 121, 1}, wantOK: true},
+		{version: "0.121.0+build.5", want: collectorVersion{0, 121, 0}, wantOK: true},
+		{version: "1.2", want: collectorVersion{1, 2, 0}, wantOK: true},
+		{version: ""},
+		{version: "v"},
+		{version: "latest"},
+		{version: "0.121.0.1"},
+		{version: "0.-1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, ok := parseCollectorVersion(tt.version)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseCollectorVersion(%q) = %v, %v, want %v, %v", tt.version, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFunctionRegistry(t *testing.T) {
+	// A registry without the versions of functions.json
+	r := &FunctionRegistry{
+		versions: []functionSet{{version: mustParseCollectorVersion(LinkedVersion), name: LinkedVersion}},
+	}
+	if err := r.Register("v0.100.0", []string{"IsMatch"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("v0.110.0", []string{"IsMatch", "Len"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("latest", nil); err == nil {
+		t.Error("Register accepted an invalid version")
+	}
+
+	tests := []struct {
+		version string
+		// want is nil if every linked function is available
+		want map[string]bool
+	}{
+		// Older versions use the oldest set
+		{version: "v0.90.0", want: map[string]bool{"IsMatch": true}},
+		{version: "v0.100.0", want: map[string]bool{"IsMatch": true}},
+		{version: "v0.105.3", want: map[string]bool{"IsMatch": true}},
+		{version: "v0.110.0", want: map[string]bool{"IsMatch": true, "Len": true}},
+		{version: "v0.120.9", want: map[string]bool{"IsMatch": true, "Len": true}},
+		{version: LinkedVersion},
+		{version: "v0.130.0"},
+		{version: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := r.Functions(tt.version); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Functions(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+
+	// Registering a version again replaces its functions
+	if err := r.Register("0.100.0", []string{"Len"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Functions("v0.100.0"), map[string]bool{"Len": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Functions after replace = %v, want %v", got, want)
+	}
+}
+
+func TestFunctionManifest(t *testing.T) {
+	r := NewFunctionRegistry()
+
+	linked := SpanFuncs("")
+	for _, set := range r.versions {
+		for name := range set.funcs {
+			if linked[name] == nil {
+				t.Errorf("function %s of %s is not linked", name, set.name)
+			}
+		}
+	}
+
+	tests := []struct {
+		version string
+		has     []string
+		lacks   []string
+	}{
+		{version: "v0.80.0", has: []string{"IsMatch", "IsRootSpan"}, lacks: []string{"IsBool", "Weekday"}},
+		{version: "v0.88.0", has: []string{"IsMatch", "IsRootSpan"}, lacks: []string{"IsBool"}},
+		{version: "v0.104.2", has: []string{"URL", "append"}, lacks: []string{"Hex"}},
+		{version: "v0.120.1", has: []string{"ToUpperCase"}, lacks: []string{"Weekday"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			functions := r.Functions(tt.version)
+			for _, name := range tt.has {
+				if !functions[name] {
+					t.Errorf("Functions(%s) lacks %s", tt.version, name)
+				}
+			}
+			for _, name := range tt.lacks {
+				if functions[name] {
+					t.Errorf("Functions(%s) has %s", tt.version, name)
+				}
+			}
+		})
+	}
+
+	for _, version := range []string{LinkedVersion, "v0.131.0", ""} {
+		if functions := r.Functions(version); functions != nil {
+			t.Errorf("Functions(%q) = %v, want every linked function", version, functions)
+		}
+	}
+}
+
+func TestIsNewerThanLinked(t *testing.T) {
+	for version, want := range map[string]bool{
+		"v0.131.0": true,
+		"0.121.1":  true,
+		"v1.0.0":   true,
+		"v0.121.0": false,
+		"v0.100.0": false,
+		"":         false,
+		"latest":   false,
+	} {
+		if got := IsNewerThanLinked(version); got != want {
+			t.Errorf("IsNewerThanLinked(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestSpanFuncsForVersion(t *testing.T) {
+	if err := DefaultFunctions.Register("v0.1.0", []string{"IsMatch"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DefaultFunctions = NewFunctionRegistry() })
+
+	funcs := SpanFuncs("v0.1.0")
+	if len(funcs) != 1 || funcs["IsMatch"] == nil {
+		t.Errorf("SpanFuncs(v0.1.0) = %v, want only IsMatch", funcs)
+	}
+	if linked := SpanFuncs(""); linked["IsRootSpan"] == nil || linked["IsMatch"] == nil {
+		t.Error("SpanFuncs() of the linked version lacks IsRootSpan or IsMatch")
+	}
+	if linked := SpanEventFuncs(""); linked["IsRootSpan"] != nil {
+		t.Error("SpanEventFuncs() has IsRootSpan, which is only available to spans")
+	}
+}
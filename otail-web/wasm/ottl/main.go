@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"syscall/js"
 
 	"github.com/mottibec/otail/wasm/ottl/evaluator"
+	"github.com/mottibec/otail/wasm/ottl/filter"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.uber.org/zap"
 )
@@ -63,15 +68,411 @@ func createJSEvaluateFunction() js.Func {
 			return NewResult("", err)
 		}
 
+		// An optional fifth argument explains the decision
+		if explainRequested(args, 4) {
+			value, err := toJSValue(evaluator.Explain(context.Background(), traces))
+			if err != nil {
+				return NewResult("", err)
+			}
+			return value
+		}
+
 		// Run evaluation
 		decision, err := evaluator.Evaluate(context.Background(), traces)
 		return NewResult(decision, err)
 	})
 }
 
+// toJSValue converts a value to its JSON form, which js.ValueOf accepts
+func toJSValue(v interface{}) (js.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return js.Undefined(), err
+	}
+	return js.ValueOf(value), nil
+}
+
+// explainRequested reports whether the optional explain argument at index i
+// is true
+func explainRequested(args []js.Value, i int) bool {
+	return len(args) > i && args[i].Type() == js.TypeBoolean && args[i].Bool()
+}
+
+// createJSTailSamplingFunction creates the JavaScript callable function that
+// evaluates a trace against a tail_sampling policy set
+func createJSTailSamplingFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			return NewResult("", errors.New("invalid number of arguments"))
+		}
+
+		otelData := args[0].String()
+		policiesData := args[1].String()
+
+		policies, err := evaluator.ParsePolicies([]byte(policiesData))
+		if err != nil {
+			return NewResult("", err)
+		}
+
+		sampler, err := evaluator.NewTailSampler(policies, zap.NewExample())
+		if err != nil {
+			return NewResult("", err)
+		}
+
+		traces, err := parseTraceData(otelData)
+		if err != nil {
+			return NewResult("", err)
+		}
+
+		var result interface{}
+		if explainRequested(args, 2) {
+			result = sampler.Explain(context.Background(), traces)
+		} else {
+			result = sampler.Evaluate(context.Background(), traces)
+		}
+		value, err := toJSValue(result)
+		if err != nil {
+			return NewResult("", err)
+		}
+		return value
+	})
+}
+
+// conditionsArg reads a list of conditions, given either as an array of
+// strings or as a single condition
+func conditionsArg(arg js.Value) []string {
+	switch arg.Type() {
+	case js.TypeString:
+		if arg.String() == "" {
+			return nil
+		}
+		return []string{arg.String()}
+	case js.TypeObject:
+		conditions := make([]string, 0, arg.Length())
+		for i := 0; i < arg.Length(); i++ {
+			conditions = append(conditions, arg.Index(i).String())
+		}
+		return conditions
+	default:
+		return nil
+	}
+}
+
+// newErrorResult creates the result returned to JavaScript when the logs or
+// metrics can't be evaluated
+func newErrorResult(err error) js.Value {
+	return js.ValueOf(map[string]interface{}{"error": err.Error()})
+}
+
+// createJSLogsFunction creates the JavaScript callable function that returns
+// the log records matched by log_record conditions
+func createJSLogsFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 3 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		otelData := args[0].String()
+		logRecordConditions := conditionsArg(args[1])
+		errMode := ottl.ErrorMode(args[2].String())
+
+		logEvaluator, err := evaluator.NewLogEvaluator(logRecordConditions, errMode, zap.NewExample())
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		logs, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs([]byte(otelData))
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		result, err := logEvaluator.Evaluate(context.Background(), logs)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		value, err := toJSValue(result)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSMetricsFunction creates the JavaScript callable function that
+// returns the metrics and data points matched by metric and datapoint
+// conditions
+func createJSMetricsFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 4 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		otelData := args[0].String()
+		metricConditions := conditionsArg(args[1])
+		dataPointConditions := conditionsArg(args[2])
+		errMode := ottl.ErrorMode(args[3].String())
+
+		metricEvaluator, err := evaluator.NewMetricEvaluator(metricConditions, dataPointConditions, errMode, zap.NewExample())
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		metrics, err := (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics([]byte(otelData))
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		result, err := metricEvaluator.Evaluate(context.Background(), metrics)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		value, err := toJSValue(result)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSTransformFunction creates the JavaScript callable function that
+// applies transform processor statements to OTLP JSON traces, logs or metrics
+func createJSTransformFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 4 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		signal := args[0].String()
+		otelData := []byte(args[1].String())
+		statementsData := args[2].String()
+		errMode := ottl.ErrorMode(args[3].String())
+
+		var groups []evaluator.ContextStatements
+		if err := json.Unmarshal([]byte(statementsData), &groups); err != nil {
+			return newErrorResult(fmt.Errorf("invalid statements: %w", err))
+		}
+
+		var result *evaluator.TransformResult
+		var err error
+		logger := zap.NewExample()
+		switch signal {
+		case "traces":
+			result, err = transform(groups, errMode, logger, evaluator.NewTraceTransformer, func() (ptrace.Traces, error) {
+				return parseTraceData(string(otelData))
+			})
+		case "logs":
+			result, err = transform(groups, errMode, logger, evaluator.NewLogTransformer, func() (plog.Logs, error) {
+				return (&plog.JSONUnmarshaler{}).UnmarshalLogs(otelData)
+			})
+		case "metrics":
+			result, err = transform(groups, errMode, logger, evaluator.NewMetricTransformer, func() (pmetric.Metrics, error) {
+				return (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics(otelData)
+			})
+		default:
+			err = fmt.Errorf("unknown signal %q, supported: traces, logs, metrics", signal)
+		}
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		value, err := toJSValue(result)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+func transform[D any](
+	groups []evaluator.ContextStatements,
+	errMode ottl.ErrorMode,
+	logger *zap.Logger,
+	newTransformer func([]evaluator.ContextStatements, ottl.ErrorMode, *zap.Logger) (*evaluator.Transformer[D], error),
+	parse func() (D, error),
+) (*evaluator.TransformResult, error) {
+	transformer, err := newTransformer(groups, errMode, logger)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	return transformer.Transform(context.Background(), data)
+}
+
+// createJSAnalyzeFunction creates the JavaScript callable function that
+// returns the diagnostics of an OTTL condition or statement
+func createJSAnalyzeFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 3 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		diagnostics, err := filter.Analyze(args[0].String(), args[1].String(), filter.ExpressionKind(args[2].String()))
+		if err != nil {
+			return newErrorResult(err)
+		}
+		value, err := toJSValue(map[string]interface{}{"diagnostics": diagnostics})
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSCatalogueFunction creates the JavaScript callable function that
+// lists the paths, functions and enums of an OTTL context
+func createJSCatalogueFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		catalogue, err := filter.GetCatalogue(args[0].String(), filter.ExpressionKind(args[1].String()))
+		if err != nil {
+			return newErrorResult(err)
+		}
+		value, err := toJSValue(catalogue)
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSCompleteFunction creates the JavaScript callable function that
+// suggests completions at a cursor offset in an OTTL expression
+func createJSCompleteFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 4 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		completions, err := filter.Complete(args[0].String(), args[1].String(), args[2].Int(), filter.ExpressionKind(args[3].String()))
+		if err != nil {
+			return newErrorResult(err)
+		}
+		value, err := toJSValue(map[string]interface{}{"completions": completions})
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSBatchFunction creates the JavaScript callable function that
+// evaluates each trace of an OTLP JSON batch against OTTL conditions
+func createJSBatchFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 4 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		otelData := args[0].String()
+		spanConditions := conditionsArg(args[1])
+		spanEventConditions := conditionsArg(args[2])
+		errMode := ottl.ErrorMode(args[3].String())
+
+		ottlEvaluator, err := evaluator.NewOTTLConditionEvaluator(spanConditions, spanEventConditions, errMode, zap.NewExample())
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		traces, err := parseTraceData(otelData)
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		value, err := toJSValue(ottlEvaluator.EvaluateBatch(context.Background(), traces))
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSTailSamplingBatchFunction creates the JavaScript callable function
+// that evaluates each trace of an OTLP JSON batch against a tail_sampling
+// policy set
+func createJSTailSamplingBatchFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		policies, err := evaluator.ParsePolicies([]byte(args[1].String()))
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		sampler, err := evaluator.NewTailSampler(policies, zap.NewExample())
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		traces, err := parseTraceData(args[0].String())
+		if err != nil {
+			return newErrorResult(err)
+		}
+
+		value, err := toJSValue(sampler.EvaluateBatch(context.Background(), traces))
+		if err != nil {
+			return newErrorResult(err)
+		}
+		return value
+	})
+}
+
+// createJSRegisterFunctionsFunction creates the JavaScript callable function
+// that registers the OTTL functions of a collector-contrib version
+func createJSRegisterFunctionsFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		if err := filter.RegisterFunctions(args[0].String(), conditionsArg(args[1])); err != nil {
+			return newErrorResult(err)
+		}
+		return js.ValueOf(map[string]interface{}{})
+	})
+}
+
+// createJSSetCollectorVersionFunction creates the JavaScript callable function
+// that restricts evaluation and analysis to the OTTL functions of the
+// collector-contrib version an agent runs
+func createJSSetCollectorVersionFunction() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			return newErrorResult(errors.New("invalid number of arguments"))
+		}
+
+		if err := filter.SetCollectorVersion(args[0].String()); err != nil {
+			return newErrorResult(err)
+		}
+		return js.ValueOf(map[string]interface{}{"version": filter.CollectorVersion()})
+	})
+}
+
 func main() {
-	// Register the JavaScript function
+	// Register the JavaScript functions
 	js.Global().Set("evaluateOTTL", createJSEvaluateFunction())
+	js.Global().Set("evaluateTailSampling", createJSTailSamplingFunction())
+	js.Global().Set("evaluateOTTLBatch", createJSBatchFunction())
+	js.Global().Set("evaluateTailSamplingBatch", createJSTailSamplingBatchFunction())
+	js.Global().Set("evaluateOTTLLogs", createJSLogsFunction())
+	js.Global().Set("evaluateOTTLMetrics", createJSMetricsFunction())
+	js.Global().Set("transformOTTL", createJSTransformFunction())
+	js.Global().Set("analyzeOTTL", createJSAnalyzeFunction())
+	js.Global().Set("ottlCatalogue", createJSCatalogueFunction())
+	js.Global().Set("completeOTTL", createJSCompleteFunction())
+	js.Global().Set("registerOTTLFunctions", createJSRegisterFunctionsFunction())
+	js.Global().Set("setCollectorVersion", createJSSetCollectorVersionFunction())
 
 	// Keep the program running
 	<-make(chan struct{})